@@ -0,0 +1,117 @@
+// Package carv2 bridges data segment aggregates and CARv2 files carrying the unpadded aggregate
+// as their data payload. Only the fixed size CARv2 pragma and header are handled, so the package
+// doesn't depend on a CAR implementation.
+package carv2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// pragmaSize is the size of the fixed prefix identifying a CARv2 file
+const pragmaSize = len(datasegment.CARv2Pragma)
+
+// Pragma returns the fixed prefix identifying a CARv2 file
+func Pragma() []byte {
+	return []byte(datasegment.CARv2Pragma)
+}
+
+// HeaderSize is the size of the CARv2 header following the Pragma
+const HeaderSize = 40
+
+// Header is the CARv2 header
+type Header struct {
+	// Characteristics is the bitfield of CARv2 characteristics
+	Characteristics [16]byte
+	// DataOffset is the offset of the data payload from the start of the file
+	DataOffset uint64
+	// DataSize is the size of the data payload
+	DataSize uint64
+	// IndexOffset is the offset of the CAR index from the start of the file, zero if absent
+	IndexOffset uint64
+}
+
+// ReadHeader reads the Pragma and the Header from the start of r
+func ReadHeader(r io.ReaderAt) (Header, error) {
+	buf := make([]byte, pragmaSize+HeaderSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return Header{}, xerrors.Errorf("reading CARv2 header: %w", err)
+	}
+	if string(buf[:pragmaSize]) != datasegment.CARv2Pragma {
+		return Header{}, xerrors.Errorf("not a CARv2 file")
+	}
+	buf = buf[pragmaSize:]
+
+	le := binary.LittleEndian
+	var h Header
+	copy(h.Characteristics[:], buf)
+	h.DataOffset = le.Uint64(buf[16:])
+	h.DataSize = le.Uint64(buf[24:])
+	h.IndexOffset = le.Uint64(buf[32:])
+	if h.DataOffset < uint64(pragmaSize+HeaderSize) {
+		return Header{}, xerrors.Errorf("data payload overlaps the header: offset %d", h.DataOffset)
+	}
+	if h.DataOffset+h.DataSize < h.DataOffset {
+		return Header{}, xerrors.Errorf("data payload size overflows: %d", h.DataSize)
+	}
+	return h, nil
+}
+
+// MarshalBinary encodes the Pragma and the Header
+func (h Header) MarshalBinary() ([]byte, error) {
+	res := make([]byte, pragmaSize+HeaderSize)
+	copy(res, datasegment.CARv2Pragma)
+	buf := res[pragmaSize:]
+
+	le := binary.LittleEndian
+	copy(buf, h.Characteristics[:])
+	le.PutUint64(buf[16:], h.DataOffset)
+	le.PutUint64(buf[24:], h.DataSize)
+	le.PutUint64(buf[32:], h.IndexOffset)
+	return res, nil
+}
+
+// ParseIndex parses the data segment index of a deal of dealSize stored in the data payload
+// of the CARv2 file r, starting at pieceOffset unpadded bytes from the start of the payload.
+func ParseIndex(r io.ReaderAt, dealSize abi.PaddedPieceSize, pieceOffset uint64) (datasegment.IndexData, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return datasegment.IndexData{}, err
+	}
+	if err := datasegment.ValidateDealSize(dealSize); err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	pieceEnd := pieceOffset + uint64(dealSize.Unpadded())
+	if pieceEnd < pieceOffset || pieceEnd > h.DataSize {
+		return datasegment.IndexData{}, xerrors.Errorf("piece at offset %d of size %d exceeds the data payload of %d bytes",
+			pieceOffset, dealSize.Unpadded(), h.DataSize)
+	}
+
+	start := h.DataOffset + pieceOffset + datasegment.DataSegmentIndexStartOffset(dealSize)
+	size := datasegment.IndexAreaSizeUnpadded(dealSize)
+	index, err := datasegment.ParseDataSegmentIndexForDeal(dealSize, io.NewSectionReader(r, int64(start), int64(size)))
+	if err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("parsing data segment index: %w", err)
+	}
+	return index, nil
+}
+
+// WrapPayload returns a reader of a CARv2 file, without a CAR index, carrying payloadSize bytes
+// of r as its data payload, e.g. the output of Aggregate.AggregateObjectReader.
+// The payload is written as is, it is readable by CAR tools only if it is itself a CARv1.
+func WrapPayload(r io.Reader, payloadSize uint64) (io.Reader, error) {
+	h := Header{
+		DataOffset: uint64(pragmaSize + HeaderSize),
+		DataSize:   payloadSize,
+	}
+	header, err := h.MarshalBinary()
+	if err != nil {
+		return nil, xerrors.Errorf("encoding CARv2 header: %w", err)
+	}
+	return io.MultiReader(bytes.NewReader(header), io.LimitReader(r, int64(payloadSize))), nil
+}
@@ -0,0 +1,71 @@
+package carv2
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapAndParseIndex(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{Size: 1 << 10},
+		{Size: 1 << 12},
+	}
+	for i := range pieces {
+		comm := make([]byte, 32)
+		comm[0] = byte(i + 1)
+		c, err := commcid.PieceCommitmentV1ToCID(comm)
+		require.NoError(t, err)
+		pieces[i].PieceCID = c
+	}
+	dealSize := abi.PaddedPieceSize(1 << 16)
+	a, err := datasegment.NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		readers[i] = bytes.NewReader(make([]byte, p.Size.Unpadded()))
+	}
+	payload, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+
+	// the aggregate is preceded by other data in the payload
+	const pieceOffset = 1000
+	prefix := bytes.Repeat([]byte{0xaa}, pieceOffset)
+	payloadSize := uint64(pieceOffset + dealSize.Unpadded())
+	wrapped, err := WrapPayload(io.MultiReader(bytes.NewReader(prefix), payload), payloadSize)
+	require.NoError(t, err)
+	car, err := io.ReadAll(wrapped)
+	require.NoError(t, err)
+
+	h, err := ReadHeader(bytes.NewReader(car))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(Pragma())+HeaderSize), h.DataOffset)
+	assert.Equal(t, payloadSize, h.DataSize)
+	assert.Len(t, car, int(h.DataOffset+h.DataSize))
+
+	index, err := ParseIndex(bytes.NewReader(car), dealSize, pieceOffset)
+	require.NoError(t, err)
+	valid, err := index.ValidEntries()
+	require.NoError(t, err)
+	assert.Equal(t, a.Index.Entries, valid)
+
+	_, err = ParseIndex(bytes.NewReader(car), dealSize, pieceOffset+1)
+	assert.ErrorContains(t, err, "exceeds the data payload")
+	_, err = ParseIndex(bytes.NewReader(car[1:]), dealSize, pieceOffset)
+	assert.ErrorContains(t, err, "not a CARv2 file")
+}
+
+func TestPragma(t *testing.T) {
+	expected := []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+	p := Pragma()
+	assert.Equal(t, expected, p)
+	p[0] = 0
+	assert.Equal(t, expected, Pragma())
+}
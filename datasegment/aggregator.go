@@ -0,0 +1,39 @@
+package datasegment
+
+import (
+	"io"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+)
+
+// Aggregator is the interface of a constructed aggregate as used by deal making services.
+// It is implemented by Aggregate and allows to mock aggregation in tests or to substitute it
+// with an alternative implementation, e.g. a client of a remote aggregation service.
+type Aggregator interface {
+	// PieceCID returns the PieceCID of the whole aggregate
+	PieceCID() (cid.Cid, error)
+	// IndexPieceCID returns the PieceCID of the data segment index
+	IndexPieceCID() (cid.Cid, error)
+	// IndexReader returns a reader of the unpadded data segment index
+	IndexReader() (io.Reader, error)
+	// IndexStartPosition returns the offset of the index area in padded bytes
+	IndexStartPosition() (uint64, error)
+	// IndexSize returns the padded size of the index area
+	IndexSize() (abi.PaddedPieceSize, error)
+	// ProofForPieceInfo returns the inclusion proof of the piece, failing with ErrAmbiguousPiece
+	// if it occurs more than once
+	ProofForPieceInfo(d abi.PieceInfo) (*InclusionProof, error)
+	// ProofsForPieceInfo returns the inclusion proofs of all occurrences of the piece
+	ProofsForPieceInfo(d abi.PieceInfo) ([]*InclusionProof, error)
+	// ProofForIndexEntry returns the inclusion proof of the given index entry
+	ProofForIndexEntry(idx int) (*InclusionProof, error)
+	// AggregateObjectReader returns a reader of the unpadded aggregate built from
+	// the readers of the sub-pieces
+	AggregateObjectReader(subPieceReaders []io.Reader) (io.Reader, error)
+	// Manifest returns the description of the composition of the aggregate
+	Manifest() (*AggregateManifest, error)
+}
+
+var _ Aggregator = Aggregate{}
+var _ Aggregator = (*Aggregate)(nil)
@@ -0,0 +1,31 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatorInterface(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(1), Size: 1 << 10},
+		{PieceCID: cidForDeal(2), Size: 1 << 12},
+	}
+	var agg Aggregator
+	agg, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+
+	dealCID, err := agg.PieceCID()
+	require.NoError(t, err)
+	ip, err := agg.ProofForPieceInfo(pieces[1])
+	require.NoError(t, err)
+	aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[1]))
+	require.NoError(t, err)
+	assert.Equal(t, dealCID, aux.CommPa)
+
+	m, err := agg.Manifest()
+	require.NoError(t, err)
+	assert.Len(t, m.Pieces, len(pieces))
+}
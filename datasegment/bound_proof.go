@@ -0,0 +1,26 @@
+package datasegment
+
+// BoundProof is an InclusionProof bound to the generation of the Aggregate's tree
+// it was collected from
+type BoundProof struct {
+	InclusionProof
+	// Generation is the generation of the tree at the time the proof was collected
+	Generation uint64
+}
+
+// BoundProofForIndexEntry is like ProofForIndexEntry but binds the proof to the current
+// generation of the tree
+func (a Aggregate) BoundProofForIndexEntry(idx int) (*BoundProof, error) {
+	ip, err := a.ProofForIndexEntry(idx)
+	if err != nil {
+		return nil, err
+	}
+	return &BoundProof{InclusionProof: *ip, Generation: a.Tree.Generation()}, nil
+}
+
+// IsStale returns true if the tree of the Aggregate was mutated since the proof was collected.
+// A stale proof still verifies against the old root, collect it again with
+// BoundProofForIndexEntry to prove inclusion in the current tree.
+func (a Aggregate) IsStale(bp *BoundProof) bool {
+	return bp.Generation != a.Tree.Generation()
+}
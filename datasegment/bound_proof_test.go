@@ -0,0 +1,41 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoundProof(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cid.Undef, Size: 256 << 10},
+	}
+	a, err := NewSparseAggregate(dealSize, pieces)
+	require.NoError(t, err)
+
+	bp, err := a.BoundProofForIndexEntry(0)
+	require.NoError(t, err)
+	assert.False(t, a.IsStale(bp))
+
+	// fill in the reserved location
+	comm := commForDeal(1)
+	require.NoError(t, a.Tree.SetNode(a.Reserved[0].Level, a.Reserved[0].Index, &comm))
+	assert.True(t, a.IsStale(bp))
+	aux, err := bp.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[0]))
+	require.NoError(t, err, "stale proofs are still valid, just for the old tree")
+	assert.NotEqual(t, Must(a.PieceCID()), aux.CommPa)
+
+	current, err := a.BoundProofForIndexEntry(0)
+	require.NoError(t, err)
+	assert.False(t, a.IsStale(current))
+	assert.NotEqual(t, bp.InclusionProof, current.InclusionProof)
+
+	aux, err = current.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[0]))
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+}
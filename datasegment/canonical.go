@@ -0,0 +1,51 @@
+package datasegment
+
+import (
+	"bytes"
+
+	"golang.org/x/exp/slices"
+)
+
+// segmentDescLess orders entries by Offset, then Size, then CommDs
+func segmentDescLess(a, b SegmentDesc) bool {
+	if a.Offset != b.Offset {
+		return a.Offset < b.Offset
+	}
+	if a.Size != b.Size {
+		return a.Size < b.Size
+	}
+	return bytes.Compare(a.CommDs[:], b.CommDs[:]) < 0
+}
+
+// SortByOffset sorts the entries in place by their Offset.
+// Entries with equal Offset are ordered by Size and then CommDs.
+func (id *IndexData) SortByOffset() {
+	slices.SortFunc(id.Entries, segmentDescLess)
+}
+
+// Canonicalize brings the index into canonical form in place: all-zero entries are removed,
+// checksums are recomputed and entries are sorted with SortByOffset.
+func (id *IndexData) Canonicalize() {
+	entries := id.Entries[:0]
+	for _, e := range id.Entries {
+		if e == (SegmentDesc{}) {
+			continue
+		}
+		entries = append(entries, e.withUpdatedChecksum())
+	}
+	id.Entries = entries
+	id.SortByOffset()
+}
+
+// IsCanonical returns true if the index is in the form produced by Canonicalize
+func (id IndexData) IsCanonical() bool {
+	for i, e := range id.Entries {
+		if e == (SegmentDesc{}) || e.computeChecksum() != e.Checksum {
+			return false
+		}
+		if i > 0 && segmentDescLess(e, id.Entries[i-1]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package datasegment
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/fr32"
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slices"
+)
+
+func TestCanonicalize(t *testing.T) {
+	entry := func(comm byte, offset, size uint64) SegmentDesc {
+		return *Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{comm}), offset, size))
+	}
+	canonical := IndexData{Entries: []SegmentDesc{
+		entry(0x1, 0, 128),
+		entry(0x3, 128, 128),
+		entry(0x2, 256, 128),
+		entry(0x1, 256, 256),
+	}}
+	assert.True(t, canonical.IsCanonical())
+	assert.True(t, IndexData{}.IsCanonical())
+
+	badChecksum := entry(0x3, 128, 128)
+	badChecksum.Checksum[0] ^= 0xff
+	messy := IndexData{Entries: []SegmentDesc{
+		entry(0x1, 256, 256),
+		{},
+		entry(0x2, 256, 128),
+		badChecksum,
+		entry(0x1, 0, 128),
+		{},
+	}}
+	assert.False(t, messy.IsCanonical())
+
+	sorted := IndexData{Entries: slices.Clone(messy.Entries)}
+	sorted.SortByOffset()
+	assert.Equal(t, []SegmentDesc{{}, {}, entry(0x1, 0, 128), badChecksum, entry(0x2, 256, 128), entry(0x1, 256, 256)},
+		sorted.Entries)
+	assert.False(t, sorted.IsCanonical())
+
+	messy.Canonicalize()
+	assert.True(t, messy.IsCanonical())
+	assert.True(t, messy.Equal(canonical))
+	assert.Equal(t, Must(canonical.MarshalBinary()), Must(messy.MarshalBinary()))
+}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// The index holds MaxIndexEntriesInDeal entries, one entry per 128KiB of the deal rounded up
+// to a power of two, but at least 4. The index area of that many entries takes up 1/2048 of
+// the deal, at its end, the space before it is available for sub-deals. Its size is returned by
+// IndexAreaSizePadded.
+
+// MaxSubdeals returns the maximum number of sub-deals of minPieceSize, or larger, which fit
+// in a deal of dealSize. It is limited by both the space before the index area and
+// MaxIndexEntriesInDeal. Zero is returned for invalid sizes.
+func MaxSubdeals(dealSize abi.PaddedPieceSize, minPieceSize abi.PaddedPieceSize) uint {
+	if ValidateDealSize(dealSize) != nil || minPieceSize.Validate() != nil {
+		return 0
+	}
+	bySpace := IndexAreaStartPadded(dealSize) / uint64(minPieceSize)
+	if maxEntries := MaxIndexEntriesInDeal(dealSize); bySpace > uint64(maxEntries) {
+		return maxEntries
+	}
+	return uint(bySpace)
+}
+
+// MinPieceSizeForCount returns the largest piece size for which n sub-deals of that size fit
+// in a deal of dealSize, that is the size to which n sub-deals have to be limited.
+// It fails if n sub-deals can't fit, regardless of their size.
+func MinPieceSizeForCount(dealSize abi.PaddedPieceSize, n uint) (abi.PaddedPieceSize, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return 0, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if n == 0 {
+		return 0, xerrors.Errorf("number of sub-deals has to be non-zero")
+	}
+	if maxEntries := MaxIndexEntriesInDeal(dealSize); n > maxEntries {
+		return 0, xerrors.Errorf("too many sub-deals for a %d sized deal: %d > %d", dealSize, n, maxEntries)
+	}
+	// pieces of equal power of two size are packed without gaps
+	size := abi.PaddedPieceSize(uint64(1) << util.Log2Floor(IndexAreaStartPadded(dealSize)/uint64(n)))
+	if size < 128 {
+		return 0, xerrors.Errorf("%d sub-deals don't fit in a %d sized deal", n, dealSize)
+	}
+	return size, nil
+}
+
+// DataCapacity returns the padded size of the part of the deal before the index area,
+// available for sub-deals. It accounts for a custom IndexCapacity.
+func (a Aggregate) DataCapacity() abi.PaddedPieceSize {
+	return abi.PaddedPieceSize(a.indexAreaStart())
+}
+
+// UsedBytes returns the padded size of the sub-deals in the Aggregate
+func (a Aggregate) UsedBytes() abi.PaddedPieceSize {
+	var res abi.PaddedPieceSize
+	for _, e := range a.Index.Entries {
+		res += e.PaddedSize()
+	}
+	return res
+}
+
+// FreeBytes returns the padded size of the FreeRanges of the Aggregate. Together with UsedBytes,
+// the size of the Reserved locations and the metadata area, if any, it adds up to DataCapacity.
+// Free bytes are not necessarily usable by a single sub-deal, which has to be aligned to its size.
+func (a Aggregate) FreeBytes() abi.PaddedPieceSize {
+	var res abi.PaddedPieceSize
+	for _, r := range a.FreeRanges() {
+		res += abi.PaddedPieceSize(r.Size)
+	}
+	return res
+}
+
+// SegmentCount returns the number of sub-deals in the Aggregate, not counting the IndexHeader
+// and entries removed with TombstoneEntry
+func (a Aggregate) SegmentCount() int {
+	res := 0
+	for _, e := range a.Index.Entries {
+		if e != (SegmentDesc{}) {
+			res++
+		}
+	}
+	return res
+}
@@ -0,0 +1,89 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexAreaSizePadded(t *testing.T) {
+	for _, dealSize := range []abi.PaddedPieceSize{256, 1 << 20, 32 << 30, 64 << 30} {
+		assert.Equal(t, abi.PaddedPieceSize(uint64(MaxIndexEntriesInDeal(dealSize))*EntrySize),
+			IndexAreaSizePadded(dealSize))
+	}
+	assert.Equal(t, abi.PaddedPieceSize(16<<20), IndexAreaSizePadded(32<<30))
+}
+
+func TestMaxSubdeals(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	// limited by the number of index entries
+	assert.Equal(t, MaxIndexEntriesInDeal(dealSize), MaxSubdeals(dealSize, 128))
+	// limited by the space before the index
+	assert.Equal(t, uint(3), MaxSubdeals(dealSize, 256<<10))
+	assert.Equal(t, uint(0), MaxSubdeals(dealSize, 1<<20))
+	assert.Equal(t, uint(0), MaxSubdeals(dealSize, 100))
+	assert.Equal(t, uint(0), MaxSubdeals(1000, 128))
+
+	// the limits match NewAggregate
+	n := MaxSubdeals(dealSize, 64<<10)
+	pieces := make([]abi.PieceInfo, n+1)
+	for i := range pieces {
+		pieces[i] = abi.PieceInfo{PieceCID: cidForDeal(i), Size: 64 << 10}
+	}
+	_, err := NewAggregate(dealSize, pieces[:n])
+	require.NoError(t, err)
+	_, err = NewAggregate(dealSize, pieces)
+	assert.Error(t, err)
+}
+
+func TestMinPieceSizeForCount(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	size, err := MinPieceSizeForCount(dealSize, 1)
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(512<<10), size)
+	size, err = MinPieceSizeForCount(dealSize, 3)
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(256<<10), size)
+	size, err = MinPieceSizeForCount(dealSize, 4)
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(128<<10), size)
+	assert.GreaterOrEqual(t, MaxSubdeals(dealSize, size), uint(4))
+
+	_, err = MinPieceSizeForCount(dealSize, MaxIndexEntriesInDeal(dealSize)+1)
+	assert.Error(t, err)
+	_, err = MinPieceSizeForCount(dealSize, 0)
+	assert.Error(t, err)
+	_, err = MinPieceSizeForCount(256, 4)
+	assert.Error(t, err)
+}
+
+func TestAggregateGeometry(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cidForDeal(1), Size: 256 << 10},
+		{PieceCID: cidForDeal(2), Size: 64 << 10},
+	}
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(IndexAreaStartPadded(dealSize)), a.DataCapacity())
+	assert.Equal(t, abi.PaddedPieceSize(448<<10), a.UsedBytes())
+	assert.Equal(t, a.DataCapacity()-a.UsedBytes(), a.FreeBytes())
+	assert.Equal(t, 3, a.SegmentCount())
+
+	custom, err := NewAggregate(dealSize, pieces, WithIndexCapacity(4*MaxIndexEntriesInDeal(dealSize)), WithIndexHeader())
+	require.NoError(t, err)
+	assert.Equal(t, dealSize-4*IndexAreaSizePadded(dealSize), custom.DataCapacity())
+	assert.Equal(t, 3, custom.SegmentCount())
+
+	// reserved locations are neither used nor free
+	pieces[1].PieceCID = cid.Undef
+	sparse, err := NewSparseAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(192<<10), sparse.UsedBytes())
+	assert.Equal(t, sparse.DataCapacity()-sparse.UsedBytes()-256<<10, sparse.FreeBytes())
+	assert.Equal(t, 2, sparse.SegmentCount())
+}
@@ -0,0 +1,79 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	xerrors "golang.org/x/xerrors"
+)
+
+// CARv2Pragma is the fixed prefix of a CARv2 file, a varint length followed by the DAG-CBOR
+// map {"version": 2}
+const CARv2Pragma = "\x0a\xa1\x67version\x02"
+
+// carV1HeaderStart is the start of the DAG-CBOR header of a CARv1 file, a map of two entries
+// whose first key is "roots"
+var carV1HeaderStart = []byte{0xa2, 0x65, 'r', 'o', 'o', 't', 's'}
+
+// SniffCAR checks if the data starts like a CAR file and returns its version, 1 or 2.
+// Only the leading magic bytes are checked, the CAR itself is not parsed.
+func SniffCAR(r io.ReaderAt) (int, bool) {
+	buf := make([]byte, binary.MaxVarintLen64+len(carV1HeaderStart))
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, false
+	}
+	buf = buf[:n]
+	if bytes.HasPrefix(buf, []byte(CARv2Pragma)) {
+		return 2, true
+	}
+	hdrLen, vn := binary.Uvarint(buf)
+	if vn <= 0 || hdrLen < uint64(len(carV1HeaderStart)) {
+		return 0, false
+	}
+	if bytes.HasPrefix(buf[vn:], carV1HeaderStart) {
+		return 1, true
+	}
+	return 0, false
+}
+
+// DataReader returns a reader of the unpadded data of the segment, dealReader is the unpadded
+// data of the whole deal
+func (sd SegmentDesc) DataReader(dealReader io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(dealReader, int64(sd.UnpaddedOffest()), int64(sd.UnpaddedLength()))
+}
+
+// IsCAR checks if the data of the segment starts like a CAR file. Entries in this version of
+// the index don't carry a multicodec, so the data itself is sniffed with SniffCAR.
+func (sd SegmentDesc) IsCAR(dealReader io.ReaderAt) bool {
+	_, ok := SniffCAR(sd.DataReader(dealReader))
+	return ok
+}
+
+// CARDataReader returns a reader of the CAR file stored in the segment of the index entry idx,
+// dealReader is the unpadded data of the whole deal. If the Aggregate has RawSizes, the reader
+// is limited to the raw size of the sub-deal, otherwise the zero padding of the segment follows
+// the CAR. It fails if the segment doesn't start like a CAR file.
+func (a Aggregate) CARDataReader(idx int, dealReader io.ReaderAt) (io.Reader, error) {
+	if idx < 0 || idx >= len(a.Index.Entries) {
+		return nil, xerrors.Errorf("index entry %d out of range, the index has %d entries", idx, len(a.Index.Entries))
+	}
+	e := a.Index.Entries[idx]
+	r := e.DataReader(dealReader)
+	if !e.IsCAR(dealReader) {
+		return nil, xerrors.Errorf("segment of entry %d doesn't contain a CAR", idx)
+	}
+	if a.RawSizes == nil {
+		return r, nil
+	}
+	if len(a.RawSizes) != len(a.Index.Entries) {
+		return nil, xerrors.Errorf("number of raw sizes doesn't match number of entries: %d != %d",
+			len(a.RawSizes), len(a.Index.Entries))
+	}
+	if a.RawSizes[idx] > e.UnpaddedLength() {
+		return nil, xerrors.Errorf("raw size of entry %d doesn't fit in the segment: %d > %d",
+			idx, a.RawSizes[idx], e.UnpaddedLength())
+	}
+	return io.LimitReader(r, int64(a.RawSizes[idx])), nil
+}
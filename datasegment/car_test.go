@@ -0,0 +1,72 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffCAR(t *testing.T) {
+	car, err := os.ReadFile("testdata/sample_aggregate/cat.png.car")
+	require.NoError(t, err)
+	v, ok := SniffCAR(bytes.NewReader(car))
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	v, ok = SniffCAR(bytes.NewReader(append([]byte(CARv2Pragma), make([]byte, 40)...)))
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	_, ok = SniffCAR(bytes.NewReader(make([]byte, 127)))
+	assert.False(t, ok)
+	_, ok = SniffCAR(bytes.NewReader(nil))
+	assert.False(t, ok)
+}
+
+func TestCARDataReader(t *testing.T) {
+	cars := make([][]byte, 2)
+	var err error
+	cars[0], err = os.ReadFile("testdata/sample_aggregate/cat.png.car")
+	require.NoError(t, err)
+	cars[1], err = os.ReadFile("testdata/sample_aggregate/Verifiable Data Aggregation.png.car")
+	require.NoError(t, err)
+	pieceInfos := []abi.PieceInfo{
+		{
+			PieceCID: cid.MustParse("baga6ea4seaqae5ysjdbsr4b5jhotaz5ooh62jrrdbxwygfpkkfjz44kvywycmgy"),
+			Size:     abi.UnpaddedPieceSize(520192).Padded(),
+		},
+		{
+			PieceCID: cid.MustParse("baga6ea4seaqnrm2n2g4m23t6rs26obxjw2tjtr7tcho24gepj2naqhevytduyoa"),
+			Size:     abi.UnpaddedPieceSize(260096).Padded(),
+		},
+	}
+	a, err := NewAggregate(1<<20, pieceInfos, WithRawSizes([]uint64{uint64(len(cars[0])), uint64(len(cars[1]))}))
+	require.NoError(t, err)
+	deal, err := io.ReadAll(Must(a.AggregateObjectReader([]io.Reader{bytes.NewReader(cars[0]), bytes.NewReader(cars[1])})))
+	require.NoError(t, err)
+
+	for i, car := range cars {
+		assert.True(t, a.Index.Entries[i].IsCAR(bytes.NewReader(deal)))
+		read, err := io.ReadAll(Must(a.CARDataReader(i, bytes.NewReader(deal))))
+		require.NoError(t, err)
+		assert.Equal(t, car, read)
+	}
+
+	withoutRaw := *a
+	withoutRaw.RawSizes = nil
+	read, err := io.ReadAll(Must(withoutRaw.CARDataReader(1, bytes.NewReader(deal))))
+	require.NoError(t, err)
+	assert.Len(t, read, int(a.Index.Entries[1].UnpaddedLength()))
+	assert.Equal(t, cars[1], read[:len(cars[1])])
+
+	_, err = a.CARDataReader(2, bytes.NewReader(deal))
+	assert.Error(t, err)
+	_, err = a.CARDataReader(0, bytes.NewReader(make([]byte, len(deal))))
+	assert.ErrorContains(t, err, "doesn't contain a CAR")
+}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	xerrors "golang.org/x/xerrors"
+)
+
+// ChainProofs chains the inner proof, of a node within the client's data segment to CommPc,
+// with the outer proof of the data segment within the deal. The resulting proof leads from that
+// node to CommPa and is verified with ComputeExpectedAuxDataChained.
+func ChainProofs(inner merkletree.ProofData, outer InclusionProof) (InclusionProof, error) {
+	innerDepth := inner.Depth()
+	if innerDepth > maxChainedInnerDepth {
+		return InclusionProof{}, xerrors.Errorf("inner proof too deep: %d > %d", innerDepth, maxChainedInnerDepth)
+	}
+	if inner.Index>>innerDepth != 0 {
+		return InclusionProof{}, xerrors.Errorf("inner proof index %d out of range for depth %d", inner.Index, innerDepth)
+	}
+	if outer.ProofSubtree.Index>>(64-innerDepth) != 0 {
+		return InclusionProof{}, xerrors.Errorf("chained proof index overflows")
+	}
+
+	path := make([]merkletree.Node, 0, innerDepth+outer.ProofSubtree.Depth())
+	path = append(path, inner.Path...)
+	path = append(path, outer.ProofSubtree.Path...)
+	return InclusionProof{
+		ProofSubtree: merkletree.ProofData{
+			Path:  path,
+			Index: outer.ProofSubtree.Index<<innerDepth | inner.Index,
+		},
+		ProofIndex: outer.ProofIndex.Clone(),
+	}, nil
+}
+
+// maxChainedInnerDepth is the depth of a single node within the largest supported piece
+const maxChainedInnerDepth = MaxSubtreeProofDepth + 2
+
+// SplitChainedProof splits a proof produced by ChainProofs, into the inner proof with the given
+// depth and the outer InclusionProof.
+func (ip InclusionProof) SplitChainedProof(innerDepth int) (merkletree.ProofData, InclusionProof, error) {
+	if innerDepth < 0 || innerDepth > ip.ProofSubtree.Depth() || innerDepth > maxChainedInnerDepth {
+		return merkletree.ProofData{}, InclusionProof{}, xerrors.Errorf("invalid inner depth %d for a proof of depth %d",
+			innerDepth, ip.ProofSubtree.Depth())
+	}
+	inner := merkletree.ProofData{
+		Path:  ip.ProofSubtree.Path[:innerDepth:innerDepth],
+		Index: ip.ProofSubtree.Index & (uint64(1)<<innerDepth - 1),
+	}
+	outer := InclusionProof{
+		ProofSubtree: merkletree.ProofData{
+			Path:  ip.ProofSubtree.Path[innerDepth:],
+			Index: ip.ProofSubtree.Index >> innerDepth,
+		},
+		ProofIndex: ip.ProofIndex,
+	}
+	return inner, outer, nil
+}
+
+// ComputeExpectedAuxDataChained verifies a chained proof, produced by ChainProofs, of the node
+// at innerDepth below CommPc, that is of a node covering SizePc>>innerDepth padded bytes of
+// the client's data. The inner part is checked against CommPc and the outer part is verified
+// like with ComputeExpectedAuxData.
+func (ip InclusionProof) ComputeExpectedAuxDataChained(node merkletree.Node, innerDepth int, veriferData InclusionVerifierData) (*InclusionAuxData, error) {
+	if err := veriferData.Validate(); err != nil {
+		return nil, xerrors.Errorf("invalid verifier data: %w", err)
+	}
+	if maxDepth := util.Log2Floor(uint64(veriferData.SizePc) / merkletree.NodeSize); innerDepth > maxDepth {
+		return nil, xerrors.Errorf("%w: inner depth %d > %d for a piece of size %d",
+			proofLimitError("inner proof too deep"), innerDepth, maxDepth, veriferData.SizePc)
+	}
+	inner, outer, err := ip.SplitChainedProof(innerDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	commPc, err := lightCid2CommP(veriferData.CommPc)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid piece commitment: %w", err)
+	}
+	if err := inner.ValidateSubtree(&node, (*merkletree.Node)(&commPc)); err != nil {
+		return nil, xerrors.Errorf("node is not included in the client's piece: %w", err)
+	}
+	return outer.ComputeExpectedAuxData(veriferData)
+}
@@ -0,0 +1,62 @@
+package datasegment
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainProofs(t *testing.T) {
+	// a client's piece of 1KiB made up of 32 nodes
+	leafs := make([]merkletree.Node, 32)
+	for i := range leafs {
+		leafs[i] = merkletree.Node{byte(i + 1)}
+	}
+	pieceTree := merkletree.GrowTreeHashedLeafs(leafs)
+	commPc := *pieceTree.Root()
+	piece := abi.PieceInfo{PieceCID: Must(lightCommP2Cid(commPc)), Size: 1 << 10}
+
+	pieces := []abi.PieceInfo{{PieceCID: cidForDeal(1), Size: 1 << 12}, piece}
+	a, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+	outer, err := a.ProofForPieceInfo(piece)
+	require.NoError(t, err)
+	vd := VerifierDataForPieceInfo(piece)
+	expectedAux, err := outer.ComputeExpectedAuxData(vd)
+	require.NoError(t, err)
+
+	// proof of the 4th node of the piece at the leaf level, depth 5 below CommPc
+	inner, err := pieceTree.ConstructProof(5, 3)
+	require.NoError(t, err)
+	chained, err := ChainProofs(*inner, *outer)
+	require.NoError(t, err)
+	assert.Equal(t, inner.Depth()+outer.ProofSubtree.Depth(), chained.ProofSubtree.Depth())
+
+	// the chained subtree proof leads from the node to CommPa
+	commPa := Must(lightCid2CommP(expectedAux.CommPa))
+	require.NoError(t, chained.ProofSubtree.ValidateSubtree(&leafs[3], (*merkletree.Node)(&commPa)))
+
+	aux, err := chained.ComputeExpectedAuxDataChained(leafs[3], inner.Depth(), vd)
+	require.NoError(t, err)
+	assert.Equal(t, expectedAux, aux)
+
+	// a node not in the piece is rejected
+	_, err = chained.ComputeExpectedAuxDataChained(leafs[4], inner.Depth(), vd)
+	assert.Error(t, err)
+	// the depth has to match
+	_, err = chained.ComputeExpectedAuxDataChained(leafs[3], inner.Depth()-1, vd)
+	assert.Error(t, err)
+	_, err = chained.ComputeExpectedAuxDataChained(leafs[3], 6, vd)
+	assert.ErrorIs(t, err, ErrProofLimitExceeded)
+
+	splitInner, splitOuter, err := chained.SplitChainedProof(inner.Depth())
+	require.NoError(t, err)
+	assert.Equal(t, *inner, splitInner)
+	assert.Equal(t, *outer, splitOuter)
+
+	_, err = ChainProofs(merkletree.ProofData{Path: make([]merkletree.Node, 2), Index: 4}, *outer)
+	assert.Error(t, err)
+}
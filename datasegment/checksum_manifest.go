@@ -0,0 +1,162 @@
+package datasegment
+
+import (
+	"io"
+	"sort"
+
+	"github.com/filecoin-project/go-data-segment/util"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// ChecksumManifest is the result of an integrity scrub of the unpadded data of a deal,
+// see ComputeManifest
+type ChecksumManifest struct {
+	// DealSize is the padded size of the scanned deal
+	DealSize abi.PaddedPieceSize
+	// DealCID is the PieceCID computed over the whole deal
+	DealCID cid.Cid
+	// Segments are the checksums of the segments in the order of the index entries, empty
+	// entries are skipped
+	Segments []SegmentChecksum
+}
+
+// SegmentChecksum is the PieceCID computed over the data of a segment
+type SegmentChecksum struct {
+	Entry SegmentDesc
+	// Computed is the PieceCID of the data of the segment
+	Computed cid.Cid
+	// Match is true if Computed is the PieceCID of the entry
+	Match bool
+}
+
+// Mismatched returns the segments whose data doesn't match their index entry
+func (m ChecksumManifest) Mismatched() []SegmentChecksum {
+	var res []SegmentChecksum
+	for _, s := range m.Segments {
+		if !s.Match {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// ComputeManifest computes, in a single scan of r holding the unpadded data of a deal, the
+// PieceCID of the data of each segment of the index and the PieceCID of the whole deal.
+// The entries have to pass Validate, e.g. be the result of ValidEntries, and fit in the deal.
+// Empty entries, such as tombstoned ones, are skipped.
+func ComputeManifest(r io.Reader, index IndexData) (ChecksumManifest, error) {
+	segments := make([]segmentCalc, 0, len(index.Entries))
+	for i, e := range index.Entries {
+		if e == (SegmentDesc{}) {
+			continue
+		}
+		if err := e.Validate(); err != nil {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		end, ok := util.CheckedAdd(e.UnpaddedOffest(), e.UnpaddedLength())
+		if !ok {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: segment end overflows", i)
+		}
+		segments = append(segments, segmentCalc{entry: e, slot: i, start: e.UnpaddedOffest(), end: end})
+	}
+	// segments are fed in the order of their offsets, with the ones overlapping the current
+	// chunk of the deal kept active
+	order := make([]int, len(segments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return segments[order[i]].start < segments[order[j]].start
+	})
+
+	deal := &commp.Calc{}
+	buf := make([]byte, deal.BlockSize()*128)
+	var pos uint64
+	var active []int
+	next := 0
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk := buf[:n]
+			chunkEnd := pos + uint64(n)
+			_, _ = deal.Write(chunk)
+			for next < len(order) && segments[order[next]].start < chunkEnd {
+				segments[order[next]].calc = &commp.Calc{}
+				active = append(active, order[next])
+				next++
+			}
+			remaining := active[:0]
+			for _, i := range active {
+				s := &segments[i]
+				from, to := max(s.start, pos), min(s.end, chunkEnd)
+				_, _ = s.calc.Write(chunk[from-pos : to-pos])
+				if s.end > chunkEnd {
+					remaining = append(remaining, i)
+					continue
+				}
+				// the segment is complete, its calculator is released right away
+				s.comm, s.paddedSize, s.err = s.calc.Digest()
+				s.calc = nil
+			}
+			active = remaining
+			pos = chunkEnd
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ChecksumManifest{}, xerrors.Errorf("reading deal at %d: %w", pos, err)
+		}
+	}
+
+	comm, paddedSize, err := deal.Digest()
+	if err != nil {
+		return ChecksumManifest{}, xerrors.Errorf("computing commP of the deal: %w", err)
+	}
+	if abi.PaddedPieceSize(paddedSize).Unpadded() != abi.UnpaddedPieceSize(pos) {
+		return ChecksumManifest{}, xerrors.Errorf("deal data doesn't fill a piece: %d bytes", pos)
+	}
+	res := ChecksumManifest{DealSize: abi.PaddedPieceSize(paddedSize), Segments: make([]SegmentChecksum, len(segments))}
+	if res.DealCID, err = commcid.PieceCommitmentV1ToCID(comm); err != nil {
+		return ChecksumManifest{}, xerrors.Errorf("converting commP of the deal: %w", err)
+	}
+
+	for i, s := range segments {
+		e := s.entry
+		if s.end > pos {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: segment ends after the deal: %d > %d", s.slot, s.end, pos)
+		}
+		if s.err != nil {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: computing commP: %w", s.slot, s.err)
+		}
+		if s.paddedSize != e.Size {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: unexpected size of segment: %d != %d", s.slot, s.paddedSize, e.Size)
+		}
+		computed, err := commcid.PieceCommitmentV1ToCID(s.comm)
+		if err != nil {
+			return ChecksumManifest{}, xerrors.Errorf("entry %d: converting commP: %w", s.slot, err)
+		}
+		// a corrupted entry without a valid PieceCID doesn't match the data
+		expected, err := e.PieceCIDErr()
+		res.Segments[i] = SegmentChecksum{Entry: e, Computed: computed, Match: err == nil && computed.Equals(expected)}
+	}
+	return res, nil
+}
+
+// segmentCalc computes the commP of the unpadded range [start, end) of the deal, calc is
+// only allocated while the range is being read
+type segmentCalc struct {
+	entry SegmentDesc
+	// slot is the position of the entry in the index
+	slot       int
+	start, end uint64
+	calc       *commp.Calc
+
+	comm       []byte
+	paddedSize uint64
+	err        error
+}
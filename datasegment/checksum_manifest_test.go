@@ -0,0 +1,62 @@
+package datasegment
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeManifest(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(21, 8<<20, 12)
+	require.NoError(t, err)
+	deal := expectedDeal(t, a, pieces)
+
+	m, err := ComputeManifest(bytes.NewReader(deal), a.Index)
+	require.NoError(t, err)
+	assert.Equal(t, a.DealSize, m.DealSize)
+	assert.Equal(t, Must(a.PieceCID()), m.DealCID)
+	require.Len(t, m.Segments, len(pieces))
+	for i, s := range m.Segments {
+		assert.True(t, s.Match, "segment %d", i)
+		assert.Equal(t, pieces[i].PieceInfo.PieceCID, s.Computed)
+	}
+	assert.Empty(t, m.Mismatched())
+
+	// the order of the entries doesn't matter
+	reversed := make([]SegmentDesc, len(a.Index.Entries))
+	for i, e := range a.Index.Entries {
+		reversed[len(reversed)-1-i] = e
+	}
+	m2, err := ComputeManifest(bytes.NewReader(deal), IndexData{Entries: reversed})
+	require.NoError(t, err)
+	assert.Equal(t, m.Segments[0], m2.Segments[len(reversed)-1])
+
+	// a flipped byte is attributed to its segment
+	corrupted := append([]byte(nil), deal...)
+	e := a.Index.Entries[3]
+	corrupted[e.UnpaddedOffest()+e.UnpaddedLength()/2] ^= 0x01
+	m, err = ComputeManifest(bytes.NewReader(corrupted), a.Index)
+	require.NoError(t, err)
+	assert.NotEqual(t, Must(a.PieceCID()), m.DealCID)
+	mismatched := m.Mismatched()
+	require.Len(t, mismatched, 1)
+	assert.Equal(t, e, mismatched[0].Entry)
+
+	// tombstoned entries are skipped
+	tombstoned := *a
+	tombstoned.Index = IndexData{Entries: append([]SegmentDesc(nil), a.Index.Entries...)}
+	tombstoned.Tree = a.Tree.Clone()
+	require.NoError(t, tombstoned.TombstoneEntry(1))
+	m, err = ComputeManifest(bytes.NewReader(deal), tombstoned.Index)
+	require.NoError(t, err)
+	require.Len(t, m.Segments, len(pieces)-1)
+	assert.Equal(t, a.Index.Entries[2], m.Segments[1].Entry)
+	assert.Empty(t, m.Mismatched())
+
+	_, err = ComputeManifest(bytes.NewReader(deal[:len(deal)-127]), a.Index)
+	assert.Error(t, err)
+	_, err = ComputeManifest(bytes.NewReader(deal), IndexData{Entries: []SegmentDesc{{Size: 128}}})
+	assert.ErrorIs(t, err, ErrValidation)
+}
@@ -2,7 +2,10 @@ package datasegment
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"slices"
 
 	"github.com/hashicorp/go-multierror"
 	cid "github.com/ipfs/go-cid"
@@ -11,7 +14,9 @@ import (
 	"github.com/filecoin-project/go-data-segment/fr32"
 	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/filecoin-project/go-data-segment/verifylite"
 	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
 	abi "github.com/filecoin-project/go-state-types/abi"
 )
 
@@ -19,15 +24,130 @@ type Aggregate struct {
 	DealSize abi.PaddedPieceSize
 	Index    IndexData
 	Tree     merkletree.Hybrid
+	// Reserved are the locations reserved for sub-deals which are not yet part of the Aggregate,
+	// see NewSparseAggregate
+	Reserved []merkletree.Location
+	// IndexCapacity is the number of entries the index area can hold, see WithIndexCapacity.
+	// Zero means the default of MaxIndexEntriesInDeal(DealSize).
+	IndexCapacity uint
+	// IndexHeader is set if the index area starts with an IndexHeader entry, see WithIndexHeader
+	IndexHeader bool
+	// RawSizes are the raw sizes of the sub-deals in the order of the index entries,
+	// if supplied with WithRawSizes
+	RawSizes []uint64
+	// ProofStore, if set, caches proofs returned by ProofForPieceInfo, see WithProofStore
+	ProofStore ProofStore
+	// ContentDigests are the secondary digests of the content of the sub-deals in the order of
+	// the index entries, if computed with WithTeeHasher
+	ContentDigests [][]byte
+	// Metadata is the metadata of the segments placed in the deal, see AttachMetadata
+	Metadata []SegmentMetadata
+	// Tombstones record the entries removed with TombstoneEntry
+	Tombstones []Tombstone
+}
+
+type aggregateOptions struct {
+	rejectDuplicates bool
+	indexCapacity    uint
+	indexHeader      bool
+	progress         ProgressFunc
+	rawSizes         []uint64
+	proofStore       ProofStore
+	teeHasher        TeeHasher
+	metadata         []SegmentMetadata
+}
+
+// AggregateOption configures the construction of an Aggregate
+type AggregateOption func(*aggregateOptions)
+
+// RejectDuplicatePieces causes NewAggregate to fail if the same piece (PieceCID and Size)
+// is included more than once.
+func RejectDuplicatePieces() AggregateOption {
+	return func(o *aggregateOptions) {
+		o.rejectDuplicates = true
+	}
+}
+
+// WithIndexCapacity reserves an index area for the given number of entries instead of
+// MaxIndexEntriesInDeal(dealSize). The capacity has to be a power of two and at least
+// MaxIndexEntriesInDeal(dealSize).
+// The index area of such an Aggregate starts earlier than FRC-0058 specifies, its proofs have
+// to be verified with InclusionProof.ComputeExpectedAuxDataWithIndexCapacity.
+func WithIndexCapacity(entries uint) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.indexCapacity = entries
+	}
+}
+
+// WithIndexHeader writes an IndexHeader entry, describing the index format version and
+// the number of entries, at the start of the index area. The header takes up one entry
+// of the index capacity. ParseDataSegmentIndex recognizes the header and skips it.
+func WithIndexHeader() AggregateOption {
+	return func(o *aggregateOptions) {
+		o.indexHeader = true
+	}
+}
+
+type noSubdealsError string
+
+// ErrNoSubdeals is returned when an Aggregate would contain no subdeals. Empty aggregates are not
+// supported: NewAggregate rejects them and methods of an Aggregate without entries fail with it.
+var ErrNoSubdeals = noSubdealsError("aggregate has no subdeals")
+
+func (nse noSubdealsError) Error() string {
+	return string(nse)
+}
+
+func (nse noSubdealsError) Is(err error) bool {
+	_, ok := err.(noSubdealsError)
+	return ok
 }
 
 // NewAggregate creates the structure for verifiable deal aggregation
 // based on target deal size and subdeals that should be included.
-func NewAggregate(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo) (*Aggregate, error) {
-	if err := dealSize.Validate(); err != nil {
+// Subdeals are placed in the order they are passed in. Duplicate subdeals are allowed unless
+// RejectDuplicatePieces is passed, use ProofForPieceInfoAt or ProofsForPieceInfo to
+// select between their instances.
+func NewAggregate(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo, opts ...AggregateOption) (*Aggregate, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := ValidateDealSize(dealSize); err != nil {
 		return nil, xerrors.Errorf("invalid dealSize: %w", err)
 	}
+	if len(subdeals) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	if options.rawSizes != nil {
+		var err error
+		subdeals, err = applyRawSizes(subdeals, options.rawSizes)
+		if err != nil {
+			return nil, xerrors.Errorf("applying raw sizes: %w", err)
+		}
+	}
+	if options.rejectDuplicates {
+		seen := make(map[abi.PieceInfo]int, len(subdeals))
+		for i, sd := range subdeals {
+			if j, ok := seen[sd]; ok {
+				return nil, xerrors.Errorf("subdeal %d is a duplicate of subdeal %d: %s", i, j, sd.PieceCID)
+			}
+			seen[sd] = i
+		}
+	}
 	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	indexSize := uint64(IndexAreaSizePadded(dealSize))
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return nil, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+		maxEntries = options.indexCapacity
+		indexSize = uint64(maxEntries) * EntrySize
+	}
+	if options.indexHeader {
+		maxEntries--
+	}
 	if uint(len(subdeals)) > maxEntries {
 		return nil, xerrors.Errorf("too many subdeals for a %d sized deal: %d > %d",
 			dealSize, len(subdeals), maxEntries)
@@ -37,79 +157,420 @@ func NewAggregate(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo) (*Aggr
 		return nil, xerrors.Errorf("computing deal placment: %w", err)
 	}
 
-	if totalSize+uint64(maxEntries)*EntrySize > uint64(dealSize) {
+	if available, ok := util.CheckedSub(uint64(dealSize), indexSize); !ok || totalSize > available {
 		return nil, xerrors.Errorf(
 			"sub-deals are too large to fit in the index: %d (packed subdeals) + %d (index) > %d (dealSize)",
-			totalSize, maxEntries*EntrySize, dealSize)
+			totalSize, indexSize, dealSize)
+	}
+
+	agg, err := newAggregateFromCommLoc(dealSize, cl, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.rawSizes != nil {
+		agg.RawSizes = append([]uint64(nil), options.rawSizes...)
+	}
+	if options.metadata != nil {
+		if err := agg.AttachMetadata(options.metadata); err != nil {
+			return nil, xerrors.Errorf("attaching metadata: %w", err)
+		}
+	}
+	return agg, nil
+}
+
+func validateIndexCapacity(dealSize abi.PaddedPieceSize, capacity uint) error {
+	if capacity == 0 {
+		return xerrors.Errorf("%w: %d", indexCapacityError("capacity is not a power of two"), capacity)
+	}
+	// the capacities accepted by proof verification
+	if _, err := verifylite.IndexAreaStart(uint64(dealSize), uint64(capacity)); err != nil {
+		return xerrors.Errorf("capacity %d for a %d sized deal: %w", capacity, dealSize, liteError(err))
+	}
+	return nil
+}
+
+// indexAreaStartForCapacity returns the start of the index area, in padded bytes,
+// for an index holding capacity entries, or zero if the index doesn't fit in the deal
+func indexAreaStartForCapacity(dealSize abi.PaddedPieceSize, capacity uint) uint64 {
+	size, ok := util.CheckedMultiply(uint64(capacity), EntrySize)
+	if !ok {
+		return 0
+	}
+	return util.SaturatingSub(uint64(dealSize), size)
+}
+
+// newAggregateFromCommLoc builds the tree and the index of an Aggregate from already placed
+// sub-deals. The placement is assumed to have been validated by the caller.
+func newAggregateFromCommLoc(dealSize abi.PaddedPieceSize, cl []merkletree.CommAndLoc, options aggregateOptions) (*Aggregate, error) {
+	var reporter *progressReporter
+	if options.progress != nil {
+		reporter = newProgressReporter(options.progress, PhaseTreeBuild, len(cl))
+		reporter.phase(PhaseTreeBuild)
 	}
 
 	ht, err := merkletree.NewHybrid(util.Log2Ceil(uint64(dealSize / merkletree.NodeSize)))
 	if err != nil {
 		return nil, xerrors.Errorf("failed creating hybrid tree: %w", err)
 	}
-	err = ht.BatchSet(cl)
+	present := cl
+	if slices.Contains(cl, merkletree.CommAndLoc{}) {
+		present = slices.DeleteFunc(slices.Clone(cl), func(c merkletree.CommAndLoc) bool {
+			return c == merkletree.CommAndLoc{}
+		})
+	}
+	err = ht.BatchSet(present)
 	if err != nil {
 		return nil, xerrors.Errorf("batch set of deal nodes failed: %w", err)
 	}
+	reporter.segmentsCompleted(len(cl))
+	reporter.phase(PhaseIndexWrite)
 	index, err := MakeIndexFromCommLoc(cl)
 	if err != nil {
 		return nil, xerrors.Errorf("failed creating index: %w", err)
 	}
 
-	indexStartNodes := indexAreaStart(dealSize) / merkletree.NodeSize
-	batch := make([]merkletree.CommAndLoc, 2*len(index.Entries))
+	agg := Aggregate{
+		DealSize:      dealSize,
+		Index:         *index,
+		IndexCapacity: options.indexCapacity,
+		IndexHeader:   options.indexHeader,
+		ProofStore:    options.proofStore,
+	}
+
+	indexStart, err := indexEntryLocation(agg.indexAreaStart(), 0)
+	if err != nil {
+		return nil, xerrors.Errorf("locating index area: %w", err)
+	}
+	entries := agg.indexAreaEntries()
+	indexNodes := entriesIntoNodes(entries)
+	err = ht.SetLeafRange(indexStart.LeafIndex(), indexNodes)
+	if err != nil {
+		return nil, xerrors.Errorf("setting index nodes failed: %w", err)
+	}
+	reporter.segmentsCompleted(len(cl))
+
+	agg.Tree = ht
+	return &agg, nil
+}
+
+// NewAggregateWithTree creates an Aggregate from already computed index and hybrid tree,
+// for example restored from CBOR. The tree is checked for consistency with the index and
+// the deal size, but it is not rebuilt.
+func NewAggregateWithTree(dealSize abi.PaddedPieceSize, index IndexData, tree merkletree.Hybrid) (*Aggregate, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if expected := util.Log2Ceil(uint64(dealSize / merkletree.NodeSize)); tree.MaxLevel() != expected {
+		return nil, xerrors.Errorf("tree depth doesn't match deal size: %d != %d", tree.MaxLevel(), expected)
+	}
+	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	if uint(len(index.Entries)) > maxEntries {
+		return nil, xerrors.Errorf("too many index entries for a %d sized deal: %d > %d",
+			dealSize, len(index.Entries), maxEntries)
+	}
+	if len(index.Entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	if err := index.Validate(); err != nil {
+		return nil, xerrors.Errorf("invalid index: %w", err)
+	}
+
+	indexStart, err := indexEntryLocation(IndexAreaStartPadded(dealSize), 0)
+	if err != nil {
+		return nil, xerrors.Errorf("locating index area: %w", err)
+	}
 	for i, e := range index.Entries {
+		cl := e.CommAndLoc()
+		n, err := tree.GetNode(cl.Loc.Level, cl.Loc.Index)
+		if err != nil {
+			return nil, xerrors.Errorf("getting node for entry %d: %w", i, err)
+		}
+		if n != e.CommDs {
+			return nil, xerrors.Errorf("tree node for entry %d doesn't match its commitment", i)
+		}
+
 		ns := e.IntoNodes()
-		batch[2*i] = merkletree.CommAndLoc{
-			Comm: ns[0],
-			Loc:  merkletree.Location{Level: 0, Index: indexStartNodes + 2*uint64(i)},
+		for j, en := range ns {
+			n, err := tree.GetNode(0, indexStart.LeafIndex()+2*uint64(i)+uint64(j))
+			if err != nil {
+				return nil, xerrors.Errorf("getting index node for entry %d: %w", i, err)
+			}
+			if n != en {
+				return nil, xerrors.Errorf("index node %d for entry %d doesn't match the tree", j, i)
+			}
 		}
-		batch[2*i+1] = merkletree.CommAndLoc{
-			Comm: ns[1],
-			Loc:  merkletree.Location{Level: 0, Index: indexStartNodes + 2*uint64(i) + 1},
+	}
+
+	return &Aggregate{
+		DealSize: dealSize,
+		Index:    index,
+		Tree:     tree,
+	}, nil
+}
+
+// NewAggregateFromIndex creates an Aggregate from an index received from a third party,
+// rebuilding the tree from the entries. Every entry has to pass ValidateStrict and the entries
+// have to be ordered by offset, not overlap and end before the index area. Offending entries
+// are reported as EntryErrors, misplaced entries wrap ErrInvalidLayout.
+// WithIndexCapacity and WithIndexHeader have to match the options the index was created with,
+// WithRawSizes checks that the raw sizes fit in the entries.
+func NewAggregateFromIndex(dealSize abi.PaddedPieceSize, index IndexData, opts ...AggregateOption) (*Aggregate, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if len(index.Entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return nil, xerrors.Errorf("invalid index capacity: %w", err)
 		}
+		maxEntries = options.indexCapacity
 	}
-	err = ht.BatchSet(batch)
-	if err != nil {
-		return nil, xerrors.Errorf("batch set of index nodes failed: %w", err)
+	slot := 0
+	if options.indexHeader {
+		maxEntries--
+		slot = 1
+	}
+	if uint(len(index.Entries)) > maxEntries {
+		return nil, xerrors.Errorf("too many index entries for a %d sized deal: %d > %d",
+			dealSize, len(index.Entries), maxEntries)
 	}
 
-	agg := Aggregate{
-		DealSize: dealSize,
-		Index:    *index,
-		Tree:     ht,
+	indexAreaStart := Aggregate{DealSize: dealSize, IndexCapacity: options.indexCapacity}.indexAreaStart()
+	var errs error
+	cl := make([]merkletree.CommAndLoc, len(index.Entries))
+	subdeals := make([]abi.PieceInfo, len(index.Entries))
+	end := uint64(0)
+	for i, e := range index.Entries {
+		if e == (SegmentDesc{}) {
+			// empty slots, e.g. tombstoned entries, stay empty
+			continue
+		}
+		if err := e.ValidateStrict(); err != nil {
+			errs = multierror.Append(errs, &EntryError{Slot: slot + i, Kind: EntryInvalid, Err: err})
+			continue
+		}
+		if e.Offset < end {
+			errs = multierror.Append(errs, &EntryError{Slot: slot + i, Kind: EntryMisplaced,
+				Err: xerrors.Errorf("%w: starts at %d, previous entry ends at %d",
+					layoutError("entry overlaps or precedes the previous entry"), e.Offset, end)})
+		}
+		entryEnd, ok := util.CheckedAdd(e.Offset, e.Size)
+		if !ok || entryEnd > indexAreaStart {
+			errs = multierror.Append(errs, &EntryError{Slot: slot + i, Kind: EntryMisplaced,
+				Err: xerrors.Errorf("%w: %d + %d > %d",
+					layoutError("entry collides with the index area"), e.Offset, e.Size, indexAreaStart)})
+			continue
+		}
+		c, err := e.PieceCIDErr()
+		if err != nil {
+			errs = multierror.Append(errs, &EntryError{Slot: slot + i, Kind: EntryInvalid, Err: err})
+			continue
+		}
+		end = max(end, entryEnd)
+		cl[i] = e.CommAndLoc()
+		subdeals[i] = abi.PieceInfo{Size: e.PaddedSize(), PieceCID: c}
+	}
+	if errs != nil {
+		return nil, xerrors.Errorf("invalid index: %w", errs)
 	}
 
-	return &agg, nil
+	if options.rejectDuplicates {
+		seen := make(map[abi.PieceInfo]int, len(subdeals))
+		for i, sd := range subdeals {
+			if sd == (abi.PieceInfo{}) {
+				continue
+			}
+			if j, ok := seen[sd]; ok {
+				return nil, xerrors.Errorf("entry %d is a duplicate of entry %d: %s", i, j, sd.PieceCID)
+			}
+			seen[sd] = i
+		}
+	}
+	if options.rawSizes != nil {
+		if len(options.rawSizes) != len(subdeals) {
+			return nil, xerrors.Errorf("number of raw sizes doesn't match number of entries: %d != %d",
+				len(options.rawSizes), len(subdeals))
+		}
+		var present []abi.PieceInfo
+		var rawSizes []uint64
+		for i, sd := range subdeals {
+			if sd == (abi.PieceInfo{}) {
+				if options.rawSizes[i] != 0 {
+					return nil, xerrors.Errorf("raw size of empty entry %d is not zero: %d", i, options.rawSizes[i])
+				}
+				continue
+			}
+			present = append(present, sd)
+			rawSizes = append(rawSizes, options.rawSizes[i])
+		}
+		if _, err := applyRawSizes(present, rawSizes); err != nil {
+			return nil, xerrors.Errorf("applying raw sizes: %w", err)
+		}
+	}
+
+	agg, err := newAggregateFromCommLoc(dealSize, cl, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.rawSizes != nil {
+		agg.RawSizes = append([]uint64(nil), options.rawSizes...)
+	}
+	return agg, nil
+}
+
+type ambiguousPieceError string
+
+// ErrAmbiguousPiece is returned by ProofForPieceInfo when the piece is present in the Aggregate
+// more than once, use ProofForPieceInfoAt or ProofForPieceInfoAtOffset to select the instance
+var ErrAmbiguousPiece = ambiguousPieceError("unknown")
+
+func (ape ambiguousPieceError) Error() string {
+	return string(ape)
+}
+
+func (ape ambiguousPieceError) Is(err error) bool {
+	_, ok := err.(ambiguousPieceError)
+	return ok
+}
+
+type layoutError string
+
+// ErrInvalidLayout is returned by AggregateObjectReader and the readers built on it when the
+// entries of the index don't describe a layout of the deal: overlapping or out of order
+// entries, entries exceeding the data area or an index larger than the index area.
+var ErrInvalidLayout = layoutError("unknown")
+
+func (le layoutError) Error() string {
+	return string(le)
+}
+
+func (le layoutError) Is(err error) bool {
+	_, ok := err.(layoutError)
+	return ok
 }
 
 // ProofForPieceInfo searches for piece within the Aggregate based on PieceInfo and gathers all the
 // information required to produce a proof.
+// If the piece is present multiple times, ErrAmbiguousPiece is returned.
 func (a Aggregate) ProofForPieceInfo(d abi.PieceInfo) (*InclusionProof, error) {
-	comm, err := commcid.CIDToPieceCommitmentV1(d.PieceCID)
+	if a.ProofStore != nil {
+		return a.cachedProofForPieceInfo(d)
+	}
+	return a.uniqueProofForPieceInfo(d)
+}
+
+// uniqueProofForPieceInfo produces the proof of the only occurrence of the piece
+func (a Aggregate) uniqueProofForPieceInfo(d abi.PieceInfo) (*InclusionProof, error) {
+	indexes, err := a.indexEntriesFor(d)
 	if err != nil {
-		return nil, xerrors.Errorf("convering cid to commitment: %w", err)
+		return nil, err
 	}
-	index := -1
-	for i, ie := range a.Index.Entries {
-		if bytes.Equal(ie.CommDs[:], comm) && ie.Size == uint64(d.Size) {
-			index = i
-			break
+	if len(indexes) > 1 {
+		return nil, xerrors.Errorf("%w: %s is present %d times", ambiguousPieceError("piece is present more than once"),
+			d.PieceCID, len(indexes))
+	}
+	return a.ProofForPieceInfoAt(d, 0)
+}
+
+// ProofForPieceInfoAtOffset produces the proof for the instance of the piece starting at
+// unpaddedOffset bytes into the deal, see SegmentDesc.UnpaddedOffest.
+func (a Aggregate) ProofForPieceInfoAtOffset(d abi.PieceInfo, unpaddedOffset uint64) (*InclusionProof, error) {
+	indexes, err := a.indexEntriesFor(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		if a.Index.Entries[idx].UnpaddedOffest() == unpaddedOffset {
+			return a.ProofForIndexEntry(idx)
 		}
 	}
-	if index == -1 {
+	return nil, xerrors.Errorf("entry for a piece with this PieceInfo was not found at offset %d in Aggregate", unpaddedOffset)
+}
+
+// ProofForPieceInfoAt produces the proof for the given occurrence (counted from 0 in the order
+// of the index) of the piece within the Aggregate.
+func (a Aggregate) ProofForPieceInfoAt(d abi.PieceInfo, occurrence int) (*InclusionProof, error) {
+	indexes, err := a.indexEntriesFor(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
+		return nil, xerrors.Errorf("entry for a piece with this PieceInfo was not found in Aggregate")
+	}
+	if occurrence < 0 || occurrence >= len(indexes) {
+		return nil, xerrors.Errorf("occurrence %d of the piece requested but it is present %d times",
+			occurrence, len(indexes))
+	}
+
+	return a.ProofForIndexEntry(indexes[occurrence])
+}
+
+// ProofsForPieceInfo produces proofs for all occurrences of the piece within the Aggregate,
+// in the order of the index.
+func (a Aggregate) ProofsForPieceInfo(d abi.PieceInfo) ([]*InclusionProof, error) {
+	indexes, err := a.indexEntriesFor(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) == 0 {
 		return nil, xerrors.Errorf("entry for a piece with this PieceInfo was not found in Aggregate")
 	}
 
-	return a.ProofForIndexEntry(index)
+	res := make([]*InclusionProof, len(indexes))
+	for i, idx := range indexes {
+		res[i], err = a.ProofForIndexEntry(idx)
+		if err != nil {
+			return nil, xerrors.Errorf("occurrence %d: %w", i, err)
+		}
+	}
+	return res, nil
+}
+
+// indexEntriesFor returns positions of all index entries matching the PieceInfo
+func (a Aggregate) indexEntriesFor(d abi.PieceInfo) ([]int, error) {
+	if len(a.Index.Entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	comm, err := commcid.CIDToPieceCommitmentV1(d.PieceCID)
+	if err != nil {
+		return nil, xerrors.Errorf("convering cid to commitment: %w", err)
+	}
+	var res []int
+	for i, ie := range a.Index.Entries {
+		if bytes.Equal(ie.CommDs[:], comm) && ie.PaddedSize() == d.Size {
+			res = append(res, i)
+		}
+	}
+	return res, nil
 }
 
 // ProofForIndexEntry gathers information required to produce an InclusionProof based on the index
 // of data within the DataSegment Index.
 func (a Aggregate) ProofForIndexEntry(idx int) (*InclusionProof, error) {
+	if len(a.Index.Entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	if idx < 0 || idx >= len(a.Index.Entries) {
+		return nil, xerrors.Errorf("index entry %d out of range, the index has %d entries", idx, len(a.Index.Entries))
+	}
 	e := a.Index.Entries[idx]
+	if e == (SegmentDesc{}) {
+		return nil, xerrors.Errorf("index entry %d is empty", idx)
+	}
 	commLoc := e.CommAndLoc()
-	ip, err := CollectInclusionProof(&a.Tree, a.DealSize, commLoc, idx)
+	if a.IndexHeader {
+		// the header precedes the entries in the index area
+		idx++
+	}
+	ip, err := collectInclusionProof(&a.Tree, a.indexAreaStart(), commLoc, idx)
 	if err != nil {
 		return nil, xerrors.Errorf("collecting inclusion proof: %w", err)
 	}
@@ -123,8 +584,43 @@ func (a Aggregate) PieceCID() (cid.Cid, error) {
 	return commcid.PieceCommitmentV1ToCID(n[:])
 }
 
+// String summarises the Aggregate with its PieceCID, deal size, number of segments and index
+// capacity. The format is stable.
+func (a Aggregate) String() string {
+	c, err := a.PieceCID()
+	if err != nil {
+		return fmt.Sprintf("Aggregate{DealSize: %d, Segments: %d, IndexCapacity: %d}",
+			a.DealSize, len(a.Index.Entries), a.indexCapacity())
+	}
+	return fmt.Sprintf("Aggregate{PieceCID: %s, DealSize: %d, Segments: %d, IndexCapacity: %d}",
+		c, a.DealSize, len(a.Index.Entries), a.indexCapacity())
+}
+
+// indexCapacity returns the number of entries the index area can hold
+func (a Aggregate) indexCapacity() uint {
+	if a.IndexCapacity != 0 {
+		return a.IndexCapacity
+	}
+	return MaxIndexEntriesInDeal(a.DealSize)
+}
+
+// indexAreaStart returns the start of the index area in padded bytes
+func (a Aggregate) indexAreaStart() uint64 {
+	return indexAreaStartForCapacity(a.DealSize, a.indexCapacity())
+}
+
+// indexAreaEntries returns the entries as they are laid out in the index area,
+// including the header if present
+func (a Aggregate) indexAreaEntries() []SegmentDesc {
+	if !a.IndexHeader {
+		return a.Index.Entries
+	}
+	header := IndexHeader{Version: IndexHeaderVersion, Entries: uint64(len(a.Index.Entries))}
+	return append([]SegmentDesc{header.SegmentDesc()}, a.Index.Entries...)
+}
+
 func (a Aggregate) indexLoc() merkletree.Location {
-	level := util.Log2Ceil(EntrySize / merkletree.NodeSize * uint64(MaxIndexEntriesInDeal(a.DealSize)))
+	level := util.Log2Ceil(EntrySize / merkletree.NodeSize * uint64(a.indexCapacity()))
 	index := uint64(1)<<(a.Tree.MaxLevel()-level) - 1
 	return merkletree.Location{Level: level, Index: index}
 }
@@ -139,9 +635,275 @@ func (a Aggregate) IndexPieceCID() (cid.Cid, error) {
 	return commcid.PieceCommitmentV1ToCID(n[:])
 }
 
+// VerifyIndexConsistency checks that the serialized index agrees with the tree of the Aggregate:
+// the commP of the bytes produced by IndexReader has to equal IndexPieceCID, and the index area
+// described by IndexStartPosition and IndexSize has to be the subtree of the index, ending the
+// deal and, for the default capacity, starting at DataSegmentIndexStartOffset.
+// It catches placement and serialization drift between writing the index and proving it.
+func (a Aggregate) VerifyIndexConsistency() error {
+	start, err := a.IndexStartPosition()
+	if err != nil {
+		return xerrors.Errorf("getting index start: %w", err)
+	}
+	size, err := a.IndexSize()
+	if err != nil {
+		return xerrors.Errorf("getting index size: %w", err)
+	}
+	if a.IndexCapacity == 0 && start != DataSegmentIndexStartOffset(a.DealSize) {
+		return xerrors.Errorf("index start doesn't match the parsing offset: %d != %d",
+			start, DataSegmentIndexStartOffset(a.DealSize))
+	}
+	startPadded := abi.UnpaddedPieceSize(start).Padded()
+	if uint64(startPadded)+uint64(size) != uint64(a.DealSize) {
+		return xerrors.Errorf("index area doesn't end the deal: %d + %d != %d", startPadded, size, a.DealSize)
+	}
+	loc := a.indexLoc()
+	if loc.ByteOffset() != uint64(startPadded) || LocationPaddedSize(loc) != size {
+		return xerrors.Errorf("index subtree at %d of size %d doesn't match the index area at %d of size %d",
+			loc.ByteOffset(), loc.Size(), startPadded, size)
+	}
+
+	r, err := a.IndexReader()
+	if err != nil {
+		return xerrors.Errorf("getting index reader: %w", err)
+	}
+	cp := &commp.Calc{}
+	if _, err := io.CopyBuffer(cp, r, make([]byte, cp.BlockSize()*128)); err != nil {
+		return xerrors.Errorf("hashing index: %w", err)
+	}
+	comm, paddedSize, err := cp.Digest()
+	if err != nil {
+		return xerrors.Errorf("computing commP of index: %w", err)
+	}
+	if paddedSize != uint64(size) {
+		return xerrors.Errorf("serialized index size doesn't match: %d != %d", paddedSize, size)
+	}
+	serialized, err := commcid.PieceCommitmentV1ToCID(comm)
+	if err != nil {
+		return xerrors.Errorf("converting commP of index: %w", err)
+	}
+	expected, err := a.IndexPieceCID()
+	if err != nil {
+		return err
+	}
+	if !serialized.Equals(expected) {
+		return xerrors.Errorf("serialized index %s doesn't match the index in the tree %s", serialized, expected)
+	}
+	return nil
+}
+
+// ComputeIndexPieceCID computes the PieceCID of the index, equal to Aggregate.IndexPieceCID,
+// of a deal of dealSize containing the entries. Only the subtree of the index area is built.
+// WithIndexCapacity and WithIndexHeader have to match the options the Aggregate was created with.
+func ComputeIndexPieceCID(entries []SegmentDesc, dealSize abi.PaddedPieceSize, opts ...AggregateOption) (cid.Cid, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := ValidateDealSize(dealSize); err != nil {
+		return cid.Undef, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return cid.Undef, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+	}
+	a := Aggregate{
+		DealSize:      dealSize,
+		Index:         IndexData{Entries: entries},
+		IndexCapacity: options.indexCapacity,
+		IndexHeader:   options.indexHeader,
+	}
+	root, err := a.indexAreaRoot()
+	if err != nil {
+		return cid.Undef, err
+	}
+	return commcid.PieceCommitmentV1ToCID(root[:])
+}
+
+// entriesIntoNodes serializes the entries into the leaf nodes of the index area
+func entriesIntoNodes(entries []SegmentDesc) []merkletree.Node {
+	res := make([]merkletree.Node, 0, len(entries)*EntrySize/merkletree.NodeSize)
+	for _, e := range entries {
+		ns := e.IntoNodes()
+		res = append(res, ns[:]...)
+	}
+	return res
+}
+
+// indexAreaRoot computes the root of the subtree of the index area holding the entries of
+// the Aggregate, without using its tree. The DealSize and the IndexCapacity should be
+// validated beforehand.
+func (a Aggregate) indexAreaRoot() (merkletree.Node, error) {
+	entries := a.indexAreaEntries()
+	if uint(len(entries)) > a.indexCapacity() {
+		return merkletree.Node{}, xerrors.Errorf("too many entries for a %d sized deal: %d > %d",
+			a.DealSize, len(entries), a.indexCapacity())
+	}
+
+	ht, err := merkletree.NewHybrid(util.Log2Ceil(uint64(a.indexCapacity()) * EntrySize / merkletree.NodeSize))
+	if err != nil {
+		return merkletree.Node{}, xerrors.Errorf("creating index tree: %w", err)
+	}
+	indexNodes := entriesIntoNodes(entries)
+	if err := ht.SetLeafRange(0, indexNodes); err != nil {
+		return merkletree.Node{}, xerrors.Errorf("setting index nodes: %w", err)
+	}
+	return ht.Root(), nil
+}
+
+// ComputeDealCommP computes the PieceCID of the deal, including the index, which NewAggregate
+// would create from the subdeals with the same options. WithIndexCapacity, WithIndexHeader,
+// WithRawSizes and WithSegmentMetadata change the deal and are taken into account.
+// Only the root is computed, the tree of the deal is not built.
+func ComputeDealCommP(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo, opts ...AggregateOption) (cid.Cid, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	return computeDealCommP(dealSize, subdeals, true, options)
+}
+
+// ComputeDealCommPWithoutIndex computes the PieceCID of the deal with the subdeals placed as
+// NewAggregate would place them, but without the data segment index, as in plain aggregation.
+// The subdeals can use the whole deal.
+func ComputeDealCommPWithoutIndex(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo) (cid.Cid, error) {
+	return computeDealCommP(dealSize, subdeals, false, aggregateOptions{})
+}
+
+func computeDealCommP(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo, withIndex bool, options aggregateOptions) (cid.Cid, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return cid.Undef, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if len(subdeals) == 0 {
+		return cid.Undef, ErrNoSubdeals
+	}
+	if options.rawSizes != nil {
+		var err error
+		subdeals, err = applyRawSizes(subdeals, options.rawSizes)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("applying raw sizes: %w", err)
+		}
+	}
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return cid.Undef, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+	}
+	cl, totalSize, err := ComputeDealPlacement(subdeals)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("computing deal placment: %w", err)
+	}
+	// the Aggregate NewAggregate would create, without its tree
+	agg := Aggregate{DealSize: dealSize, IndexCapacity: options.indexCapacity, IndexHeader: options.indexHeader}
+	available := uint64(dealSize)
+	if withIndex {
+		available = agg.indexAreaStart()
+	}
+	if totalSize > available {
+		return cid.Undef, xerrors.Errorf("sub-deals are too large to fit in the deal: %d > %d",
+			totalSize, available)
+	}
+
+	maxLevel := util.Log2Ceil(uint64(dealSize / merkletree.NodeSize))
+	if withIndex {
+		index, err := MakeIndexFromCommLoc(cl)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("creating index: %w", err)
+		}
+		agg.Index = *index
+		indexRoot, err := agg.indexAreaRoot()
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("computing index root: %w", err)
+		}
+		level := util.Log2Ceil(uint64(agg.indexCapacity()) * EntrySize / merkletree.NodeSize)
+		cl = append(cl, merkletree.CommAndLoc{
+			Comm: indexRoot,
+			Loc:  merkletree.Location{Level: level, Index: uint64(1)<<(maxLevel-level) - 1},
+		})
+		if options.metadata != nil {
+			metadataNodes, err := agg.metadataNodes(options.metadata)
+			if err != nil {
+				return cid.Undef, xerrors.Errorf("attaching metadata: %w", err)
+			}
+			cl = append(cl, metadataNodes...)
+		}
+	}
+
+	root, err := merkletree.ComputeSparseRoot(maxLevel, cl)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("computing root: %w", err)
+	}
+	return commcid.PieceCommitmentV1ToCID(root[:])
+}
+
+// SelfCheck checks the invariants of the Aggregate without rebuilding the tree: every index
+// entry, except the ones emptied by TombstoneEntry, has to pass validation and the root of the
+// tree has to equal the root recomputed from the placements of the entries and the leaves of
+// the index area. It is cheap compared to building the Aggregate and is meant to be run on
+// persisted state after loading it, to catch corruption before serving proofs.
+func (a Aggregate) SelfCheck() error {
+	for i, e := range a.Index.Entries {
+		if e == (SegmentDesc{}) {
+			continue
+		}
+		if err := e.Validate(); err != nil {
+			return xerrors.Errorf("entry %d: %w", i, err)
+		}
+	}
+	if expected := util.Log2Ceil(uint64(a.DealSize / merkletree.NodeSize)); a.Tree.MaxLevel() != expected {
+		return xerrors.Errorf("tree depth doesn't match deal size: %d != %d", a.Tree.MaxLevel(), expected)
+	}
+
+	indexStart, err := indexEntryLocation(a.indexAreaStart(), 0)
+	if err != nil {
+		return xerrors.Errorf("locating index area: %w", err)
+	}
+	indexNodes := entriesIntoNodes(a.indexAreaEntries())
+	nodes := make([]merkletree.CommAndLoc, 0, len(a.Index.Entries)+len(indexNodes))
+	for _, e := range a.Index.Entries {
+		if e == (SegmentDesc{}) {
+			continue
+		}
+		nodes = append(nodes, e.CommAndLoc())
+	}
+	if a.Metadata != nil {
+		layout, err := a.metadataLayout()
+		if err != nil {
+			return xerrors.Errorf("metadata: %w", err)
+		}
+		metadataNodes, err := layout.nodes()
+		if err != nil {
+			return xerrors.Errorf("metadata: %w", err)
+		}
+		nodes = append(nodes, metadataNodes...)
+	}
+	for i, n := range indexNodes {
+		nodes = append(nodes, merkletree.CommAndLoc{
+			Comm: n,
+			Loc:  merkletree.Location{Level: 0, Index: indexStart.LeafIndex() + uint64(i)},
+		})
+	}
+	root, err := merkletree.ComputeSparseRoot(a.Tree.MaxLevel(), nodes)
+	if err != nil {
+		return xerrors.Errorf("recomputing root: %w", err)
+	}
+	if actual := a.Tree.Root(); root != actual {
+		return xerrors.Errorf("root of the tree doesn't match the entries: %x != %x", actual, root)
+	}
+	if err := a.VerifyTombstones(); err != nil {
+		return xerrors.Errorf("verifying tombstones: %w", err)
+	}
+	return nil
+}
+
 // IndexReader returns a reader for the index containing unpadded bytes of the index
 func (a Aggregate) IndexReader() (io.Reader, error) {
-	b, err := a.Index.MarshalBinary()
+	if len(a.Index.Entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+	b, err := IndexData{Entries: a.indexAreaEntries()}.MarshalBinary()
 	if err != nil {
 		return nil, xerrors.Errorf("marshaling index: %w", err)
 	}
@@ -155,9 +917,15 @@ func (a Aggregate) IndexReader() (io.Reader, error) {
 	bNoPad := make([]byte, len(b)-len(b)/128)
 	fr32.Unpad(bNoPad, b)
 
-	unpaddedIndexSize := int64(MaxIndexEntriesInDeal(a.DealSize) * EntrySize)
-	unpaddedIndexSize = unpaddedIndexSize - unpaddedIndexSize/128
-	paddingSize := unpaddedIndexSize - int64(len(bNoPad))
+	indexSize, err := a.IndexSize()
+	if err != nil {
+		return nil, err
+	}
+	paddingSize := int64(indexSize.Unpadded()) - int64(len(bNoPad))
+	if paddingSize < 0 {
+		return nil, xerrors.Errorf("%w: %d entries, index capacity is %d",
+			layoutError("index doesn't fit the index area"), len(a.indexAreaEntries()), a.indexCapacity())
+	}
 
 	return io.MultiReader(bytes.NewReader(bNoPad), io.LimitReader(zeroReader{}, paddingSize)), nil
 }
@@ -165,11 +933,12 @@ func (a Aggregate) IndexReader() (io.Reader, error) {
 // IndexStartPosition returns the expected starting position where the index should be placed
 // in the unpadded units
 func (a Aggregate) IndexStartPosition() (uint64, error) {
-	return DataSegmentIndexStartOffset(a.DealSize), nil
+	start := a.indexAreaStart()
+	return start - start/128, nil
 }
 
 func (a Aggregate) IndexSize() (abi.PaddedPieceSize, error) {
-	size := abi.PaddedPieceSize(uint64(MaxIndexEntriesInDeal(a.DealSize)) * EntrySize)
+	size := abi.PaddedPieceSize(uint64(a.indexCapacity()) * EntrySize)
 	if err := size.Validate(); err != nil {
 		return abi.PaddedPieceSize(1<<64 - 1), xerrors.Errorf("validating index size %v, report this: %w", size, err)
 	}
@@ -179,60 +948,126 @@ func (a Aggregate) IndexSize() (abi.PaddedPieceSize, error) {
 // AggregateStreamReader creates a reader for the whole aggregate, including the index.
 // The subPieceReaders should be passed in the same order as subdeals in the construction call
 // of the Aggregate.
-// AggregateStreamReader assumes a non-manipulated Index as created by the Aggregate constructor.
+// Entries which overlap, are out of order or exceed the data area of the deal are rejected
+// with ErrInvalidLayout.
 func (a Aggregate) AggregateObjectReader(subPieceReaders []io.Reader) (io.Reader, error) {
+	return a.aggregateObjectReader(subPieceReaders, false)
+}
+
+// AggregateObjectReaderStrict is like AggregateObjectReader but each sub-piece reader is limited
+// to the unpadded length of its entry: the remainder is zero-filled and reading fails if
+// a sub-piece reader yields more bytes than its entry can hold.
+// If the Aggregate has RawSizes, each sub-piece reader has to yield exactly the raw size of
+// its sub-piece, otherwise the unpadded piece length is the bound.
+func (a Aggregate) AggregateObjectReaderStrict(subPieceReaders []io.Reader) (io.Reader, error) {
+	return a.aggregateObjectReader(subPieceReaders, true)
+}
+
+// PaddedObjectReader is like AggregateObjectReader but produces the Fr32 padded bytes of
+// the whole aggregate, exactly DealSize bytes.
+func (a Aggregate) PaddedObjectReader(subPieceReaders []io.Reader) (io.Reader, error) {
+	r, err := a.AggregateObjectReader(subPieceReaders)
+	if err != nil {
+		return nil, err
+	}
+	return fr32.NewPadReader(r), nil
+}
+
+func (a Aggregate) aggregateObjectReader(subPieceReaders []io.Reader, strict bool) (io.Reader, error) {
 	if len(subPieceReaders) != len(a.Index.Entries) {
 		return nil, xerrors.Errorf("passed different number of subPieceReaders than subPieces: %d != %d", len(subPieceReaders), len(a.Index.Entries))
 	}
+	if strict && a.RawSizes != nil && len(a.RawSizes) != len(a.Index.Entries) {
+		return nil, xerrors.Errorf("number of raw sizes doesn't match number of entries: %d != %d",
+			len(a.RawSizes), len(a.Index.Entries))
+	}
 	readers := []io.Reader{}
 	add := func(r ...io.Reader) {
 		readers = append(readers, r...)
 	}
 
-	offset := int64(0)
-	addPiece := func(r io.Reader, targetOffset, targetLength int64) error {
+	indexStart, err := a.IndexStartPosition()
+	if err != nil {
+		return nil, err
+	}
+	offset := uint64(0)
+	addPiece := func(r io.Reader, targetOffset, targetLength, limit uint64) error {
 		if offset > targetOffset {
-			return xerrors.Errorf("current aggregate offset is greater"+
-				" than expected offset from the index. %d > %d", offset, targetOffset)
+			return xerrors.Errorf("%w: current aggregate offset is greater"+
+				" than expected offset from the index. %d > %d",
+				layoutError("overlapping or out of order entries"), offset, targetOffset)
+		}
+		end, ok := util.CheckedAdd(targetOffset, targetLength)
+		if !ok || end > limit {
+			return xerrors.Errorf("%w: %d + %d > %d",
+				layoutError("entry exceeds the data area of the deal"), targetOffset, targetLength, limit)
 		}
 		if offset != targetOffset {
 			add(io.LimitReader(zeroReader{}, int64(targetOffset-offset)))
 		}
 
-		// NOTE: maybe some kind of check that the `r` was exhausted
+		if strict {
+			r = &exactLimitReader{r: r, n: int64(targetLength)}
+		}
 		add(io.LimitReader(io.MultiReader(r, zeroReader{}), int64(targetLength)))
-		offset = targetOffset + targetLength
+		offset = end
 		return nil
 	}
 
 	var errs error
 	for i := 0; i < len(subPieceReaders); i++ {
 		spEntry := a.Index.Entries[i]
+		if spEntry == (SegmentDesc{}) {
+			// tombstoned, the range is zero-filled
+			continue
+		}
+		if err := spEntry.CheckSizes(); err != nil {
+			errs = multierror.Append(errs, xerrors.Errorf("subpiece %d: %w: %s",
+				i, layoutError("invalid entry"), err))
+			continue
+		}
 		spOffset := spEntry.UnpaddedOffest()
 		spLen := spEntry.UnpaddedLength()
 
-		if err := addPiece(subPieceReaders[i], int64(spOffset), int64(spLen)); err != nil {
+		r := subPieceReaders[i]
+		if strict && a.RawSizes != nil {
+			if a.RawSizes[i] > spLen {
+				errs = multierror.Append(errs, xerrors.Errorf("subpiece %d: raw size doesn't fit in the segment: %d > %d",
+					i, a.RawSizes[i], spLen))
+				continue
+			}
+			r = &exactLimitReader{r: r, n: int64(a.RawSizes[i]), exact: true}
+		}
+		if err := addPiece(r, spOffset, spLen, indexStart); err != nil {
 			errs = multierror.Append(errs, xerrors.Errorf("subpiece %d: %w", i, err))
 		}
 	}
 
+	if a.Metadata != nil {
+		layout, err := a.metadataLayout()
+		if err != nil {
+			return nil, xerrors.Errorf("metadata: %w", err)
+		}
+		metadataStart := uint64(abi.PaddedPieceSize(layout.recordOffset).Unpadded())
+		if err := addPiece(bytes.NewReader(layout.unpadded()), metadataStart,
+			indexStart-metadataStart, indexStart); err != nil {
+			errs = multierror.Append(errs, xerrors.Errorf("metadata: %w", err))
+		}
+	}
+
 	{
 		var indexErrs error
 		indexReader, err := a.IndexReader()
 		if err != nil {
 			indexErrs = multierror.Append(indexErrs, err)
 		}
-		indexStart, err := a.IndexStartPosition()
-		if err != nil {
-			indexErrs = multierror.Append(indexErrs, err)
-		}
 		indexLength, err := a.IndexSize()
 		if err != nil {
 			indexErrs = multierror.Append(indexErrs, err)
 		}
 		if indexErrs == nil {
-			if err := addPiece(indexReader,
-				int64(indexStart), int64(indexLength.Unpadded())); err != nil {
+			if err := addPiece(indexReader, indexStart, uint64(indexLength.Unpadded()),
+				uint64(a.DealSize.Unpadded())); err != nil {
 				errs = multierror.Append(errs, err)
 			}
 		} else {
@@ -252,25 +1087,81 @@ func (a Aggregate) AggregateObjectReader(subPieceReaders []io.Reader) (io.Reader
 // also returns number of bytes required and any errors
 func ComputeDealPlacement(dealInfos []abi.PieceInfo) ([]merkletree.CommAndLoc, uint64, error) {
 	res := make([]merkletree.CommAndLoc, len(dealInfos))
-	offset := uint64(0)
+	offset := uint64(0) // in padded bytes
 	for i, di := range dealInfos {
 		if err := di.Size.Validate(); err != nil {
 			return nil, 0, xerrors.Errorf("subpiece %d: size doesn't validate: %w", i, err)
 		}
-		sizeInNodes := uint64(di.Size) / merkletree.NodeSize
 		comm, err := commcid.CIDToPieceCommitmentV1(di.PieceCID)
 		if err != nil {
 			return nil, 0, xerrors.Errorf("converting to piece commitment: %w", err)
 		}
 		res[i].Comm = *(*merkletree.Node)(comm)
 
-		res[i].Loc.Level = util.Log2Ceil(sizeInNodes)     // level is log2(sizeInNodes)
-		index := (offset + sizeInNodes - 1) / sizeInNodes // idx is ceil(offset/sizeInNodes)
-		res[i].Loc.Index = index
+		size := uint64(di.Size)
+		start, end, ok := alignedPlacement(offset, size)
+		if !ok {
+			return nil, 0, xerrors.Errorf("subpiece %d: placement overflows: offset %d, size %d", i, offset, size)
+		}
+		res[i].Loc, err = merkletree.LocationForOffsetSize(start, size)
+		if err != nil {
+			return nil, 0, xerrors.Errorf("subpiece %d: computing location: %w", i, err)
+		}
+		offset = end
+	}
+	return res, offset, nil
+}
+
+// alignedPlacement places a range of size bytes at the first offset aligned to size
+// which is not smaller than offset. It returns false if the range would overflow.
+// The size has to be a power of two.
+func alignedPlacement(offset, size uint64) (start, end uint64, ok bool) {
+	start, ok = util.CheckedAdd(offset, size-1)
+	if !ok {
+		return 0, 0, false
+	}
+	start &^= size - 1
+	end, ok = util.CheckedAdd(start, size)
+	return start, end, ok
+}
 
-		offset = (index + 1) * sizeInNodes // select the next index at ni.lvl and go back to nodewise
+// exactLimitReader reads at most n bytes from r and fails if r has more data available
+type exactLimitReader struct {
+	r io.Reader
+	n int64
+	// exact also rejects readers yielding fewer than n bytes
+	exact bool
+}
+
+func (l *exactLimitReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, l.checkExhausted()
+	}
+	if int64(len(b)) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.r.Read(b)
+	l.n -= int64(n)
+	if l.exact && l.n > 0 && errors.Is(err, io.EOF) {
+		return n, xerrors.Errorf("sub-piece reader yields less data than its raw size, %d bytes missing", l.n)
+	}
+	if l.n == 0 && err == nil {
+		// the consumer might not read again once it got all the bytes it expects
+		err = l.checkExhausted()
+	}
+	return n, err
+}
+
+func (l *exactLimitReader) checkExhausted() error {
+	var probe [1]byte
+	n, err := io.ReadFull(l.r, probe[:])
+	if n != 0 {
+		return xerrors.Errorf("sub-piece reader yields more data than the piece can hold")
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
 	}
-	return res, offset * merkletree.NodeSize, nil
+	return io.EOF
 }
 
 type zeroReader struct{}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+)
+
+// FuzzAggregateObjectReader manipulates an entry of the index of a valid Aggregate, with
+// the checksum updated, and checks that AggregateObjectReader either fails with
+// ErrInvalidLayout or produces exactly the unpadded deal, whose CommP doesn't match the
+// original one unless the index is unchanged.
+func FuzzAggregateObjectReader(f *testing.F) {
+	dealSize := abi.PaddedPieceSize(32 << 10)
+	a, pieces, err := GenerateRandomAggregate(4153, dealSize, 3)
+	if err != nil {
+		f.Fatal(err)
+	}
+	original := Must(a.PieceCID())
+
+	for i, e := range a.Index.Entries {
+		f.Add(uint8(i), e.Offset, e.Size, uint8(i))
+	}
+	// overlapping the previous entry
+	f.Add(uint8(1), a.Index.Entries[0].Offset, a.Index.Entries[1].Size, uint8(1))
+	// out of order
+	f.Add(uint8(0), a.Index.Entries[0].Offset, a.Index.Entries[0].Size, uint8(2))
+	// exceeding the data area and the deal
+	f.Add(uint8(2), a.indexAreaStart()-128, uint64(256), uint8(2))
+	f.Add(uint8(2), uint64(dealSize), uint64(128), uint8(2))
+	// overflowing
+	f.Add(uint8(2), uint64(1)<<63, uint64(1)<<63, uint8(2))
+	f.Add(uint8(2), uint64(1<<64-128), uint64(128), uint8(2))
+	// not a valid piece size
+	f.Add(uint8(2), a.Index.Entries[2].Offset, uint64(384), uint8(2))
+
+	f.Fuzz(func(t *testing.T, i uint8, offset, size uint64, swap uint8) {
+		n := len(a.Index.Entries)
+		entries := append([]SegmentDesc(nil), a.Index.Entries...)
+		e := &entries[int(i)%n]
+		e.Offset, e.Size = offset, size
+		*e = e.withUpdatedChecksum()
+		j := int(swap) % n
+		entries[int(i)%n], entries[j] = entries[j], entries[int(i)%n]
+		mutated := *a
+		mutated.Index = IndexData{Entries: entries}
+
+		readers := make([]io.Reader, n)
+		for k, p := range pieces {
+			readers[k] = p.Reader()
+		}
+		r, err := mutated.AggregateObjectReader(readers)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidLayout) {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			return
+		}
+
+		expected := int64(dealSize.Unpadded())
+		cp := commp.Calc{}
+		read, err := io.Copy(&cp, io.LimitReader(r, expected+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read != expected {
+			t.Fatalf("object has %d bytes instead of %d", read, expected)
+		}
+		digest, _, err := cp.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := false
+		for k := range entries {
+			changed = changed || entries[k] != a.Index.Entries[k]
+		}
+		if changed && Must(commcid.PieceCommitmentV1ToCID(digest)) == original {
+			t.Fatalf("manipulated index matches the original CommP")
+		}
+	})
+}
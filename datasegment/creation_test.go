@@ -1,13 +1,17 @@
 package datasegment
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"testing"
 
+	"github.com/filecoin-project/go-data-segment/fr32"
+	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/filecoin-project/go-data-segment/verifylite"
 
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	commp "github.com/filecoin-project/go-fil-commp-hashhash"
@@ -52,6 +56,14 @@ func TestAggregateCreation(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, a.Index.Entries, parsedValidEntries)
 	})
+	t.Run("index is parsed for deal", func(t *testing.T) {
+		ir, err := a.IndexReader()
+		assert.NoError(t, err)
+		parsedIndex, err := ParseDataSegmentIndexForDeal(dealSize, io.MultiReader(ir, zeroReader{}))
+		assert.NoError(t, err)
+		assert.Len(t, parsedIndex.Entries, int(MaxIndexEntriesInDeal(dealSize)))
+		assert.Equal(t, a.Index.Entries, Must(parsedIndex.ValidEntries()))
+	})
 
 	for _, pi := range subPieceInfos {
 		ip, err := a.ProofForPieceInfo(pi)
@@ -100,6 +112,94 @@ func TestAggregateObjectReader(t *testing.T) {
 
 }
 
+func TestPaddedObjectReader(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 256},
+		{PieceCID: cidForDeal(1), Size: 128},
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	readers := func() []io.Reader {
+		return []io.Reader{
+			bytes.NewReader(bytes.Repeat([]byte{0xff}, 254)),
+			bytes.NewReader(bytes.Repeat([]byte{0x2}, 127)),
+		}
+	}
+
+	unpadded, err := io.ReadAll(Must(a.AggregateObjectReader(readers())))
+	require.NoError(t, err)
+	padded, err := io.ReadAll(Must(a.PaddedObjectReader(readers())))
+	require.NoError(t, err)
+	require.Len(t, padded, int(dealSize))
+
+	expected := make([]byte, dealSize)
+	fr32.Pad(unpadded, expected)
+	assert.Equal(t, expected, padded)
+}
+
+func TestAggregateObjectReaderStrict(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 256},
+		{PieceCID: cidForDeal(1), Size: 128},
+	}
+	a, err := NewAggregate(abi.PaddedPieceSize(1<<20), pieces)
+	require.NoError(t, err)
+
+	lenient, err := a.AggregateObjectReader([]io.Reader{
+		bytes.NewReader(bytes.Repeat([]byte{0x1}, 100)),
+		bytes.NewReader(bytes.Repeat([]byte{0x2}, 127)),
+	})
+	require.NoError(t, err)
+	strict, err := a.AggregateObjectReaderStrict([]io.Reader{
+		bytes.NewReader(bytes.Repeat([]byte{0x1}, 100)),
+		bytes.NewReader(bytes.Repeat([]byte{0x2}, 127)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Must(io.ReadAll(lenient)), Must(io.ReadAll(strict)))
+
+	strict, err = a.AggregateObjectReaderStrict([]io.Reader{
+		bytes.NewReader(bytes.Repeat([]byte{0x1}, 100)),
+		bytes.NewReader(bytes.Repeat([]byte{0x2}, 128)),
+	})
+	require.NoError(t, err)
+	_, err = io.ReadAll(strict)
+	assert.ErrorContains(t, err, "more data than the piece can hold")
+}
+
+func TestAggregateObjectReaderStrictRawSizes(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0)},
+		{PieceCID: cidForDeal(1)},
+	}
+	rawSizes := []uint64{1000, 3000}
+	a, err := NewAggregate(abi.PaddedPieceSize(1<<20), pieces, WithRawSizes(rawSizes))
+	require.NoError(t, err)
+
+	payloads := [][]byte{bytes.Repeat([]byte{0x1}, 1000), bytes.Repeat([]byte{0x2}, 3000)}
+	strict, err := a.AggregateObjectReaderStrict([]io.Reader{
+		bytes.NewReader(payloads[0]),
+		bytes.NewReader(payloads[1]),
+	})
+	require.NoError(t, err)
+	deal := Must(io.ReadAll(strict))
+	for i, e := range a.Index.Entries {
+		segment := deal[e.UnpaddedOffest() : e.UnpaddedOffest()+e.UnpaddedLength()]
+		assert.Equal(t, payloads[i], segment[:rawSizes[i]])
+		assert.Equal(t, make([]byte, len(segment)-int(rawSizes[i])), segment[rawSizes[i]:])
+	}
+
+	for _, payload := range [][]byte{payloads[1][:2999], append(payloads[1], 0x2)} {
+		strict, err = a.AggregateObjectReaderStrict([]io.Reader{
+			bytes.NewReader(payloads[0]),
+			bytes.NewReader(payload),
+		})
+		require.NoError(t, err)
+		_, err = io.ReadAll(strict)
+		assert.Error(t, err, "payload of %d bytes", len(payload))
+	}
+}
+
 func TestIndexCID(t *testing.T) {
 	pieceInfos := []abi.PieceInfo{
 		{
@@ -133,6 +233,170 @@ func TestIndexCID(t *testing.T) {
 	assert.Equal(t, indexCID, indexCID2)
 }
 
+func TestVerifyIndexConsistency(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 4096},
+		{PieceCID: cidForDeal(2), Size: 1024},
+	}
+	for _, dealSize := range []abi.PaddedPieceSize{DealSize8MiB, 1 << 30} {
+		capacity := WithIndexCapacity(4 * MaxIndexEntriesInDeal(dealSize))
+		for _, opts := range [][]AggregateOption{nil, {capacity}, {WithIndexHeader()}} {
+			a, err := NewAggregate(dealSize, pieces, opts...)
+			require.NoError(t, err)
+			assert.NoError(t, a.VerifyIndexConsistency(), "deal size %d", dealSize)
+		}
+	}
+
+	a, err := NewAggregate(DealSize8MiB, pieces)
+	require.NoError(t, err)
+	drifted := *a
+	drifted.Index.Entries = append([]SegmentDesc(nil), a.Index.Entries...)
+	drifted.Index.Entries[1].Offset += 4096
+	drifted.Index.Entries[1] = drifted.Index.Entries[1].withUpdatedChecksum()
+	assert.ErrorContains(t, drifted.VerifyIndexConsistency(), "doesn't match the index in the tree")
+
+	drifted = *a
+	drifted.IndexCapacity = 128
+	assert.Error(t, drifted.VerifyIndexConsistency())
+}
+
+func TestAggregateSelfCheck(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 4096},
+		{PieceCID: cidForDeal(2), Size: 1024},
+	}
+	capacity := WithIndexCapacity(4 * MaxIndexEntriesInDeal(DealSize8MiB))
+	for _, opts := range [][]AggregateOption{nil, {capacity}, {WithIndexHeader()}} {
+		a, err := NewAggregate(DealSize8MiB, pieces, opts...)
+		require.NoError(t, err)
+		assert.NoError(t, a.SelfCheck())
+	}
+	random, _, err := GenerateRandomAggregate(31, DealSize8MiB, 20)
+	require.NoError(t, err)
+	assert.NoError(t, random.SelfCheck())
+
+	a, err := NewAggregate(DealSize8MiB, pieces)
+	require.NoError(t, err)
+	corrupted := *a
+	corrupted.Index.Entries = append([]SegmentDesc(nil), a.Index.Entries...)
+	corrupted.Index.Entries[2].Checksum[0] ^= 1
+	assert.ErrorIs(t, corrupted.SelfCheck(), ErrValidation)
+
+	corrupted.Index.Entries[2] = a.Index.Entries[2]
+	corrupted.Index.Entries[1].Offset += 1 << 20
+	corrupted.Index.Entries[1] = corrupted.Index.Entries[1].withUpdatedChecksum()
+	assert.ErrorContains(t, corrupted.SelfCheck(), "doesn't match the entries")
+
+	corrupted = *a
+	corrupted.Tree = a.Tree.Clone()
+	require.NoError(t, corrupted.Tree.SetNode(0, 1<<16, &merkletree.Node{0x1}))
+	assert.ErrorContains(t, corrupted.SelfCheck(), "doesn't match the entries")
+}
+
+func TestComputeIndexPieceCID(t *testing.T) {
+	for _, dealSize := range []abi.PaddedPieceSize{1 << 10, 1 << 20, 32 << 30} {
+		pieces := []abi.PieceInfo{
+			{PieceCID: cidForDeal(0), Size: 128},
+			{PieceCID: cidForDeal(1), Size: 256},
+			{PieceCID: cidForDeal(2), Size: 128},
+		}
+		a, err := NewAggregate(dealSize, pieces)
+		require.NoError(t, err)
+
+		c, err := ComputeIndexPieceCID(a.Index.Entries, dealSize)
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.IndexPieceCID()), c, "deal size %d", dealSize)
+	}
+
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 256},
+	}
+	for _, opts := range [][]AggregateOption{
+		{WithIndexCapacity(64)},
+		{WithIndexHeader()},
+		{WithIndexCapacity(64), WithIndexHeader()},
+	} {
+		a, err := NewAggregate(1<<20, pieces, opts...)
+		require.NoError(t, err)
+		c, err := ComputeIndexPieceCID(a.Index.Entries, 1<<20, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.IndexPieceCID()), c)
+		assert.NotEqual(t, Must(ComputeIndexPieceCID(a.Index.Entries, 1<<20)), c)
+	}
+	_, err := ComputeIndexPieceCID(nil, 1<<20, WithIndexCapacity(3))
+	assert.ErrorContains(t, err, "invalid index capacity")
+
+	_, err = ComputeIndexPieceCID(make([]SegmentDesc, 5), 1<<10)
+	assert.ErrorContains(t, err, "too many entries")
+	_, err = ComputeIndexPieceCID(make([]SegmentDesc, 4), 1<<10, WithIndexHeader())
+	assert.ErrorContains(t, err, "too many entries")
+	_, err = ComputeIndexPieceCID(nil, 1000)
+	assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+}
+
+func TestComputeDealCommP(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 1 << 12},
+		{PieceCID: cidForDeal(2), Size: 256},
+	}
+	for _, dealSize := range []abi.PaddedPieceSize{1 << 14, 1 << 20, 32 << 30} {
+		a, err := NewAggregate(dealSize, pieces)
+		require.NoError(t, err)
+		c, err := ComputeDealCommP(dealSize, pieces)
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), c, "deal size %d", dealSize)
+
+		cl, _, err := ComputeDealPlacement(pieces)
+		require.NoError(t, err)
+		ht, err := merkletree.NewHybrid(util.Log2Ceil(uint64(dealSize / merkletree.NodeSize)))
+		require.NoError(t, err)
+		require.NoError(t, ht.BatchSet(cl))
+		root := ht.Root()
+		c, err = ComputeDealCommPWithoutIndex(dealSize, pieces)
+		require.NoError(t, err)
+		assert.Equal(t, Must(commcid.PieceCommitmentV1ToCID(root[:])), c, "deal size %d", dealSize)
+	}
+
+	// without the index the sub-deals can fill the whole deal
+	full := []abi.PieceInfo{{PieceCID: cidForDeal(0), Size: 1 << 14}}
+	_, err := ComputeDealCommP(1<<14, full)
+	assert.Error(t, err)
+	c, err := ComputeDealCommPWithoutIndex(1<<14, full)
+	require.NoError(t, err)
+	assert.Equal(t, full[0].PieceCID, c)
+
+	_, err = ComputeDealCommP(1000, pieces)
+	assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+}
+
+func TestComputeDealCommPOptions(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 1 << 12},
+		{PieceCID: cidForDeal(2), Size: 256},
+	}
+	md := []SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(100), BlockCount: 3}}
+	for _, opts := range [][]AggregateOption{
+		{WithIndexCapacity(64)},
+		{WithIndexCapacity(64), WithIndexHeader()},
+		{WithIndexHeader(), WithRawSizes([]uint64{100, 4000, 200})},
+		{WithIndexCapacity(64), WithSegmentMetadata(md)},
+	} {
+		a, err := NewAggregate(1<<20, pieces, opts...)
+		require.NoError(t, err)
+		c, err := ComputeDealCommP(1<<20, pieces, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), c)
+	}
+
+	_, err := ComputeDealCommP(1<<20, pieces, WithIndexCapacity(3))
+	assert.Error(t, err)
+}
+
 func TestProofForPieceInfo(t *testing.T) {
 	pieceInfos := []abi.PieceInfo{
 		{
@@ -239,37 +503,18 @@ func TestAggregateSample(t *testing.T) {
 	require.NoError(t, err)
 
 	{
-		_, err = f.Seek(int64(a.Index.Entries[0].UnpaddedOffest()), io.SeekStart)
-		require.NoError(t, err)
 		p0, err := os.Open("testdata/sample_aggregate/cat.png.car")
 		require.NoError(t, err)
-		_, err = io.Copy(f, p0)
-		require.NoError(t, err)
+		require.NoError(t, WriteSegmentAt(f, a.Index.Entries[0], p0))
+		assert.NoError(t, p0.Close())
 	}
 	{
 		p1, err := os.Open("testdata/sample_aggregate/Verifiable Data Aggregation.png.car")
 		require.NoError(t, err)
-		_, err = f.Seek(int64(a.Index.Entries[1].UnpaddedOffest()), io.SeekStart)
-		require.NoError(t, err)
-		_, err = io.Copy(f, p1)
-		require.NoError(t, err)
-		err = p1.Close()
-		assert.NoError(t, err)
-	}
-	{
-		index_start, err := a.IndexStartPosition()
-		require.NoError(t, err)
-		_, err = f.Seek(int64(index_start), io.SeekStart)
-		require.NoError(t, err)
-		r, err := a.IndexReader()
-		require.NoError(t, err)
-		nbytes, err = io.Copy(f, r)
-		require.NoError(t, err)
-
-		index_size, err := a.IndexSize()
-		require.NoError(t, err)
-		assert.Equal(t, int64(index_size.Unpadded()), nbytes)
+		require.NoError(t, WriteSegmentAt(f, a.Index.Entries[1], p1))
+		assert.NoError(t, p1.Close())
 	}
+	require.NoError(t, WriteIndexAt(f, a))
 
 	{
 		indexStart := DataSegmentIndexStartOffset(dealSize)
@@ -325,3 +570,478 @@ func TestFailWithNonPow2(t *testing.T) {
 	_, err := NewAggregate(abi.PaddedPieceSize(1<<20+1), nil)
 	assert.ErrorContains(t, err, "padded piece size must be a power of 2")
 }
+
+func TestNoSubdeals(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	_, err := NewAggregate(dealSize, nil)
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+	_, _, err = NewAggregateFromReaders(dealSize, nil)
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+	_, err = ComputeDealCommP(dealSize, []abi.PieceInfo{})
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+
+	a, err := NewAggregate(dealSize, []abi.PieceInfo{{PieceCID: cidForDeal(0), Size: 128}})
+	require.NoError(t, err)
+	_, err = NewAggregateWithTree(dealSize, IndexData{}, a.Tree)
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+
+	empty := Aggregate{DealSize: dealSize, Tree: a.Tree}
+	_, err = empty.IndexReader()
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+	_, err = empty.ProofForPieceInfo(abi.PieceInfo{PieceCID: cidForDeal(0), Size: 128})
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+	_, err = empty.ProofForIndexEntry(0)
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+	_, err = a.ProofForIndexEntry(1)
+	assert.Error(t, err)
+}
+
+func TestNewAggregateWithTree(t *testing.T) {
+	subPieceInfos := samplePieceInfos1()
+	dealSize := abi.PaddedPieceSize(32 << 30)
+	a, err := NewAggregate(dealSize, subPieceInfos)
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, a.Tree.MarshalCBOR(buf))
+	var tree merkletree.Hybrid
+	require.NoError(t, tree.UnmarshalCBOR(buf))
+
+	a2, err := NewAggregateWithTree(dealSize, a.Index, tree)
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+	for _, pi := range subPieceInfos {
+		ip, err := a2.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+	}
+
+	_, err = NewAggregateWithTree(dealSize*2, a.Index, tree)
+	assert.Error(t, err)
+
+	badIndex := IndexData{Entries: append([]SegmentDesc{}, a.Index.Entries...)}
+	badIndex.Entries[0].CommDs[0] ^= 0x1
+	badIndex.Entries[0] = badIndex.Entries[0].withUpdatedChecksum()
+	_, err = NewAggregateWithTree(dealSize, badIndex, tree)
+	assert.Error(t, err)
+}
+
+func TestNewAggregateFromIndex(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 1 << 10},
+		{PieceCID: cidForDeal(1), Size: 256},
+		{PieceCID: cidForDeal(2), Size: 4 << 10},
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+
+	a2, err := NewAggregateFromIndex(dealSize, a.Index)
+	require.NoError(t, err)
+	assert.Equal(t, a.Index, a2.Index)
+	assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+	for _, pi := range pieces {
+		ip, err := a2.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+	}
+
+	withHeader, err := NewAggregate(dealSize, pieces, WithIndexHeader())
+	require.NoError(t, err)
+	a2, err = NewAggregateFromIndex(dealSize, withHeader.Index, WithIndexHeader())
+	require.NoError(t, err)
+	assert.Equal(t, Must(withHeader.PieceCID()), Must(a2.PieceCID()))
+
+	_, err = NewAggregateFromIndex(dealSize, IndexData{})
+	assert.ErrorIs(t, err, ErrNoSubdeals)
+
+	// tombstoned entries keep their slot empty
+	tombstoned := *a
+	require.NoError(t, tombstoned.TombstoneEntry(1))
+	a2, err = NewAggregateFromIndex(dealSize, tombstoned.Index)
+	require.NoError(t, err)
+	assert.Equal(t, tombstoned.Index, a2.Index)
+	assert.Equal(t, SegmentDesc{}, a2.Index.Entries[1])
+	assert.Equal(t, Must(tombstoned.PieceCID()), Must(a2.PieceCID()))
+	ip, err := a2.ProofForIndexEntry(2)
+	require.NoError(t, err)
+	aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[2]))
+	require.NoError(t, err)
+	assert.Equal(t, Must(tombstoned.PieceCID()), aux.CommPa)
+	_, err = NewAggregateFromIndex(dealSize, tombstoned.Index, WithRawSizes([]uint64{1000, 0, 4000}))
+	assert.NoError(t, err)
+	_, err = NewAggregateFromIndex(dealSize, tombstoned.Index, WithRawSizes([]uint64{1000, 200, 4000}))
+	assert.Error(t, err)
+
+	mutate := func(i int, f func(e *SegmentDesc)) IndexData {
+		entries := append([]SegmentDesc(nil), a.Index.Entries...)
+		f(&entries[i])
+		entries[i] = entries[i].withUpdatedChecksum()
+		return IndexData{Entries: entries}
+	}
+	cases := []struct {
+		name  string
+		index IndexData
+		slot  int
+		kind  EntryErrorKind
+	}{
+		{"bad checksum", IndexData{Entries: []SegmentDesc{a.Index.Entries[0], {Offset: 1 << 10, Size: 256}}}, 1, EntryInvalid},
+		{"not a subtree", mutate(1, func(e *SegmentDesc) { e.Offset += 128 }), 1, EntryInvalid},
+		{"overlapping", mutate(1, func(e *SegmentDesc) { e.Offset = 0 }), 1, EntryMisplaced},
+		{"out of order", mutate(2, func(e *SegmentDesc) { e.Offset = 0 }), 2, EntryMisplaced},
+		{"index collision", mutate(2, func(e *SegmentDesc) { e.Offset = uint64(dealSize) - e.Size }), 2, EntryMisplaced},
+		{"exceeding the deal", mutate(2, func(e *SegmentDesc) { e.Offset = uint64(dealSize) }), 2, EntryMisplaced},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewAggregateFromIndex(dealSize, c.index)
+			var ee *EntryError
+			require.ErrorAs(t, err, &ee)
+			assert.Equal(t, c.slot, ee.Slot)
+			assert.Equal(t, c.kind, ee.Kind)
+			if c.kind == EntryMisplaced {
+				assert.ErrorIs(t, err, ErrInvalidLayout)
+			}
+		})
+	}
+
+	// slots count the header
+	_, err = NewAggregateFromIndex(dealSize, mutate(1, func(e *SegmentDesc) { e.Offset = 0 }), WithIndexHeader())
+	var ee *EntryError
+	require.ErrorAs(t, err, &ee)
+	assert.Equal(t, 2, ee.Slot)
+}
+
+func TestAggregateDuplicatePieces(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 64 << 10},
+		{PieceCID: cidForDeal(1), Size: 64 << 10},
+		{PieceCID: cidForDeal(0), Size: 64 << 10},
+	}
+
+	_, err := NewAggregate(dealSize, pieces, RejectDuplicatePieces())
+	assert.ErrorContains(t, err, "duplicate")
+
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+
+	proofs, err := a.ProofsForPieceInfo(pieces[0])
+	require.NoError(t, err)
+	require.Len(t, proofs, 2)
+	assert.Equal(t, Must(a.ProofForPieceInfoAt(pieces[0], 0)), proofs[0])
+	assert.Equal(t, Must(a.ProofForPieceInfoAt(pieces[0], 1)), proofs[1])
+	_, err = a.ProofForPieceInfo(pieces[0])
+	assert.ErrorIs(t, err, ErrAmbiguousPiece)
+	assert.NotNil(t, Must(a.ProofForPieceInfo(pieces[1])))
+
+	for i, idx := range []int{0, 2} {
+		offset := a.Index.Entries[idx].UnpaddedOffest()
+		assert.Equal(t, proofs[i], Must(a.ProofForPieceInfoAtOffset(pieces[0], offset)))
+	}
+	_, err = a.ProofForPieceInfoAtOffset(pieces[0], a.Index.Entries[1].UnpaddedOffest())
+	assert.Error(t, err)
+	assert.NotEqual(t, proofs[0].ProofSubtree.Index, proofs[1].ProofSubtree.Index)
+	for _, p := range proofs {
+		aux, err := p.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[0]))
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+	}
+
+	_, err = a.ProofForPieceInfoAt(pieces[0], 2)
+	assert.Error(t, err)
+	_, err = a.ProofsForPieceInfo(abi.PieceInfo{PieceCID: cidForDeal(5), Size: 64 << 10})
+	assert.Error(t, err)
+}
+
+func TestAggregateIndexCapacity(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 64 << 10},
+		{PieceCID: cidForDeal(1), Size: 128 << 10},
+	}
+	required := MaxIndexEntriesInDeal(dealSize)
+
+	_, err := NewAggregate(dealSize, pieces, WithIndexCapacity(required/2))
+	assert.ErrorContains(t, err, "smaller than required")
+	_, err = NewAggregate(dealSize, pieces, WithIndexCapacity(required+1))
+	assert.ErrorContains(t, err, "power of two")
+	_, err = NewAggregate(dealSize, pieces, WithIndexCapacity(uint(dealSize)/EntrySize))
+	assert.ErrorContains(t, err, "does not fit")
+
+	def, err := NewAggregate(dealSize, pieces, WithIndexCapacity(required))
+	require.NoError(t, err)
+	assert.Equal(t, Must(NewAggregate(dealSize, pieces)).Tree.Root(), def.Tree.Root())
+
+	capacity := required * 4
+	a, err := NewAggregate(dealSize, pieces, WithIndexCapacity(capacity))
+	require.NoError(t, err)
+	assert.NotEqual(t, def.Tree.Root(), a.Tree.Root())
+
+	size, err := a.IndexSize()
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(uint64(capacity)*EntrySize), size)
+	start, err := a.IndexStartPosition()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(dealSize.Unpadded())-uint64(size.Unpadded()), start)
+
+	r, err := a.IndexReader()
+	require.NoError(t, err)
+	idx, err := ParseDataSegmentIndex(r)
+	require.NoError(t, err)
+	assert.Len(t, idx.Entries, int(capacity))
+	assert.Equal(t, a.Index.Entries, idx.Entries[:len(pieces)])
+
+	for _, p := range pieces {
+		proof, err := a.ProofForPieceInfo(p)
+		require.NoError(t, err)
+		_, err = proof.ComputeExpectedAuxData(VerifierDataForPieceInfo(p))
+		assert.ErrorContains(t, err, "wrong position")
+
+		aux, err := proof.ComputeExpectedAuxDataWithIndexCapacity(VerifierDataForPieceInfo(p), capacity)
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+		assert.Equal(t, dealSize, aux.SizePa)
+
+		_, err = proof.ComputeExpectedAuxDataWithIndexCapacity(VerifierDataForPieceInfo(p), 3*capacity)
+		assert.ErrorIs(t, err, ErrInvalidIndexCapacity)
+		assert.ErrorIs(t, err, verifylite.ErrInvalidIndexCapacity)
+		assert.NotErrorIs(t, err, ErrInvalidProof)
+	}
+
+	for _, c := range []uint{3, 4} {
+		_, err = NewAggregate(dealSize, pieces, WithIndexCapacity(c))
+		assert.ErrorIs(t, err, ErrInvalidIndexCapacity, "capacity %d", c)
+	}
+}
+
+func TestAggregateIndexHeader(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 64 << 10},
+		{PieceCID: cidForDeal(1), Size: 128 << 10},
+	}
+
+	a, err := NewAggregate(dealSize, pieces, WithIndexHeader())
+	require.NoError(t, err)
+	assert.True(t, a.IndexHeader)
+	assert.Len(t, a.Index.Entries, len(pieces))
+	assert.NotEqual(t, Must(NewAggregate(dealSize, pieces)).Tree.Root(), a.Tree.Root())
+
+	r, err := a.IndexReader()
+	require.NoError(t, err)
+	idx, err := ParseDataSegmentIndex(r)
+	require.NoError(t, err)
+	assert.Equal(t, a.Index.Entries, idx.Entries)
+
+	// parsers unaware of the header reject it
+	r, err = a.IndexReader()
+	require.NoError(t, err)
+	raw, err := io.ReadAll(r)
+	require.NoError(t, err)
+	var rawIdx IndexData
+	padded := make([]byte, len(raw)/127*128)
+	fr32.Pad(raw, padded)
+	require.NoError(t, rawIdx.UnmarshalBinary(padded))
+	header, ok := ParseIndexHeader(rawIdx.Entries[0])
+	require.True(t, ok)
+	assert.Equal(t, IndexHeader{Version: IndexHeaderVersion, Entries: uint64(len(pieces))}, header)
+	valid, report, err := rawIdx.ValidEntriesWithReport()
+	require.NoError(t, err)
+	assert.Equal(t, a.Index.Entries, valid)
+	assert.Len(t, report.Rejected, 1)
+
+	for _, p := range pieces {
+		proof, err := a.ProofForPieceInfo(p)
+		require.NoError(t, err)
+		aux, err := proof.ComputeExpectedAuxData(VerifierDataForPieceInfo(p))
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+	}
+
+	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	many := make([]abi.PieceInfo, maxEntries)
+	for i := range many {
+		many[i] = abi.PieceInfo{PieceCID: cidForDeal(i), Size: 128}
+	}
+	_, err = NewAggregate(dealSize, many)
+	require.NoError(t, err)
+	_, err = NewAggregate(dealSize, many, WithIndexHeader())
+	assert.ErrorContains(t, err, "too many subdeals")
+}
+
+func TestComputeDealPlacementOverflow(t *testing.T) {
+	_, _, err := ComputeDealPlacement([]abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128},
+		{PieceCID: cidForDeal(1), Size: 1 << 63},
+	})
+	assert.ErrorContains(t, err, "overflows")
+}
+
+// FuzzComputeDealPlacement feeds adversarial sizes to the placement and checks that the result
+// is either an error or a valid, non-overlapping placement
+func FuzzComputeDealPlacement(f *testing.F) {
+	f.Add(uint8(7), uint8(63), uint8(7), uint8(63))
+	f.Add(uint8(62), uint8(62), uint8(62), uint8(7))
+	f.Add(uint8(10), uint8(11), uint8(12), uint8(13))
+	f.Fuzz(func(t *testing.T, a, b, c, d uint8) {
+		var pieces []abi.PieceInfo
+		for i, l := range []uint8{a, b, c, d} {
+			pieces = append(pieces, abi.PieceInfo{PieceCID: cidForDeal(i), Size: abi.PaddedPieceSize(1) << (l % 64)})
+		}
+		cl, total, err := ComputeDealPlacement(pieces)
+		if err != nil {
+			return
+		}
+		end := uint64(0)
+		for i, c := range cl {
+			start := c.Loc.ByteOffset()
+			if start < end || start%uint64(pieces[i].Size) != 0 {
+				t.Fatalf("invalid placement of piece %d: %d", i, start)
+			}
+			end = start + uint64(pieces[i].Size)
+			if end < start {
+				t.Fatalf("placement of piece %d wraps", i)
+			}
+		}
+		if total != end {
+			t.Fatalf("total size doesn't match the end of the last piece: %d != %d", total, end)
+		}
+	})
+}
+
+func TestIndexPlacementMatchesObjectReader(t *testing.T) {
+	content := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i + 1)}, int(abi.PaddedPieceSize(128<<(i%3)).Unpadded()))
+	}
+	pieces := make([]abi.PieceInfo, 0, 7)
+	for i := 0; i < 7; i++ {
+		pi, err := pieceInfoForSource(PieceSource{Reader: bytes.NewReader(content(i))}, nil)
+		require.NoError(t, err)
+		pieces = append(pieces, pi)
+	}
+	for _, tc := range []struct {
+		name string
+		opts []AggregateOption
+	}{
+		{"default", nil},
+		{"capacity", []AggregateOption{WithIndexCapacity(16)}},
+		{"header", []AggregateOption{WithIndexHeader()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewAggregate(1<<20, pieces, tc.opts...)
+			require.NoError(t, err)
+			readers := make([]io.Reader, len(pieces))
+			for i := range pieces {
+				readers[i] = bytes.NewReader(content(i))
+			}
+			r, err := a.AggregateObjectReader(readers)
+			require.NoError(t, err)
+
+			cp := commp.Calc{}
+			_, err = io.CopyBuffer(&cp, r, make([]byte, cp.BlockSize()*128))
+			require.NoError(t, err)
+			comm, paddedSize, err := cp.Digest()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(a.DealSize), paddedSize)
+			assert.Equal(t, Must(commcid.PieceCommitmentV1ToCID(comm)), Must(a.PieceCID()))
+		})
+	}
+}
+
+func TestDevnetDealSizes(t *testing.T) {
+	for _, dealSize := range []abi.PaddedPieceSize{MinSupportedDealSize, 1 << 10, DealSize2KiB} {
+		n := MaxSubdeals(dealSize, MinSegmentSize)
+		require.NotZero(t, n, "deal size %d", dealSize)
+		// random pieces are generated with room for their alignment
+		a, pieces, err := GenerateRandomAggregate(int64(dealSize), dealSize, int(n+1)/2)
+		require.NoError(t, err, "deal size %d", dealSize)
+		require.NoError(t, a.SelfCheck())
+		require.NoError(t, a.VerifyIndexConsistency())
+
+		deal := expectedDeal(t, a, pieces)
+		parsed, err := ParseDataSegmentIndexForDeal(dealSize, bytes.NewReader(deal[DataSegmentIndexStartOffset(dealSize):]))
+		require.NoError(t, err)
+		assert.Equal(t, a.Index.Entries, Must(parsed.ValidEntries()))
+
+		aux := InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: dealSize}
+		for i, p := range pieces {
+			ip, err := a.ProofForIndexEntry(i)
+			require.NoError(t, err)
+			got, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(p.PieceInfo))
+			require.NoError(t, err)
+			assert.Equal(t, aux, *got)
+		}
+	}
+
+	for _, dealSize := range []abi.PaddedPieceSize{128, 256} {
+		_, err := NewAggregate(dealSize, []abi.PieceInfo{{PieceCID: cidForDeal(0), Size: MinSegmentSize}})
+		assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+		assert.ErrorContains(t, err, fmt.Sprintf("< %d", MinSupportedDealSize))
+	}
+}
+
+func TestSingleNodeSegments(t *testing.T) {
+	content := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i + 1)}, 127)
+	}
+	pieces := make([]abi.PieceInfo, 4)
+	for i := range pieces {
+		pi, err := pieceInfoForSource(PieceSource{Reader: bytes.NewReader(content(i))}, nil)
+		require.NoError(t, err)
+		require.Equal(t, abi.PaddedPieceSize(MinSegmentSize), pi.Size)
+		pieces[i] = pi
+	}
+
+	a, err := NewAggregate(DealSize2KiB, pieces)
+	require.NoError(t, err)
+	aux := InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: a.DealSize}
+	for i, e := range a.Index.Entries {
+		require.NoError(t, e.ValidateStrict())
+		assert.Equal(t, uint64(i*MinSegmentSize), e.Offset)
+		assert.Equal(t, merkletree.Location{Level: 2, Index: uint64(i)}, e.CommAndLoc().Loc)
+
+		ip, err := a.ProofForPieceInfo(pieces[i])
+		require.NoError(t, err)
+		assert.Equal(t, util.Log2Ceil(uint64(a.DealSize)/MinSegmentSize), ip.ProofSubtree.Depth())
+		got, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[i]))
+		require.NoError(t, err)
+		assert.Equal(t, aux, *got)
+
+		start := e.UnpaddedOffest()
+		p, err := a.ProveRangeOwnership(start, 127)
+		require.NoError(t, err)
+		assert.Equal(t, RangeInSegment, p.Kind)
+		assert.NoError(t, p.Verify(start, 127, aux))
+	}
+
+	readers := make([]io.Reader, len(pieces))
+	for i := range pieces {
+		readers[i] = bytes.NewReader(content(i))
+	}
+	r, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+	deal, err := io.ReadAll(r)
+	require.NoError(t, err)
+	for i, e := range a.Index.Entries {
+		assert.Equal(t, content(i), deal[e.UnpaddedOffest():e.UnpaddedOffest()+e.UnpaddedLength()])
+	}
+	parsed, err := ParseDataSegmentIndex(bytes.NewReader(deal[DataSegmentIndexStartOffset(a.DealSize):]))
+	require.NoError(t, err)
+	assert.Equal(t, a.Index.Entries, Must(parsed.ValidEntries()))
+
+	// a zero sized entry in the index is rejected instead of being placed as a single node
+	zero := SegmentDesc{CommDs: a.Index.Entries[0].CommDs, Offset: 0, Size: 0}.withUpdatedChecksum()
+	assert.ErrorIs(t, zero.Validate(), ErrSegmentTooSmall)
+	assert.ErrorIs(t, zero.Validate(), ErrValidation)
+	idx := IndexData{Entries: []SegmentDesc{zero, a.Index.Entries[1]}}
+	valid, report, err := idx.ValidEntriesWithReport()
+	require.NoError(t, err)
+	assert.Equal(t, []SegmentDesc{a.Index.Entries[1]}, valid)
+	require.Len(t, report.Rejected, 1)
+	assert.ErrorIs(t, report.Rejected[0].Err, ErrSegmentTooSmall)
+}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"io"
+
+	xerrors "golang.org/x/xerrors"
+)
+
+// WriteSegmentAt writes the unpadded data of the segment described by entry, read from src,
+// into dst holding the unpadded data of the deal. dst is expected to be zero-filled, e.g. a file
+// truncated to the unpadded deal size, as a src shorter than the segment leaves the rest of the
+// segment untouched. It fails if src holds more data than the segment can.
+func WriteSegmentAt(dst io.WriteSeeker, entry SegmentDesc, src io.Reader) error {
+	if err := entry.CheckSizes(); err != nil {
+		return xerrors.Errorf("invalid entry: %w", err)
+	}
+	_, err := writeAt(dst, int64(entry.UnpaddedOffest()), int64(entry.UnpaddedLength()), src)
+	return err
+}
+
+// WriteSubPieceAt is like WriteSegmentAt for the sub-piece of the index entry idx. If the
+// Aggregate has RawSizes, src has to provide exactly the raw size of the sub-piece.
+func (a Aggregate) WriteSubPieceAt(dst io.WriteSeeker, idx int, src io.Reader) error {
+	if idx < 0 || idx >= len(a.Index.Entries) {
+		return xerrors.Errorf("index entry %d out of range, the index has %d entries", idx, len(a.Index.Entries))
+	}
+	e := a.Index.Entries[idx]
+	if a.RawSizes == nil {
+		return WriteSegmentAt(dst, e, src)
+	}
+	if len(a.RawSizes) != len(a.Index.Entries) {
+		return xerrors.Errorf("number of raw sizes doesn't match number of entries: %d != %d",
+			len(a.RawSizes), len(a.Index.Entries))
+	}
+	raw := a.RawSizes[idx]
+	if raw > e.UnpaddedLength() {
+		return xerrors.Errorf("raw size of entry %d doesn't fit in the segment: %d > %d",
+			idx, raw, e.UnpaddedLength())
+	}
+	n, err := writeAt(dst, int64(e.UnpaddedOffest()), int64(raw), src)
+	if err != nil {
+		return xerrors.Errorf("sub-piece %d: %w", idx, err)
+	}
+	if uint64(n) != raw {
+		return xerrors.Errorf("sub-piece %d: reader is shorter than the raw size: %d < %d", idx, n, raw)
+	}
+	return nil
+}
+
+// WriteIndexAt writes the unpadded index area of the Aggregate into dst holding the unpadded
+// data of the deal
+func WriteIndexAt(dst io.WriteSeeker, a *Aggregate) error {
+	r, err := a.IndexReader()
+	if err != nil {
+		return err
+	}
+	start, err := a.IndexStartPosition()
+	if err != nil {
+		return err
+	}
+	size, err := a.IndexSize()
+	if err != nil {
+		return err
+	}
+	n, err := writeAt(dst, int64(start), int64(size.Unpadded()), r)
+	if err != nil {
+		return xerrors.Errorf("writing index: %w", err)
+	}
+	if n != int64(size.Unpadded()) {
+		return xerrors.Errorf("short index write: %d != %d", n, size.Unpadded())
+	}
+	return nil
+}
+
+// writeAt copies at most limit bytes of src into dst at offset, failing if src has more
+func writeAt(dst io.WriteSeeker, offset, limit int64, src io.Reader) (int64, error) {
+	if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+		return 0, xerrors.Errorf("seeking to %d: %w", offset, err)
+	}
+	n, err := io.Copy(dst, &exactLimitReader{r: src, n: limit})
+	if err != nil {
+		return n, xerrors.Errorf("copying data at %d: %w", offset, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDealFile(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(3, 1<<20, 5)
+	require.NoError(t, err)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "deal"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, f.Truncate(int64(a.DealSize.Unpadded())))
+	for i, e := range a.Index.Entries {
+		require.NoError(t, WriteSegmentAt(f, e, pieces[i].Reader()))
+	}
+	require.NoError(t, WriteIndexAt(f, a))
+
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		readers[i] = p.Reader()
+	}
+	expected, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	assert.Equal(t, Must(io.ReadAll(expected)), Must(io.ReadAll(f)))
+
+	// the source doesn't fit in the segment
+	e := a.Index.Entries[0]
+	tooLong := bytes.NewReader(make([]byte, e.UnpaddedLength()+1))
+	assert.Error(t, WriteSegmentAt(f, e, tooLong))
+	e.Offset++
+	assert.ErrorIs(t, WriteSegmentAt(f, e, bytes.NewReader(nil)), ErrValidation)
+}
+
+func TestWriteSubPieceAtRawSizes(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0)},
+		{PieceCID: cidForDeal(1)},
+	}
+	a, err := NewAggregate(1<<20, pieces, WithRawSizes([]uint64{1000, 3000}))
+	require.NoError(t, err)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "deal"))
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, f.Truncate(int64(a.DealSize.Unpadded())))
+
+	data := bytes.Repeat([]byte{0xaa}, 3000)
+	require.NoError(t, a.WriteSubPieceAt(f, 1, bytes.NewReader(data)))
+	got := make([]byte, a.Index.Entries[1].UnpaddedLength())
+	_, err = f.ReadAt(got, int64(a.Index.Entries[1].UnpaddedOffest()))
+	require.NoError(t, err)
+	assert.Equal(t, data, got[:3000])
+	assert.Equal(t, make([]byte, len(got)-3000), got[3000:])
+
+	assert.Error(t, a.WriteSubPieceAt(f, 0, bytes.NewReader(data[:999])), "shorter than the raw size")
+	assert.Error(t, a.WriteSubPieceAt(f, 0, bytes.NewReader(data[:1001])), "longer than the raw size")
+	assert.NoError(t, a.WriteSubPieceAt(f, 0, bytes.NewReader(data[:1000])))
+	assert.Error(t, a.WriteSubPieceAt(f, 2, bytes.NewReader(nil)))
+
+	// without raw sizes the segment is the bound
+	a.RawSizes = nil
+	assert.NoError(t, a.WriteSubPieceAt(f, 0, bytes.NewReader(data[:1001])))
+}
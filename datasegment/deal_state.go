@@ -0,0 +1,84 @@
+package datasegment
+
+import (
+	"context"
+	"io"
+
+	"github.com/filecoin-project/go-data-segment/fr32"
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// StateReader gives access to the on-chain state required by AggregateFromDeal.
+// It is implemented by the caller, e.g. over a Lotus or Glif API.
+type StateReader interface {
+	// DealPiece returns the PieceCID and the padded size of the piece of the deal
+	DealPiece(ctx context.Context, dealID abi.DealID) (abi.PieceInfo, error)
+}
+
+// AggregateFromDeal rebuilds the Aggregate of an on-chain deal from its piece.
+// The piece is read from the unpadded piece data, its index is parsed and the Aggregate
+// is reconstructed from the valid entries. The rebuilt PieceCID is checked against the deal,
+// so only deals created with the default index capacity by this library can be rebuilt.
+func AggregateFromDeal(ctx context.Context, sr StateReader, dealID abi.DealID, piece io.ReaderAt) (*Aggregate, error) {
+	pi, err := sr.DealPiece(ctx, dealID)
+	if err != nil {
+		return nil, xerrors.Errorf("fetching piece of deal %d: %w", dealID, err)
+	}
+	if err := ValidateDealSize(pi.Size); err != nil {
+		return nil, xerrors.Errorf("deal %d: invalid dealSize: %w", dealID, err)
+	}
+
+	indexArea := io.NewSectionReader(piece, int64(IndexAreaStartUnpadded(pi.Size)), int64(IndexAreaSizeUnpadded(pi.Size)))
+	header, err := hasIndexHeader(indexArea)
+	if err != nil {
+		return nil, xerrors.Errorf("deal %d: %w", dealID, err)
+	}
+	index, err := ParseDataSegmentIndexForDeal(pi.Size, indexArea)
+	if err != nil {
+		return nil, xerrors.Errorf("deal %d: parsing index: %w", dealID, err)
+	}
+	entries, err := index.ValidEntries()
+	if err != nil {
+		return nil, xerrors.Errorf("deal %d: validating index: %w", dealID, err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoSubdeals
+	}
+
+	cl := make([]merkletree.CommAndLoc, len(entries))
+	for i, e := range entries {
+		cl[i] = e.CommAndLoc()
+	}
+	agg, err := newAggregateFromCommLoc(pi.Size, cl, aggregateOptions{indexHeader: header})
+	if err != nil {
+		return nil, xerrors.Errorf("deal %d: rebuilding aggregate: %w", dealID, err)
+	}
+
+	rebuilt, err := agg.PieceCID()
+	if err != nil {
+		return nil, xerrors.Errorf("deal %d: %w", dealID, err)
+	}
+	if !rebuilt.Equals(pi.PieceCID) {
+		return nil, xerrors.Errorf("deal %d: rebuilt PieceCID %s doesn't match the deal's %s",
+			dealID, rebuilt, pi.PieceCID)
+	}
+	return agg, nil
+}
+
+// hasIndexHeader checks if the index area, given as unpadded data, starts with an IndexHeader
+func hasIndexHeader(indexArea io.ReaderAt) (bool, error) {
+	unpadded := make([]byte, 127)
+	if _, err := indexArea.ReadAt(unpadded, 0); err != nil {
+		return false, xerrors.Errorf("reading index area: %w", err)
+	}
+	padded := make([]byte, 128)
+	fr32.Pad(unpadded, padded)
+	var first SegmentDesc
+	if err := first.UnmarshalBinary(padded[:EntrySize]); err != nil {
+		return false, xerrors.Errorf("decoding first entry: %w", err)
+	}
+	_, ok := ParseIndexHeader(first)
+	return ok, nil
+}
@@ -0,0 +1,75 @@
+package datasegment
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type testStateReader map[abi.DealID]abi.PieceInfo
+
+func (sr testStateReader) DealPiece(_ context.Context, dealID abi.DealID) (abi.PieceInfo, error) {
+	pi, ok := sr[dealID]
+	if !ok {
+		return abi.PieceInfo{}, xerrors.Errorf("deal %d not found", dealID)
+	}
+	return pi, nil
+}
+
+func TestAggregateFromDeal(t *testing.T) {
+	content := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i + 1)}, int(abi.PaddedPieceSize(256<<i).Unpadded()))
+	}
+	var pieces []abi.PieceInfo
+	for i := 0; i < 3; i++ {
+		pi, err := pieceInfoForSource(PieceSource{Reader: bytes.NewReader(content(i))}, nil)
+		require.NoError(t, err)
+		pieces = append(pieces, pi)
+	}
+	readers := func() []io.Reader {
+		res := make([]io.Reader, len(pieces))
+		for i := range pieces {
+			res[i] = bytes.NewReader(content(i))
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []AggregateOption
+	}{
+		{"default", nil},
+		{"header", []AggregateOption{WithIndexHeader()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewAggregate(1<<20, pieces, tc.opts...)
+			require.NoError(t, err)
+			data, err := io.ReadAll(Must(a.AggregateObjectReader(readers())))
+			require.NoError(t, err)
+			sr := testStateReader{7: {PieceCID: Must(a.PieceCID()), Size: a.DealSize}}
+
+			rebuilt, err := AggregateFromDeal(context.Background(), sr, 7, bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, a.Index, rebuilt.Index)
+			assert.Equal(t, a.IndexHeader, rebuilt.IndexHeader)
+			ip, err := rebuilt.ProofForPieceInfo(pieces[1])
+			require.NoError(t, err)
+			aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pieces[1]))
+			require.NoError(t, err)
+			assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+
+			_, err = AggregateFromDeal(context.Background(), sr, 8, bytes.NewReader(data))
+			assert.ErrorContains(t, err, "not found")
+
+			other := testStateReader{7: {PieceCID: cidForDeal(1), Size: a.DealSize}}
+			_, err = AggregateFromDeal(context.Background(), other, 7, bytes.NewReader(data))
+			assert.ErrorContains(t, err, "doesn't match")
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"golang.org/x/exp/slices"
+)
+
+// Equal returns true if both indexes contain the same entries in the same order
+func (id IndexData) Equal(other IndexData) bool {
+	return slices.Equal(id.Entries, other.Entries)
+}
+
+// DiffKind describes the kind of difference between two indexes
+type DiffKind int
+
+const (
+	// EntryAdded means the entry is present only in the second index
+	EntryAdded DiffKind = iota
+	// EntryRemoved means the entry is present only in the first index
+	EntryRemoved
+	// EntryChanged means entries with the same CommDs and Offset differ in other fields
+	EntryChanged
+)
+
+func (dk DiffKind) String() string {
+	switch dk {
+	case EntryAdded:
+		return "added"
+	case EntryRemoved:
+		return "removed"
+	case EntryChanged:
+		return "changed"
+	default:
+		return "unknown"
+	}
+}
+
+// EntryDiff is a single difference between two indexes
+type EntryDiff struct {
+	Kind DiffKind
+	// Old is the entry in the first index, nil for EntryAdded
+	Old *SegmentDesc
+	// New is the entry in the second index, nil for EntryRemoved
+	New *SegmentDesc
+}
+
+type entryKey struct {
+	CommDs merkletree.Node
+	Offset uint64
+}
+
+// DiffIndexes compares entries of two indexes, keyed by CommDs and Offset, ignoring their order.
+// Removed and changed entries are reported in order of the first index, followed by
+// added entries in order of the second index.
+func DiffIndexes(a, b IndexData) []EntryDiff {
+	inB := make(map[entryKey][]int)
+	for i, e := range b.Entries {
+		k := entryKey{CommDs: e.CommDs, Offset: e.Offset}
+		inB[k] = append(inB[k], i)
+	}
+
+	var res []EntryDiff
+	matched := make([]bool, len(b.Entries))
+	for i := range a.Entries {
+		ea := &a.Entries[i]
+		k := entryKey{CommDs: ea.CommDs, Offset: ea.Offset}
+		candidates := inB[k]
+		if len(candidates) == 0 {
+			res = append(res, EntryDiff{Kind: EntryRemoved, Old: ea})
+			continue
+		}
+		j := candidates[0]
+		inB[k] = candidates[1:]
+		matched[j] = true
+		if eb := &b.Entries[j]; *ea != *eb {
+			res = append(res, EntryDiff{Kind: EntryChanged, Old: ea, New: eb})
+		}
+	}
+	for j := range b.Entries {
+		if !matched[j] {
+			res = append(res, EntryDiff{Kind: EntryAdded, New: &b.Entries[j]})
+		}
+	}
+	return res
+}
@@ -0,0 +1,36 @@
+package datasegment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	a := IndexData{Entries: []SegmentDesc{
+		SegmentDesc{CommDs: commForDeal(0), Offset: 0, Size: 128}.withUpdatedChecksum(),
+		SegmentDesc{CommDs: commForDeal(1), Offset: 128, Size: 128}.withUpdatedChecksum(),
+		SegmentDesc{CommDs: commForDeal(2), Offset: 256, Size: 256}.withUpdatedChecksum(),
+	}}
+	assert.True(t, a.Equal(a))
+	assert.Empty(t, DiffIndexes(a, a))
+
+	b := IndexData{Entries: []SegmentDesc{
+		a.Entries[0],
+		SegmentDesc{CommDs: commForDeal(2), Offset: 256, Size: 512}.withUpdatedChecksum(),
+		SegmentDesc{CommDs: commForDeal(3), Offset: 1024, Size: 128}.withUpdatedChecksum(),
+	}}
+	assert.False(t, a.Equal(b))
+
+	diff := DiffIndexes(a, b)
+	assert.Equal(t, []EntryDiff{
+		{Kind: EntryRemoved, Old: &a.Entries[1]},
+		{Kind: EntryChanged, Old: &a.Entries[2], New: &b.Entries[1]},
+		{Kind: EntryAdded, New: &b.Entries[2]},
+	}, diff)
+	assert.Equal(t, "changed", diff[1].Kind.String())
+
+	reordered := IndexData{Entries: []SegmentDesc{a.Entries[2], a.Entries[0], a.Entries[1]}}
+	assert.False(t, a.Equal(reordered))
+	assert.Empty(t, DiffIndexes(a, reordered))
+}
@@ -1,9 +1,12 @@
 package datasegment
 
 import (
-	"github.com/filecoin-project/go-data-segment/fr32"
+	"errors"
+	"fmt"
+
 	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/filecoin-project/go-data-segment/verifylite"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
@@ -20,6 +23,137 @@ type InclusionVerifierData struct {
 	SizePc abi.PaddedPieceSize
 }
 
+type verifierDataError string
+
+// ErrInvalidVerifierData is returned when the InclusionVerifierData is malformed
+var ErrInvalidVerifierData = verifierDataError("unknown")
+
+func (vde verifierDataError) Error() string {
+	return string(vde)
+}
+
+func (vde verifierDataError) Is(err error) bool {
+	_, ok := err.(verifierDataError)
+	return ok
+}
+
+// Validate checks that the verifier data is well formed: SizePc is a valid padded piece size
+// and CommPc is a v1 piece CID. Returned errors match ErrInvalidVerifierData.
+func (vd InclusionVerifierData) Validate() error {
+	_, err := vd.validate()
+	return err
+}
+
+// validate validates the verifier data and returns the decoded CommPc
+func (vd InclusionVerifierData) validate() (merkletree.Node, error) {
+	if !util.IsPow2(uint64(vd.SizePc)) {
+		return merkletree.Node{}, xerrors.Errorf("%w: %d", verifierDataError("size of piece is not power of two"), vd.SizePc)
+	}
+	if vd.SizePc < 128 {
+		return merkletree.Node{}, xerrors.Errorf("%w: %d < 128", verifierDataError("size of piece is too small"), vd.SizePc)
+	}
+	commPc, err := cid2CommP(vd.CommPc)
+	if err != nil {
+		return merkletree.Node{}, fmt.Errorf("%w: %w", verifierDataError("invalid piece commitment"), err)
+	}
+	return commPc, nil
+}
+
+// VerifierDataForRawSize returns the verifier data of a client's payload of rawSize unpadded bytes,
+// which doesn't have to fill a power of two sized piece. The size of the piece is rounded up
+// with PaddedSizeForRaw, commPc has to be computed over the payload zero padded to that size,
+// as done by commP calculators. Returned errors match ErrInvalidVerifierData.
+func VerifierDataForRawSize(commPc cid.Cid, rawSize uint64) (InclusionVerifierData, error) {
+	size := PaddedSizeForRaw(rawSize)
+	if size == 0 {
+		return InclusionVerifierData{}, xerrors.Errorf("%w: %d", verifierDataError("raw size too large"), rawSize)
+	}
+	vd := InclusionVerifierData{CommPc: commPc, SizePc: size}
+	if err := vd.Validate(); err != nil {
+		return InclusionVerifierData{}, err
+	}
+	return vd, nil
+}
+
+type proofError string
+
+// ErrInvalidProof is returned when an InclusionProof doesn't prove the inclusion of the piece
+// described by the verifier data, as opposed to ErrInvalidVerifierData and ErrProofLimitExceeded
+// which are returned for inputs of the wrong shape
+var ErrInvalidProof = proofError("unknown")
+
+func (pe proofError) Error() string {
+	return string(pe)
+}
+
+func (pe proofError) Is(err error) bool {
+	_, ok := err.(proofError)
+	return ok
+}
+
+type proofLimitError string
+
+// ErrProofLimitExceeded is returned when an InclusionProof exceeds the limits of deals supported
+// by this library. Such proofs are rejected before any hashing is performed.
+var ErrProofLimitExceeded = proofLimitError("unknown")
+
+func (ple proofLimitError) Error() string {
+	return string(ple)
+}
+
+func (ple proofLimitError) Is(err error) bool {
+	_, ok := err.(proofLimitError)
+	return ok
+}
+
+type indexCapacityError string
+
+// ErrInvalidIndexCapacity is returned for an index capacity a deal can't have, such as one not
+// a power of two or one too small for the deal size, see WithIndexCapacity
+var ErrInvalidIndexCapacity = indexCapacityError("unknown")
+
+func (ice indexCapacityError) Error() string {
+	return string(ice)
+}
+
+func (ice indexCapacityError) Is(err error) bool {
+	_, ok := err.(indexCapacityError)
+	return ok
+}
+
+const (
+	// MaxSubtreeProofDepth is the maximum depth of ProofSubtree, reached by a 128 byte piece
+	// within a deal of MaxSupportedDealSize
+	MaxSubtreeProofDepth = verifylite.MaxSubtreeProofDepth
+	// MaxIndexProofDepth is the maximum depth of ProofIndex, equal to the depth of
+	// an index entry within a deal of MaxSupportedDealSize
+	MaxIndexProofDepth = verifylite.MaxIndexProofDepth
+)
+
+// CheckLimits checks that the proof doesn't exceed the limits of deals supported by this library.
+// It is cheap and is performed by ComputeExpectedAuxData before any work on the proof.
+// Returned errors match ErrProofLimitExceeded.
+func (ip InclusionProof) CheckLimits() error {
+	if d := ip.ProofSubtree.Depth(); d > MaxSubtreeProofDepth {
+		return xerrors.Errorf("%w: %d > %d", proofLimitError("subtree proof too deep"), d, MaxSubtreeProofDepth)
+	}
+	if d := ip.ProofIndex.Depth(); d > MaxIndexProofDepth {
+		return xerrors.Errorf("%w: %d > %d", proofLimitError("index proof too deep"), d, MaxIndexProofDepth)
+	}
+	return nil
+}
+
+// ImpliedDealSize returns the size of the aggregator's deal the proof is for. The size is
+// uniquely determined by the depth of ProofIndex, as every index entry is a 64 byte leaf pair
+// of the deal tree, so proofs can be matched against candidate deals by size before they are
+// verified. ComputeExpectedAuxData returns the same size for proofs it accepts.
+func (ip InclusionProof) ImpliedDealSize() (abi.PaddedPieceSize, error) {
+	if err := ip.CheckLimits(); err != nil {
+		return 0, xerrors.Errorf("proof rejected: %w", err)
+	}
+	return abi.PaddedPieceSize(EntrySize) << ip.ProofIndex.Depth(), nil
+}
+
 // InclusionAuxData is required for verification of the proof and needs to be cross-checked with the chain state
 type InclusionAuxData struct {
 	// Piece Commitment to aggregator's deal
@@ -28,7 +162,13 @@ type InclusionAuxData struct {
 	SizePa abi.PaddedPieceSize
 }
 
+// String formats the aux data as the PieceCID and padded size of the deal. The format is stable.
+func (ad InclusionAuxData) String() string {
+	return fmt.Sprintf("InclusionAuxData{CommPa: %s, SizePa: %d}", ad.CommPa, ad.SizePa)
+}
+
 // InclusionPoof is produced by the aggregator (or possibly by the SP)
+// Like merkletree.ProofData, it should be treated as immutable, use Clone before modifying it.
 type InclusionProof struct {
 	// ProofSubtree is proof of inclusion of the client's data segment in the data aggregator's Merkle tree (includes position information)
 	// I.e. a proof that the root node of the subtree containing all the nodes (leafs) of a data segment is contained in CommDA
@@ -38,11 +178,32 @@ type InclusionProof struct {
 	ProofIndex merkletree.ProofData
 }
 
-func indexAreaStart(sizePa abi.PaddedPieceSize) uint64 {
-	return uint64(sizePa) - uint64(MaxIndexEntriesInDeal(sizePa))*uint64(EntrySize)
+// Clone returns a deep copy of the proof
+func (ip InclusionProof) Clone() InclusionProof {
+	return InclusionProof{
+		ProofSubtree: ip.ProofSubtree.Clone(),
+		ProofIndex:   ip.ProofIndex.Clone(),
+	}
 }
 
+// ComputeExpectedAuxData verifies the proof and returns the commitment and the size of the
+// aggregator's deal. The proof is checked by the verifylite core, which also builds with TinyGo
+// and for wasm32.
 func (ip InclusionProof) ComputeExpectedAuxData(veriferData InclusionVerifierData) (*InclusionAuxData, error) {
+	return ip.computeExpectedAuxData(veriferData, 0)
+}
+
+// ComputeExpectedAuxDataWithIndexCapacity is like ComputeExpectedAuxData but accepts proofs for
+// an Aggregate created with WithIndexCapacity, where the index area holds indexCapacity entries.
+func (ip InclusionProof) ComputeExpectedAuxDataWithIndexCapacity(veriferData InclusionVerifierData, indexCapacity uint) (*InclusionAuxData, error) {
+	if indexCapacity == 0 {
+		return nil, xerrors.Errorf("index capacity has to be non-zero")
+	}
+	return ip.computeExpectedAuxData(veriferData, indexCapacity)
+}
+
+// computeExpectedAuxData verifies the proof, indexCapacity of zero selects the default capacity
+func (ip InclusionProof) computeExpectedAuxData(veriferData InclusionVerifierData, indexCapacity uint) (*InclusionAuxData, error) {
 	// Verification flow:
 	//  1. Varify inputs
 	//	2. Decode Client's Piece commitment
@@ -55,97 +216,97 @@ func (ip InclusionProof) ComputeExpectedAuxData(veriferData InclusionVerifierDat
 	//	9. Compare deal sizes and commitments from steps 2+3 against steps 5+6. Fail if not equal.
 	//	10. Return the computed values of aggregator's Commitment and Size as AuxData.
 
-	if !util.IsPow2(uint64(veriferData.SizePc)) {
-		return nil, xerrors.Errorf("size of piece provided by verifier is not power of two")
-	}
-
-	commPc, err := lightCid2CommP(veriferData.CommPc)
+	commPc, err := veriferData.validate()
 	if err != nil {
-		return nil, xerrors.Errorf("invalid piece commitment: %w", err)
+		return nil, xerrors.Errorf("invalid verifier data: %w", err)
 	}
-	nodeCommPc := (merkletree.Node)(commPc)
-
-	// Compute the Commitment to aggregator's data and assume it is correct
-	// we will cross validate it against the other proof and then return it for futher validation
-	assumedCommPa, err := ip.ProofSubtree.ComputeRoot(&nodeCommPc)
+	commPa, sizePa, err := ip.verify(commPc, veriferData.SizePc, indexCapacity)
 	if err != nil {
-		return nil, xerrors.Errorf("could not validate the subtree proof: %w", err)
-	}
-
-	var assumedSizePa abi.PaddedPieceSize
-	{
-		assumedSizePau64, ok := util.CheckedMultiply(uint64(1)<<ip.ProofSubtree.Depth(), uint64(veriferData.SizePc))
-		if !ok {
-			return nil, xerrors.Errorf("assumedSizePa overflow")
-		}
-		assumedSizePa = abi.PaddedPieceSize(assumedSizePau64)
+		return nil, err
 	}
 
-	// inclusion proof verification checks that index is less than the 1<<(path length)
-	dataOffset := ip.ProofSubtree.Index * uint64(veriferData.SizePc)
-
-	en, err := MakeDataSegmentIndexEntry((*fr32.Fr32)(&nodeCommPc), dataOffset, uint64(veriferData.SizePc))
+	cidPa, err := lightCommP2Cid(commPa)
 	if err != nil {
-		return nil, xerrors.Errorf("createding data segment index entry: %w", err)
+		return nil, xerrors.Errorf("converting raw commiement to CID: %w", err)
 	}
 
-	enNode := merkletree.TruncatedHash(en.SerializeFr32())
+	return &InclusionAuxData{
+		CommPa: cidPa,
+		SizePa: sizePa,
+	}, nil
+}
 
-	assumedCommPa2, err := ip.ProofIndex.ComputeRoot(enNode)
+// verify verifies the proof of the client's piece commPc of sizePc and returns the commitment
+// and the size of the aggregator's deal. It doesn't allocate unless the proof is rejected.
+func (ip InclusionProof) verify(commPc merkletree.Node, sizePc abi.PaddedPieceSize, indexCapacity uint) (merkletree.Node, abi.PaddedPieceSize, error) {
+	commPa, sizePa, err := ip.lite().ComputeExpectedAuxDataWithIndexCapacity(verifylite.Node(commPc),
+		uint64(sizePc), uint64(indexCapacity))
 	if err != nil {
-		return nil, xerrors.Errorf("could not validate the index proof: %w", err)
+		return merkletree.Node{}, 0, liteError(err)
 	}
+	return merkletree.Node(commPa), abi.PaddedPieceSize(sizePa), nil
+}
 
-	if *assumedCommPa != *assumedCommPa2 {
-		return nil, xerrors.Errorf("aggregator's data commiements don't match: %x != %x", assumedCommPa, assumedCommPa2)
+// lite returns the proof as verified by the verifylite core, without copying the paths
+func (ip InclusionProof) lite() verifylite.InclusionProofOf[merkletree.Node] {
+	return verifylite.InclusionProofOf[merkletree.Node]{
+		ProofSubtree: verifylite.ProofOf[merkletree.Node](ip.ProofSubtree),
+		ProofIndex:   verifylite.ProofOf[merkletree.Node](ip.ProofIndex),
 	}
+}
 
-	const BytesInDataSegmentIndexEntry = 2 * merkletree.NodeSize
-
-	var assumedSizePa2 abi.PaddedPieceSize
-	{
-		assumedSizePau64, ok := util.CheckedMultiply(uint64(1)<<ip.ProofIndex.Depth(), BytesInDataSegmentIndexEntry)
-		if !ok {
-			return nil, xerrors.Errorf("assumedSizePa2 overflow")
-		}
-		assumedSizePa2 = abi.PaddedPieceSize(assumedSizePau64)
+// liteError converts an error of the verifylite core into the errors of this package,
+// the error of the core remains in the chain
+func liteError(err error) error {
+	var le *verifylite.Error
+	if !errors.As(err, &le) {
+		return err
 	}
-
-	if assumedSizePa2 != assumedSizePa {
-		return nil, xerrors.Errorf("aggregator's data size doesn't match")
+	switch le.Kind {
+	case verifylite.ErrInvalidVerifierData:
+		return &coreError{xerrors.Errorf("invalid verifier data: %w", verifierDataError(le.Reason)), le}
+	case verifylite.ErrProofLimitExceeded:
+		return &coreError{xerrors.Errorf("proof rejected: %w", proofLimitError(le.Reason)), le}
+	case verifylite.ErrInvalidIndexCapacity:
+		return &coreError{xerrors.Errorf("invalid index capacity: %w", indexCapacityError(le.Reason)), le}
+	default:
+		return &coreError{proofError(le.Reason), le}
 	}
+}
 
-	idxStart := indexAreaStart(assumedSizePa2)
-	indexOffset, ok := util.CheckedMultiply(ip.ProofIndex.Index, BytesInDataSegmentIndexEntry)
-	if !ok {
-		return nil, xerrors.Errorf("indexOffset overflow")
-	}
-	if indexOffset < idxStart {
-		return nil, xerrors.Errorf("index entry at wrong position: %d < %d",
-			ip.ProofIndex.Index*uint64(EntrySize), idxStart)
-	}
+// coreError is an error of this package converted from an error of the verifylite core,
+// both match with errors.Is and errors.As
+type coreError struct {
+	err  error
+	core *verifylite.Error
+}
 
-	cidPa, err := lightCommP2Cid(*assumedCommPa)
-	if err != nil {
-		return nil, xerrors.Errorf("converting raw commiement to CID: %w", err)
-	}
+func (ce *coreError) Error() string {
+	return ce.err.Error()
+}
 
-	return &InclusionAuxData{
-		CommPa: cidPa,
-		SizePa: assumedSizePa,
-	}, nil
+func (ce *coreError) Unwrap() []error {
+	return []error{ce.err, ce.core}
 }
 
 func CollectInclusionProof(ht *merkletree.Hybrid, dealSize abi.PaddedPieceSize, pieceInfo merkletree.CommAndLoc, indexEntry int) (*InclusionProof, error) {
+	return collectInclusionProof(ht, IndexAreaStartPadded(dealSize), pieceInfo, indexEntry)
+}
+
+// collectInclusionProof collects the proof for an index area starting at iAS padded bytes
+func collectInclusionProof(ht *merkletree.Hybrid, iAS uint64, pieceInfo merkletree.CommAndLoc, indexEntry int) (*InclusionProof, error) {
 	subTreeProof, err := ht.CollectProof(pieceInfo.Loc.Level, pieceInfo.Loc.Index)
 	if err != nil {
 		return nil, xerrors.Errorf("collecting subtree proof: %w", err)
 	}
 
-	iAS := indexAreaStart(dealSize)
-	dsProof, err := ht.CollectProof(1, iAS/EntrySize+uint64(indexEntry))
+	entryLoc, err := indexEntryLocation(iAS, uint64(indexEntry))
 	if err != nil {
-		return nil, xerrors.Errorf("collecting subtree proof: %w", err)
+		return nil, xerrors.Errorf("locating index entry: %w", err)
+	}
+	dsProof, err := ht.CollectProof(entryLoc.Level, entryLoc.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("collecting index proof: %w", err)
 	}
 
 	return &InclusionProof{ProofSubtree: subTreeProof, ProofIndex: dsProof}, nil
@@ -1,8 +1,11 @@
 package datasegment
 
 import (
+	"errors"
+	"math/rand"
 	"testing"
 
+	"github.com/filecoin-project/go-data-segment/merkletree"
 	abi "github.com/filecoin-project/go-state-types/abi"
 )
 
@@ -45,3 +48,111 @@ func FuzzInclusionProofSizes(f *testing.F) {
 		}
 	})
 }
+
+// FuzzInclusionProofStructure crafts proofs of arbitrary depth and position and checks that
+// proofs exceeding the limits are rejected with ErrProofLimitExceeded and that none of them
+// pass verification.
+func FuzzInclusionProofStructure(f *testing.F) {
+	verifData, incProof, _ := InclusionGolden1()
+	f.Add(uint8(incProof.ProofSubtree.Depth()), incProof.ProofSubtree.Index,
+		uint8(incProof.ProofIndex.Depth()), incProof.ProofIndex.Index, uint64(verifData.SizePc))
+	f.Add(uint8(63), uint64(1)<<62, uint8(63), uint64(1)<<62, uint64(128))
+	f.Add(uint8(29), uint64(0), uint8(30), uint64(1)<<30-1, uint64(128))
+	f.Add(uint8(255), uint64(0), uint8(0), uint64(0), uint64(1)<<63)
+
+	f.Fuzz(func(t *testing.T, subtreeDepth uint8, subtreeIndex uint64, indexDepth uint8, indexIndex uint64, sizePc uint64) {
+		newVerifData := verifData
+		newVerifData.SizePc = abi.PaddedPieceSize(sizePc)
+		proof := InclusionProof{
+			ProofSubtree: merkletree.ProofData{Index: subtreeIndex, Path: make([]merkletree.Node, subtreeDepth)},
+			ProofIndex:   merkletree.ProofData{Index: indexIndex, Path: make([]merkletree.Node, indexDepth)},
+		}
+		_, err := proof.ComputeExpectedAuxData(newVerifData)
+		if err == nil {
+			t.Fatalf("crafted proof passed verification")
+		}
+		if (int(subtreeDepth) > MaxSubtreeProofDepth || int(indexDepth) > MaxIndexProofDepth) &&
+			newVerifData.Validate() == nil && !errors.Is(err, ErrProofLimitExceeded) {
+			t.Fatalf("proof exceeding limits not rejected as such: %v", err)
+		}
+	})
+}
+
+// FuzzVerificationPathsAgree builds random aggregates, mutates proofs of their pieces and checks
+// that the verification paths accepting the same proofs agree on accepting or rejecting them:
+// ComputeExpectedAuxData, ComputeExpectedAuxDataWithIndexCapacity with the default capacity and
+// ComputeExpectedAuxDataChained without an inner proof.
+func FuzzVerificationPathsAgree(f *testing.F) {
+	for m := uint8(0); m < 8; m++ {
+		f.Add(int64(m), uint8(5), uint8(8), m, uint16(m)*7)
+	}
+	f.Fuzz(func(t *testing.T, seed int64, nPieces, dealLog, mutation uint8, mutPos uint16) {
+		rng := rand.New(rand.NewSource(seed))
+		dealSize := abi.PaddedPieceSize(1) << (12 + dealLog%12)
+		pieces := make([]abi.PieceInfo, 1+int(nPieces)%12)
+		for i := range pieces {
+			pieces[i] = abi.PieceInfo{PieceCID: cidForDeal(rng.Int()), Size: 128 << rng.Intn(5)}
+		}
+		a, err := NewAggregate(dealSize, pieces)
+		if err != nil {
+			return
+		}
+		pi := pieces[rng.Intn(len(pieces))]
+		ip, err := a.ProofForPieceInfo(pi)
+		if err != nil {
+			t.Fatalf("producing proof: %+v", err)
+		}
+		proof := ip.Clone()
+		vd := VerifierDataForPieceInfo(pi)
+
+		flip := func(path []merkletree.Node) {
+			if len(path) != 0 {
+				path[int(mutPos)%len(path)][int(mutPos)%merkletree.NodeSize] ^= 1
+			}
+		}
+		switch mutation % 8 {
+		case 1:
+			flip(proof.ProofSubtree.Path)
+		case 2:
+			flip(proof.ProofIndex.Path)
+		case 3:
+			proof.ProofSubtree.Index ^= uint64(mutPos)
+		case 4:
+			proof.ProofIndex.Index ^= uint64(mutPos)
+		case 5:
+			vd.SizePc <<= mutPos % 3
+		case 6:
+			if len(proof.ProofSubtree.Path) != 0 {
+				proof.ProofSubtree.Path = proof.ProofSubtree.Path[1:]
+			}
+		case 7:
+			vd.CommPc = cidForDeal(int(mutPos))
+		}
+
+		aux1, err1 := proof.ComputeExpectedAuxData(vd)
+		aux2, err2 := proof.ComputeExpectedAuxDataWithIndexCapacity(vd, MaxIndexEntriesInDeal(dealSize))
+		var aux3 *InclusionAuxData
+		var err3 error
+		if commPc, err := lightCid2CommP(vd.CommPc); err == nil {
+			aux3, err3 = proof.ComputeExpectedAuxDataChained(commPc, 0, vd)
+		} else {
+			err3 = err
+		}
+
+		if (err1 == nil) != (err2 == nil) || (err1 == nil) != (err3 == nil) {
+			t.Fatalf("verification paths disagree: %v, %v, %v", err1, err2, err3)
+		}
+		if err1 != nil {
+			if mutation%8 == 0 {
+				t.Fatalf("unmodified proof rejected: %+v", err1)
+			}
+			return
+		}
+		if *aux1 != *aux2 || *aux1 != *aux3 {
+			t.Fatalf("verification paths computed different aux data: %v, %v, %v", aux1, aux2, aux3)
+		}
+		if aux1.CommPa != Must(a.PieceCID()) || aux1.SizePa != dealSize {
+			t.Fatalf("accepted proof for a different deal: %v", aux1)
+		}
+	})
+}
@@ -3,10 +3,12 @@ package datasegment
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/filecoin-project/go-data-segment/verifylite"
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	"github.com/filecoin-project/go-state-types/abi"
 	cid "github.com/ipfs/go-cid"
@@ -79,7 +81,7 @@ func TestComputeExpectedAuxData1(t *testing.T) {
 	index, err := MakeIndexFromCommLoc(col)
 
 	require.NoError(t, err)
-	indexStartNodes := indexAreaStart(SizePa) / merkletree.NodeSize
+	indexStartNodes := IndexAreaStartPadded(SizePa) / merkletree.NodeSize
 	for i, e := range index.Entries {
 		ns := e.IntoNodes()
 		err := ht.SetNode(0, indexStartNodes+2*uint64(i), &ns[0])
@@ -131,3 +133,151 @@ func Must[T any](t T, err error) T {
 	}
 	return t
 }
+
+func TestInclusionVerifierDataValidate(t *testing.T) {
+	assert.NoError(t, InclusionVerifierData{CommPc: cidForDeal(1), SizePc: 128}.Validate())
+
+	rawCid := cid.NewCidV1(cid.Raw, cidForDeal(1).Hash())
+	for name, vd := range map[string]InclusionVerifierData{
+		"not pow2":      {CommPc: cidForDeal(1), SizePc: 384},
+		"zero size":     {CommPc: cidForDeal(1), SizePc: 0},
+		"too small":     {CommPc: cidForDeal(1), SizePc: 64},
+		"undefined cid": {CommPc: cid.Undef, SizePc: 128},
+		"wrong codec":   {CommPc: rawCid, SizePc: 128},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := vd.Validate()
+			assert.ErrorIs(t, err, ErrInvalidVerifierData)
+
+			_, err = InclusionProof{}.ComputeExpectedAuxData(vd)
+			assert.ErrorIs(t, err, ErrInvalidVerifierData)
+		})
+	}
+}
+
+func TestVerifierDataForRawSize(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x5}, 1000)
+	pi, err := pieceInfoForSource(PieceSource{Reader: bytes.NewReader(payload)}, nil)
+	require.NoError(t, err)
+	a, err := NewAggregate(1<<20, []abi.PieceInfo{{PieceCID: cidForDeal(1), Size: 256}, pi})
+	require.NoError(t, err)
+	ip, err := a.ProofForPieceInfo(pi)
+	require.NoError(t, err)
+
+	vd, err := VerifierDataForRawSize(pi.PieceCID, uint64(len(payload)))
+	require.NoError(t, err)
+	assert.Equal(t, pi.Size, vd.SizePc)
+	aux, err := ip.ComputeExpectedAuxData(vd)
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+
+	_, err = VerifierDataForRawSize(pi.PieceCID, 1<<63)
+	assert.ErrorIs(t, err, ErrInvalidVerifierData)
+	_, err = VerifierDataForRawSize(cid.Undef, 1000)
+	assert.ErrorIs(t, err, ErrInvalidVerifierData)
+
+	// a proof of a different piece is a proof failure, not a shape failure
+	other, err := a.ProofForPieceInfo(abi.PieceInfo{PieceCID: cidForDeal(1), Size: 256})
+	require.NoError(t, err)
+	other.ProofSubtree.Path = append(other.ProofSubtree.Path[:0:0], other.ProofSubtree.Path[1:]...)
+	other.ProofSubtree.Index >>= 1
+	_, err = other.ComputeExpectedAuxData(vd)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+	assert.NotErrorIs(t, err, ErrInvalidVerifierData)
+
+	bad := ip.Clone()
+	bad.ProofIndex.Path[0][0] ^= 1
+	_, err = bad.ComputeExpectedAuxData(vd)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+	// the error of the verifylite core is kept in the chain
+	assert.ErrorIs(t, err, verifylite.ErrInvalidProof)
+	var le *verifylite.Error
+	require.ErrorAs(t, err, &le)
+	assert.Equal(t, err.Error(), le.Reason)
+}
+
+func TestInclusionProofClone(t *testing.T) {
+	pi := abi.PieceInfo{PieceCID: cidForDeal(0), Size: 128}
+	a, err := NewAggregate(1<<20, []abi.PieceInfo{pi})
+	require.NoError(t, err)
+	proof, err := a.ProofForPieceInfo(pi)
+	require.NoError(t, err)
+
+	clone := proof.Clone()
+	assert.Equal(t, *proof, clone)
+	clone.ProofSubtree.Path[0] = merkletree.Node{0xff}
+	clone.ProofIndex.Path[0] = merkletree.Node{0xff}
+
+	_, err = proof.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+	assert.NoError(t, err)
+	_, err = clone.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+	assert.Error(t, err)
+
+	// proofs collected from the tree don't share their paths with it
+	again, err := a.ProofForPieceInfo(pi)
+	require.NoError(t, err)
+	again.ProofSubtree.Path[0] = merkletree.Node{0xff}
+	assert.Equal(t, *proof, *Must(a.ProofForPieceInfo(pi)))
+}
+
+func TestInclusionProofLimits(t *testing.T) {
+	verifData, incProof, expectedAux := InclusionGolden1()
+	require.NoError(t, incProof.CheckLimits())
+	aux, err := incProof.ComputeExpectedAuxData(verifData)
+	require.NoError(t, err)
+	assert.Equal(t, expectedAux, *aux)
+
+	deep := incProof.Clone()
+	deep.ProofSubtree.Path = make([]merkletree.Node, MaxSubtreeProofDepth+1)
+	assert.ErrorIs(t, deep.CheckLimits(), ErrProofLimitExceeded)
+	_, err = deep.ComputeExpectedAuxData(verifData)
+	assert.ErrorIs(t, err, ErrProofLimitExceeded)
+
+	deep = incProof.Clone()
+	deep.ProofIndex.Path = make([]merkletree.Node, MaxIndexProofDepth+1)
+	_, err = deep.ComputeExpectedAuxData(verifData)
+	assert.ErrorIs(t, err, ErrProofLimitExceeded)
+	_, err = deep.ImpliedDealSize()
+	assert.ErrorIs(t, err, ErrProofLimitExceeded)
+	assert.Equal(t, expectedAux.SizePa, Must(incProof.ImpliedDealSize()))
+
+	// a proof claiming a deal larger than MaxSupportedDealSize
+	verifData.SizePc = MaxSupportedDealSize
+	_, err = incProof.ComputeExpectedAuxData(verifData)
+	assert.ErrorIs(t, err, ErrProofLimitExceeded)
+
+	// the limits are consistent with MaxSupportedDealSize
+	assert.Equal(t, uint64(MaxSupportedDealSize), uint64(128)<<MaxSubtreeProofDepth)
+	assert.Equal(t, uint64(MaxSupportedDealSize), uint64(EntrySize)<<MaxIndexProofDepth)
+}
+
+func TestIndexEntryAddressingAllDealSizes(t *testing.T) {
+	piece := abi.PieceInfo{PieceCID: cidForDeal(1), Size: 128}
+	for dealSize := abi.PaddedPieceSize(512); dealSize <= MaxSupportedDealSize; dealSize <<= 1 {
+		iAS := IndexAreaStartPadded(dealSize)
+		loc, err := indexEntryLocation(iAS, 0)
+		require.NoError(t, err, "deal size %d", dealSize)
+		assert.Equal(t, iAS, loc.ByteOffset(), "deal size %d", dealSize)
+		assert.Equal(t, uint64(EntrySize), loc.Size())
+
+		a, err := NewAggregate(dealSize, []abi.PieceInfo{piece})
+		require.NoError(t, err, "deal size %d", dealSize)
+		ip, err := a.ProofForPieceInfo(piece)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(piece))
+		require.NoError(t, err, "deal size %d", dealSize)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+		assert.Equal(t, loc.Index, ip.ProofIndex.Index)
+		assert.Equal(t, dealSize, Must(ip.ImpliedDealSize()))
+
+		last := int(MaxIndexEntriesInDeal(dealSize)) - 1
+		sp, err := a.ProofForIndexSlot(last)
+		require.NoError(t, err)
+		require.NoError(t, sp.Verify(*aux), "deal size %d", dealSize)
+	}
+
+	_, err := indexEntryLocation(EntrySize+merkletree.NodeSize, 0)
+	assert.Error(t, err)
+	_, err = indexEntryLocation(EntrySize, math.MaxUint64)
+	assert.Error(t, err)
+}
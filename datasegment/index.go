@@ -7,16 +7,15 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"iter"
 
 	"github.com/filecoin-project/go-data-segment/fr32"
 	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/filecoin-project/go-data-segment/verifylite"
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	"github.com/filecoin-project/go-state-types/abi"
 	cid "github.com/ipfs/go-cid"
-	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 )
 
@@ -33,21 +32,103 @@ func (ve validationError) Is(err error) bool {
 	return ok
 }
 
-const ChecksumSize = 16
+type segmentSizeError string
+
+// ErrSegmentTooSmall is returned by Validate for entries smaller than MinSegmentSize, including
+// entries of zero size. It also matches ErrValidation.
+var ErrSegmentTooSmall = segmentSizeError("unknown")
+
+func (sse segmentSizeError) Error() string {
+	return string(sse)
+}
+
+func (sse segmentSizeError) Is(err error) bool {
+	switch err.(type) {
+	case segmentSizeError, validationError:
+		return true
+	}
+	return false
+}
+
+// MinSegmentSize is the padded size of the smallest segment, holding 127 bytes of unpadded data
+// in a subtree of four leaf nodes
+const MinSegmentSize = verifylite.MinSegmentSize
+
+type dealSizeError string
+
+// ErrDealSizeNotSupported is returned when the deal size is not supported by this library
+var ErrDealSizeNotSupported = dealSizeError("unknown")
+
+func (dse dealSizeError) Error() string {
+	return string(dse)
+}
+
+func (dse dealSizeError) Is(err error) bool {
+	_, ok := err.(dealSizeError)
+	return ok
+}
+
+// MaxSupportedDealSize is the largest deal size supported, equal to the largest sector size
+const MaxSupportedDealSize = abi.PaddedPieceSize(verifylite.MaxSupportedDealSize)
+
+// MinSupportedDealSize is the smallest deal size supported. Smaller deals can't fit both the
+// minimal index area of 4 entries and a segment of MinSegmentSize, the smallest devnet sectors
+// of 2KiB are supported.
+const MinSupportedDealSize = abi.PaddedPieceSize(512)
+
+// ValidateDealSize checks that the dealSize is a valid padded piece size between
+// MinSupportedDealSize and MaxSupportedDealSize. Returned errors match ErrDealSizeNotSupported.
+func ValidateDealSize(dealSize abi.PaddedPieceSize) error {
+	if err := dealSize.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", dealSizeError("invalid deal size"), err)
+	}
+	if dealSize < MinSupportedDealSize {
+		return xerrors.Errorf("%w: %d < %d", dealSizeError("deal size too small to hold the index and a segment"),
+			dealSize, MinSupportedDealSize)
+	}
+	if dealSize > MaxSupportedDealSize {
+		return xerrors.Errorf("%w: %d > %d", dealSizeError("deal size too large"), dealSize, MaxSupportedDealSize)
+	}
+	return nil
+}
+
+const ChecksumSize = verifylite.ChecksumSize
 
 const EntrySize = merkletree.NodeSize + 2*BytesInInt + ChecksumSize
 
+// indexEntryLevel is the level of the node covering a single index entry, which spans
+// two leaf nodes
+const indexEntryLevel = 1
+
+// indexEntryLocation returns the location of the node covering the entry in the given slot
+// of an index area starting at indexAreaStart padded bytes. It is the single source of the
+// addressing of index entries, both when writing the index into the tree and when
+// collecting proofs of it.
+func indexEntryLocation(indexAreaStart uint64, slot uint64) (merkletree.Location, error) {
+	if indexAreaStart%EntrySize != 0 {
+		return merkletree.Location{}, xerrors.Errorf("index area start %d is not aligned to the entry size", indexAreaStart)
+	}
+	index, ok := util.CheckedAdd(indexAreaStart/EntrySize, slot)
+	if !ok {
+		return merkletree.Location{}, xerrors.Errorf("index entry slot %d overflows", slot)
+	}
+	return merkletree.Location{Level: indexEntryLevel, Index: index}, nil
+}
+
+// LocationPaddedSize returns the number of padded bytes under the Location as a padded piece size
+func LocationPaddedSize(l merkletree.Location) abi.PaddedPieceSize {
+	return abi.PaddedPieceSize(l.Size())
+}
+
 // MaxIndexEntriesInDeal defines the maximum number of index entries in for a given size of a deal
+// The result is only meaningful for deal sizes accepted by ValidateDealSize.
 func MaxIndexEntriesInDeal(dealSize abi.PaddedPieceSize) uint {
-	res := uint(1) << util.Log2Ceil(uint64(dealSize)/2048/uint64(EntrySize))
-	if res < 4 {
-		return 4
-	}
-	return res
+	return uint(verifylite.MaxIndexEntries(uint64(dealSize)))
 }
 
 type IndexData struct {
-	Entries []SegmentDesc
+	// Entries allows for up to 2Mi entries in CBOR, enough for the index of a 64GiB deal
+	Entries []SegmentDesc `cborgen:"maxlen=2097152"`
 }
 
 func MakeIndex(entries []SegmentDesc) (*IndexData, error) {
@@ -60,13 +141,19 @@ func MakeIndex(entries []SegmentDesc) (*IndexData, error) {
 	return &index, nil
 }
 
+// MakeIndexFromCommLoc creates the index describing the deal nodes, an empty CommAndLoc results
+// in an empty entry
 func MakeIndexFromCommLoc(dealInfos []merkletree.CommAndLoc) (*IndexData, error) {
 	entries := make([]SegmentDesc, 0, len(dealInfos))
 	for _, di := range dealInfos {
+		if di == (merkletree.CommAndLoc{}) {
+			entries = append(entries, SegmentDesc{})
+			continue
+		}
 		sd := SegmentDesc{
 			CommDs: di.Comm,
-			Offset: di.Loc.LeafIndex() * merkletree.NodeSize,
-			Size:   1 << di.Loc.Level * merkletree.NodeSize,
+			Offset: di.Loc.ByteOffset(),
+			Size:   di.Loc.Size(),
 		}
 		sd.Checksum = sd.computeChecksum()
 		entries = append(entries, sd)
@@ -101,20 +188,39 @@ func (id IndexData) MarshalBinary() (data []byte, err error) {
 }
 
 func (id *IndexData) UnmarshalBinary(data []byte) error {
+	res, err := UnmarshalBinaryInto(data, nil)
+	if err != nil {
+		*id = IndexData{}
+		return err
+	}
+	*id = res
+	return nil
+}
+
+// UnmarshalBinaryInto is like IndexData.UnmarshalBinary but decodes the entries into the
+// backing array of entries if its capacity suffices, allocating only otherwise. The returned
+// index aliases entries, which must not be used while the index is.
+// For high-throughput parsing, entries slices can be kept in a sync.Pool and returned
+// to it once the index is no longer needed.
+func UnmarshalBinaryInto(data []byte, entries []SegmentDesc) (IndexData, error) {
 	if rem := len(data) % EntrySize; rem != 0 {
-		return xerrors.Errorf("data to unmarshal is not a multiple of EntrySize: %d % %d != 0 (%d)",
+		return IndexData{}, xerrors.Errorf("data to unmarshal is not a multiple of EntrySize: %d %% %d != 0 (%d)",
 			len(data), EntrySize, rem)
 	}
 
-	*id = IndexData{}
-	id.Entries = make([]SegmentDesc, len(data)/EntrySize)
-	for i := 0; i < len(id.Entries); i++ {
-		err := id.Entries[i].UnmarshalBinary(data[i*EntrySize : (i+1)*EntrySize])
+	n := len(data) / EntrySize
+	if entries != nil && cap(entries) >= n {
+		entries = entries[:n]
+	} else {
+		entries = make([]SegmentDesc, n)
+	}
+	for i := range entries {
+		err := entries[i].UnmarshalBinary(data[i*EntrySize : (i+1)*EntrySize])
 		if err != nil {
-			return xerrors.Errorf("unamrshaling entry at index %d: %w", i, err)
+			return IndexData{}, xerrors.Errorf("unamrshaling entry at index %d: %w", i, err)
 		}
 	}
-	return nil
+	return IndexData{Entries: entries}, nil
 }
 
 func (id IndexData) Validate() error {
@@ -126,21 +232,106 @@ func (id IndexData) Validate() error {
 	return nil
 }
 
+// ValidateStrict is like Validate but uses SegmentDesc.ValidateStrict for every entry
+func (id IndexData) ValidateStrict() error {
+	for i, e := range id.Entries {
+		if err := e.ValidateStrict(); err != nil {
+			return xerrors.Errorf("entry at index %d failed strict validation: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// All returns an iterator over the positions and entries of the index, including empty
+// and invalid entries
+func (id IndexData) All() iter.Seq2[int, SegmentDesc] {
+	return func(yield func(int, SegmentDesc) bool) {
+		for i, e := range id.Entries {
+			if !yield(i, e) {
+				return
+			}
+		}
+	}
+}
+
+// Valid returns an iterator over the entries which pass validation checks, like ValidEntries
+// but without allocating. Empty entries and entries failing validation are skipped.
+// Errors other than ErrValidation are yielded with a zero entry, after which iteration stops.
+func (id IndexData) Valid() iter.Seq2[SegmentDesc, error] {
+	return func(yield func(SegmentDesc, error) bool) {
+		for i, e := range id.Entries {
+			if e == (SegmentDesc{}) {
+				continue
+			}
+			if err := e.Validate(); err != nil {
+				if errors.Is(err, ErrValidation) {
+					continue
+				}
+				yield(SegmentDesc{}, xerrors.Errorf("got unknown error for entry %d: %w", i, err))
+				return
+			}
+			if !yield(e, nil) {
+				return
+			}
+		}
+	}
+}
+
 // ValidEntries returns a slice of entries in the index which pass validation checks
 func (id IndexData) ValidEntries() ([]SegmentDesc, error) {
+	res, _, err := id.ValidEntriesWithReport()
+	return res, err
+}
+
+// RejectedEntry describes an entry of the index which failed validation
+type RejectedEntry struct {
+	// Index is the position of the entry in the index
+	Index int
+	Err   error
+}
+
+// ValidationReport summarises the validation of all entries in the index
+type ValidationReport struct {
+	// Valid is the number of entries which passed validation
+	Valid int
+	// Empty is the number of all-zero entries, these are unused slots and are not reported as rejected
+	Empty int
+	// Rejected lists entries which failed validation, in order of their position
+	Rejected []RejectedEntry
+}
+
+// RejectionCounts returns the number of rejected entries for each reason
+func (vr ValidationReport) RejectionCounts() map[string]int {
+	res := make(map[string]int)
+	for _, r := range vr.Rejected {
+		res[r.Err.Error()]++
+	}
+	return res
+}
+
+// ValidEntriesWithReport returns a slice of entries in the index which pass validation checks
+// together with a report on entries which were skipped.
+func (id IndexData) ValidEntriesWithReport() ([]SegmentDesc, ValidationReport, error) {
 	res := []SegmentDesc{}
+	report := ValidationReport{}
 	for i, e := range id.Entries {
+		if e == (SegmentDesc{}) {
+			report.Empty++
+			continue
+		}
 
 		if err := e.Validate(); err != nil {
 			if errors.Is(err, ErrValidation) {
+				report.Rejected = append(report.Rejected, RejectedEntry{Index: i, Err: err})
 				continue
 			} else {
-				return nil, xerrors.Errorf("got unknown error for entry %d: %w", i, err)
+				return nil, ValidationReport{}, xerrors.Errorf("got unknown error for entry %d: %w", i, err)
 			}
 		}
 		res = append(res, e)
 	}
-	return res, nil
+	report.Valid = len(res)
+	return res, report, nil
 }
 
 // SegmentDesc contains a data segment description to be contained as two Fr32 elements in 2 leaf nodes of the data segment index
@@ -156,24 +347,77 @@ type SegmentDesc struct {
 }
 
 // PieceCID returns the PieceCID of the sub-deal
+//
+// Deprecated: PieceCID panics if the commitment cannot be converted, use PieceCIDErr instead.
 func (sd SegmentDesc) PieceCID() cid.Cid {
-	c, err := commcid.PieceCommitmentV1ToCID(sd.CommDs[:])
+	c, err := sd.PieceCIDErr()
 	if err != nil {
-		panic("CommDs is always 32 bytes: " + err.Error())
+		panic(err)
 	}
 	return c
 }
 
+// PieceCIDErr returns the PieceCID of the sub-deal or an error if the CommDs cannot be converted
+func (sd SegmentDesc) PieceCIDErr() (cid.Cid, error) {
+	c, err := commcid.PieceCommitmentV1ToCID(sd.CommDs[:])
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("converting CommDs to PieceCID: %w", err)
+	}
+	return c, nil
+}
+
+// String formats the entry on one line as its PieceCID, padded offset and padded size.
+// The format is stable.
+func (sd SegmentDesc) String() string {
+	c, err := sd.PieceCIDErr()
+	if err != nil {
+		return fmt.Sprintf("SegmentDesc{CommDs: %x, Offset: %d, Size: %d}", sd.CommDs[:], sd.Offset, sd.Size)
+	}
+	return fmt.Sprintf("SegmentDesc{PieceCID: %s, Offset: %d, Size: %d}", c, sd.Offset, sd.Size)
+}
+
+// PaddedOffset returns the offset of the sub-deal relative to the deal start in padded bytes
+func (sd SegmentDesc) PaddedOffset() abi.PaddedPieceSize {
+	return abi.PaddedPieceSize(sd.Offset)
+}
+
+// PaddedSize returns the padded size of the sub-deal
+func (sd SegmentDesc) PaddedSize() abi.PaddedPieceSize {
+	return abi.PaddedPieceSize(sd.Size)
+}
+
+// UnpaddedSize returns the unpadded size of the sub-deal.
+// The result is only meaningful for entries passing Validate, see CheckSizes.
+func (sd SegmentDesc) UnpaddedSize() abi.UnpaddedPieceSize {
+	return sd.PaddedSize().Unpadded()
+}
+
+// CheckSizes checks that the offset and size of the entry are consistent with padded data:
+// the size is a valid padded piece size and the offset is aligned to 128 bytes.
+// Returned errors match ErrValidation.
+func (sd SegmentDesc) CheckSizes() error {
+	if err := sd.PaddedSize().Validate(); err != nil {
+		return fmt.Errorf("%w: %w", validationError("invalid padded size"), err)
+	}
+	if sd.Offset%128 != 0 {
+		return validationError("offset is not aligned in padded data")
+	}
+	return nil
+}
+
 // UnpaddedOffest returns unpadded offset of the sub-deal relative to the deal start
 func (sd SegmentDesc) UnpaddedOffest() uint64 {
-	return sd.Offset - sd.Offset/128
+	return uint64(sd.PaddedOffset().Unpadded())
 }
 
 // UnpaddedLength returns unpadded length of the sub-deal
 func (sd SegmentDesc) UnpaddedLength() uint64 {
-	return sd.Size - sd.Size/128
+	return uint64(sd.UnpaddedSize())
 }
 
+// CommAndLoc returns the commitment and the location of the subtree of the segment.
+// The result is only meaningful for entries passing Validate, a segment of MinSegmentSize
+// is rooted at level 2.
 func (sd SegmentDesc) CommAndLoc() merkletree.CommAndLoc {
 	lvl := util.Log2Ceil(sd.Size / merkletree.NodeSize)
 	res := merkletree.CommAndLoc{
@@ -187,15 +431,7 @@ func (sd SegmentDesc) CommAndLoc() merkletree.CommAndLoc {
 }
 
 func (sd SegmentDesc) computeChecksum() [ChecksumSize]byte {
-	sdCopy := sd
-	sdCopy.Checksum = [ChecksumSize]byte{}
-
-	toHash := sdCopy.SerializeFr32()
-	digest := sha256.Sum256(toHash)
-	res := digest[:ChecksumSize]
-	// Truncate to  126 bits
-	res[ChecksumSize-1] &= 0b00111111
-	return *(*[ChecksumSize]byte)(res)
+	return verifylite.Entry{CommDs: verifylite.Node(sd.CommDs), Offset: sd.Offset, Size: sd.Size}.ComputeChecksum()
 }
 
 func (sd SegmentDesc) withUpdatedChecksum() SegmentDesc {
@@ -248,13 +484,18 @@ func (sd SegmentDesc) SerializeFr32Into(slice []byte) {
 }
 
 func (sd SegmentDesc) IntoNodes() [2]merkletree.Node {
-	res := sd.SerializeFr32()
+	var res [EntrySize]byte
+	sd.SerializeFr32Into(res[:])
 	return [2]merkletree.Node{
 		*(*merkletree.Node)(res[:merkletree.NodeSize]),
 		*(*merkletree.Node)(res[merkletree.NodeSize:]),
 	}
 }
 
+// Validate checks the checksum, alignment and size of the entry.
+// Entries have no reserved fields a later revision of FRC-0058 could set: the two top bits of
+// the checksum, outside of the 126 bit checksum, are cleared by Fr32 padding, so they are zero
+// in every index read from a deal. Any other change to an entry changes its checksum.
 func (sd SegmentDesc) Validate() error {
 	if sd.computeChecksum() != sd.Checksum {
 		return validationError("computed checksum does not match embedded checksum")
@@ -265,6 +506,32 @@ func (sd SegmentDesc) Validate() error {
 	if sd.Size%128 != 0 {
 		return validationError("size is not aligned in padded data")
 	}
+	if sd.Size < MinSegmentSize {
+		return xerrors.Errorf("%w: %d < %d", segmentSizeError("segment is smaller than the minimal piece size"), sd.Size, MinSegmentSize)
+	}
+	if _, ok := util.CheckedAdd(sd.Offset, sd.Size); !ok {
+		return validationError("end of the segment overflows")
+	}
+	if _, err := sd.PieceCIDErr(); err != nil {
+		return validationError("commitment cannot be converted to PieceCID")
+	}
+	return nil
+}
+
+// ValidateStrict performs the checks of Validate and additionally requires the entry to
+// describe a whole subtree of the deal: Size is a power of two and Offset is aligned to Size.
+// Entries created by NewAggregate always satisfy these. The 127 byte unpadded alignment
+// recommended by FRC-0058 equals the 128 byte padded alignment checked by Validate.
+func (sd SegmentDesc) ValidateStrict() error {
+	if err := sd.Validate(); err != nil {
+		return err
+	}
+	if !util.IsPow2(sd.Size) || sd.Size < 128 {
+		return validationError("size is not a power of two of at least 128 bytes")
+	}
+	if sd.Offset%sd.Size != 0 {
+		return validationError("offset is not aligned to size")
+	}
 	return nil
 }
 
@@ -308,7 +575,7 @@ func MakeDataSegmentIndexEntry(CommP *fr32.Fr32, offset uint64, size uint64) (*S
 func MakeDataSegmentIdx(commDs *fr32.Fr32, offset uint64, size uint64) (SegmentDesc, error) {
 	checksum, err := computeChecksum((*merkletree.Node)(commDs), offset, size)
 	if err != nil {
-		log.Println("could not compute checksum")
+		logger.Printf("could not compute checksum")
 		return SegmentDesc{}, err
 	}
 	return MakeDataSegmentIdxWithChecksum(commDs, offset, size, checksum)
@@ -377,7 +644,7 @@ func SerializeIndex(index *IndexData) ([]byte, error) {
 	}
 	res, err := serializeIndex(index)
 	if err != nil {
-		log.Println("could not serialize index")
+		logger.Printf("could not serialize index")
 		return nil, err
 	}
 	return res, nil
@@ -389,7 +656,7 @@ func serializeIndex(index *IndexData) ([]byte, error) {
 	for i := 0; i < index.NumberEntries(); i++ {
 		err := serializeFr32Entry(buf, index.SegmentDesc(i))
 		if err != nil {
-			log.Printf("could not write SegmentDesc %d\n", i)
+			logger.Printf("could not write SegmentDesc %d", i)
 			return nil, xerrors.Errorf("could not write data segment (index %d): %w", i, err)
 		}
 	}
@@ -456,90 +723,3 @@ func validateChecksum(en *SegmentDesc) (bool, error) {
 	}
 	return bytes.Equal(refChecksum[:], en.Checksum[:]), nil
 }
-
-var lengthBufIndexData = []byte{129}
-
-// adjusted encoder, allowing 2Mi entries in the Index
-func (t *IndexData) MarshalCBOR(w io.Writer) error {
-	if t == nil {
-		_, err := w.Write(cbg.CborNull)
-		return err
-	}
-
-	cw := cbg.NewCborWriter(w)
-
-	if _, err := cw.Write(lengthBufIndexData); err != nil {
-		return err
-	}
-
-	// t.Entries ([]datasegment.SegmentDesc) (slice)
-	if len(t.Entries) > 2<<20 {
-		return xerrors.Errorf("Slice value in field t.Entries was too long")
-	}
-
-	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.Entries))); err != nil {
-		return err
-	}
-	for _, v := range t.Entries {
-		if err := v.MarshalCBOR(cw); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// adjusted decoder, allowing 2Mi entries in the Index
-func (t *IndexData) UnmarshalCBOR(r io.Reader) (err error) {
-	*t = IndexData{}
-
-	cr := cbg.NewCborReader(r)
-
-	maj, extra, err := cr.ReadHeader()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
-		}
-	}()
-
-	if maj != cbg.MajArray {
-		return fmt.Errorf("cbor input should be of type array")
-	}
-
-	if extra != 1 {
-		return fmt.Errorf("cbor input had wrong number of fields")
-	}
-
-	// t.Entries ([]datasegment.SegmentDesc) (slice)
-
-	maj, extra, err = cr.ReadHeader()
-	if err != nil {
-		return err
-	}
-
-	if extra > 2<<20 {
-		return fmt.Errorf("t.Entries: array too large (%d)", extra)
-	}
-
-	if maj != cbg.MajArray {
-		return fmt.Errorf("expected cbor array")
-	}
-
-	if extra > 0 {
-		t.Entries = make([]SegmentDesc, extra)
-	}
-
-	for i := 0; i < int(extra); i++ {
-
-		var v SegmentDesc
-		if err := v.UnmarshalCBOR(cr); err != nil {
-			return err
-		}
-
-		t.Entries[i] = v
-	}
-
-	return nil
-}
@@ -0,0 +1,189 @@
+package datasegment
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+)
+
+// maxIndexDataEntries is the limit on the number of entries of IndexData in CBOR
+const maxIndexDataEntries = 2097152
+
+const (
+	// cborEntryPrefixSize is the size of the encoding of a SegmentDesc up to the head of Offset:
+	// the array head and the CommDs byte string
+	cborEntryPrefixSize = 1 + 2 + merkletree.NodeSize
+	// cborChecksumSize is the size of the encoding of the Checksum byte string
+	cborChecksumSize = 1 + ChecksumSize
+	// maxCborEntrySize is the largest size of the encoding of a SegmentDesc
+	maxCborEntrySize = cborEntryPrefixSize + 2*9 + cborChecksumSize
+)
+
+// WriteCBORTo writes the index in the encoding of MarshalCBOR. The entries are encoded into
+// a single buffer of bounded size, which is written to w in large chunks, so w doesn't have
+// to be buffered.
+func (id IndexData) WriteCBORTo(w io.Writer) error {
+	if len(id.Entries) > maxIndexDataEntries {
+		return xerrors.Errorf("too many entries: %d > %d", len(id.Entries), maxIndexDataEntries)
+	}
+	bw := bufio.NewWriterSize(w, 64<<10)
+	var buf [maxCborEntrySize]byte
+	head := append(buf[:0], lengthBufIndexData...)
+	head = appendCborHead(head, cbg.MajArray, uint64(len(id.Entries)))
+	if _, err := bw.Write(head); err != nil {
+		return err
+	}
+	for i := range id.Entries {
+		if _, err := bw.Write(appendCborEntry(buf[:0], &id.Entries[i])); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadCBORFrom decodes the index written by WriteCBORTo or MarshalCBOR. The declared number of
+// entries is validated before anything is allocated, the entries slice grows with the entries
+// actually read. Nothing past the end of the index is read from r, wrapping r in a
+// bufio.Reader speeds up decoding.
+func (id *IndexData) ReadCBORFrom(r io.Reader) error {
+	*id = IndexData{}
+	var buf [maxCborEntrySize]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return xerrors.Errorf("reading index head: %w", err)
+	}
+	if buf[0] != lengthBufIndexData[0] {
+		return xerrors.Errorf("index should be a CBOR array of 1 field: %#x", buf[0])
+	}
+	maj, n, err := readCborHead(r, buf[:])
+	if err != nil {
+		return xerrors.Errorf("reading entries head: %w", noEOF(err))
+	}
+	if maj != cbg.MajArray {
+		return xerrors.Errorf("entries should be a CBOR array: major type %d", maj)
+	}
+	if n > maxIndexDataEntries {
+		return xerrors.Errorf("too many entries: %d > %d", n, maxIndexDataEntries)
+	}
+
+	entries := make([]SegmentDesc, 0, min(n, 4096))
+	for i := uint64(0); i < n; i++ {
+		var e SegmentDesc
+		if err := readCborEntry(r, buf[:], &e); err != nil {
+			return xerrors.Errorf("reading entry %d: %w", i, noEOF(err))
+		}
+		entries = append(entries, e)
+	}
+	if n != 0 {
+		id.Entries = entries
+	}
+	return nil
+}
+
+// appendCborEntry appends the encoding of the entry, equal to the one of MarshalCBOR
+func appendCborEntry(buf []byte, e *SegmentDesc) []byte {
+	buf = append(buf, lengthBufSegmentDesc...)
+	buf = appendCborHead(buf, cbg.MajByteString, merkletree.NodeSize)
+	buf = append(buf, e.CommDs[:]...)
+	buf = appendCborHead(buf, cbg.MajUnsignedInt, e.Offset)
+	buf = appendCborHead(buf, cbg.MajUnsignedInt, e.Size)
+	buf = appendCborHead(buf, cbg.MajByteString, ChecksumSize)
+	return append(buf, e.Checksum[:]...)
+}
+
+// readCborEntry reads the encoding of an entry with exact reads into buf
+func readCborEntry(r io.Reader, buf []byte, e *SegmentDesc) error {
+	prefix := buf[:cborEntryPrefixSize]
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return err
+	}
+	if prefix[0] != lengthBufSegmentDesc[0] {
+		return xerrors.Errorf("entry should be a CBOR array of 4 fields: %#x", prefix[0])
+	}
+	if prefix[1] != cbg.MajByteString<<5|24 || prefix[2] != merkletree.NodeSize {
+		return xerrors.Errorf("CommDs should be a byte string of %d bytes", merkletree.NodeSize)
+	}
+	copy(e.CommDs[:], prefix[3:])
+
+	var err error
+	for _, v := range []*uint64{&e.Offset, &e.Size} {
+		var maj byte
+		if maj, *v, err = readCborHead(r, buf); err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return xerrors.Errorf("expected an unsigned integer: major type %d", maj)
+		}
+	}
+
+	checksum := buf[:cborChecksumSize]
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		return err
+	}
+	if checksum[0] != cbg.MajByteString<<5|ChecksumSize {
+		return xerrors.Errorf("Checksum should be a byte string of %d bytes", ChecksumSize)
+	}
+	copy(e.Checksum[:], checksum[1:])
+	return nil
+}
+
+// appendCborHead appends the shortest CBOR head of the major type with the value n
+func appendCborHead(buf []byte, maj byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(buf, maj<<5|byte(n))
+	case n <= 0xff:
+		return append(buf, maj<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, maj<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, maj<<5|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, maj<<5|27), n)
+	}
+}
+
+// readCborHead reads a CBOR head using buf, which has to hold at least 9 bytes, and rejects
+// heads which are not in their shortest form, as cbor-gen does
+func readCborHead(r io.Reader, buf []byte) (byte, uint64, error) {
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, 0, err
+	}
+	maj, low := buf[0]>>5, buf[0]&0x1f
+	if low < 24 {
+		return maj, uint64(low), nil
+	}
+	if low > 27 {
+		return 0, 0, xerrors.Errorf("unsupported CBOR head: %#x", buf[0])
+	}
+	size := 1 << (low - 24)
+	if _, err := io.ReadFull(r, buf[:size]); err != nil {
+		return 0, 0, err
+	}
+	var n, least uint64
+	switch size {
+	case 1:
+		n, least = uint64(buf[0]), 24
+	case 2:
+		n, least = uint64(binary.BigEndian.Uint16(buf)), 0x100
+	case 4:
+		n, least = uint64(binary.BigEndian.Uint32(buf)), 0x10000
+	default:
+		n, least = binary.BigEndian.Uint64(buf), 0x100000000
+	}
+	if n < least {
+		return 0, 0, xerrors.Errorf("CBOR head is not in its shortest form")
+	}
+	return maj, n, nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, the index ended in the middle
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
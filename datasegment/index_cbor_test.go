@@ -0,0 +1,71 @@
+package datasegment
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexDataCBORStream(t *testing.T) {
+	a, _, err := GenerateRandomAggregate(41, 8<<20, 40)
+	require.NoError(t, err)
+	// large offsets and sizes exercise all lengths of CBOR heads
+	entries := append(a.Index.Entries, SegmentDesc{Offset: 1 << 40, Size: 1 << 33}.withUpdatedChecksum())
+	for _, index := range []IndexData{{}, a.Index, {Entries: entries}} {
+		var expected bytes.Buffer
+		require.NoError(t, index.MarshalCBOR(&expected))
+		var streamed bytes.Buffer
+		require.NoError(t, index.WriteCBORTo(&streamed))
+		assert.Equal(t, expected.Bytes(), streamed.Bytes())
+
+		// nothing past the index is read
+		r := bytes.NewReader(append(streamed.Bytes(), 0xff))
+		var decoded IndexData
+		require.NoError(t, decoded.ReadCBORFrom(r))
+		assert.Equal(t, index, decoded)
+		assert.Equal(t, 1, r.Len())
+
+		var unmarshaled IndexData
+		require.NoError(t, unmarshaled.UnmarshalCBOR(bytes.NewReader(streamed.Bytes())))
+		assert.Equal(t, unmarshaled, decoded)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		_ = a.Index.WriteCBORTo(io.Discard)
+	})
+	assert.LessOrEqual(t, allocs, 2.0)
+
+	var buf bytes.Buffer
+	require.NoError(t, a.Index.WriteCBORTo(&buf))
+	var decoded IndexData
+	require.NoError(t, decoded.ReadCBORFrom(bufio.NewReader(bytes.NewReader(buf.Bytes()))))
+	assert.Equal(t, a.Index, decoded)
+}
+
+func TestIndexDataReadCBORFromErrors(t *testing.T) {
+	var encoded bytes.Buffer
+	require.NoError(t, validIndex(t).WriteCBORTo(&encoded))
+	data := encoded.Bytes()
+
+	var id IndexData
+	err := id.ReadCBORFrom(bytes.NewReader(data[:len(data)-1]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// the count is validated before reading entries
+	err = id.ReadCBORFrom(bytes.NewReader([]byte{0x81, 0x9a, 0x00, 0x20, 0x00, 0x01}))
+	assert.ErrorContains(t, err, "too many entries")
+	err = id.ReadCBORFrom(bytes.NewReader([]byte{0x81, 0x9a, 0x00, 0x10, 0x00, 0x00}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// non-minimal heads are rejected, as by UnmarshalCBOR
+	nonMinimal := append([]byte{0x81, 0x98, 0x02}, data[2:]...)
+	assert.Error(t, id.UnmarshalCBOR(bytes.NewReader(nonMinimal)))
+	assert.ErrorContains(t, id.ReadCBORFrom(bytes.NewReader(nonMinimal)), "shortest form")
+
+	assert.Error(t, id.ReadCBORFrom(bytes.NewReader([]byte{0x82})))
+	assert.Error(t, id.ReadCBORFrom(bytes.NewReader([]byte{0x81, 0x01})))
+}
@@ -0,0 +1,61 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+)
+
+// IndexHeaderVersion is the version of the index format described by the index header
+const IndexHeaderVersion = 1
+
+// indexHeaderMagic identifies the index header, it occupies the beginning of the CommDs field
+var indexHeaderMagic = [16]byte{'F', 'R', 'C', '-', '0', '0', '5', '8', ' ', 'i', 'n', 'd', 'e', 'x'}
+
+// indexHeaderSize is stored in the Size field of the header entry. It is deliberately not aligned
+// to 128 bytes such that parsers unaware of the header reject the entry as invalid.
+const indexHeaderSize = 1
+
+// IndexHeader is an optional entry at the start of the index describing the index itself,
+// see WithIndexHeader
+type IndexHeader struct {
+	// Version is the version of the index format
+	Version uint64
+	// Entries is the number of entries following the header
+	Entries uint64
+}
+
+// SegmentDesc encodes the header as an index entry.
+// The magic and version are stored in CommDs, the number of entries in Offset.
+func (h IndexHeader) SegmentDesc() SegmentDesc {
+	var comm merkletree.Node
+	copy(comm[:], indexHeaderMagic[:])
+	binary.LittleEndian.PutUint64(comm[len(indexHeaderMagic):], h.Version)
+
+	sd := SegmentDesc{
+		CommDs: comm,
+		Offset: h.Entries,
+		Size:   indexHeaderSize,
+	}
+	return sd.withUpdatedChecksum()
+}
+
+// ParseIndexHeader decodes the index header from the entry, it returns false if the entry
+// is not a valid header
+func ParseIndexHeader(sd SegmentDesc) (IndexHeader, bool) {
+	if !bytes.Equal(sd.CommDs[:len(indexHeaderMagic)], indexHeaderMagic[:]) {
+		return IndexHeader{}, false
+	}
+	if sd.Size != indexHeaderSize || sd.computeChecksum() != sd.Checksum {
+		return IndexHeader{}, false
+	}
+	rest := sd.CommDs[len(indexHeaderMagic):]
+	if !bytes.Equal(rest[8:], make([]byte, len(rest)-8)) {
+		return IndexHeader{}, false
+	}
+	return IndexHeader{
+		Version: binary.LittleEndian.Uint64(rest),
+		Entries: sd.Offset,
+	}, true
+}
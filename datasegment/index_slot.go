@@ -0,0 +1,139 @@
+package datasegment
+
+import (
+	"iter"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	xerrors "golang.org/x/xerrors"
+)
+
+// IndexSlotProof proves the content of a single slot of the index area of a deal.
+// Unlike InclusionProof, it can also prove that a slot is empty, which allows clients to check
+// that no additional segments were placed in the unused slots of the index.
+type IndexSlotProof struct {
+	// Slot is the position within the index area, the IndexHeader, if present, occupies slot 0
+	Slot uint64
+	// Entry is the content of the slot, the zero value for an empty slot
+	Entry SegmentDesc
+	// Proof is the proof of inclusion of the entry in the deal's tree
+	Proof merkletree.ProofData
+}
+
+// IsEmpty returns true if the slot is proven to be empty
+func (sp IndexSlotProof) IsEmpty() bool {
+	return sp.Entry == SegmentDesc{}
+}
+
+// IndexSlot is a slot of the index area as it is laid out in the deal
+type IndexSlot struct {
+	// Slot is the position within the index area, the IndexHeader, if present, occupies slot 0
+	Slot int
+	// Offset is the offset of the slot from the start of the deal in padded bytes
+	Offset uint64
+	// Entry is the content of the slot, the zero value for an empty slot
+	Entry SegmentDesc
+}
+
+// IndexEntriesReader returns an iterator over all slots of the index area in the order they
+// are stored in the deal, exactly as serialized by IndexReader: the IndexHeader if present,
+// the entries and the trailing empty slots up to the index capacity.
+func (a Aggregate) IndexEntriesReader() iter.Seq[IndexSlot] {
+	return func(yield func(IndexSlot) bool) {
+		entries := a.indexAreaEntries()
+		start := a.indexAreaStart()
+		for slot := 0; uint(slot) < a.indexCapacity(); slot++ {
+			is := IndexSlot{Slot: slot, Offset: start + uint64(slot)*EntrySize}
+			if slot < len(entries) {
+				is.Entry = entries[slot]
+			}
+			if !yield(is) {
+				return
+			}
+		}
+	}
+}
+
+// ProofForIndexSlot proves the content of the given slot of the index area.
+// The entry is read from the tree, so the proof reflects what the deal commits to.
+// Slots past the last entry are proven to be empty.
+func (a Aggregate) ProofForIndexSlot(slot int) (*IndexSlotProof, error) {
+	if slot < 0 || uint(slot) >= a.indexCapacity() {
+		return nil, xerrors.Errorf("slot %d out of the index area of %d entries", slot, a.indexCapacity())
+	}
+	loc, err := indexEntryLocation(a.indexAreaStart(), uint64(slot))
+	if err != nil {
+		return nil, xerrors.Errorf("locating slot: %w", err)
+	}
+
+	var buf [EntrySize]byte
+	for i := uint64(0); i < 2; i++ {
+		n, err := a.Tree.GetNode(0, loc.LeafIndex()+i)
+		if err != nil {
+			return nil, xerrors.Errorf("getting entry node: %w", err)
+		}
+		copy(buf[i*merkletree.NodeSize:], n[:])
+	}
+	var entry SegmentDesc
+	if err := entry.UnmarshalBinary(buf[:]); err != nil {
+		return nil, xerrors.Errorf("decoding entry: %w", err)
+	}
+
+	proof, err := a.Tree.CollectProof(loc.Level, loc.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("collecting slot proof: %w", err)
+	}
+	return &IndexSlotProof{Slot: uint64(slot), Entry: entry, Proof: proof}, nil
+}
+
+// Verify checks the proof against the aggregator's deal described by the aux data.
+func (sp IndexSlotProof) Verify(aux InclusionAuxData) error {
+	return sp.verify(aux, 0)
+}
+
+// VerifyWithIndexCapacity is like Verify but for deals created with WithIndexCapacity
+func (sp IndexSlotProof) VerifyWithIndexCapacity(aux InclusionAuxData, indexCapacity uint) error {
+	if indexCapacity == 0 {
+		return xerrors.Errorf("index capacity has to be non-zero")
+	}
+	return sp.verify(aux, indexCapacity)
+}
+
+func (sp IndexSlotProof) verify(aux InclusionAuxData, indexCapacity uint) error {
+	if err := ValidateDealSize(aux.SizePa); err != nil {
+		return xerrors.Errorf("invalid deal size: %w", err)
+	}
+	if sp.Proof.Depth() > MaxIndexProofDepth ||
+		uint64(EntrySize)<<sp.Proof.Depth() != uint64(aux.SizePa) {
+		return xerrors.Errorf("proof depth %d doesn't match deal size %d", sp.Proof.Depth(), aux.SizePa)
+	}
+	if indexCapacity == 0 {
+		indexCapacity = MaxIndexEntriesInDeal(aux.SizePa)
+	} else if err := validateIndexCapacity(aux.SizePa, indexCapacity); err != nil {
+		return xerrors.Errorf("invalid index capacity: %w", err)
+	}
+	if sp.Slot >= uint64(indexCapacity) {
+		return xerrors.Errorf("slot %d out of the index area of %d entries", sp.Slot, indexCapacity)
+	}
+	loc, err := indexEntryLocation(indexAreaStartForCapacity(aux.SizePa, indexCapacity), sp.Slot)
+	if err != nil {
+		return xerrors.Errorf("locating slot: %w", err)
+	}
+	if sp.Proof.Index != loc.Index {
+		return xerrors.Errorf("proof is for position %d, expected %d", sp.Proof.Index, loc.Index)
+	}
+
+	commPa, err := lightCid2CommP(aux.CommPa)
+	if err != nil {
+		return xerrors.Errorf("invalid deal commitment: %w", err)
+	}
+	nodes := sp.Entry.IntoNodes()
+	node := merkletree.PairHash(&nodes[0], &nodes[1])
+	root, err := sp.Proof.ComputeRoot(&node)
+	if err != nil {
+		return xerrors.Errorf("computing root: %w", err)
+	}
+	if *root != merkletree.Node(commPa) {
+		return xerrors.Errorf("slot proof doesn't match deal commitment")
+	}
+	return nil
+}
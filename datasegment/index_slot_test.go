@@ -0,0 +1,124 @@
+package datasegment
+
+import (
+	"io"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/fr32"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexEntriesReader(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(1), Size: 1 << 10},
+		{PieceCID: cidForDeal(2), Size: 1 << 12},
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	for _, opts := range [][]AggregateOption{nil, {WithIndexHeader()}, {WithIndexCapacity(32)}} {
+		a, err := NewAggregate(dealSize, pieces, opts...)
+		require.NoError(t, err)
+		unpadded, err := io.ReadAll(Must(a.IndexReader()))
+		require.NoError(t, err)
+		area := make([]byte, len(unpadded)/127*128)
+		fr32.Pad(unpadded, area)
+
+		var slots []IndexSlot
+		for is := range a.IndexEntriesReader() {
+			slots = append(slots, is)
+		}
+		require.Len(t, slots, int(a.indexCapacity()))
+		entries := a.Index.Entries
+		if a.IndexHeader {
+			entries = append([]SegmentDesc{{}}, entries...)
+		}
+		for i, is := range slots {
+			assert.Equal(t, i, is.Slot)
+			pos := is.Offset - a.indexAreaStart()
+			assert.Equal(t, area[pos:pos+EntrySize], Must(is.Entry.MarshalBinary()), "slot %d", i)
+			switch {
+			case a.IndexHeader && i == 0:
+				_, ok := ParseIndexHeader(is.Entry)
+				assert.True(t, ok)
+			case i < len(entries):
+				assert.Equal(t, entries[i], is.Entry)
+			default:
+				assert.Equal(t, SegmentDesc{}, is.Entry, "slot %d", i)
+			}
+		}
+		assert.Equal(t, uint64(dealSize)-EntrySize, slots[len(slots)-1].Offset)
+
+		// the iteration stops early
+		n := 0
+		for range a.IndexEntriesReader() {
+			n++
+			break
+		}
+		assert.Equal(t, 1, n)
+	}
+}
+
+func TestProofForIndexSlot(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(1), Size: 1 << 10},
+		{PieceCID: cidForDeal(2), Size: 1 << 12},
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	dealCID, err := a.PieceCID()
+	require.NoError(t, err)
+	aux := InclusionAuxData{CommPa: dealCID, SizePa: dealSize}
+
+	for slot := 0; slot < int(MaxIndexEntriesInDeal(dealSize)); slot++ {
+		sp, err := a.ProofForIndexSlot(slot)
+		require.NoError(t, err)
+		require.NoError(t, sp.Verify(aux), "slot %d", slot)
+		if slot < len(pieces) {
+			assert.Equal(t, a.Index.Entries[slot], sp.Entry)
+			assert.False(t, sp.IsEmpty())
+		} else {
+			assert.True(t, sp.IsEmpty(), "slot %d", slot)
+		}
+	}
+
+	_, err = a.ProofForIndexSlot(int(MaxIndexEntriesInDeal(dealSize)))
+	assert.Error(t, err)
+
+	// claiming an occupied slot is empty fails
+	sp, err := a.ProofForIndexSlot(0)
+	require.NoError(t, err)
+	sp.Entry = SegmentDesc{}
+	assert.Error(t, sp.Verify(aux))
+
+	// proofs of other positions are rejected
+	sp, err = a.ProofForIndexSlot(5)
+	require.NoError(t, err)
+	sp.Slot = 4
+	assert.Error(t, sp.Verify(aux))
+}
+
+func TestProofForIndexSlotWithCapacity(t *testing.T) {
+	pieces := []abi.PieceInfo{{PieceCID: cidForDeal(1), Size: 1 << 10}}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	capacity := 4 * MaxIndexEntriesInDeal(dealSize)
+	a, err := NewAggregate(dealSize, pieces, WithIndexCapacity(capacity), WithIndexHeader())
+	require.NoError(t, err)
+	dealCID, err := a.PieceCID()
+	require.NoError(t, err)
+	aux := InclusionAuxData{CommPa: dealCID, SizePa: dealSize}
+
+	sp, err := a.ProofForIndexSlot(0)
+	require.NoError(t, err)
+	header, ok := ParseIndexHeader(sp.Entry)
+	require.True(t, ok)
+	assert.Equal(t, uint64(1), header.Entries)
+	require.NoError(t, sp.VerifyWithIndexCapacity(aux, capacity))
+	assert.Error(t, sp.Verify(aux))
+
+	sp, err = a.ProofForIndexSlot(int(capacity) - 1)
+	require.NoError(t, err)
+	assert.True(t, sp.IsEmpty())
+	require.NoError(t, sp.VerifyWithIndexCapacity(aux, capacity))
+}
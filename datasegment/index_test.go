@@ -1,12 +1,19 @@
 package datasegment
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/filecoin-project/go-data-segment/fr32"
 	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
 )
 
 // HELPER METHODS
@@ -55,7 +62,8 @@ func TestValidateEntry(t *testing.T) {
 		sd  SegmentDesc
 		err string
 	}{
-		{sd: SegmentDesc{Offset: 0, Size: 0}.withUpdatedChecksum()},
+		{sd: SegmentDesc{Offset: 0, Size: 0}.withUpdatedChecksum(), err: "minimal piece size"},
+		{sd: SegmentDesc{Offset: 0, Size: 128}.withUpdatedChecksum()},
 		{sd: SegmentDesc{Offset: 128, Size: 128 * 3249}.withUpdatedChecksum()},
 		{sd: SegmentDesc{Offset: 128 * 323221, Size: 128 * 3249}.withUpdatedChecksum()},
 		{sd: SegmentDesc{Offset: 128*323221 + 1, Size: 128 * 3249}.withUpdatedChecksum(), err: "offset"},
@@ -110,6 +118,35 @@ func TestIndexSerialization(t *testing.T) {
 	assert.Equal(t, index, decoded)
 }
 
+func TestUnmarshalBinaryInto(t *testing.T) {
+	index := validIndex(t)
+	encoded, err := index.MarshalBinary()
+	require.NoError(t, err)
+
+	buf := make([]SegmentDesc, 0, 2*len(index.Entries))
+	decoded, err := UnmarshalBinaryInto(encoded, buf)
+	require.NoError(t, err)
+	assert.Equal(t, index, decoded)
+	assert.Same(t, &buf[:1][0], &decoded.Entries[0], "entries are decoded in place")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := UnmarshalBinaryInto(encoded, buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Zero(t, allocs)
+
+	// too small capacity allocates a new slice
+	small := make([]SegmentDesc, 1)
+	decoded, err = UnmarshalBinaryInto(encoded, small)
+	require.NoError(t, err)
+	assert.Equal(t, index, decoded)
+	assert.Equal(t, SegmentDesc{}, small[0])
+
+	_, err = UnmarshalBinaryInto(encoded[:len(encoded)-1], buf)
+	assert.Error(t, err)
+}
+
 func TestIndexLargeSizes(t *testing.T) {
 	index := validIndex(t)
 	MakeIndex(index.Entries)
@@ -191,3 +228,385 @@ func TestNegativeMakeDescWrongSegments(t *testing.T) {
 	_, err := MakeSegDescs(segments, sizes)
 	assert.Error(t, err)
 }
+
+func TestSegmentDescPieceCIDErr(t *testing.T) {
+	en := validIndex(t).Entries[0]
+	c, err := en.PieceCIDErr()
+	assert.NoError(t, err)
+	assert.Equal(t, Must(commcid.PieceCommitmentV1ToCID(en.CommDs[:])), c)
+	assert.Equal(t, c, en.PieceCID())
+}
+
+func TestDealSizeLimits(t *testing.T) {
+	tests := []struct {
+		dealSize         abi.PaddedPieceSize
+		maxEntries       uint
+		indexStartOffset uint64
+		err              bool
+	}{
+		{dealSize: 512, maxEntries: 4, indexStartOffset: 254},
+		{dealSize: 1 << 10, maxEntries: 4, indexStartOffset: 762},
+		{dealSize: 2 << 10, maxEntries: 4, indexStartOffset: 1778},
+		{dealSize: 8 << 20, maxEntries: 64, indexStartOffset: 8319008},
+		{dealSize: 512 << 20, maxEntries: 4096, indexStartOffset: 532416512},
+		{dealSize: 32 << 30, maxEntries: 262144, indexStartOffset: 34074656768},
+		{dealSize: 64 << 30, maxEntries: 524288, indexStartOffset: 68149313536},
+		{dealSize: 128 << 30, err: true},
+		{dealSize: 1 << 62, err: true},
+		{dealSize: 3 << 20, err: true},
+		{dealSize: 0, err: true},
+		{dealSize: 128, err: true},
+		{dealSize: 256, err: true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(fmt.Sprintf("%d", tc.dealSize), func(t *testing.T) {
+			err := ValidateDealSize(tc.dealSize)
+			if tc.err {
+				assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+				_, err = NewAggregate(tc.dealSize, nil)
+				assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+				_, err = ParseDataSegmentIndexForDeal(tc.dealSize, bytes.NewReader(nil))
+				assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.maxEntries, MaxIndexEntriesInDeal(tc.dealSize))
+			assert.Equal(t, tc.indexStartOffset, DataSegmentIndexStartOffset(tc.dealSize))
+
+			// cross-check the index area bounds
+			assert.Equal(t, tc.indexStartOffset, IndexAreaStartUnpadded(tc.dealSize))
+			assert.Equal(t, abi.PaddedPieceSize(tc.maxEntries*EntrySize), IndexAreaSizePadded(tc.dealSize))
+			assert.Equal(t, IndexAreaSizePadded(tc.dealSize).Unpadded(), IndexAreaSizeUnpadded(tc.dealSize))
+			assert.Equal(t, uint64(tc.dealSize), IndexAreaStartPadded(tc.dealSize)+uint64(IndexAreaSizePadded(tc.dealSize)))
+			assert.Equal(t, uint64(tc.dealSize.Unpadded()),
+				IndexAreaStartUnpadded(tc.dealSize)+uint64(IndexAreaSizeUnpadded(tc.dealSize)))
+			assert.Equal(t, IndexAreaStartPadded(tc.dealSize)/128*127, IndexAreaStartUnpadded(tc.dealSize))
+		})
+	}
+}
+
+func TestValidEntriesWithReport(t *testing.T) {
+	valid := validIndex(t)
+	index := IndexData{Entries: []SegmentDesc{
+		valid.Entries[0], invalidEntry1(), {}, valid.Entries[1], invalidEntry2(), {},
+		SegmentDesc{Offset: 3, Size: 128}.withUpdatedChecksum(),
+	}}
+
+	entries, report, err := index.ValidEntriesWithReport()
+	assert.NoError(t, err)
+	assert.Equal(t, valid.Entries, entries)
+	assert.Equal(t, 2, report.Valid)
+	assert.Equal(t, 2, report.Empty)
+	if assert.Len(t, report.Rejected, 3) {
+		assert.Equal(t, 1, report.Rejected[0].Index)
+		assert.Equal(t, 4, report.Rejected[1].Index)
+		assert.Equal(t, 6, report.Rejected[2].Index)
+		for _, r := range report.Rejected {
+			assert.ErrorIs(t, r.Err, ErrValidation)
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"computed checksum does not match embedded checksum": 2,
+		"offset is not aligned in padded data":               1,
+	}, report.RejectionCounts())
+}
+
+func TestIndexIterators(t *testing.T) {
+	valid := validIndex(t)
+	index := IndexData{Entries: []SegmentDesc{
+		valid.Entries[0], invalidEntry1(), {}, valid.Entries[1],
+	}}
+
+	var positions []int
+	for i, e := range index.All() {
+		assert.Equal(t, index.Entries[i], e)
+		positions = append(positions, i)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3}, positions)
+
+	var entries []SegmentDesc
+	for e, err := range index.Valid() {
+		require.NoError(t, err)
+		entries = append(entries, e)
+	}
+	assert.Equal(t, valid.Entries, entries)
+
+	for e, err := range index.Valid() {
+		require.NoError(t, err)
+		assert.Equal(t, valid.Entries[0], e)
+		break
+	}
+}
+
+func TestIndexHeader(t *testing.T) {
+	h := IndexHeader{Version: IndexHeaderVersion, Entries: 1234}
+	sd := h.SegmentDesc()
+	parsed, ok := ParseIndexHeader(sd)
+	require.True(t, ok)
+	assert.Equal(t, h, parsed)
+	assert.ErrorIs(t, sd.Validate(), ErrValidation)
+
+	broken := sd
+	broken.Offset++
+	_, ok = ParseIndexHeader(broken)
+	assert.False(t, ok, "checksum mismatch")
+
+	_, ok = ParseIndexHeader(*Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x1}), 0, 128)))
+	assert.False(t, ok)
+
+	entries := []SegmentDesc{
+		*Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x1}), 0, 128)),
+		*Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x2}), 128, 128)),
+	}
+	parse := func(header IndexHeader, entries []SegmentDesc) (IndexData, error) {
+		b, err := IndexData{Entries: append([]SegmentDesc{header.SegmentDesc()}, entries...)}.MarshalBinary()
+		require.NoError(t, err)
+		// garbage after the declared entries
+		b = append(b, bytes.Repeat([]byte{0xff}, 64)...)
+		unpadded := make([]byte, len(b)/128*127)
+		fr32.Unpad(unpadded, b)
+		return ParseDataSegmentIndex(bytes.NewReader(unpadded))
+	}
+
+	idx, err := parse(IndexHeader{Version: IndexHeaderVersion, Entries: 2}, entries)
+	require.NoError(t, err)
+	assert.Equal(t, entries, idx.Entries)
+
+	_, err = parse(IndexHeader{Version: IndexHeaderVersion, Entries: 5}, entries)
+	assert.ErrorContains(t, err, "more entries")
+	_, err = parse(IndexHeader{Version: IndexHeaderVersion + 1, Entries: 2}, entries)
+	assert.ErrorContains(t, err, "unsupported index version")
+}
+
+func TestSegmentDescBoundaryRoundtrip(t *testing.T) {
+	var maxComm merkletree.Node
+	for i := range maxComm {
+		maxComm[i] = 0xff
+	}
+	maxComm[merkletree.NodeSize-1] = 0x3f // highest value which is a valid Fr32
+
+	values := []uint64{0, 1, 127, 128, 1 << 62, 1<<63 - 128, 1 << 63, 1<<64 - 128, 1<<64 - 1}
+	var entries []SegmentDesc
+	for _, offset := range values {
+		for _, size := range values {
+			sd := SegmentDesc{CommDs: maxComm, Offset: offset, Size: size}.withUpdatedChecksum()
+			entries = append(entries, sd)
+
+			var fromBinary SegmentDesc
+			require.NoError(t, fromBinary.UnmarshalBinary(Must(sd.MarshalBinary())))
+			assert.Equal(t, sd, fromBinary)
+
+			var buf bytes.Buffer
+			require.NoError(t, sd.MarshalCBOR(&buf))
+			var fromCBOR SegmentDesc
+			require.NoError(t, fromCBOR.UnmarshalCBOR(&buf))
+			assert.Equal(t, sd, fromCBOR)
+
+			nodes := sd.IntoNodes()
+			assert.Equal(t, sd.SerializeFr32(), append(nodes[0][:], nodes[1][:]...))
+
+			// alignment, the minimal size and the end of the segment are checked for these values
+			_, fits := util.CheckedAdd(offset, size)
+			if offset%128 == 0 && size%128 == 0 && size >= MinSegmentSize && fits {
+				assert.NoError(t, sd.Validate(), "offset %d, size %d", offset, size)
+			} else if offset%128 == 0 && size == 0 {
+				assert.ErrorIs(t, sd.Validate(), ErrSegmentTooSmall, "offset %d", offset)
+			} else {
+				assert.ErrorIs(t, sd.Validate(), ErrValidation, "offset %d, size %d", offset, size)
+			}
+		}
+	}
+
+	idx := IndexData{Entries: entries}
+	var fromBinary IndexData
+	require.NoError(t, fromBinary.UnmarshalBinary(Must(idx.MarshalBinary())))
+	assert.Equal(t, idx, fromBinary)
+
+	var buf bytes.Buffer
+	require.NoError(t, idx.MarshalCBOR(&buf))
+	var fromCBOR IndexData
+	require.NoError(t, fromCBOR.UnmarshalCBOR(&buf))
+	assert.Equal(t, idx, fromCBOR)
+}
+
+func TestSegmentDescValidateStrict(t *testing.T) {
+	entry := func(offset, size uint64) SegmentDesc {
+		return *Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x1}), offset, size))
+	}
+	for _, sd := range []SegmentDesc{entry(0, 128), entry(256, 256), entry(1<<30, 1<<30)} {
+		assert.NoError(t, sd.ValidateStrict())
+	}
+	for _, sd := range []SegmentDesc{entry(128, 256), entry(0, 384), entry(0, 0), entry(64, 128)} {
+		assert.ErrorIs(t, sd.ValidateStrict(), ErrValidation, "offset %d, size %d", sd.Offset, sd.Size)
+	}
+	// entries failing strict validation can still pass the regular one
+	assert.NoError(t, entry(128, 256).Validate())
+
+	idx := IndexData{Entries: []SegmentDesc{entry(0, 128), entry(128, 256)}}
+	assert.NoError(t, idx.Validate())
+	assert.ErrorIs(t, idx.ValidateStrict(), ErrValidation)
+
+	a, err := NewAggregate(32<<30, samplePieceInfos1())
+	require.NoError(t, err)
+	assert.NoError(t, a.Index.ValidateStrict())
+}
+
+func TestIndexDataCBOR(t *testing.T) {
+	sd := *Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x1}), 128, 256))
+	idx := IndexData{Entries: []SegmentDesc{sd, {}}}
+
+	var buf bytes.Buffer
+	require.NoError(t, idx.MarshalCBOR(&buf))
+	// tuple of one field holding an array of two entries
+	assert.Equal(t, []byte{0x81, 0x82}, buf.Bytes()[:2])
+	var decoded IndexData
+	require.NoError(t, decoded.UnmarshalCBOR(&buf))
+	assert.Equal(t, idx, decoded)
+
+	// more entries than fit in the index of the largest deal
+	tooMany := []byte{0x81, 0x9a, 0x00, 0x20, 0x00, 0x01}
+	assert.ErrorContains(t, decoded.UnmarshalCBOR(bytes.NewReader(tooMany)), "too large")
+	assert.LessOrEqual(t, MaxIndexEntriesInDeal(MaxSupportedDealSize), uint(2<<20))
+}
+
+func TestSegmentDescTypedSizes(t *testing.T) {
+	sd := *Must(MakeDataSegmentIndexEntry((*fr32.Fr32)(&merkletree.Node{0x1}), 2048, 1024))
+	require.NoError(t, sd.CheckSizes())
+	assert.Equal(t, abi.PaddedPieceSize(2048), sd.PaddedOffset())
+	assert.Equal(t, abi.PaddedPieceSize(1024), sd.PaddedSize())
+	assert.Equal(t, abi.UnpaddedPieceSize(1016), sd.UnpaddedSize())
+	assert.Equal(t, uint64(2032), sd.UnpaddedOffest())
+	assert.Equal(t, uint64(sd.UnpaddedSize()), sd.UnpaddedLength())
+
+	sd.Size = 3 * 128
+	assert.ErrorIs(t, sd.CheckSizes(), ErrValidation)
+	sd.Size = 128
+	sd.Offset = 64
+	assert.ErrorIs(t, sd.CheckSizes(), ErrValidation)
+}
+
+type faultyReaderAt struct {
+	data     []byte
+	from, to int64
+}
+
+func (f faultyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < f.to && off+int64(len(p)) > f.from {
+		return 0, xerrors.Errorf("bad sector")
+	}
+	return bytes.NewReader(f.data).ReadAt(p, off)
+}
+
+func TestParseDataSegmentIndexAt(t *testing.T) {
+	var pieces []abi.PieceInfo
+	for i := 0; i < 8; i++ {
+		pieces = append(pieces, abi.PieceInfo{PieceCID: cidForDeal(i), Size: 1 << 10})
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	ir, err := a.IndexReader()
+	require.NoError(t, err)
+	area, err := io.ReadAll(ir)
+	require.NoError(t, err)
+	size := int64(len(area))
+
+	index, entryErrs, err := ParseDataSegmentIndexAt(bytes.NewReader(area), size)
+	require.NoError(t, err)
+	assert.Empty(t, entryErrs)
+	assert.Equal(t, a.Index.Entries, index.Entries[:len(pieces)])
+
+	// the second chunk, holding entries 2 and 3, can't be read and entry 4 is corrupted
+	corrupted := bytes.Clone(area)
+	corrupted[2*127+10] ^= 0xff
+	index, entryErrs, err = ParseDataSegmentIndexAt(faultyReaderAt{corrupted, 127, 2 * 127}, size)
+	require.NoError(t, err)
+	require.Len(t, entryErrs, 3)
+	assert.Equal(t, 2, entryErrs[0].Slot)
+	assert.Equal(t, EntryReadError, entryErrs[0].Kind)
+	assert.Equal(t, 3, entryErrs[1].Slot)
+	assert.Equal(t, 4, entryErrs[2].Slot)
+	assert.Equal(t, EntryInvalid, entryErrs[2].Kind)
+	assert.ErrorIs(t, entryErrs[2], ErrValidation)
+	assert.Equal(t, SegmentDesc{}, index.Entries[2])
+	// entries around the failures are recovered
+	assert.Equal(t, a.Index.Entries[:2], index.Entries[:2])
+	assert.Equal(t, a.Index.Entries[5:], index.Entries[5:len(pieces)])
+	valid, err := index.ValidEntries()
+	require.NoError(t, err)
+	assert.Len(t, valid, len(pieces)-3)
+
+	_, _, err = ParseDataSegmentIndexAt(faultyReaderAt{corrupted, 127, 2 * 127}, size, StopAtFirstError())
+	var ee *EntryError
+	require.ErrorAs(t, err, &ee)
+	assert.Equal(t, 2, ee.Slot)
+
+	_, _, err = ParseDataSegmentIndexAt(bytes.NewReader(area), size-1)
+	assert.Error(t, err)
+	_, entryErrs, err = ParseDataSegmentIndexAt(bytes.NewReader(area[:size-127]), size)
+	require.NoError(t, err)
+	require.Len(t, entryErrs, 2)
+	assert.ErrorIs(t, entryErrs[0], io.ErrUnexpectedEOF)
+}
+
+func TestParseDataSegmentIndexWithStatus(t *testing.T) {
+	var pieces []abi.PieceInfo
+	for i := 0; i < 5; i++ {
+		pieces = append(pieces, abi.PieceInfo{PieceCID: cidForDeal(i), Size: 1 << 10})
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces, WithIndexHeader())
+	require.NoError(t, err)
+	area, err := io.ReadAll(Must(a.IndexReader()))
+	require.NoError(t, err)
+
+	index, statuses, err := ParseDataSegmentIndexWithStatus(bytes.NewReader(area))
+	require.NoError(t, err)
+	assert.Equal(t, a.Index, index)
+	require.Len(t, statuses, int(MaxIndexEntriesInDeal(dealSize)))
+	for slot, s := range statuses {
+		assert.Equal(t, slot, s.Slot)
+		if slot <= len(pieces) {
+			assert.Equal(t, EntryOK, s.State, "slot %d", slot)
+		} else {
+			assert.Equal(t, EntryEmpty, s.State, "slot %d", slot)
+		}
+		assert.NoError(t, s.Err)
+	}
+
+	// entry 3, in slot 4 after the header, is corrupted while ParseDataSegmentIndex drops it silently
+	corrupted := bytes.Clone(area)
+	corrupted[2*127+10] ^= 0xff
+	_, statuses, err = ParseDataSegmentIndexWithStatus(bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	assert.Equal(t, EntryCorrupt, statuses[4].State)
+	assert.Equal(t, "corrupt", statuses[4].State.String())
+	assert.ErrorIs(t, statuses[4].Err, ErrValidation)
+	for slot, s := range statuses {
+		if slot != 4 {
+			assert.NotEqual(t, EntryCorrupt, s.State, "slot %d", slot)
+		}
+	}
+	plain, err := ParseDataSegmentIndex(bytes.NewReader(corrupted))
+	require.NoError(t, err)
+	valid, err := plain.ValidEntries()
+	require.NoError(t, err)
+	assert.Len(t, valid, len(pieces)-1)
+}
+
+func TestStringFormats(t *testing.T) {
+	pieces := []abi.PieceInfo{{PieceCID: cidForDeal(1), Size: 1024}}
+	a, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+
+	e := a.Index.Entries[0]
+	assert.Equal(t, fmt.Sprintf("SegmentDesc{PieceCID: %s, Offset: 0, Size: 1024}", cidForDeal(1)), e.String())
+	assert.Equal(t, e.String(), fmt.Sprint(e))
+
+	aux := InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: a.DealSize}
+	assert.Equal(t, fmt.Sprintf("InclusionAuxData{CommPa: %s, SizePa: 1048576}", aux.CommPa), aux.String())
+
+	assert.Equal(t, fmt.Sprintf("Aggregate{PieceCID: %s, DealSize: 1048576, Segments: 1, IndexCapacity: 8}", aux.CommPa), a.String())
+	assert.NotPanics(t, func() { _ = Aggregate{}.String() })
+}
@@ -0,0 +1,12 @@
+package datasegment
+
+import "github.com/filecoin-project/go-data-segment/util"
+
+// logger is read by library calls concurrently with SetLogger
+var logger util.AtomicLogger
+
+// SetLogger sets the logger used for diagnostics in the datasegment package.
+// By default all diagnostics are discarded. Passing nil restores the default.
+func SetLogger(l util.Logger) {
+	logger.Store(l)
+}
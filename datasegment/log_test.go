@@ -0,0 +1,50 @@
+package datasegment
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingLogger struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (l *countingLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n++
+}
+
+// TestSetLoggerConcurrent is meant to be run with -race
+func TestSetLoggerConcurrent(t *testing.T) {
+	t.Cleanup(func() { SetLogger(nil) })
+
+	cl := &countingLogger{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				SetLogger(cl)
+			} else {
+				SetLogger(nil)
+			}
+		}
+		SetLogger(cl)
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logger.Printf("message %d", i)
+		}
+	}()
+	wg.Wait()
+
+	before := cl.n
+	logger.Printf("last")
+	assert.Equal(t, before+1, cl.n)
+}
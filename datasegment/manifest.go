@@ -0,0 +1,182 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// AggregateManifest is a serializable description of the composition of an Aggregate.
+// It can be stored (as JSON or CBOR) and later used to reconstruct the Aggregate
+// with AggregateFromManifest without re-deriving the placement of sub-pieces.
+type AggregateManifest struct {
+	// DealSize is the padded size of the aggregate deal
+	DealSize abi.PaddedPieceSize
+	// DealCID is the PieceCID of the whole aggregate
+	DealCID cid.Cid
+	// IndexCID is the PieceCID of the data segment index
+	IndexCID cid.Cid
+	// IndexCapacity is the capacity of the index area, see WithIndexCapacity.
+	// Zero means the default of MaxIndexEntriesInDeal(DealSize).
+	IndexCapacity uint64
+	// IndexHeader is set if the index area starts with an IndexHeader entry, see WithIndexHeader
+	IndexHeader bool
+	// Pieces are the sub-pieces in the order of the index entries
+	Pieces []ManifestPiece
+	// RawSizes are the raw sizes of the sub-pieces, see WithRawSizes
+	RawSizes []uint64
+	// Metadata is the metadata attached to the Aggregate, see AttachMetadata
+	Metadata []SegmentMetadata
+}
+
+// ManifestPiece describes a single sub-piece within the AggregateManifest
+type ManifestPiece struct {
+	PieceCID cid.Cid
+	// Size is the padded size of the sub-piece
+	Size abi.PaddedPieceSize
+	// Offset is the offset of the sub-piece from the start of the deal in padded bytes
+	Offset uint64
+}
+
+// Manifest produces the AggregateManifest describing the Aggregate.
+// Aggregates with entries removed by TombstoneEntry cannot be described by a manifest.
+func (a Aggregate) Manifest() (*AggregateManifest, error) {
+	if len(a.Tombstones) != 0 {
+		return nil, xerrors.Errorf("aggregate has %d tombstoned entries, which a manifest cannot describe",
+			len(a.Tombstones))
+	}
+	dealCID, err := a.PieceCID()
+	if err != nil {
+		return nil, xerrors.Errorf("computing deal PieceCID: %w", err)
+	}
+	indexCID, err := a.IndexPieceCID()
+	if err != nil {
+		return nil, xerrors.Errorf("computing index PieceCID: %w", err)
+	}
+
+	pieces := make([]ManifestPiece, len(a.Index.Entries))
+	for i, e := range a.Index.Entries {
+		c, err := e.PieceCIDErr()
+		if err != nil {
+			return nil, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		pieces[i] = ManifestPiece{
+			PieceCID: c,
+			Size:     e.PaddedSize(),
+			Offset:   e.Offset,
+		}
+	}
+
+	return &AggregateManifest{
+		DealSize:      a.DealSize,
+		DealCID:       dealCID,
+		IndexCID:      indexCID,
+		IndexCapacity: uint64(a.IndexCapacity),
+		IndexHeader:   a.IndexHeader,
+		Pieces:        pieces,
+		RawSizes:      append([]uint64(nil), a.RawSizes...),
+		Metadata:      append([]SegmentMetadata(nil), a.Metadata...),
+	}, nil
+}
+
+// AggregateFromManifest reconstructs the Aggregate described by the manifest.
+// The placement of the sub-pieces is taken from the manifest as is, it is validated but not
+// re-computed. The index capacity and header, raw sizes and metadata recorded in the manifest
+// are restored. If DealCID or IndexCID are defined in the manifest, they are cross-checked
+// against the reconstructed Aggregate.
+func AggregateFromManifest(m AggregateManifest) (*Aggregate, error) {
+	if err := ValidateDealSize(m.DealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if uint64(uint(m.IndexCapacity)) != m.IndexCapacity {
+		return nil, xerrors.Errorf("invalid index capacity: %d", m.IndexCapacity)
+	}
+	options := aggregateOptions{indexCapacity: uint(m.IndexCapacity), indexHeader: m.IndexHeader}
+	maxEntries := MaxIndexEntriesInDeal(m.DealSize)
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(m.DealSize, options.indexCapacity); err != nil {
+			return nil, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+		maxEntries = options.indexCapacity
+	}
+	if options.indexHeader {
+		maxEntries--
+	}
+	if uint(len(m.Pieces)) > maxEntries {
+		return nil, xerrors.Errorf("too many pieces for a %d sized deal: %d > %d",
+			m.DealSize, len(m.Pieces), maxEntries)
+	}
+
+	indexStart := Aggregate{DealSize: m.DealSize, IndexCapacity: options.indexCapacity}.indexAreaStart()
+	cl := make([]merkletree.CommAndLoc, len(m.Pieces))
+	subdeals := make([]abi.PieceInfo, len(m.Pieces))
+	offset := uint64(0)
+	for i, p := range m.Pieces {
+		if err := p.Size.Validate(); err != nil {
+			return nil, xerrors.Errorf("piece %d: size doesn't validate: %w", i, err)
+		}
+		if p.Offset < offset {
+			return nil, xerrors.Errorf("piece %d: offset %d overlaps previous piece ending at %d",
+				i, p.Offset, offset)
+		}
+		end, ok := util.CheckedAdd(p.Offset, uint64(p.Size))
+		if !ok || end > indexStart {
+			return nil, xerrors.Errorf("piece %d: overlaps the index area", i)
+		}
+		offset = end
+
+		comm, err := lightCid2CommP(p.PieceCID)
+		if err != nil {
+			return nil, xerrors.Errorf("piece %d: converting to piece commitment: %w", i, err)
+		}
+		loc, err := merkletree.LocationForOffsetSize(p.Offset, uint64(p.Size))
+		if err != nil {
+			return nil, xerrors.Errorf("piece %d: %w", i, err)
+		}
+		cl[i] = merkletree.CommAndLoc{Comm: comm, Loc: loc}
+		subdeals[i] = abi.PieceInfo{PieceCID: p.PieceCID, Size: p.Size}
+	}
+	if m.RawSizes != nil {
+		if _, err := applyRawSizes(subdeals, m.RawSizes); err != nil {
+			return nil, xerrors.Errorf("applying raw sizes: %w", err)
+		}
+	}
+
+	agg, err := newAggregateFromCommLoc(m.DealSize, cl, options)
+	if err != nil {
+		return nil, xerrors.Errorf("building aggregate: %w", err)
+	}
+	if m.RawSizes != nil {
+		agg.RawSizes = append([]uint64(nil), m.RawSizes...)
+	}
+	if m.Metadata != nil {
+		if err := agg.AttachMetadata(m.Metadata); err != nil {
+			return nil, xerrors.Errorf("attaching metadata: %w", err)
+		}
+	}
+
+	if m.DealCID.Defined() {
+		dealCID, err := agg.PieceCID()
+		if err != nil {
+			return nil, xerrors.Errorf("computing deal PieceCID: %w", err)
+		}
+		if !dealCID.Equals(m.DealCID) {
+			return nil, xerrors.Errorf("deal PieceCID mismatch: %s (computed) != %s (manifest)",
+				dealCID, m.DealCID)
+		}
+	}
+	if m.IndexCID.Defined() {
+		indexCID, err := agg.IndexPieceCID()
+		if err != nil {
+			return nil, xerrors.Errorf("computing index PieceCID: %w", err)
+		}
+		if !indexCID.Equals(m.IndexCID) {
+			return nil, xerrors.Errorf("index PieceCID mismatch: %s (computed) != %s (manifest)",
+				indexCID, m.IndexCID)
+		}
+	}
+
+	return agg, nil
+}
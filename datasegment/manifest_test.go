@@ -0,0 +1,138 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateManifestRoundtrip(t *testing.T) {
+	subPieceInfos := samplePieceInfos1()
+	a, err := NewAggregate(abi.PaddedPieceSize(32<<30), subPieceInfos)
+	require.NoError(t, err)
+
+	m, err := a.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), m.DealCID)
+	assert.Equal(t, Must(a.IndexPieceCID()), m.IndexCID)
+	require.Len(t, m.Pieces, len(subPieceInfos))
+	for i, pi := range subPieceInfos {
+		assert.Equal(t, pi.PieceCID, m.Pieces[i].PieceCID)
+		assert.Equal(t, pi.Size, m.Pieces[i].Size)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		b, err := json.Marshal(m)
+		require.NoError(t, err)
+		var m2 AggregateManifest
+		require.NoError(t, json.Unmarshal(b, &m2))
+		assert.Equal(t, *m, m2)
+
+		a2, err := AggregateFromManifest(m2)
+		require.NoError(t, err)
+		assert.Equal(t, a.Index, a2.Index)
+		assert.Equal(t, a.Tree.Root(), a2.Tree.Root())
+	})
+	t.Run("cbor", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, m.MarshalCBOR(buf))
+		var m2 AggregateManifest
+		require.NoError(t, m2.UnmarshalCBOR(buf))
+		assert.Equal(t, *m, m2)
+
+		a2, err := AggregateFromManifest(m2)
+		require.NoError(t, err)
+		assert.Equal(t, a.Index, a2.Index)
+		assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+	})
+	t.Run("mismatched deal cid", func(t *testing.T) {
+		m2 := *m
+		m2.DealCID = m.IndexCID
+		_, err := AggregateFromManifest(m2)
+		assert.Error(t, err)
+	})
+	t.Run("overlapping pieces", func(t *testing.T) {
+		m2 := *m
+		m2.Pieces = append([]ManifestPiece{}, m.Pieces...)
+		m2.Pieces[1].Offset = m2.Pieces[0].Offset
+		_, err := AggregateFromManifest(m2)
+		assert.Error(t, err)
+	})
+}
+
+func TestAggregateManifestIndexCapacity(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cidForDeal(1), Size: 256 << 10},
+	}
+	a, err := NewAggregate(dealSize, pieces, WithIndexCapacity(64))
+	require.NoError(t, err)
+
+	m, err := a.Manifest()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(64), m.IndexCapacity)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, m.MarshalCBOR(buf))
+	var m2 AggregateManifest
+	require.NoError(t, m2.UnmarshalCBOR(buf))
+	assert.Equal(t, *m, m2)
+
+	a2, err := AggregateFromManifest(m2)
+	require.NoError(t, err)
+	assert.Equal(t, a.IndexCapacity, a2.IndexCapacity)
+	assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+
+	m2.IndexCapacity = 3
+	_, err = AggregateFromManifest(m2)
+	assert.Error(t, err)
+}
+
+func TestAggregateManifestOptions(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cidForDeal(1), Size: 256 << 10},
+	}
+	a, err := NewAggregate(dealSize, pieces, WithIndexHeader(), WithRawSizes([]uint64{100 << 10, 200 << 10}),
+		WithSegmentMetadata([]SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(100), BlockCount: 3}}))
+	require.NoError(t, err)
+
+	m, err := a.Manifest()
+	require.NoError(t, err)
+	assert.True(t, m.IndexHeader)
+	assert.Equal(t, a.RawSizes, m.RawSizes)
+	assert.Equal(t, a.Metadata, m.Metadata)
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	var fromJSON AggregateManifest
+	require.NoError(t, json.Unmarshal(b, &fromJSON))
+	assert.Equal(t, *m, fromJSON)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, m.MarshalCBOR(buf))
+	var m2 AggregateManifest
+	require.NoError(t, m2.UnmarshalCBOR(buf))
+	assert.Equal(t, *m, m2)
+
+	a2, err := AggregateFromManifest(m2)
+	require.NoError(t, err)
+	assert.True(t, a2.IndexHeader)
+	assert.Equal(t, a.RawSizes, a2.RawSizes)
+	assert.Equal(t, a.Metadata, a2.Metadata)
+	assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+
+	m2.RawSizes = []uint64{100 << 10}
+	_, err = AggregateFromManifest(m2)
+	assert.Error(t, err)
+
+	require.NoError(t, a.TombstoneEntry(0))
+	_, err = a.Manifest()
+	assert.Error(t, err)
+}
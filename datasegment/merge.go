@@ -0,0 +1,156 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// MergeAggregates combines the sub-pieces of existing aggregates into a single Aggregate of dealSize.
+// The data region of each part, the smallest power of two covering its sub-pieces and reserved
+// locations, is placed like a sub-piece and the placement of the sub-pieces within it is kept.
+// The index of the result lists the entries of the parts in order, entries emptied by
+// TombstoneEntry are left out. Reserved locations, RawSizes and Metadata of the parts are
+// carried over. The index areas of the parts are not: the layout of the index of the result
+// is selected with WithIndexCapacity and WithIndexHeader.
+func MergeAggregates(dealSize abi.PaddedPieceSize, parts []*Aggregate, opts ...AggregateOption) (*Aggregate, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+
+	regionSizes := make([]uint64, len(parts))
+	entries := 0
+	withRawSizes := false
+	for i, p := range parts {
+		if p.RawSizes != nil {
+			if len(p.RawSizes) != len(p.Index.Entries) {
+				return nil, xerrors.Errorf("part %d: number of raw sizes doesn't match number of entries: %d != %d",
+					i, len(p.RawSizes), len(p.Index.Entries))
+			}
+			withRawSizes = true
+		}
+		end := uint64(0)
+		for j, e := range p.Index.Entries {
+			if e == (SegmentDesc{}) {
+				continue
+			}
+			eEnd, ok := util.CheckedAdd(e.Offset, e.Size)
+			if !ok {
+				return nil, xerrors.Errorf("part %d, entry %d: end of the entry overflows", i, j)
+			}
+			if eEnd > end {
+				end = eEnd
+			}
+		}
+		for _, r := range p.Reserved {
+			if r.ByteOffset()+r.Size() > end {
+				end = r.ByteOffset() + r.Size()
+			}
+		}
+		if end == 0 {
+			return nil, xerrors.Errorf("part %d is empty", i)
+		}
+		regionSize, err := util.CeilPow2(end)
+		if err != nil {
+			return nil, xerrors.Errorf("part %d: region too large: %w", i, err)
+		}
+		regionSizes[i] = regionSize
+		for _, e := range p.Index.Entries {
+			if e != (SegmentDesc{}) {
+				entries++
+			}
+		}
+	}
+
+	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return nil, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+		maxEntries = options.indexCapacity
+	}
+	if options.indexHeader {
+		maxEntries--
+	}
+	if uint(entries) > maxEntries {
+		return nil, xerrors.Errorf("too many sub-pieces for a %d sized deal: %d > %d",
+			dealSize, entries, maxEntries)
+	}
+	bases := make([]uint64, len(parts))
+	offset := uint64(0)
+	for i, size := range regionSizes {
+		// align the region to its size
+		var ok bool
+		bases[i], offset, ok = alignedPlacement(offset, size)
+		if !ok {
+			return nil, xerrors.Errorf("part %d: placement overflows", i)
+		}
+	}
+	indexAreaStart := Aggregate{DealSize: dealSize, IndexCapacity: options.indexCapacity}.indexAreaStart()
+	if offset > indexAreaStart {
+		return nil, xerrors.Errorf("parts are too large to fit in the deal: %d > %d",
+			offset, indexAreaStart)
+	}
+
+	cl := make([]merkletree.CommAndLoc, 0, entries)
+	var reserved []merkletree.Location
+	var rawSizes []uint64
+	var metadata []SegmentMetadata
+	for i, p := range parts {
+		base := bases[i]
+		// slots maps the entries of the part to their position in the merged index
+		slots := make([]int, len(p.Index.Entries))
+		for j, e := range p.Index.Entries {
+			slots[j] = -1
+			if e == (SegmentDesc{}) {
+				continue
+			}
+			loc, err := merkletree.LocationForOffsetSize(base+e.Offset, e.Size)
+			if err != nil {
+				return nil, xerrors.Errorf("part %d, entry %d: %w", i, j, err)
+			}
+			slots[j] = len(cl)
+			cl = append(cl, merkletree.CommAndLoc{Comm: e.CommDs, Loc: loc})
+			if withRawSizes {
+				raw := e.UnpaddedLength()
+				if p.RawSizes != nil {
+					raw = p.RawSizes[j]
+				}
+				rawSizes = append(rawSizes, raw)
+			}
+		}
+		for j, m := range p.Metadata {
+			if m.Segment >= uint64(len(slots)) || slots[m.Segment] < 0 {
+				return nil, xerrors.Errorf("part %d, metadata %d: segment %d is not in the index", i, j, m.Segment)
+			}
+			m.Segment = uint64(slots[m.Segment])
+			metadata = append(metadata, m)
+		}
+		for j, r := range p.Reserved {
+			loc, err := merkletree.LocationForOffsetSize(base+r.ByteOffset(), r.Size())
+			if err != nil {
+				return nil, xerrors.Errorf("part %d, reserved location %d: %w", i, j, err)
+			}
+			reserved = append(reserved, loc)
+		}
+	}
+
+	agg, err := newAggregateFromCommLoc(dealSize, cl, options)
+	if err != nil {
+		return nil, xerrors.Errorf("building aggregate: %w", err)
+	}
+	agg.Reserved = reserved
+	agg.RawSizes = rawSizes
+	if metadata != nil {
+		if err := agg.AttachMetadata(metadata); err != nil {
+			return nil, xerrors.Errorf("attaching metadata: %w", err)
+		}
+	}
+	return agg, nil
+}
@@ -0,0 +1,115 @@
+package datasegment
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeAggregates(t *testing.T) {
+	partSize := abi.PaddedPieceSize(1 << 20)
+	piecesA := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 256 << 10},
+		{PieceCID: cidForDeal(1), Size: 128 << 10},
+	}
+	piecesB := []abi.PieceInfo{
+		{PieceCID: cidForDeal(2), Size: 64 << 10},
+		{PieceCID: cid.Undef, Size: 64 << 10},
+		{PieceCID: cidForDeal(3), Size: 256 << 10},
+	}
+	partA, err := NewAggregate(partSize, piecesA)
+	require.NoError(t, err)
+	partB, err := NewSparseAggregate(partSize, piecesB)
+	require.NoError(t, err)
+
+	dealSize := abi.PaddedPieceSize(4 << 20)
+	merged, err := MergeAggregates(dealSize, []*Aggregate{partA, partB})
+	require.NoError(t, err)
+
+	// part A covers 512KiB, part B covers 512KiB and is placed after it
+	require.Len(t, merged.Index.Entries, 4)
+	offsets := []uint64{0, 256 << 10, 512 << 10, 768 << 10}
+	for i, e := range merged.Index.Entries {
+		assert.Equal(t, offsets[i], e.Offset, "entry %d", i)
+	}
+	assert.Equal(t, []merkletree.Location{{Level: 11, Index: 9}}, merged.Reserved)
+
+	for _, pi := range []abi.PieceInfo{piecesA[0], piecesA[1], piecesB[0], piecesB[2]} {
+		ip, err := merged.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(merged.PieceCID()), aux.CommPa)
+		assert.Equal(t, dealSize, aux.SizePa)
+	}
+
+	_, err = MergeAggregates(partSize, []*Aggregate{partA, partB})
+	assert.ErrorContains(t, err, "too large")
+	_, err = MergeAggregates(dealSize, []*Aggregate{{DealSize: partSize}})
+	assert.ErrorContains(t, err, "empty")
+}
+
+func TestMergeAggregatesCarriesOver(t *testing.T) {
+	partSize := abi.PaddedPieceSize(1 << 20)
+	piecesA := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0)},
+		{PieceCID: cidForDeal(1)},
+	}
+	partA, err := NewAggregate(partSize, piecesA, WithRawSizes([]uint64{1000, 3000}),
+		WithSegmentMetadata([]SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(101)}}))
+	require.NoError(t, err)
+	piecesB := []abi.PieceInfo{
+		{PieceCID: cidForDeal(2), Size: 64 << 10},
+		{PieceCID: cidForDeal(3), Size: 128 << 10},
+		{PieceCID: cidForDeal(4), Size: 64 << 10},
+	}
+	partB, err := NewAggregate(partSize, piecesB, WithIndexCapacity(64), WithIndexHeader(),
+		WithSegmentMetadata([]SegmentMetadata{{Segment: 2, PayloadCID: cidForDeal(104)}}))
+	require.NoError(t, err)
+	require.NoError(t, partB.TombstoneEntry(1))
+
+	dealSize := abi.PaddedPieceSize(4 << 20)
+	merged, err := MergeAggregates(dealSize, []*Aggregate{partA, partB}, WithIndexHeader())
+	require.NoError(t, err)
+	require.NoError(t, merged.SelfCheck())
+
+	// the tombstoned entry of part B is left out
+	require.Len(t, merged.Index.Entries, 4)
+	assert.True(t, merged.IndexHeader)
+	assert.Zero(t, merged.IndexCapacity)
+	assert.Equal(t, []uint64{1000, 3000, uint64(piecesB[0].Size.Unpadded()), uint64(piecesB[2].Size.Unpadded())}, merged.RawSizes)
+	assert.Equal(t, []SegmentMetadata{
+		{Segment: 1, PayloadCID: cidForDeal(101)},
+		{Segment: 3, PayloadCID: cidForDeal(104)},
+	}, merged.Metadata)
+
+	pieceA := abi.PieceInfo{PieceCID: cidForDeal(0), Size: partA.Index.Entries[0].PaddedSize()}
+	for _, pi := range []abi.PieceInfo{pieceA, piecesB[0], piecesB[2]} {
+		ip, err := merged.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(merged.PieceCID()), aux.CommPa)
+	}
+
+	withCapacity, err := MergeAggregates(dealSize, []*Aggregate{partA, partB}, WithIndexCapacity(128))
+	require.NoError(t, err)
+	assert.Equal(t, uint(128), withCapacity.IndexCapacity)
+	require.NoError(t, withCapacity.SelfCheck())
+	_, err = MergeAggregates(dealSize, []*Aggregate{partA, partB}, WithIndexCapacity(3))
+	assert.ErrorContains(t, err, "invalid index capacity")
+
+	// metadata of a tombstoned entry can't be carried over
+	broken := *partB
+	broken.Metadata = []SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(103)}}
+	_, err = MergeAggregates(dealSize, []*Aggregate{partA, &broken})
+	assert.ErrorContains(t, err, "is not in the index")
+	broken = *partA
+	broken.RawSizes = broken.RawSizes[:1]
+	_, err = MergeAggregates(dealSize, []*Aggregate{&broken, partB})
+	assert.ErrorContains(t, err, "number of raw sizes")
+}
@@ -0,0 +1,301 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// SegmentMetadataVersion is the version of the metadata locator format
+const SegmentMetadataVersion = 1
+
+// metadataMagic identifies the metadata locator
+var metadataMagic = [16]byte{'F', 'R', 'C', '-', '0', '0', '5', '8', ' ', 'm', 'e', 't', 'a'}
+
+const (
+	// metadataLocatorSize is the padded size of the metadata locator, a single Fr32 chunk
+	metadataLocatorSize = 128
+	// metadataLocatorLength is the number of meaningful unpadded bytes of the locator:
+	// magic, version, offset and size of the record and length of the encoded table
+	metadataLocatorLength = len(metadataMagic) + 4*8
+)
+
+// MaxSegmentMetadataLength is the maximum length of the encoded SegmentMetadataTable. It bounds
+// the memory ParseSegmentMetadata allocates for the length read from the untrusted locator.
+const MaxSegmentMetadataLength = 4 << 20
+
+// SegmentMetadata describes the content of a segment, e.g. to let retrieval systems map
+// the segment to the IPLD payload it holds. Metadata is not part of the index entries.
+type SegmentMetadata struct {
+	// Segment is the position of the entry of the segment in the index
+	Segment uint64
+	// PayloadCID is the root CID of the IPLD payload of the segment, e.g. the root of its CAR
+	PayloadCID cid.Cid
+	// BlockCount is the number of blocks of the payload, zero if unknown
+	BlockCount uint64
+}
+
+// SegmentMetadataTable is the side-table of SegmentMetadata as it is serialized in the deal
+type SegmentMetadataTable struct {
+	Segments []SegmentMetadata
+}
+
+type noMetadataError string
+
+// ErrNoSegmentMetadata is returned by ParseSegmentMetadata for deals without metadata
+var ErrNoSegmentMetadata = noMetadataError("deal has no segment metadata")
+
+func (nme noMetadataError) Error() string {
+	return string(nme)
+}
+
+func (nme noMetadataError) Is(err error) bool {
+	_, ok := err.(noMetadataError)
+	return ok
+}
+
+// WithSegmentMetadata attaches the metadata to the Aggregate created by NewAggregate,
+// see Aggregate.AttachMetadata
+func WithSegmentMetadata(md []SegmentMetadata) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.metadata = md
+	}
+}
+
+// AttachMetadata places the metadata in the free space of the deal, right before the index
+// area, and updates the tree. The metadata is serialized as a SegmentMetadataTable into
+// the smallest subtree holding it, followed by a locator in the last 128 bytes before the
+// index area, which ParseSegmentMetadata looks for. The metadata has to be sorted by Segment,
+// with at most one item per segment, and the space it takes up has to be free.
+// The metadata becomes part of the data of the deal: AggregateObjectReader includes it and
+// the PieceCID of the Aggregate changes.
+func (a *Aggregate) AttachMetadata(md []SegmentMetadata) error {
+	if a.Metadata != nil {
+		return xerrors.Errorf("metadata is already attached")
+	}
+	nodes, err := a.metadataNodes(md)
+	if err != nil {
+		return err
+	}
+	tree := a.Tree.Clone()
+	if err := tree.BatchSet(nodes); err != nil {
+		return xerrors.Errorf("setting metadata nodes: %w", err)
+	}
+	a.Tree = tree
+	a.Metadata = append([]SegmentMetadata(nil), md...)
+	return nil
+}
+
+// metadataNodes checks that the metadata can be attached to the Aggregate and returns the
+// commitments of the metadata area, the tree of the Aggregate is not used
+func (a Aggregate) metadataNodes(md []SegmentMetadata) ([]merkletree.CommAndLoc, error) {
+	if len(md) == 0 {
+		return nil, xerrors.Errorf("metadata cannot be empty")
+	}
+	for i, m := range md {
+		if m.Segment >= uint64(len(a.Index.Entries)) {
+			return nil, xerrors.Errorf("metadata %d: segment %d out of range, the index has %d entries",
+				i, m.Segment, len(a.Index.Entries))
+		}
+		if i > 0 && m.Segment <= md[i-1].Segment {
+			return nil, xerrors.Errorf("metadata %d: segments are not sorted or duplicated: %d <= %d",
+				i, m.Segment, md[i-1].Segment)
+		}
+		if !m.PayloadCID.Defined() {
+			return nil, xerrors.Errorf("metadata %d: payload CID is undefined", i)
+		}
+	}
+
+	withMetadata := a
+	withMetadata.Metadata = md
+	layout, err := withMetadata.metadataLayout()
+	if err != nil {
+		return nil, err
+	}
+	end := a.indexAreaStart()
+	for i, e := range a.Index.Entries {
+		if e.Offset < end && layout.recordOffset < util.SaturatingAdd(e.Offset, e.Size) {
+			return nil, xerrors.Errorf("not enough free space for metadata of %d bytes: segment %d at %d overlaps it",
+				layout.recordSize, i, e.Offset)
+		}
+	}
+	for _, l := range a.Reserved {
+		if l.ByteOffset() < end && layout.recordOffset < l.ByteOffset()+l.Size() {
+			return nil, xerrors.Errorf("not enough free space for metadata of %d bytes: reserved %s overlaps it",
+				layout.recordSize, l)
+		}
+	}
+	return layout.nodes()
+}
+
+// MetadataReader returns a reader of the unpadded bytes of the metadata area: the metadata
+// record, zeros and the locator, up to the start of the index area.
+// It returns ErrNoSegmentMetadata if no metadata is attached.
+func (a Aggregate) MetadataReader() (io.Reader, error) {
+	layout, err := a.metadataLayout()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(layout.unpadded()), nil
+}
+
+// metadataLayout describes the placement of the metadata in the deal, in padded bytes
+type metadataLayout struct {
+	encoded       []byte
+	recordOffset  uint64
+	recordSize    uint64
+	locatorOffset uint64
+}
+
+func (a Aggregate) metadataLayout() (metadataLayout, error) {
+	if a.Metadata == nil {
+		return metadataLayout{}, ErrNoSegmentMetadata
+	}
+	var buf bytes.Buffer
+	if err := (&SegmentMetadataTable{Segments: a.Metadata}).MarshalCBOR(&buf); err != nil {
+		return metadataLayout{}, xerrors.Errorf("marshaling metadata: %w", err)
+	}
+	if buf.Len() > MaxSegmentMetadataLength {
+		return metadataLayout{}, xerrors.Errorf("metadata too long: %d > %d", buf.Len(), MaxSegmentMetadataLength)
+	}
+	size := uint64(PaddedSizeForRaw(uint64(buf.Len())))
+	locatorOffset, ok := util.CheckedSub(a.indexAreaStart(), metadataLocatorSize)
+	if !ok || size == 0 || size > locatorOffset {
+		return metadataLayout{}, xerrors.Errorf("metadata of %d bytes doesn't fit in the deal", buf.Len())
+	}
+	return metadataLayout{
+		encoded:       buf.Bytes(),
+		recordOffset:  (locatorOffset - size) &^ (size - 1),
+		recordSize:    size,
+		locatorOffset: locatorOffset,
+	}, nil
+}
+
+// locator returns the unpadded bytes of the locator
+func (ml metadataLayout) locator() []byte {
+	res := make([]byte, abi.PaddedPieceSize(metadataLocatorSize).Unpadded())
+	copy(res, metadataMagic[:])
+	b := res[len(metadataMagic):]
+	binary.LittleEndian.PutUint64(b, SegmentMetadataVersion)
+	binary.LittleEndian.PutUint64(b[8:], ml.recordOffset)
+	binary.LittleEndian.PutUint64(b[16:], ml.recordSize)
+	binary.LittleEndian.PutUint64(b[24:], uint64(len(ml.encoded)))
+	return res
+}
+
+// unpadded returns the unpadded bytes from the start of the record to the end of the locator
+func (ml metadataLayout) unpadded() []byte {
+	start := abi.PaddedPieceSize(ml.recordOffset).Unpadded()
+	locatorStart := abi.PaddedPieceSize(ml.locatorOffset).Unpadded()
+	res := make([]byte, locatorStart-start, locatorStart-start+abi.PaddedPieceSize(metadataLocatorSize).Unpadded())
+	copy(res, ml.encoded)
+	return append(res, ml.locator()...)
+}
+
+// nodes returns the commitments of the record and the locator
+func (ml metadataLayout) nodes() ([]merkletree.CommAndLoc, error) {
+	record, err := unpaddedCommP(ml.encoded, abi.PaddedPieceSize(ml.recordSize))
+	if err != nil {
+		return nil, xerrors.Errorf("computing commP of metadata: %w", err)
+	}
+	recordLoc, err := merkletree.LocationForOffsetSize(ml.recordOffset, ml.recordSize)
+	if err != nil {
+		return nil, xerrors.Errorf("locating metadata: %w", err)
+	}
+	locator, err := unpaddedCommP(ml.locator(), metadataLocatorSize)
+	if err != nil {
+		return nil, xerrors.Errorf("computing commP of metadata locator: %w", err)
+	}
+	locatorLoc, err := merkletree.LocationForOffsetSize(ml.locatorOffset, metadataLocatorSize)
+	if err != nil {
+		return nil, xerrors.Errorf("locating metadata locator: %w", err)
+	}
+	return []merkletree.CommAndLoc{
+		{Comm: record, Loc: recordLoc},
+		{Comm: locator, Loc: locatorLoc},
+	}, nil
+}
+
+// unpaddedCommP computes the commP of the unpadded data zero-filled up to size
+func unpaddedCommP(data []byte, size abi.PaddedPieceSize) (merkletree.Node, error) {
+	cp := &commp.Calc{}
+	_, _ = cp.Write(data)
+	_, _ = cp.Write(make([]byte, int(size.Unpadded())-len(data)))
+	comm, paddedSize, err := cp.Digest()
+	if err != nil {
+		return merkletree.Node{}, err
+	}
+	if paddedSize != uint64(size) {
+		return merkletree.Node{}, xerrors.Errorf("unexpected size of commP: %d != %d", paddedSize, size)
+	}
+	return *(*merkletree.Node)(comm), nil
+}
+
+// ParseSegmentMetadata reads the metadata attached with AttachMetadata from r holding the
+// unpadded data of a deal of dealSize. WithIndexCapacity has to match the option the Aggregate
+// was created with, the locator precedes its index area.
+// It returns ErrNoSegmentMetadata if the deal has no metadata locator.
+func ParseSegmentMetadata(r io.ReaderAt, dealSize abi.PaddedPieceSize, opts ...AggregateOption) ([]SegmentMetadata, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return nil, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+	}
+	indexAreaStart := Aggregate{DealSize: dealSize, IndexCapacity: options.indexCapacity}.indexAreaStart()
+	locatorOffset, ok := util.CheckedSub(indexAreaStart, metadataLocatorSize)
+	if !ok {
+		return nil, ErrNoSegmentMetadata
+	}
+	locator := make([]byte, metadataLocatorLength)
+	if _, err := r.ReadAt(locator, int64(abi.PaddedPieceSize(locatorOffset).Unpadded())); err != nil {
+		return nil, xerrors.Errorf("reading metadata locator: %w", err)
+	}
+	if !bytes.Equal(locator[:len(metadataMagic)], metadataMagic[:]) {
+		return nil, ErrNoSegmentMetadata
+	}
+	b := locator[len(metadataMagic):]
+	version := binary.LittleEndian.Uint64(b)
+	offset := binary.LittleEndian.Uint64(b[8:])
+	size := binary.LittleEndian.Uint64(b[16:])
+	length := binary.LittleEndian.Uint64(b[24:])
+	if version != SegmentMetadataVersion {
+		return nil, xerrors.Errorf("unsupported metadata version: %d", version)
+	}
+	if _, err := merkletree.LocationForOffsetSize(offset, size); err != nil || size < 128 {
+		return nil, xerrors.Errorf("invalid metadata placement: offset %d, size %d", offset, size)
+	}
+	if end, ok := util.CheckedAdd(offset, size); !ok || end > locatorOffset {
+		return nil, xerrors.Errorf("metadata overlaps its locator: %d + %d > %d", offset, size, locatorOffset)
+	}
+	if length > uint64(abi.PaddedPieceSize(size).Unpadded()) {
+		return nil, xerrors.Errorf("metadata length exceeds its size: %d > %d", length, abi.PaddedPieceSize(size).Unpadded())
+	}
+	if length > MaxSegmentMetadataLength {
+		return nil, xerrors.Errorf("metadata too long: %d > %d", length, MaxSegmentMetadataLength)
+	}
+
+	encoded := make([]byte, length)
+	if _, err := r.ReadAt(encoded, int64(abi.PaddedPieceSize(offset).Unpadded())); err != nil {
+		return nil, xerrors.Errorf("reading metadata: %w", err)
+	}
+	var table SegmentMetadataTable
+	if err := table.UnmarshalCBOR(bytes.NewReader(encoded)); err != nil {
+		return nil, xerrors.Errorf("decoding metadata: %w", err)
+	}
+	return table.Segments, nil
+}
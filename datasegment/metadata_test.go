@@ -0,0 +1,150 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentMetadata(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	plain, pieces, err := GenerateRandomAggregate(4156, dealSize, 4)
+	require.NoError(t, err)
+	pieceInfos := make([]abi.PieceInfo, len(pieces))
+	for i, p := range pieces {
+		pieceInfos[i] = p.PieceInfo
+	}
+	md := []SegmentMetadata{
+		{Segment: 0, PayloadCID: cidForDeal(100), BlockCount: 12},
+		{Segment: 2, PayloadCID: cidForDeal(102)},
+	}
+
+	a, err := NewAggregate(dealSize, pieceInfos, WithSegmentMetadata(md))
+	require.NoError(t, err)
+	assert.Equal(t, md, a.Metadata)
+	assert.Equal(t, plain.Index, a.Index)
+	assert.NotEqual(t, Must(plain.PieceCID()), Must(a.PieceCID()))
+	require.NoError(t, a.SelfCheck())
+	for _, pi := range pieceInfos {
+		ip, err := a.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(a.PieceCID()), aux.CommPa)
+	}
+	layout, err := a.metadataLayout()
+	require.NoError(t, err)
+	assert.Equal(t, IndexAreaStartPadded(dealSize)-metadataLocatorSize, layout.locatorOffset)
+	assert.Equal(t, uint64(plain.FreeBytes())-(IndexAreaStartPadded(dealSize)-layout.recordOffset), uint64(a.FreeBytes()))
+
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		readers[i] = p.Reader()
+	}
+	deal, err := io.ReadAll(Must(a.AggregateObjectReader(readers)))
+	require.NoError(t, err)
+	require.Len(t, deal, int(dealSize.Unpadded()))
+	cp := &commp.Calc{}
+	_, _ = cp.Write(deal)
+	comm, _, err := cp.Digest()
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), Must(commcid.PieceCommitmentV1ToCID(comm)))
+
+	parsed, err := ParseSegmentMetadata(bytes.NewReader(deal), dealSize)
+	require.NoError(t, err)
+	assert.Equal(t, md, parsed)
+	index, err := ParseDataSegmentIndexForDeal(dealSize, bytes.NewReader(deal[DataSegmentIndexStartOffset(dealSize):]))
+	require.NoError(t, err)
+	assert.Equal(t, a.Index.Entries, Must(index.ValidEntries()))
+
+	metadata, err := io.ReadAll(Must(a.MetadataReader()))
+	require.NoError(t, err)
+	start := abi.PaddedPieceSize(layout.recordOffset).Unpadded()
+	assert.Equal(t, deal[start:DataSegmentIndexStartOffset(dealSize)], metadata)
+
+	// deals without metadata
+	_, err = plain.MetadataReader()
+	assert.ErrorIs(t, err, ErrNoSegmentMetadata)
+	plainDeal, err := io.ReadAll(Must(plain.AggregateObjectReader(readers)))
+	require.NoError(t, err)
+	_, err = ParseSegmentMetadata(bytes.NewReader(plainDeal), dealSize)
+	assert.ErrorIs(t, err, ErrNoSegmentMetadata)
+}
+
+func TestAttachMetadataRejects(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cidForDeal(1), Size: 256 << 10},
+	}
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	root := a.Tree.Root()
+
+	for _, md := range [][]SegmentMetadata{
+		nil,
+		{{Segment: 2, PayloadCID: cidForDeal(100)}},
+		{{Segment: 1, PayloadCID: cidForDeal(100)}, {Segment: 0, PayloadCID: cidForDeal(101)}},
+		{{Segment: 0, PayloadCID: cidForDeal(100)}, {Segment: 0, PayloadCID: cidForDeal(101)}},
+		{{Segment: 0}},
+	} {
+		assert.Error(t, a.AttachMetadata(md), "%v", md)
+		assert.Nil(t, a.Metadata)
+		assert.Equal(t, root, a.Tree.Root())
+	}
+
+	require.NoError(t, a.AttachMetadata([]SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(100)}}))
+	assert.Error(t, a.AttachMetadata([]SegmentMetadata{{Segment: 0, PayloadCID: cidForDeal(100)}}))
+
+	// a segment right before the index area
+	entry := SegmentDesc{CommDs: commForDeal(0), Offset: IndexAreaStartPadded(dealSize) - 512, Size: 512}
+	full, err := NewAggregateFromIndex(dealSize, IndexData{Entries: []SegmentDesc{entry.withUpdatedChecksum()}})
+	require.NoError(t, err)
+	assert.Error(t, full.AttachMetadata([]SegmentMetadata{{Segment: 0, PayloadCID: cidForDeal(100)}}))
+}
+
+func TestParseSegmentMetadataLayout(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	_, pieces, err := GenerateRandomAggregate(4092, dealSize, 2)
+	require.NoError(t, err)
+	pieceInfos := make([]abi.PieceInfo, len(pieces))
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		pieceInfos[i] = p.PieceInfo
+		readers[i] = p.Reader()
+	}
+	md := []SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(100)}}
+	a, err := NewAggregate(dealSize, pieceInfos, WithIndexCapacity(64), WithIndexHeader(), WithSegmentMetadata(md))
+	require.NoError(t, err)
+	deal, err := io.ReadAll(Must(a.AggregateObjectReader(readers)))
+	require.NoError(t, err)
+
+	parsed, err := ParseSegmentMetadata(bytes.NewReader(deal), dealSize, WithIndexCapacity(64))
+	require.NoError(t, err)
+	assert.Equal(t, md, parsed)
+	_, err = ParseSegmentMetadata(bytes.NewReader(deal), dealSize)
+	assert.ErrorIs(t, err, ErrNoSegmentMetadata)
+	_, err = ParseSegmentMetadata(bytes.NewReader(deal), dealSize, WithIndexCapacity(3))
+	assert.ErrorContains(t, err, "invalid index capacity")
+}
+
+func TestParseSegmentMetadataTooLong(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(16 << 20)
+	locatorOffset := IndexAreaStartPadded(dealSize) - metadataLocatorSize
+	layout := metadataLayout{recordOffset: 0, recordSize: 8 << 20, locatorOffset: locatorOffset}
+	locator := layout.locator()
+	// the length of the record claimed by the locator fits in the record but exceeds the limit
+	binary.LittleEndian.PutUint64(locator[len(metadataMagic)+24:], MaxSegmentMetadataLength+1)
+
+	deal := make([]byte, dealSize.Unpadded())
+	copy(deal[abi.PaddedPieceSize(locatorOffset).Unpadded():], locator)
+	_, err := ParseSegmentMetadata(bytes.NewReader(deal), dealSize)
+	assert.ErrorContains(t, err, "metadata too long")
+}
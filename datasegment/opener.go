@@ -0,0 +1,296 @@
+package datasegment
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	xerrors "golang.org/x/xerrors"
+)
+
+// PieceOpener opens the unpadded data of a sub-piece starting offset bytes into it.
+// After a failed read it is called again with the offset of the first byte not read yet,
+// so the sub-piece doesn't have to be read from the start again.
+type PieceOpener func(offset int64) (io.ReadCloser, error)
+
+// ReaderAtOpener returns a PieceOpener of the first size bytes of r
+func ReaderAtOpener(r io.ReaderAt, size int64) PieceOpener {
+	return func(offset int64) (io.ReadCloser, error) {
+		if offset < 0 || offset > size {
+			return nil, xerrors.Errorf("offset %d out of the piece of size %d", offset, size)
+		}
+		return io.NopCloser(io.NewSectionReader(r, offset, size-offset)), nil
+	}
+}
+
+// ObjectReaderOption configures AggregateObjectReaderFromOpeners
+type ObjectReaderOption func(*objectReaderOptions)
+
+type objectReaderOptions struct {
+	retries  int
+	backoff  time.Duration
+	prefetch int
+	ctx      context.Context
+}
+
+const (
+	// DefaultRetryBackoff is the delay before the first reopen of a sub-piece, see WithRetryBackoff
+	DefaultRetryBackoff = 50 * time.Millisecond
+	// MaxRetryBackoff bounds the delay before reopening a sub-piece
+	MaxRetryBackoff = 5 * time.Second
+)
+
+// WithReadRetries allows up to retries failed opens or reads of each sub-piece, the sub-piece
+// is reopened at the first byte not read yet
+func WithReadRetries(retries int) ObjectReaderOption {
+	return func(o *objectReaderOptions) {
+		o.retries = retries
+	}
+}
+
+// WithRetryBackoff sets the delay before the first reopen of a sub-piece, it doubles with
+// every failure of the sub-piece up to MaxRetryBackoff. Zero reopens without delay.
+func WithRetryBackoff(backoff time.Duration) ObjectReaderOption {
+	return func(o *objectReaderOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WithReadContext stops retries once ctx is done, reads then fail with the error of ctx
+func WithReadContext(ctx context.Context) ObjectReaderOption {
+	return func(o *objectReaderOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithPrefetch reads up to size bytes of the next sub-piece in the background once reading
+// of the current sub-piece starts, hiding the latency of opening it
+func WithPrefetch(size int) ObjectReaderOption {
+	return func(o *objectReaderOptions) {
+		o.prefetch = size
+	}
+}
+
+// AggregateObjectReaderFromOpeners is like AggregateObjectReader but opens the sub-pieces when
+// they are reached, in the same order as subdeals in the construction call of the Aggregate.
+// A failed read doesn't fail the whole stream if retries are allowed with WithReadRetries.
+// The returned reader has to be closed, which closes the sub-pieces still open.
+func (a Aggregate) AggregateObjectReaderFromOpeners(openers []PieceOpener, opts ...ObjectReaderOption) (io.ReadCloser, error) {
+	options := objectReaderOptions{backoff: DefaultRetryBackoff, ctx: context.Background()}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.retries < 0 || options.prefetch < 0 || options.backoff < 0 {
+		return nil, xerrors.Errorf("retries, backoff and prefetch size cannot be negative: %d, %s, %d",
+			options.retries, options.backoff, options.prefetch)
+	}
+	if options.ctx == nil {
+		return nil, xerrors.Errorf("context cannot be nil")
+	}
+	if len(openers) != len(a.Index.Entries) {
+		return nil, xerrors.Errorf("passed different number of openers than subPieces: %d != %d", len(openers), len(a.Index.Entries))
+	}
+
+	or := &openerObjectReader{
+		pieces:     make([]*retryReader, len(openers)),
+		prefetches: make([]*prefetchReader, 0, len(openers)),
+	}
+	readers := make([]io.Reader, len(openers))
+	for i, open := range openers {
+		if open == nil {
+			return nil, xerrors.Errorf("opener %d is nil", i)
+		}
+		or.pieces[i] = &retryReader{open: open, retries: options.retries, backoff: options.backoff, ctx: options.ctx}
+		readers[i] = or.pieces[i]
+	}
+	if options.prefetch > 0 {
+		for i := len(readers) - 1; i >= 0; i-- {
+			var next *prefetchReader
+			if i+1 < len(readers) {
+				next = readers[i+1].(*prefetchReader)
+			}
+			pr := &prefetchReader{r: readers[i], size: options.prefetch, next: next, done: make(chan struct{})}
+			or.prefetches = append(or.prefetches, pr)
+			readers[i] = pr
+		}
+	}
+
+	r, err := a.AggregateObjectReader(readers)
+	if err != nil {
+		return nil, err
+	}
+	or.r = r
+	return or, nil
+}
+
+type openerObjectReader struct {
+	r          io.Reader
+	pieces     []*retryReader
+	prefetches []*prefetchReader
+}
+
+func (or *openerObjectReader) Read(b []byte) (int, error) {
+	return or.r.Read(b)
+}
+
+// Close waits for prefetches in progress and closes the sub-pieces still open
+func (or *openerObjectReader) Close() error {
+	for _, p := range or.prefetches {
+		p.wait()
+	}
+	var errs error
+	for i, p := range or.pieces {
+		if err := p.close(); err != nil {
+			errs = multierror.Append(errs, xerrors.Errorf("closing sub-piece %d: %w", i, err))
+		}
+	}
+	return errs
+}
+
+// retryReader reads a sub-piece opened by open, reopening it after failures
+type retryReader struct {
+	open     PieceOpener
+	retries  int
+	backoff  time.Duration
+	ctx      context.Context
+	failures int
+	// failed is set if the sub-piece has to be reopened after a failure
+	failed bool
+	r      io.ReadCloser
+	offset int64
+}
+
+func (rr *retryReader) Read(b []byte) (int, error) {
+	for {
+		if rr.r == nil {
+			if err := rr.wait(); err != nil {
+				return 0, xerrors.Errorf("reopening sub-piece at %d: %w", rr.offset, err)
+			}
+			r, err := rr.open(rr.offset)
+			if err != nil {
+				if rr.giveUp() {
+					return 0, xerrors.Errorf("opening sub-piece at %d: %w", rr.offset, err)
+				}
+				continue
+			}
+			rr.r = r
+		}
+
+		n, err := rr.r.Read(b)
+		rr.offset += int64(n)
+		if err == nil || err == io.EOF {
+			if err == io.EOF {
+				_ = rr.close()
+			}
+			return n, err
+		}
+
+		_ = rr.close()
+		if rr.giveUp() {
+			return n, xerrors.Errorf("reading sub-piece at %d: %w", rr.offset, err)
+		}
+		if n > 0 {
+			// the rest is read from the reopened sub-piece on the next call
+			return n, nil
+		}
+	}
+}
+
+// giveUp records a failure and reports if the retries are exhausted
+func (rr *retryReader) giveUp() bool {
+	rr.failures++
+	rr.failed = true
+	return rr.failures > rr.retries
+}
+
+// wait delays reopening the sub-piece after a failure, the delay doubles with every failure up
+// to MaxRetryBackoff. It returns early with the error of the context once it is done.
+func (rr *retryReader) wait() error {
+	if err := rr.ctx.Err(); err != nil {
+		return err
+	}
+	if !rr.failed {
+		return nil
+	}
+	rr.failed = false
+	if rr.backoff == 0 {
+		return nil
+	}
+	delay := MaxRetryBackoff
+	if shift := rr.failures - 1; shift < 32 && rr.backoff < MaxRetryBackoff>>shift {
+		delay = rr.backoff << shift
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-rr.ctx.Done():
+		return rr.ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (rr *retryReader) close() error {
+	if rr.r == nil {
+		return nil
+	}
+	err := rr.r.Close()
+	rr.r = nil
+	return err
+}
+
+// prefetchReader reads up to size bytes of r in the background when started, reading of
+// the next reader is started together with this one
+type prefetchReader struct {
+	r    io.Reader
+	size int
+	next *prefetchReader
+
+	once    sync.Once
+	started bool
+	done    chan struct{}
+	buf     []byte
+	err     error
+}
+
+func (pr *prefetchReader) start() {
+	pr.once.Do(func() {
+		pr.started = true
+		go func() {
+			defer close(pr.done)
+			buf := make([]byte, pr.size)
+			n, err := io.ReadFull(pr.r, buf)
+			pr.buf = buf[:n]
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			pr.err = err
+		}()
+	})
+}
+
+// wait waits for the prefetch to finish if it was started
+func (pr *prefetchReader) wait() {
+	if pr.started {
+		<-pr.done
+	}
+}
+
+func (pr *prefetchReader) Read(b []byte) (int, error) {
+	pr.start()
+	if pr.next != nil {
+		pr.next.start()
+	}
+	<-pr.done
+	if len(pr.buf) > 0 {
+		n := copy(b, pr.buf)
+		pr.buf = pr.buf[n:]
+		return n, nil
+	}
+	if pr.err != nil {
+		return 0, pr.err
+	}
+	return pr.r.Read(b)
+}
@@ -0,0 +1,205 @@
+package datasegment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyReader fails once after failAfter bytes were read
+type flakyReader struct {
+	r         io.Reader
+	failAfter int
+	read      int
+	failed    *bool
+}
+
+func (fr *flakyReader) Read(b []byte) (int, error) {
+	if !*fr.failed && fr.read+len(b) > fr.failAfter {
+		b = b[:fr.failAfter-fr.read]
+		n, _ := fr.r.Read(b)
+		fr.read += n
+		*fr.failed = true
+		return n, errors.New("connection reset")
+	}
+	n, err := fr.r.Read(b)
+	fr.read += n
+	return n, err
+}
+
+func (fr *flakyReader) Close() error {
+	return nil
+}
+
+// flakyOpeners returns openers of the pieces failing once in the middle of each piece
+func flakyOpeners(pieces []RandomPiece) []PieceOpener {
+	res := make([]PieceOpener, len(pieces))
+	for i, p := range pieces {
+		data := p.Data
+		failed := false
+		res[i] = func(offset int64) (io.ReadCloser, error) {
+			return &flakyReader{r: bytes.NewReader(data[offset:]), failAfter: len(data) / 2, failed: &failed}, nil
+		}
+	}
+	return res
+}
+
+func expectedDeal(t *testing.T, a *Aggregate, pieces []RandomPiece) []byte {
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		readers[i] = p.Reader()
+	}
+	r, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+	return Must(io.ReadAll(r))
+}
+
+func TestAggregateObjectReaderFromOpeners(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(11, 8<<20, 6)
+	require.NoError(t, err)
+	expected := expectedDeal(t, a, pieces)
+
+	openers := make([]PieceOpener, len(pieces))
+	for i, p := range pieces {
+		openers[i] = ReaderAtOpener(bytes.NewReader(p.Data), int64(len(p.Data)))
+	}
+	for _, opts := range [][]ObjectReaderOption{nil, {WithPrefetch(1000)}, {WithPrefetch(1 << 20)}} {
+		r, err := a.AggregateObjectReaderFromOpeners(openers, opts...)
+		require.NoError(t, err)
+		assert.Equal(t, expected, Must(io.ReadAll(r)))
+		assert.NoError(t, r.Close())
+	}
+
+	// a failed read is resumed at the first unread byte
+	for _, opts := range [][]ObjectReaderOption{{WithReadRetries(1)}, {WithReadRetries(1), WithPrefetch(100)}} {
+		r, err := a.AggregateObjectReaderFromOpeners(flakyOpeners(pieces), opts...)
+		require.NoError(t, err)
+		assert.Equal(t, expected, Must(io.ReadAll(r)))
+		assert.NoError(t, r.Close())
+	}
+
+	r, err := a.AggregateObjectReaderFromOpeners(flakyOpeners(pieces))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "connection reset")
+	assert.NoError(t, r.Close())
+
+	_, err = a.AggregateObjectReaderFromOpeners(openers[1:])
+	assert.Error(t, err)
+	_, err = a.AggregateObjectReaderFromOpeners(openers, WithReadRetries(-1))
+	assert.Error(t, err)
+}
+
+func TestAggregateObjectReaderFromOpenersRetriesOpen(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(12, 1<<20, 2)
+	require.NoError(t, err)
+	expected := expectedDeal(t, a, pieces)
+
+	openers := make([]PieceOpener, len(pieces))
+	for i, p := range pieces {
+		attempts := 0
+		inner := ReaderAtOpener(bytes.NewReader(p.Data), int64(len(p.Data)))
+		openers[i] = func(offset int64) (io.ReadCloser, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("unavailable")
+			}
+			return inner(offset)
+		}
+	}
+	r, err := a.AggregateObjectReaderFromOpeners(openers, WithReadRetries(2))
+	require.NoError(t, err)
+	assert.Equal(t, expected, Must(io.ReadAll(r)))
+	assert.NoError(t, r.Close())
+}
+
+func TestAggregateObjectReaderFromOpenersBackoff(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(12, 1<<20, 1)
+	require.NoError(t, err)
+	expected := expectedDeal(t, a, pieces)
+
+	var opens []time.Time
+	inner := ReaderAtOpener(bytes.NewReader(pieces[0].Data), int64(len(pieces[0].Data)))
+	opener := func(offset int64) (io.ReadCloser, error) {
+		opens = append(opens, time.Now())
+		if len(opens) < 3 {
+			return nil, errors.New("unavailable")
+		}
+		return inner(offset)
+	}
+	r, err := a.AggregateObjectReaderFromOpeners([]PieceOpener{opener},
+		WithReadRetries(2), WithRetryBackoff(20*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, expected, Must(io.ReadAll(r)))
+	assert.NoError(t, r.Close())
+	require.Len(t, opens, 3)
+	assert.GreaterOrEqual(t, opens[1].Sub(opens[0]), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, opens[2].Sub(opens[1]), 40*time.Millisecond)
+
+	_, err = a.AggregateObjectReaderFromOpeners([]PieceOpener{opener}, WithRetryBackoff(-1))
+	assert.Error(t, err)
+}
+
+func TestAggregateObjectReaderFromOpenersContext(t *testing.T) {
+	a, _, err := GenerateRandomAggregate(12, 1<<20, 1)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opens := 0
+	opener := func(offset int64) (io.ReadCloser, error) {
+		opens++
+		if opens == 2 {
+			cancel()
+		}
+		return nil, errors.New("unavailable")
+	}
+	r, err := a.AggregateObjectReaderFromOpeners([]PieceOpener{opener},
+		WithReadRetries(1000), WithRetryBackoff(time.Millisecond), WithReadContext(ctx))
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, opens)
+	assert.Less(t, time.Since(start), MaxRetryBackoff)
+	assert.NoError(t, r.Close())
+}
+
+func TestAggregateObjectReaderFromOpenersPrefetch(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(13, 1<<20, 3)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	opened := make(map[int]bool)
+	openers := make([]PieceOpener, len(pieces))
+	for i, p := range pieces {
+		i, inner := i, ReaderAtOpener(bytes.NewReader(p.Data), int64(len(p.Data)))
+		openers[i] = func(offset int64) (io.ReadCloser, error) {
+			mu.Lock()
+			opened[i] = true
+			mu.Unlock()
+			return inner(offset)
+		}
+	}
+	r, err := a.AggregateObjectReaderFromOpeners(openers, WithPrefetch(64))
+	require.NoError(t, err)
+
+	// the first piece starts the deal, reading it starts the prefetch of the second one
+	_, err = r.Read(make([]byte, 1))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return opened[1]
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.False(t, opened[2])
+	mu.Unlock()
+	assert.NoError(t, r.Close())
+}
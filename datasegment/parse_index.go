@@ -2,48 +2,306 @@ package datasegment
 
 import (
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/filecoin-project/go-data-segment/fr32"
+	"github.com/filecoin-project/go-data-segment/util"
 	abi "github.com/filecoin-project/go-state-types/abi"
 	xerrors "golang.org/x/xerrors"
 )
 
+// IndexAreaSizePadded returns the size of the index area of a deal in padded bytes, equal to
+// MaxIndexEntriesInDeal(dealSize) entries.
+// The dealSize should be validated with ValidateDealSize beforehand.
+func IndexAreaSizePadded(dealSize abi.PaddedPieceSize) abi.PaddedPieceSize {
+	return abi.PaddedPieceSize(uint64(MaxIndexEntriesInDeal(dealSize)) * EntrySize)
+}
+
+// IndexAreaSizeUnpadded returns the size of the index area of a deal in unpadded bytes.
+// The dealSize should be validated with ValidateDealSize beforehand.
+func IndexAreaSizeUnpadded(dealSize abi.PaddedPieceSize) abi.UnpaddedPieceSize {
+	// safe because EntrySize = 64 and min(MaxIndexEntriesInDeal(x)) = 4
+	return IndexAreaSizePadded(dealSize).Unpadded()
+}
+
+// IndexAreaStartPadded returns the offset of the index area from the start of the deal
+// in padded bytes.
+// The dealSize should be validated with ValidateDealSize beforehand.
+func IndexAreaStartPadded(dealSize abi.PaddedPieceSize) uint64 {
+	return util.SaturatingSub(uint64(dealSize), uint64(IndexAreaSizePadded(dealSize)))
+}
+
+// IndexAreaStartUnpadded returns the offset of the index area from the start of the deal
+// in unpadded bytes.
+// The dealSize should be validated with ValidateDealSize beforehand.
+func IndexAreaStartUnpadded(dealSize abi.PaddedPieceSize) uint64 {
+	return util.SaturatingSub(uint64(dealSize.Unpadded()), uint64(IndexAreaSizeUnpadded(dealSize)))
+}
+
 // DataSegmentIndexStartOffset takes in the padded size of the deal and returns the starting offset
-// of data segment index in unpadded units.
+// of data segment index in unpadded units, it is equal to IndexAreaStartUnpadded.
+// The dealSize should be validated with ValidateDealSize beforehand.
 func DataSegmentIndexStartOffset(dealSize abi.PaddedPieceSize) uint64 {
-	mie := MaxIndexEntriesInDeal(dealSize)
-	fromBack := uint64(mie) * uint64(EntrySize)
-	fromBack = fromBack - fromBack/128 // safe because EntrySize = 64 and min(MaxIndexEntriesInDeal(x)) = 4
-	return uint64(dealSize.Unpadded()) - fromBack
+	return IndexAreaStartUnpadded(dealSize)
+}
+
+// ParseDataSegmentIndexForDeal validates the dealSize and parses the data segment index of
+// a deal of that size. The reader should start at offset returned by DataSegmentIndexStartOffset,
+// no more than the size of the index area is read from it.
+func ParseDataSegmentIndexForDeal(dealSize abi.PaddedPieceSize, unpaddedReader io.Reader) (IndexData, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return IndexData{}, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	return ParseDataSegmentIndex(io.LimitReader(unpaddedReader, int64(IndexAreaSizeUnpadded(dealSize))))
 }
 
 // ParseDataSegmentIndex takes in a reader of of unppaded deal data, it should start at offset
 // returned by DataSegmentIndexStartOffset
+// If the index starts with an IndexHeader, the header is skipped and only the number of entries
+// declared by it is returned.
 // After parsing use IndexData#ValidEntries() to gather valid data segments
 func ParseDataSegmentIndex(unpaddedReader io.Reader) (IndexData, error) {
+	allEntries, err := readIndexArea(unpaddedReader)
+	if err != nil {
+		return IndexData{}, err
+	}
+	return indexDataFromArea(allEntries)
+}
+
+// ParseDataSegmentIndexWithStatus is like ParseDataSegmentIndex but additionally returns the
+// status of every slot of the index area, counting the IndexHeader if present, such that
+// corrupted entries can be told apart from empty slots.
+func ParseDataSegmentIndexWithStatus(unpaddedReader io.Reader) (IndexData, []EntryStatus, error) {
+	allEntries, err := readIndexArea(unpaddedReader)
+	if err != nil {
+		return IndexData{}, nil, err
+	}
+	statuses := make([]EntryStatus, len(allEntries))
+	for slot, en := range allEntries {
+		statuses[slot] = EntryStatus{Slot: slot, State: EntryOK}
+		if en == (SegmentDesc{}) {
+			statuses[slot].State = EntryEmpty
+			continue
+		}
+		if _, ok := ParseIndexHeader(en); ok && slot == 0 {
+			continue
+		}
+		if err := en.Validate(); err != nil {
+			statuses[slot].State = EntryCorrupt
+			statuses[slot].Err = err
+		}
+	}
+	index, err := indexDataFromArea(allEntries)
+	if err != nil {
+		return IndexData{}, nil, err
+	}
+	return index, statuses, nil
+}
+
+// readIndexArea reads all entries of the index area from the unpadded reader
+func readIndexArea(unpaddedReader io.Reader) ([]SegmentDesc, error) {
 	allEntries := []SegmentDesc{}
 
-	unpaddedBuf := make([]byte, 127)
+	paddedReader := fr32.NewPadReader(unpaddedReader)
 	paddedBuf := make([]byte, 128)
 	for {
-		_, err := io.ReadFull(unpaddedReader, unpaddedBuf)
+		_, err := io.ReadFull(paddedReader, paddedBuf)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			} else {
-				return IndexData{}, xerrors.Errorf("reading 127 bytes from parsing: %w", err)
+				return nil, xerrors.Errorf("reading 128 padded bytes for parsing: %w", err)
 			}
 		}
 
-		fr32.Pad(unpaddedBuf, paddedBuf)
-
 		en1 := SegmentDesc{}
 		en1.UnmarshalBinary(paddedBuf[:EntrySize])
 		en2 := SegmentDesc{}
 		en2.UnmarshalBinary(paddedBuf[EntrySize:])
 		allEntries = append(allEntries, en1, en2)
 	}
+	return allEntries, nil
+}
+
+// EntryState classifies a slot of the index area, see ParseDataSegmentIndexWithStatus
+type EntryState int
+
+const (
+	// EntryOK means the slot holds a valid entry or the IndexHeader
+	EntryOK EntryState = iota
+	// EntryEmpty means the slot is all zeros
+	EntryEmpty
+	// EntryCorrupt means the slot is not empty but doesn't hold a valid entry
+	EntryCorrupt
+)
+
+func (s EntryState) String() string {
+	switch s {
+	case EntryOK:
+		return "ok"
+	case EntryEmpty:
+		return "empty"
+	case EntryCorrupt:
+		return "corrupt"
+	default:
+		return "unknown"
+	}
+}
+
+// EntryStatus is the status of a single slot of the index area
+type EntryStatus struct {
+	// Slot is the position of the entry within the index area, counting the IndexHeader if present
+	Slot  int
+	State EntryState
+	// Err is the reason a corrupt entry failed validation
+	Err error
+}
+
+// indexDataFromArea strips the IndexHeader, if present, from the entries of the index area
+func indexDataFromArea(allEntries []SegmentDesc) (IndexData, error) {
+	if len(allEntries) != 0 {
+		if header, ok := ParseIndexHeader(allEntries[0]); ok {
+			if header.Version != IndexHeaderVersion {
+				return IndexData{}, xerrors.Errorf("unsupported index version: %d", header.Version)
+			}
+			if header.Entries > uint64(len(allEntries)-1) {
+				return IndexData{}, xerrors.Errorf("index header declares more entries than present: %d > %d",
+					header.Entries, len(allEntries)-1)
+			}
+			allEntries = allEntries[1 : 1+header.Entries]
+		}
+	}
 
 	return IndexData{Entries: allEntries}, nil
 }
+
+// EntryErrorKind classifies the failures reported by ParseDataSegmentIndexAt and
+// NewAggregateFromIndex
+type EntryErrorKind int
+
+const (
+	// EntryReadError means the data of the entry could not be read
+	EntryReadError EntryErrorKind = iota
+	// EntryInvalid means the entry was read but is not a valid non-empty entry, e.g. its
+	// checksum doesn't match
+	EntryInvalid
+	// EntryMisplaced means the entry is valid on its own but its placement is not: it overlaps
+	// or precedes the previous entry, or it collides with the index area
+	EntryMisplaced
+)
+
+func (k EntryErrorKind) String() string {
+	switch k {
+	case EntryReadError:
+		return "read error"
+	case EntryInvalid:
+		return "invalid entry"
+	case EntryMisplaced:
+		return "misplaced entry"
+	default:
+		return "unknown"
+	}
+}
+
+// EntryError describes the failure to recover a single entry of the index area
+type EntryError struct {
+	// Slot is the position of the entry within the index area, counting the IndexHeader if present
+	Slot int
+	Kind EntryErrorKind
+	Err  error
+}
+
+func (ee *EntryError) Error() string {
+	return fmt.Sprintf("entry %d: %s: %s", ee.Slot, ee.Kind, ee.Err)
+}
+
+func (ee *EntryError) Unwrap() error {
+	return ee.Err
+}
+
+type parseOptions struct {
+	stopAtFirstError bool
+}
+
+// ParseOption configures ParseDataSegmentIndexAt
+type ParseOption func(*parseOptions)
+
+// StopAtFirstError causes ParseDataSegmentIndexAt to fail on the first entry which cannot be
+// read or is invalid, instead of recovering the remaining entries.
+func StopAtFirstError() ParseOption {
+	return func(o *parseOptions) {
+		o.stopAtFirstError = true
+	}
+}
+
+// ParseDataSegmentIndexAt parses the index area of unpaddedSize bytes from r, which should
+// be positioned such that offset 0 is the offset returned by DataSegmentIndexStartOffset.
+// Every 127 byte chunk, holding two entries, is read independently, so a localized failure
+// of the storage doesn't affect the other entries. Entries which could not be read are left
+// empty and, together with non-empty entries failing validation, are reported as EntryErrors.
+// Empty entries are not reported. With StopAtFirstError the first EntryError is returned
+// as the error instead.
+func ParseDataSegmentIndexAt(r io.ReaderAt, unpaddedSize int64, opts ...ParseOption) (IndexData, []*EntryError, error) {
+	var options parseOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	const chunkSize = 127
+	if unpaddedSize < 0 || unpaddedSize%chunkSize != 0 {
+		return IndexData{}, nil, xerrors.Errorf("size of the index area is not a multiple of %d: %d",
+			chunkSize, unpaddedSize)
+	}
+
+	chunks := int(unpaddedSize / chunkSize)
+	allEntries := make([]SegmentDesc, 2*chunks)
+	var entryErrs []*EntryError
+	report := func(ee *EntryError) error {
+		if options.stopAtFirstError {
+			return ee
+		}
+		entryErrs = append(entryErrs, ee)
+		return nil
+	}
+
+	unpaddedBuf := make([]byte, chunkSize)
+	paddedBuf := make([]byte, 128)
+	for i := 0; i < chunks; i++ {
+		// ReadAt may return io.EOF together with a full buffer at the end of the data
+		if n, err := r.ReadAt(unpaddedBuf, int64(i)*chunkSize); n != chunkSize {
+			if err == nil || errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			for j := 0; j < 2; j++ {
+				if err := report(&EntryError{Slot: 2*i + j, Kind: EntryReadError, Err: err}); err != nil {
+					return IndexData{}, nil, err
+				}
+			}
+			continue
+		}
+		fr32.Pad(unpaddedBuf, paddedBuf)
+
+		for j := 0; j < 2; j++ {
+			slot := 2*i + j
+			en := &allEntries[slot]
+			_ = en.UnmarshalBinary(paddedBuf[j*EntrySize : (j+1)*EntrySize])
+			if *en == (SegmentDesc{}) {
+				continue
+			}
+			if _, ok := ParseIndexHeader(*en); ok && slot == 0 {
+				continue
+			}
+			if err := en.Validate(); err != nil {
+				if err := report(&EntryError{Slot: slot, Kind: EntryInvalid, Err: err}); err != nil {
+					return IndexData{}, nil, err
+				}
+			}
+		}
+	}
+
+	index, err := indexDataFromArea(allEntries)
+	if err != nil {
+		return IndexData{}, nil, err
+	}
+	return index, entryErrs, nil
+}
@@ -0,0 +1,153 @@
+package datasegment
+
+import (
+	"io"
+	"sync"
+)
+
+// ProgressPhase is the phase of a long-running operation reported through ProgressFunc
+type ProgressPhase int
+
+const (
+	// PhaseHashing is the computation of sub-piece commitments in NewAggregateFromReaders
+	PhaseHashing ProgressPhase = iota
+	// PhaseTreeBuild is the placement of sub-pieces into the deal tree
+	PhaseTreeBuild
+	// PhaseIndexWrite is the writing of the index into the deal tree
+	PhaseIndexWrite
+	// PhaseStreaming is the reading of the aggregate through AggregateObjectReaderWithProgress
+	PhaseStreaming
+)
+
+func (pp ProgressPhase) String() string {
+	switch pp {
+	case PhaseHashing:
+		return "hashing"
+	case PhaseTreeBuild:
+		return "tree build"
+	case PhaseIndexWrite:
+		return "index write"
+	case PhaseStreaming:
+		return "streaming"
+	default:
+		return "unknown"
+	}
+}
+
+// Progress describes the progress of a long-running operation
+type Progress struct {
+	Phase ProgressPhase
+	// BytesProcessed is the number of bytes processed in the current phase,
+	// it is only reported for PhaseHashing and PhaseStreaming
+	BytesProcessed uint64
+	// SegmentsCompleted is the number of sub-pieces completed in the current phase
+	SegmentsCompleted int
+	// SegmentsTotal is the number of sub-pieces
+	SegmentsTotal int
+}
+
+// ProgressFunc receives progress updates. Calls are serialized but can come from
+// different goroutines.
+type ProgressFunc func(Progress)
+
+// WithProgress reports the progress of NewAggregate and NewAggregateFromReaders to the callback
+func WithProgress(progress ProgressFunc) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.progress = progress
+	}
+}
+
+// progressReporter serializes progress updates of an operation
+type progressReporter struct {
+	lk       sync.Mutex
+	progress ProgressFunc
+	state    Progress
+}
+
+func newProgressReporter(progress ProgressFunc, phase ProgressPhase, total int) *progressReporter {
+	return &progressReporter{progress: progress, state: Progress{Phase: phase, SegmentsTotal: total}}
+}
+
+// update applies the function to the state and reports it, nil reporters do nothing
+func (pr *progressReporter) update(f func(*Progress)) {
+	if pr == nil || pr.progress == nil {
+		return
+	}
+	pr.lk.Lock()
+	defer pr.lk.Unlock()
+	f(&pr.state)
+	pr.progress(pr.state)
+}
+
+// phase starts a new phase, resetting the counters
+func (pr *progressReporter) phase(phase ProgressPhase) {
+	pr.update(func(p *Progress) {
+		*p = Progress{Phase: phase, SegmentsTotal: p.SegmentsTotal}
+	})
+}
+
+func (pr *progressReporter) addBytes(n int) {
+	pr.update(func(p *Progress) {
+		p.BytesProcessed += uint64(n)
+	})
+}
+
+func (pr *progressReporter) segmentsCompleted(n int) {
+	pr.update(func(p *Progress) {
+		p.SegmentsCompleted = n
+	})
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	reporter *progressReporter
+}
+
+func (pr progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.reporter.addBytes(n)
+	}
+	return n, err
+}
+
+// AggregateObjectReaderWithProgress is like AggregateObjectReader but reports the progress
+// of reading the returned reader in PhaseStreaming. A sub-piece is completed once all of its
+// bytes were read.
+func (a Aggregate) AggregateObjectReaderWithProgress(subPieceReaders []io.Reader, progress ProgressFunc) (io.Reader, error) {
+	r, err := a.AggregateObjectReader(subPieceReaders)
+	if err != nil {
+		return nil, err
+	}
+	reporter := newProgressReporter(progress, PhaseStreaming, len(a.Index.Entries))
+	return &streamingProgressReader{entries: a.Index.Entries, r: r, reporter: reporter}, nil
+}
+
+type streamingProgressReader struct {
+	entries   []SegmentDesc
+	r         io.Reader
+	reporter  *progressReporter
+	read      uint64
+	completed int
+}
+
+func (spr *streamingProgressReader) Read(b []byte) (int, error) {
+	n, err := spr.r.Read(b)
+	if n == 0 {
+		return n, err
+	}
+	spr.read += uint64(n)
+	for spr.completed < len(spr.entries) {
+		e := spr.entries[spr.completed]
+		if e.UnpaddedOffest()+e.UnpaddedLength() > spr.read {
+			break
+		}
+		spr.completed++
+	}
+	spr.reporter.update(func(p *Progress) {
+		p.BytesProcessed = spr.read
+		p.SegmentsCompleted = spr.completed
+	})
+	return n, err
+}
@@ -0,0 +1,67 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	data := [][]byte{
+		bytes.Repeat([]byte{0x1}, 100<<10),
+		bytes.Repeat([]byte{0x2}, 1000),
+		bytes.Repeat([]byte{0x3}, 300),
+	}
+	sources := make([]PieceSource, len(data))
+	for i, d := range data {
+		sources[i] = PieceSource{Reader: bytes.NewReader(d)}
+	}
+
+	var events []Progress
+	a, _, err := NewAggregateFromReaders(dealSize, sources, WithProgress(func(p Progress) {
+		events = append(events, p)
+	}))
+	require.NoError(t, err)
+
+	lastOfPhase := map[ProgressPhase]Progress{}
+	var phases []ProgressPhase
+	for _, e := range events {
+		if len(phases) == 0 || phases[len(phases)-1] != e.Phase {
+			phases = append(phases, e.Phase)
+		}
+		lastOfPhase[e.Phase] = e
+		assert.Equal(t, len(data), e.SegmentsTotal)
+	}
+	assert.Equal(t, []ProgressPhase{PhaseHashing, PhaseTreeBuild, PhaseIndexWrite}, phases)
+	assert.Equal(t, Progress{Phase: PhaseHashing, BytesProcessed: 100<<10 + 1000 + 300, SegmentsCompleted: 3, SegmentsTotal: 3},
+		lastOfPhase[PhaseHashing])
+	assert.Equal(t, 3, lastOfPhase[PhaseTreeBuild].SegmentsCompleted)
+	assert.Equal(t, 3, lastOfPhase[PhaseIndexWrite].SegmentsCompleted)
+
+	events = nil
+	readers := make([]io.Reader, len(data))
+	for i, d := range data {
+		readers[i] = bytes.NewReader(d)
+	}
+	r, err := a.AggregateObjectReaderWithProgress(readers, func(p Progress) {
+		events = append(events, p)
+	})
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, r)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, events)
+	completed := 0
+	for _, e := range events {
+		assert.Equal(t, PhaseStreaming, e.Phase)
+		assert.GreaterOrEqual(t, e.SegmentsCompleted, completed)
+		completed = e.SegmentsCompleted
+	}
+	assert.Equal(t, Progress{Phase: PhaseStreaming, BytesProcessed: uint64(dealSize.Unpadded()), SegmentsCompleted: 3, SegmentsTotal: 3},
+		events[len(events)-1])
+}
@@ -0,0 +1,77 @@
+package datasegment
+
+import (
+	"bytes"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	xerrors "golang.org/x/xerrors"
+)
+
+// The proofs, InclusionProof and DataAggregationProof with all the structures they contain,
+// are encoded as CBOR tuples: arrays of definite length with the fields in the order of their
+// declaration. There are no maps, floats or indefinite lengths, and cbor-gen writes all heads
+// in their shortest form, so MarshalCBOR produces the canonical encoding. New fields are only
+// ever appended, changing the encoding of existing proofs would change the tuple length.
+
+type encodingError string
+
+// ErrNonCanonicalEncoding is returned by VerifyCanonicalEncoding for encodings which decode
+// successfully but are not the canonical encoding of the decoded value
+var ErrNonCanonicalEncoding = encodingError("unknown")
+
+func (ee encodingError) Error() string {
+	return string(ee)
+}
+
+func (ee encodingError) Is(err error) bool {
+	_, ok := err.(encodingError)
+	return ok
+}
+
+// CanonicalCBOR is a value with a canonical CBOR encoding, e.g. *InclusionProof or
+// *DataAggregationProof
+type CanonicalCBOR interface {
+	cbg.CBORMarshaler
+	cbg.CBORUnmarshaler
+}
+
+// MarshalCanonical returns the canonical encoding of v, as signed or passed on chain
+func MarshalCanonical(v cbg.CBORMarshaler) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := v.MarshalCBOR(&buf); err != nil {
+		return nil, xerrors.Errorf("encoding: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// VerifyCanonicalEncoding decodes data into v and checks that data is exactly the canonical
+// encoding of the decoded value, without trailing bytes. Encodings which decode to the same
+// value but differ in bytes, e.g. in the length of CBOR heads, fail with ErrNonCanonicalEncoding.
+func VerifyCanonicalEncoding(data []byte, v CanonicalCBOR) error {
+	r := bytes.NewReader(data)
+	if err := v.UnmarshalCBOR(r); err != nil {
+		return xerrors.Errorf("decoding: %w", err)
+	}
+	if r.Len() != 0 {
+		return xerrors.Errorf("%w: %d trailing bytes", encodingError("trailing data"), r.Len())
+	}
+	canonical, err := MarshalCanonical(v)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, data) {
+		return xerrors.Errorf("%w: re-encoding differs at byte %d", encodingError("non-canonical encoding"),
+			firstDifference(canonical, data))
+	}
+	return nil
+}
+
+// firstDifference returns the position of the first byte differing between a and b
+func firstDifference(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return min(len(a), len(b))
+}
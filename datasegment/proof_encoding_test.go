@@ -0,0 +1,52 @@
+package datasegment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProofEncodingIsStable(t *testing.T) {
+	_, ip, _ := InclusionGolden1()
+	dap := DataAggregationProof{Inclusion: ip, AuxDataSource: SingletonMarketSource{DealID: 1234}}
+	encoded, err := dap.MarshalForChain()
+	require.NoError(t, err)
+
+	// changing the encoding of existing proofs breaks signatures and on-chain verifiers
+	digest := sha256.Sum256(encoded)
+	assert.Equal(t, "2cefcf7c2180364eed78a0e490076ad8119c515a4b7ee4f2b2841fe9a5abc5f9", hex.EncodeToString(digest[:]))
+	assert.Len(t, encoded, 1208)
+
+	var decoded DataAggregationProof
+	require.NoError(t, VerifyCanonicalEncoding(encoded, &decoded))
+	assert.Equal(t, dap, decoded)
+
+	inclusion, err := MarshalCanonical(&ip)
+	require.NoError(t, err)
+	var decodedInclusion InclusionProof
+	assert.NoError(t, VerifyCanonicalEncoding(inclusion, &decodedInclusion))
+}
+
+func TestVerifyCanonicalEncodingRejects(t *testing.T) {
+	_, ip, _ := InclusionGolden1()
+	dap := DataAggregationProof{Inclusion: ip, AuxDataSource: SingletonMarketSource{DealID: 1234}}
+	encoded := Must(dap.MarshalForChain())
+	// the encoding ends with AuxDataType 0 and the tuple of the DealID 1234
+	require.Equal(t, []byte{0x00, 0x81, 0x19, 0x04, 0xd2}, encoded[len(encoded)-5:])
+	prefix := encoded[:len(encoded)-5]
+
+	var decoded DataAggregationProof
+	trailing := append(append([]byte(nil), encoded...), 0x00)
+	assert.ErrorIs(t, VerifyCanonicalEncoding(trailing, &decoded), ErrNonCanonicalEncoding)
+
+	// heads longer than needed are rejected while decoding
+	longHead := append(append([]byte(nil), prefix...), 0x18, 0x00, 0x81, 0x19, 0x04, 0xd2)
+	assert.Error(t, VerifyCanonicalEncoding(longHead, &decoded))
+	longDealID := append(append([]byte(nil), prefix...), 0x00, 0x81, 0x1a, 0x00, 0x00, 0x04, 0xd2)
+	assert.Error(t, VerifyCanonicalEncoding(longDealID, &decoded))
+
+	assert.Error(t, VerifyCanonicalEncoding(encoded[:len(encoded)-1], &decoded))
+}
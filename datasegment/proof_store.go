@@ -0,0 +1,116 @@
+package datasegment
+
+import (
+	"container/list"
+	"sync"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+)
+
+// ProofKey identifies a proof of a piece occurring once within a deal
+type ProofKey struct {
+	DealCID   cid.Cid
+	PieceCID  cid.Cid
+	PieceSize abi.PaddedPieceSize
+}
+
+// ProofStore caches inclusion proofs, see WithProofStore.
+// Implementations have to be safe for concurrent use, failures of persistent implementations
+// should be reported as misses by Get.
+type ProofStore interface {
+	Get(key ProofKey) (*InclusionProof, bool)
+	Put(key ProofKey, proof *InclusionProof)
+}
+
+// WithProofStore causes Aggregate.ProofForPieceInfo to consult the store before collecting
+// the proof from the tree and to put collected proofs into it. The store is kept in
+// Aggregate.ProofStore. As the keys include the deal's PieceCID, one store can be shared
+// between Aggregates.
+func WithProofStore(store ProofStore) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.proofStore = store
+	}
+}
+
+// cachedProofForPieceInfo is ProofForPieceInfo going through the ProofStore of the Aggregate
+func (a Aggregate) cachedProofForPieceInfo(d abi.PieceInfo) (*InclusionProof, error) {
+	dealCID, err := a.PieceCID()
+	if err != nil {
+		return nil, err
+	}
+	key := ProofKey{DealCID: dealCID, PieceCID: d.PieceCID, PieceSize: d.Size}
+	if ip, ok := a.ProofStore.Get(key); ok {
+		return ip, nil
+	}
+	ip, err := a.uniqueProofForPieceInfo(d)
+	if err != nil {
+		return nil, err
+	}
+	a.ProofStore.Put(key, ip)
+	return ip, nil
+}
+
+// LRUProofStore is an in-memory ProofStore keeping a limited number of the most recently
+// used proofs
+type LRUProofStore struct {
+	lk      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[ProofKey]*list.Element
+}
+
+type lruProofEntry struct {
+	key   ProofKey
+	proof *InclusionProof
+}
+
+var _ ProofStore = (*LRUProofStore)(nil)
+
+// NewLRUProofStore creates an LRUProofStore holding up to size proofs
+func NewLRUProofStore(size int) *LRUProofStore {
+	return &LRUProofStore{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[ProofKey]*list.Element),
+	}
+}
+
+// Get returns the proof for the key if present
+func (s *LRUProofStore) Get(key ProofKey) (*InclusionProof, bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruProofEntry).proof, true
+}
+
+// Put stores the proof, evicting the least recently used one if the store is full
+func (s *LRUProofStore) Put(key ProofKey, proof *InclusionProof) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*lruProofEntry).proof = proof
+		s.order.MoveToFront(el)
+		return
+	}
+	if s.size <= 0 {
+		return
+	}
+	if s.order.Len() >= s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruProofEntry).key)
+	}
+	s.entries[key] = s.order.PushFront(&lruProofEntry{key: key, proof: proof})
+}
+
+// Len returns the number of proofs in the store
+func (s *LRUProofStore) Len() int {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.order.Len()
+}
@@ -0,0 +1,75 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingProofStore struct {
+	ProofStore
+	gets, hits int
+}
+
+func (s *countingProofStore) Get(key ProofKey) (*InclusionProof, bool) {
+	s.gets++
+	ip, ok := s.ProofStore.Get(key)
+	if ok {
+		s.hits++
+	}
+	return ip, ok
+}
+
+func TestProofStore(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 1 << 10},
+		{PieceCID: cidForDeal(1), Size: 1 << 12},
+	}
+	store := &countingProofStore{ProofStore: NewLRUProofStore(10)}
+	a, err := NewAggregate(1<<20, pieces, WithProofStore(store))
+	require.NoError(t, err)
+
+	ip1, err := a.ProofForPieceInfo(pieces[1])
+	require.NoError(t, err)
+	ip2, err := a.ProofForPieceInfo(pieces[1])
+	require.NoError(t, err)
+	assert.Same(t, ip1, ip2)
+	assert.Equal(t, 2, store.gets)
+	assert.Equal(t, 1, store.hits)
+
+	uncached, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+	expected, err := uncached.ProofForPieceInfo(pieces[1])
+	require.NoError(t, err)
+	assert.Equal(t, expected, ip1)
+
+	// the size is part of the key
+	_, err = a.ProofForPieceInfo(abi.PieceInfo{PieceCID: pieces[1].PieceCID, Size: 1 << 11})
+	assert.Error(t, err)
+}
+
+func TestLRUProofStore(t *testing.T) {
+	s := NewLRUProofStore(2)
+	key := func(i int) ProofKey {
+		return ProofKey{DealCID: cidForDeal(100), PieceCID: cidForDeal(i), PieceSize: 128}
+	}
+	proofs := []*InclusionProof{{}, {}, {}}
+	s.Put(key(0), proofs[0])
+	s.Put(key(1), proofs[1])
+	_, ok := s.Get(key(0))
+	assert.True(t, ok)
+	// key 1 is the least recently used
+	s.Put(key(2), proofs[2])
+	assert.Equal(t, 2, s.Len())
+	_, ok = s.Get(key(1))
+	assert.False(t, ok)
+	ip, ok := s.Get(key(0))
+	assert.True(t, ok)
+	assert.Same(t, proofs[0], ip)
+
+	empty := NewLRUProofStore(0)
+	empty.Put(key(0), proofs[0])
+	assert.Equal(t, 0, empty.Len())
+}
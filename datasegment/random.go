@@ -0,0 +1,98 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+
+	"github.com/filecoin-project/go-data-segment/util"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// maxRandomPieceSize caps the size of generated pieces to keep CommP computation cheap
+const maxRandomPieceSize = 8 << 20
+
+// RandomPiece is a sub-piece generated by GenerateRandomAggregate
+type RandomPiece struct {
+	PieceInfo abi.PieceInfo
+	// Data is the unpadded content of the piece, it can be shorter than the unpadded piece size
+	// in which case the rest of the piece is zero
+	Data []byte
+}
+
+// Reader returns a reader of the unpadded content of the piece
+func (rp RandomPiece) Reader() io.Reader {
+	return bytes.NewReader(rp.Data)
+}
+
+// GenerateRandomAggregate generates n pseudo-random sub-pieces, deterministically based on the seed,
+// and creates an Aggregate of dealSize containing them.
+// Sub-pieces are either zero-filled or contain pseudo-random content, their PieceCIDs are
+// computed from the content.
+func GenerateRandomAggregate(seed int64, dealSize abi.PaddedPieceSize, n int) (*Aggregate, []RandomPiece, error) {
+	return GenerateRandomAggregateFrom(rand.New(rand.NewSource(seed)), dealSize, n)
+}
+
+// GenerateRandomAggregateFrom is GenerateRandomAggregate drawing from the given source of
+// randomness, the result is a function of the state of rng only.
+func GenerateRandomAggregateFrom(rng *rand.Rand, dealSize abi.PaddedPieceSize, n int) (*Aggregate, []RandomPiece, error) {
+	if rng == nil {
+		return nil, nil, xerrors.Errorf("rng cannot be nil")
+	}
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if n <= 0 || uint(n) > MaxIndexEntriesInDeal(dealSize) {
+		return nil, nil, xerrors.Errorf("number of pieces has to be between 1 and %d: %d",
+			MaxIndexEntriesInDeal(dealSize), n)
+	}
+
+	// the alignment of pieces can at most double the space they take up
+	available := IndexAreaStartPadded(dealSize) / uint64(n) / 2
+	if available < 128 {
+		return nil, nil, xerrors.Errorf("%d pieces don't fit in a %d sized deal", n, dealSize)
+	}
+	maxLog2 := util.Log2Floor(available)
+	if maxLog2 > util.Log2Floor(maxRandomPieceSize) {
+		maxLog2 = util.Log2Floor(maxRandomPieceSize)
+	}
+
+	pieces := make([]RandomPiece, n)
+	pieceInfos := make([]abi.PieceInfo, n)
+	for i := range pieces {
+		size := abi.PaddedPieceSize(1) << (7 + rng.Intn(maxLog2-7+1))
+		data := make([]byte, 1+rng.Intn(int(size.Unpadded())))
+		if rng.Intn(2) == 1 {
+			rng.Read(data)
+		}
+
+		cp := &commp.Calc{}
+		cp.Write(data)
+		if len(data) < int(size.Unpadded()) {
+			cp.Write(make([]byte, int(size.Unpadded())-len(data)))
+		}
+		comm, paddedSize, err := cp.Digest()
+		if err != nil {
+			return nil, nil, xerrors.Errorf("computing commP of piece %d: %w", i, err)
+		}
+		if paddedSize != uint64(size) {
+			return nil, nil, xerrors.Errorf("unexpected size of piece %d: %d != %d", i, paddedSize, size)
+		}
+		c, err := commcid.PieceCommitmentV1ToCID(comm)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("converting commP of piece %d: %w", i, err)
+		}
+
+		pieceInfos[i] = abi.PieceInfo{PieceCID: c, Size: size}
+		pieces[i] = RandomPiece{PieceInfo: pieceInfos[i], Data: data}
+	}
+
+	a, err := NewAggregate(dealSize, pieceInfos)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("creating aggregate: %w", err)
+	}
+	return a, pieces, nil
+}
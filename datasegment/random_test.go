@@ -0,0 +1,94 @@
+package datasegment
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomAggregateProperties(t *testing.T) {
+	tests := []struct {
+		dealSize abi.PaddedPieceSize
+		n        int
+	}{
+		{dealSize: 1 << 20, n: 1},
+		{dealSize: 1 << 20, n: 8},
+		{dealSize: 8 << 20, n: 30},
+		{dealSize: 32 << 20, n: 5},
+	}
+	for _, tc := range tests {
+		for seed := int64(0); seed < 3; seed++ {
+			tc, seed := tc, seed
+			t.Run(fmt.Sprintf("%d-%d-%d", tc.dealSize, tc.n, seed), func(t *testing.T) {
+				a, pieces, err := GenerateRandomAggregate(seed, tc.dealSize, tc.n)
+				require.NoError(t, err)
+				require.Len(t, pieces, tc.n)
+
+				a2, pieces2, err := GenerateRandomAggregate(seed, tc.dealSize, tc.n)
+				require.NoError(t, err)
+				assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+				assert.Equal(t, pieces, pieces2)
+				require.NoError(t, a.VerifyIndexConsistency())
+
+				for i, p := range pieces {
+					// zero-filled pieces of the same size are duplicates, the instance is selected by offset
+					ip, err := a.ProofForPieceInfoAtOffset(p.PieceInfo, a.Index.Entries[i].UnpaddedOffest())
+					require.NoError(t, err)
+					aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(p.PieceInfo))
+					require.NoError(t, err)
+					assert.Equal(t, InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: a.DealSize}, *aux)
+				}
+
+				readers := make([]io.Reader, len(pieces))
+				for i, p := range pieces {
+					readers[i] = p.Reader()
+				}
+				objectReader, err := a.AggregateObjectReader(readers)
+				require.NoError(t, err)
+				deal, err := io.ReadAll(objectReader)
+				require.NoError(t, err)
+				require.Len(t, deal, int(tc.dealSize.Unpadded()))
+
+				parsed, err := ParseDataSegmentIndex(bytes.NewReader(deal[DataSegmentIndexStartOffset(tc.dealSize):]))
+				require.NoError(t, err)
+				assert.Equal(t, a.Index.Entries, Must(parsed.ValidEntries()))
+
+				for i, e := range a.Index.Entries {
+					segment := deal[e.UnpaddedOffest() : e.UnpaddedOffest()+e.UnpaddedLength()]
+					assert.Equal(t, pieces[i].Data, segment[:len(pieces[i].Data)])
+					assert.Equal(t, make([]byte, len(segment)-len(pieces[i].Data)), segment[len(pieces[i].Data):])
+				}
+			})
+		}
+	}
+
+	_, _, err := GenerateRandomAggregate(0, 1<<20, 0)
+	assert.Error(t, err)
+}
+
+func TestGenerateRandomAggregateFrom(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(7, 1<<20, 4)
+	require.NoError(t, err)
+	a2, pieces2, err := GenerateRandomAggregateFrom(rand.New(rand.NewSource(7)), 1<<20, 4)
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), Must(a2.PieceCID()))
+	assert.Equal(t, pieces, pieces2)
+
+	// the source is advanced, so consecutive aggregates differ
+	rng := rand.New(rand.NewSource(7))
+	a3, _, err := GenerateRandomAggregateFrom(rng, 1<<20, 4)
+	require.NoError(t, err)
+	a4, _, err := GenerateRandomAggregateFrom(rng, 1<<20, 4)
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.PieceCID()), Must(a3.PieceCID()))
+	assert.NotEqual(t, Must(a3.PieceCID()), Must(a4.PieceCID()))
+
+	_, _, err = GenerateRandomAggregateFrom(nil, 1<<20, 4)
+	assert.Error(t, err)
+}
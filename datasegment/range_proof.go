@@ -0,0 +1,208 @@
+package datasegment
+
+import (
+	"math/bits"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// RangeProofKind is the kind of answer given by a RangeOwnershipProof
+type RangeProofKind int
+
+const (
+	// RangeInSegment proves that the range lies within a single segment
+	RangeInSegment RangeProofKind = iota
+	// RangeInPadding proves that the range consists only of zero padding
+	RangeInPadding
+)
+
+func (k RangeProofKind) String() string {
+	switch k {
+	case RangeInSegment:
+		return "segment"
+	case RangeInPadding:
+		return "padding"
+	default:
+		return "unknown"
+	}
+}
+
+// ZeroSubtreeProof proves that the subtree at Level, located by Proof.Index, is a zero subtree
+type ZeroSubtreeProof struct {
+	Level int
+	Proof merkletree.ProofData
+}
+
+// location returns the location of the proven subtree
+func (zp ZeroSubtreeProof) location() merkletree.Location {
+	return merkletree.Location{Level: zp.Level, Index: zp.Proof.Index}
+}
+
+// RangeOwnershipProof answers which part of the deal an unpadded byte range belongs to
+type RangeOwnershipProof struct {
+	Kind RangeProofKind
+	// Segment and Inclusion are set for RangeInSegment
+	Segment   abi.PieceInfo
+	Inclusion *InclusionProof
+	// Zero is set for RangeInPadding, the subtrees are ordered by their offset
+	Zero []ZeroSubtreeProof
+}
+
+// paddedRange returns the padded byte range [start, end) of the Fr32 chunks holding
+// the unpadded byte range
+func paddedRange(start, length uint64, dealSize abi.PaddedPieceSize) (uint64, uint64, error) {
+	if length == 0 {
+		return 0, 0, xerrors.Errorf("range cannot be empty")
+	}
+	end, ok := util.CheckedAdd(start, length)
+	if !ok || end > uint64(dealSize.Unpadded()) {
+		return 0, 0, xerrors.Errorf("range [%d, %d+%d) is outside of the deal of %d unpadded bytes",
+			start, start, length, dealSize.Unpadded())
+	}
+	const chunk = 127
+	return start / chunk * 128, (end + chunk - 1) / chunk * 128, nil
+}
+
+// zeroCover returns the minimal list of aligned subtrees covering the padded range [start, end)
+func zeroCover(start, end uint64) []merkletree.Location {
+	var res []merkletree.Location
+	pos, last := start/merkletree.NodeSize, end/merkletree.NodeSize
+	for pos < last {
+		level := bits.TrailingZeros64(pos)
+		if pos == 0 {
+			level = 63
+		}
+		for level > 0 && pos+uint64(1)<<level > last {
+			level--
+		}
+		res = append(res, merkletree.Location{Level: level, Index: pos >> level})
+		pos += uint64(1) << level
+	}
+	return res
+}
+
+// ProveRangeOwnership proves where the range of length unpadded bytes starting at start,
+// as produced by AggregateObjectReader, lies within the deal. If the range is contained in
+// a single segment the proof is an inclusion proof of that segment, if it consists only of
+// padding the proof shows that the subtrees covering the range are zero.
+// Ranges spanning multiple segments, segments and padding, or the index area are rejected.
+func (a Aggregate) ProveRangeOwnership(start, length uint64) (*RangeOwnershipProof, error) {
+	ps, pe, err := paddedRange(start, length, a.DealSize)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, e := range a.Index.Entries {
+		if e.Offset <= ps && pe <= util.SaturatingAdd(e.Offset, e.Size) {
+			ip, err := a.ProofForIndexEntry(i)
+			if err != nil {
+				return nil, xerrors.Errorf("proving segment %d: %w", i, err)
+			}
+			c, err := e.PieceCIDErr()
+			if err != nil {
+				return nil, xerrors.Errorf("segment %d: %w", i, err)
+			}
+			pi := abi.PieceInfo{PieceCID: c, Size: e.PaddedSize()}
+			return &RangeOwnershipProof{Kind: RangeInSegment, Segment: pi, Inclusion: ip}, nil
+		}
+	}
+	// the tree holds only the roots of the segments, nodes below them read as zero
+	for i, e := range a.Index.Entries {
+		if end := util.SaturatingAdd(e.Offset, e.Size); e.Offset < pe && ps < end {
+			return nil, xerrors.Errorf("range [%d, %d) partially overlaps segment %d at [%d, %d)",
+				ps, pe, i, e.Offset, end)
+		}
+	}
+
+	cover := zeroCover(ps, pe)
+	zps := make([]ZeroSubtreeProof, len(cover))
+	for i, l := range cover {
+		n, err := a.Tree.GetNode(l.Level, l.Index)
+		if err != nil {
+			return nil, xerrors.Errorf("getting node: %w", err)
+		}
+		if n != merkletree.ZeroCommitmentForLevel(l.Level) {
+			return nil, xerrors.Errorf("range is neither within a single segment nor padding: "+
+				"non-zero data at offset %d", l.ByteOffset())
+		}
+		p, err := a.Tree.CollectProof(l.Level, l.Index)
+		if err != nil {
+			return nil, xerrors.Errorf("collecting proof: %w", err)
+		}
+		zps[i] = ZeroSubtreeProof{Level: l.Level, Proof: p}
+	}
+	return &RangeOwnershipProof{Kind: RangeInPadding, Zero: zps}, nil
+}
+
+// Verify checks that the proof answers for the unpadded byte range within the deal
+// described by aux
+func (rp RangeOwnershipProof) Verify(start, length uint64, aux InclusionAuxData) error {
+	if err := ValidateDealSize(aux.SizePa); err != nil {
+		return xerrors.Errorf("invalid aux data: %w", err)
+	}
+	ps, pe, err := paddedRange(start, length, aux.SizePa)
+	if err != nil {
+		return err
+	}
+
+	switch rp.Kind {
+	case RangeInSegment:
+		if rp.Inclusion == nil {
+			return xerrors.Errorf("missing inclusion proof")
+		}
+		computed, err := rp.Inclusion.ComputeExpectedAuxData(VerifierDataForPieceInfo(rp.Segment))
+		if err != nil {
+			return xerrors.Errorf("verifying inclusion proof: %w", err)
+		}
+		if *computed != aux {
+			return xerrors.Errorf("inclusion proof is for a different deal")
+		}
+		offset, ok := util.CheckedMultiply(rp.Inclusion.ProofSubtree.Index, uint64(rp.Segment.Size))
+		if !ok {
+			return xerrors.Errorf("segment offset overflows")
+		}
+		end := util.SaturatingAdd(offset, uint64(rp.Segment.Size))
+		if ps < offset || end < pe {
+			return xerrors.Errorf("range [%d, %d) is not within the segment at [%d, %d)",
+				ps, pe, offset, end)
+		}
+		return nil
+	case RangeInPadding:
+		commPa, err := lightCid2CommP(aux.CommPa)
+		if err != nil {
+			return xerrors.Errorf("invalid aux data commitment: %w", err)
+		}
+		root := merkletree.Node(commPa)
+		treeLevels := util.Log2Ceil(uint64(aux.SizePa) / merkletree.NodeSize)
+		// a minimal cover has at most two subtrees per level
+		if len(rp.Zero) > 2*treeLevels {
+			return xerrors.Errorf("too many zero proofs: %d > %d", len(rp.Zero), 2*treeLevels)
+		}
+		covered := ps
+		for i, zp := range rp.Zero {
+			if zp.Level < 0 || zp.Level+zp.Proof.Depth() != treeLevels {
+				return xerrors.Errorf("zero proof %d doesn't match the size of the deal", i)
+			}
+			l := zp.location()
+			if l.ByteOffset() > covered {
+				return xerrors.Errorf("zero proofs leave a gap at offset %d", covered)
+			}
+			zero := merkletree.ZeroCommitmentForLevel(zp.Level)
+			if err := zp.Proof.ValidateSubtree(&zero, &root); err != nil {
+				return xerrors.Errorf("zero proof %d: %w", i, err)
+			}
+			if e := l.ByteOffset() + l.Size(); e > covered {
+				covered = e
+			}
+		}
+		if covered < pe {
+			return xerrors.Errorf("zero proofs cover the range only up to offset %d < %d", covered, pe)
+		}
+		return nil
+	default:
+		return xerrors.Errorf("unknown range proof kind: %d", rp.Kind)
+	}
+}
@@ -0,0 +1,66 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroCover(t *testing.T) {
+	cover := zeroCover(128, 1024)
+	var covered uint64 = 128
+	for _, l := range cover {
+		assert.Equal(t, covered, l.ByteOffset())
+		covered += l.Size()
+	}
+	assert.Equal(t, uint64(1024), covered)
+	assert.Len(t, cover, 3)
+}
+
+func TestProveRangeOwnership(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 1024},
+		{PieceCID: cidForDeal(1), Size: 4096},
+	}
+	a, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+	aux := InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: a.DealSize}
+
+	// the second piece is placed at 4096
+	p, err := a.ProveRangeOwnership(4096/128*127+10, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, RangeInSegment, p.Kind)
+	assert.Equal(t, pieces[1], p.Segment)
+	assert.NoError(t, p.Verify(4096/128*127+10, 1000, aux))
+	assert.Error(t, p.Verify(10, 1000, aux), "range outside of the proven segment")
+
+	// between the end of the first piece and the second piece
+	p, err = a.ProveRangeOwnership(1024/128*127, 3072/128*127)
+	require.NoError(t, err)
+	assert.Equal(t, RangeInPadding, p.Kind)
+	assert.NoError(t, p.Verify(1024/128*127, 3072/128*127, aux))
+	assert.Error(t, p.Verify(1024/128*127, 3072/128*127+1, aux), "range not fully covered")
+	assert.Error(t, p.Verify(0, 100, aux), "range not covered")
+
+	truncated := *p
+	truncated.Zero = p.Zero[1:]
+	assert.Error(t, truncated.Verify(1024/128*127, 3072/128*127, aux))
+
+	otherAux := aux
+	otherAux.CommPa = cidForDeal(5)
+	assert.Error(t, p.Verify(1024/128*127, 3072/128*127, otherAux))
+
+	// spans the first piece and padding
+	_, err = a.ProveRangeOwnership(1000, 1000)
+	assert.Error(t, err)
+	// within the index area
+	_, err = a.ProveRangeOwnership(IndexAreaStartPadded(a.DealSize)/128*127, 10)
+	assert.Error(t, err)
+	// outside of the deal
+	_, err = a.ProveRangeOwnership(uint64(a.DealSize.Unpadded()), 1)
+	assert.Error(t, err)
+	_, err = a.ProveRangeOwnership(0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// PaddedSizeForRaw returns the smallest padded piece size which can hold rawSize bytes of data
+// after Fr32 padding, the minimum being 128. It returns zero if no padded piece size can hold
+// that much data.
+func PaddedSizeForRaw(rawSize uint64) abi.PaddedPieceSize {
+	chunks := (rawSize + 126) / 127
+	if chunks > 1<<56 {
+		return 0
+	}
+	if chunks == 0 {
+		chunks = 1
+	}
+	padded, err := util.CeilPow2(chunks * 128)
+	if err != nil {
+		return 0
+	}
+	return abi.PaddedPieceSize(padded)
+}
+
+// WithRawSizes supplies the raw, unpadded sizes of the subdeals, e.g. the sizes of CAR files,
+// in the order of the subdeals. The padded size of each subdeal is computed with PaddedSizeForRaw
+// if its Size is zero, otherwise it is checked that the raw size fits in it.
+// The raw sizes are recorded in Aggregate.RawSizes, they are not part of the index.
+func WithRawSizes(rawSizes []uint64) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.rawSizes = rawSizes
+	}
+}
+
+// applyRawSizes returns the subdeals with the padded sizes derived from the raw sizes
+func applyRawSizes(subdeals []abi.PieceInfo, rawSizes []uint64) ([]abi.PieceInfo, error) {
+	if len(rawSizes) != len(subdeals) {
+		return nil, xerrors.Errorf("number of raw sizes doesn't match number of subdeals: %d != %d",
+			len(rawSizes), len(subdeals))
+	}
+	res := make([]abi.PieceInfo, len(subdeals))
+	for i, sd := range subdeals {
+		padded := PaddedSizeForRaw(rawSizes[i])
+		if padded == 0 {
+			return nil, xerrors.Errorf("subdeal %d: raw size too large: %d", i, rawSizes[i])
+		}
+		switch {
+		case sd.Size == 0:
+			sd.Size = padded
+		case uint64(sd.Size.Unpadded()) < rawSizes[i]:
+			return nil, xerrors.Errorf("subdeal %d: raw size doesn't fit in the padded size: %d > %d",
+				i, rawSizes[i], sd.Size.Unpadded())
+		}
+		res[i] = sd
+	}
+	return res, nil
+}
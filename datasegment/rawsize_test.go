@@ -0,0 +1,51 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaddedSizeForRaw(t *testing.T) {
+	testCases := []struct {
+		raw    uint64
+		padded abi.PaddedPieceSize
+	}{
+		{0, 128},
+		{1, 128},
+		{127, 128},
+		{128, 256},
+		{254, 256},
+		{255, 512},
+		{1016, 1024},
+		{1017, 2048},
+		{uint64(abi.PaddedPieceSize(32 << 30).Unpadded()), 32 << 30},
+		{uint64(abi.PaddedPieceSize(32<<30).Unpadded()) + 1, 64 << 30},
+		{1 << 63, 0},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.padded, PaddedSizeForRaw(tc.raw), "raw size %d", tc.raw)
+	}
+}
+
+func TestNewAggregateWithRawSizes(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0)},
+		{PieceCID: cidForDeal(1), Size: 1 << 12},
+	}
+	rawSizes := []uint64{1017, 3000}
+	a, err := NewAggregate(1<<20, pieces, WithRawSizes(rawSizes))
+	require.NoError(t, err)
+	assert.Equal(t, rawSizes, a.RawSizes)
+	assert.Equal(t, abi.PaddedPieceSize(2048), a.Index.Entries[0].PaddedSize())
+	assert.Equal(t, abi.PaddedPieceSize(1<<12), a.Index.Entries[1].PaddedSize())
+	// subdeals passed in are not modified
+	assert.Equal(t, abi.PaddedPieceSize(0), pieces[0].Size)
+
+	_, err = NewAggregate(1<<20, pieces, WithRawSizes([]uint64{1017, 5000}))
+	assert.ErrorContains(t, err, "doesn't fit")
+	_, err = NewAggregate(1<<20, pieces, WithRawSizes([]uint64{1017}))
+	assert.Error(t, err)
+}
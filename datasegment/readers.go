@@ -0,0 +1,135 @@
+package datasegment
+
+import (
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// PieceSource is the unpadded content of a sub-piece used by NewAggregateFromReaders
+type PieceSource struct {
+	Reader io.Reader
+	// Size is the padded size of the sub-piece, zero selects the smallest size fitting the content
+	Size abi.PaddedPieceSize
+}
+
+// TeeHasher creates a hash which NewAggregateFromReaders computes over the unpadded content of
+// each source in the same pass as its commP, e.g. a constructor of a blake3 hash.
+type TeeHasher func() hash.Hash
+
+// WithTeeHasher causes NewAggregateFromReaders to compute a secondary digest of the content of
+// each source with hashers created by newHash. The digests are kept out-of-band of the index,
+// in Aggregate.ContentDigests. The digest covers only the content read from the source,
+// not the zero padding up to the size of the sub-piece.
+func WithTeeHasher(newHash TeeHasher) AggregateOption {
+	return func(o *aggregateOptions) {
+		o.teeHasher = newHash
+	}
+}
+
+// NewAggregateFromReaders computes the PieceInfo of each source, hashing the sources in parallel,
+// and creates an Aggregate of them with NewAggregate.
+// The computed PieceInfos are returned in the order of the sources.
+func NewAggregateFromReaders(dealSize abi.PaddedPieceSize, sources []PieceSource, opts ...AggregateOption) (*Aggregate, []abi.PieceInfo, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	var reporter *progressReporter
+	if options.progress != nil {
+		reporter = newProgressReporter(options.progress, PhaseHashing, len(sources))
+		reporter.phase(PhaseHashing)
+	}
+
+	pieceInfos := make([]abi.PieceInfo, len(sources))
+	errs := make([]error, len(sources))
+	var digests [][]byte
+	if options.teeHasher != nil {
+		digests = make([][]byte, len(sources))
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i := range sources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			src := sources[i]
+			if reporter != nil {
+				src.Reader = progressReader{r: src.Reader, reporter: reporter}
+			}
+			var h hash.Hash
+			if options.teeHasher != nil {
+				h = options.teeHasher()
+			}
+			pieceInfos[i], errs[i] = pieceInfoForSource(src, h)
+			if h != nil && errs[i] == nil {
+				digests[i] = h.Sum(nil)
+			}
+			reporter.update(func(p *Progress) {
+				p.SegmentsCompleted++
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, nil, xerrors.Errorf("computing PieceInfo of source %d: %w", i, err)
+		}
+	}
+
+	a, err := NewAggregate(dealSize, pieceInfos, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.ContentDigests = digests
+	return a, pieceInfos, nil
+}
+
+// pieceInfoForSource computes the PieceInfo of the source, writing the content also to
+// the tee hash if it is not nil
+func pieceInfoForSource(ps PieceSource, tee hash.Hash) (abi.PieceInfo, error) {
+	cp := &commp.Calc{}
+	var w io.Writer = cp
+	if tee != nil {
+		w = io.MultiWriter(cp, tee)
+	}
+	if _, err := io.CopyBuffer(w, ps.Reader, make([]byte, cp.BlockSize()*128)); err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("reading source: %w", err)
+	}
+	comm, paddedSize, err := cp.Digest()
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("computing commP: %w", err)
+	}
+
+	if ps.Size != 0 {
+		if err := ps.Size.Validate(); err != nil {
+			return abi.PieceInfo{}, xerrors.Errorf("invalid size: %w", err)
+		}
+		if uint64(ps.Size) < paddedSize {
+			return abi.PieceInfo{}, xerrors.Errorf("content doesn't fit in the size: %d > %d", paddedSize, ps.Size)
+		}
+		if uint64(ps.Size) > paddedSize {
+			comm, err = commp.PadCommP(comm, paddedSize, uint64(ps.Size))
+			if err != nil {
+				return abi.PieceInfo{}, xerrors.Errorf("padding commP: %w", err)
+			}
+			paddedSize = uint64(ps.Size)
+		}
+	}
+
+	c, err := commcid.PieceCommitmentV1ToCID(comm)
+	if err != nil {
+		return abi.PieceInfo{}, xerrors.Errorf("converting commP: %w", err)
+	}
+	return abi.PieceInfo{PieceCID: c, Size: abi.PaddedPieceSize(paddedSize)}, nil
+}
@@ -0,0 +1,83 @@
+package datasegment
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAggregateFromReaders(t *testing.T) {
+	p0, err := os.Open("testdata/sample_aggregate/cat.png.car")
+	require.NoError(t, err)
+	defer p0.Close()
+	p1, err := os.Open("testdata/sample_aggregate/Verifiable Data Aggregation.png.car")
+	require.NoError(t, err)
+	defer p1.Close()
+
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, pieceInfos, err := NewAggregateFromReaders(dealSize, []PieceSource{{Reader: p0}, {Reader: p1}})
+	require.NoError(t, err)
+	assert.Equal(t, []abi.PieceInfo{
+		{
+			PieceCID: cid.MustParse("baga6ea4seaqae5ysjdbsr4b5jhotaz5ooh62jrrdbxwygfpkkfjz44kvywycmgy"),
+			Size:     abi.UnpaddedPieceSize(520192).Padded(),
+		},
+		{
+			PieceCID: cid.MustParse("baga6ea4seaqnrm2n2g4m23t6rs26obxjw2tjtr7tcho24gepj2naqhevytduyoa"),
+			Size:     abi.UnpaddedPieceSize(260096).Padded(),
+		},
+	}, pieceInfos)
+	assert.Equal(t, cid.MustParse("baga6ea4seaqnqkeoqevjjjfe46wo2lpfclcbmkyms4wkz5srou3vzmr3w3c72bq"), Must(a.PieceCID()))
+
+	data := bytes.Repeat([]byte{0x1}, 100)
+	_, small, err := NewAggregateFromReaders(dealSize, []PieceSource{{Reader: bytes.NewReader(data)}})
+	require.NoError(t, err)
+	assert.Equal(t, abi.PaddedPieceSize(128), small[0].Size)
+
+	// padding to a larger size is the same as zero-filling the content
+	_, padded, err := NewAggregateFromReaders(dealSize, []PieceSource{{Reader: bytes.NewReader(data), Size: 1024}})
+	require.NoError(t, err)
+	zeroFilled := io.MultiReader(bytes.NewReader(data), bytes.NewReader(make([]byte, 1016-len(data))))
+	_, expected, err := NewAggregateFromReaders(dealSize, []PieceSource{{Reader: zeroFilled}})
+	require.NoError(t, err)
+	assert.Equal(t, expected, padded)
+
+	_, _, err = NewAggregateFromReaders(dealSize, []PieceSource{{Reader: bytes.NewReader(make([]byte, 200)), Size: 128}})
+	assert.ErrorContains(t, err, "doesn't fit")
+	_, _, err = NewAggregateFromReaders(dealSize, []PieceSource{{Reader: bytes.NewReader(make([]byte, 10))}})
+	assert.Error(t, err)
+}
+
+func TestNewAggregateFromReadersWithTeeHasher(t *testing.T) {
+	contents := [][]byte{
+		bytes.Repeat([]byte{0x1}, 1000),
+		bytes.Repeat([]byte{0x2}, 5000),
+	}
+	sources := make([]PieceSource, len(contents))
+	for i, c := range contents {
+		sources[i] = PieceSource{Reader: bytes.NewReader(c)}
+	}
+	a, pieceInfos, err := NewAggregateFromReaders(1<<20, sources, WithTeeHasher(sha256.New))
+	require.NoError(t, err)
+	require.Len(t, a.ContentDigests, len(contents))
+	for i, c := range contents {
+		digest := sha256.Sum256(c)
+		assert.Equal(t, digest[:], a.ContentDigests[i])
+	}
+
+	// the commitments are not affected
+	plain, plainInfos, err := NewAggregateFromReaders(1<<20, []PieceSource{
+		{Reader: bytes.NewReader(contents[0])}, {Reader: bytes.NewReader(contents[1])},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, plainInfos, pieceInfos)
+	assert.Equal(t, Must(plain.PieceCID()), Must(a.PieceCID()))
+	assert.Nil(t, plain.ContentDigests)
+}
@@ -0,0 +1,54 @@
+package datasegment
+
+import (
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// SegmentRecord is a flat description of a single segment within a deal,
+// intended for ingestion by content indexing services.
+// It encodes to CBOR as a tuple and to JSON through encoding/json.
+type SegmentRecord struct {
+	// PieceCID is the PieceCID of the segment
+	PieceCID cid.Cid
+	// DealCID is the PieceCID of the deal containing the segment
+	DealCID cid.Cid
+	DealID  abi.DealID
+
+	// PaddedOffset is the offset of the segment from the start of the deal in padded bytes
+	PaddedOffset uint64
+	// PaddedSize is the size of the segment in padded bytes
+	PaddedSize uint64
+	// UnpaddedOffset is the offset of the segment from the start of the deal in unpadded bytes
+	UnpaddedOffset uint64
+	// UnpaddedSize is the size of the segment in unpadded bytes
+	UnpaddedSize uint64
+}
+
+// IndexRecords produces a SegmentRecord for every valid entry of the index of the given deal.
+// Entries in this version of the index don't carry a multicodec, so records don't either.
+func (id IndexData) IndexRecords(dealCID cid.Cid, dealID abi.DealID) ([]SegmentRecord, error) {
+	entries, err := id.ValidEntries()
+	if err != nil {
+		return nil, xerrors.Errorf("getting valid entries: %w", err)
+	}
+
+	res := make([]SegmentRecord, len(entries))
+	for i, e := range entries {
+		c, err := e.PieceCIDErr()
+		if err != nil {
+			return nil, xerrors.Errorf("entry %d: %w", i, err)
+		}
+		res[i] = SegmentRecord{
+			PieceCID:       c,
+			DealCID:        dealCID,
+			DealID:         dealID,
+			PaddedOffset:   e.Offset,
+			PaddedSize:     e.Size,
+			UnpaddedOffset: e.UnpaddedOffest(),
+			UnpaddedSize:   uint64(e.UnpaddedSize()),
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package datasegment
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexRecords(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cidForDeal(1), Size: 64 << 10},
+	}
+	a, err := NewAggregate(abi.PaddedPieceSize(1<<20), pieces)
+	require.NoError(t, err)
+	dealCID := Must(a.PieceCID())
+
+	records, err := a.Index.IndexRecords(dealCID, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, []SegmentRecord{
+		{
+			PieceCID: pieces[0].PieceCID, DealCID: dealCID, DealID: 1234,
+			PaddedOffset: 0, PaddedSize: 128 << 10,
+			UnpaddedOffset: 0, UnpaddedSize: 127 << 10,
+		},
+		{
+			PieceCID: pieces[1].PieceCID, DealCID: dealCID, DealID: 1234,
+			PaddedOffset: 128 << 10, PaddedSize: 64 << 10,
+			UnpaddedOffset: 127 << 10, UnpaddedSize: 127 << 9,
+		},
+	}, records)
+
+	t.Run("json", func(t *testing.T) {
+		b, err := json.Marshal(records)
+		require.NoError(t, err)
+		var records2 []SegmentRecord
+		require.NoError(t, json.Unmarshal(b, &records2))
+		assert.Equal(t, records, records2)
+	})
+	t.Run("cbor", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, records[1].MarshalCBOR(&buf))
+		var r SegmentRecord
+		require.NoError(t, r.UnmarshalCBOR(&buf))
+		assert.Equal(t, records[1], r)
+	})
+
+	// invalid entries are skipped
+	idx := IndexData{Entries: append([]SegmentDesc{{}}, a.Index.Entries...)}
+	records2, err := idx.IndexRecords(dealCID, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, records, records2)
+}
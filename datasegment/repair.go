@@ -0,0 +1,140 @@
+package datasegment
+
+import (
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// FixKind describes the kind of repair done by RepairIndex
+type FixKind int
+
+const (
+	// FixInvalidEntry means the entry failed validation and was replaced by the expected one
+	FixInvalidEntry FixKind = iota
+	// FixMissingEntry means the slot was empty or absent and the expected entry was added
+	FixMissingEntry
+	// FixClearedEntry means an invalid entry past the expected entries was cleared
+	FixClearedEntry
+	// FixIndexHeader means the IndexHeader was damaged and was rewritten
+	FixIndexHeader
+)
+
+func (fk FixKind) String() string {
+	switch fk {
+	case FixInvalidEntry:
+		return "invalid entry"
+	case FixMissingEntry:
+		return "missing entry"
+	case FixClearedEntry:
+		return "cleared entry"
+	case FixIndexHeader:
+		return "index header"
+	default:
+		return "unknown"
+	}
+}
+
+// Fix describes a single repair done by RepairIndex
+type Fix struct {
+	// Slot is the position of the entry in the index area, counting the IndexHeader if present
+	Slot int
+	Kind FixKind
+	// Old is the entry before the repair, zero if the slot was absent
+	Old SegmentDesc
+	// New is the entry after the repair, zero for FixClearedEntry
+	New SegmentDesc
+}
+
+// RepairIndex reconstructs the index of a deal of dealSize built by NewAggregate from
+// the expected pieces, reusing the parsed index where it is intact. The placement, offsets and
+// checksums are recomputed from the expected pieces, the sub-pieces themselves are not needed.
+// Entries failing validation are replaced, missing entries are added and invalid entries past
+// the expected ones are cleared, each of these is reported as a Fix.
+// A valid entry which doesn't match the expected piece in its slot is an error, as it indicates
+// the expected pieces don't describe the deal.
+// The index layout is taken from WithIndexCapacity and WithIndexHeader, which have to match the
+// options the deal was built with, other options are ignored. The parsed and repaired indexes
+// don't hold the IndexHeader, as returned by ParseDataSegmentIndex. A damaged header is not
+// recognized by the parser, which then returns every slot of the index area; in that case the
+// first slot is taken to be the header and reported as FixIndexHeader.
+func RepairIndex(parsed IndexData, expected []abi.PieceInfo, dealSize abi.PaddedPieceSize, opts ...AggregateOption) (IndexData, []Fix, error) {
+	var options aggregateOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	if err := ValidateDealSize(dealSize); err != nil {
+		return IndexData{}, nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	if options.indexCapacity != 0 {
+		if err := validateIndexCapacity(dealSize, options.indexCapacity); err != nil {
+			return IndexData{}, nil, xerrors.Errorf("invalid index capacity: %w", err)
+		}
+	}
+	layout := Aggregate{DealSize: dealSize, IndexCapacity: options.indexCapacity, IndexHeader: options.indexHeader}
+	maxEntries := layout.indexCapacity()
+	if layout.IndexHeader {
+		maxEntries--
+	}
+	entries := parsed.Entries
+	slot := 0
+	var damagedHeader *SegmentDesc
+	if layout.IndexHeader {
+		slot = 1
+		if uint(len(entries)) > maxEntries && len(entries) != 0 {
+			damagedHeader = &entries[0]
+			entries = entries[1:]
+		}
+	}
+	if uint(len(expected)) > maxEntries || uint(len(entries)) > maxEntries {
+		return IndexData{}, nil, xerrors.Errorf("too many entries for the index of a %d sized deal: %d > %d",
+			dealSize, max(len(expected), len(entries)), maxEntries)
+	}
+	cl, totalSize, err := ComputeDealPlacement(expected)
+	if err != nil {
+		return IndexData{}, nil, xerrors.Errorf("computing deal placment: %w", err)
+	}
+	if totalSize > layout.indexAreaStart() {
+		return IndexData{}, nil, xerrors.Errorf("pieces are too large to fit in the deal: %d > %d",
+			totalSize, layout.indexAreaStart())
+	}
+	expectedIndex, err := MakeIndexFromCommLoc(cl)
+	if err != nil {
+		return IndexData{}, nil, xerrors.Errorf("creating expected index: %w", err)
+	}
+
+	res := make([]SegmentDesc, max(len(expected), len(entries)))
+	copy(res, entries)
+
+	var fixes []Fix
+	if damagedHeader != nil {
+		header := IndexHeader{Version: IndexHeaderVersion, Entries: uint64(len(res))}
+		fixes = append(fixes, Fix{Slot: 0, Kind: FixIndexHeader, Old: *damagedHeader, New: header.SegmentDesc()})
+	}
+	for i, e := range res {
+		if i >= len(expectedIndex.Entries) {
+			if e != (SegmentDesc{}) {
+				if e.Validate() == nil {
+					return IndexData{}, nil, xerrors.Errorf("entry %d is valid but no piece is expected in it", i)
+				}
+				res[i] = SegmentDesc{}
+				fixes = append(fixes, Fix{Slot: slot + i, Kind: FixClearedEntry, Old: e})
+			}
+			continue
+		}
+
+		want := expectedIndex.Entries[i]
+		switch {
+		case e == want:
+			continue
+		case e == (SegmentDesc{}):
+			fixes = append(fixes, Fix{Slot: slot + i, Kind: FixMissingEntry, New: want})
+		case e.Validate() != nil:
+			fixes = append(fixes, Fix{Slot: slot + i, Kind: FixInvalidEntry, Old: e, New: want})
+		default:
+			return IndexData{}, nil, xerrors.Errorf("entry %d is valid but doesn't match the expected piece %s",
+				i, expected[i].PieceCID)
+		}
+		res[i] = want
+	}
+	return IndexData{Entries: res}, fixes, nil
+}
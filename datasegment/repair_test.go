@@ -0,0 +1,104 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairIndex(t *testing.T) {
+	var pieces []abi.PieceInfo
+	for i := 0; i < 5; i++ {
+		pieces = append(pieces, abi.PieceInfo{PieceCID: cidForDeal(i), Size: 1 << 10})
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	ir, err := a.IndexReader()
+	require.NoError(t, err)
+	parsed, err := ParseDataSegmentIndex(ir)
+	require.NoError(t, err)
+
+	repaired, fixes, err := RepairIndex(parsed, pieces, dealSize)
+	require.NoError(t, err)
+	assert.Empty(t, fixes)
+	assert.True(t, repaired.Equal(parsed))
+
+	damaged := IndexData{Entries: append([]SegmentDesc(nil), parsed.Entries...)}
+	damaged.Entries[1].Offset ^= 1 << 20
+	damaged.Entries[3] = SegmentDesc{}
+	damaged.Entries[7].CommDs[0] = 0x1
+	repaired, fixes, err = RepairIndex(damaged, pieces, dealSize)
+	require.NoError(t, err)
+	assert.True(t, repaired.Equal(parsed))
+	require.Len(t, fixes, 3)
+	assert.Equal(t, Fix{Slot: 1, Kind: FixInvalidEntry, Old: damaged.Entries[1], New: parsed.Entries[1]}, fixes[0])
+	assert.Equal(t, Fix{Slot: 3, Kind: FixMissingEntry, New: parsed.Entries[3]}, fixes[1])
+	assert.Equal(t, Fix{Slot: 7, Kind: FixClearedEntry, Old: damaged.Entries[7]}, fixes[2])
+
+	// the repaired index matches the tree of the deal
+	c, err := ComputeIndexPieceCID(repaired.Entries, dealSize)
+	require.NoError(t, err)
+	assert.Equal(t, Must(a.IndexPieceCID()), c)
+
+	// only the first entries were parsed
+	repaired, fixes, err = RepairIndex(IndexData{Entries: parsed.Entries[:2]}, pieces, dealSize)
+	require.NoError(t, err)
+	assert.Len(t, fixes, 3)
+	assert.Equal(t, a.Index.Entries, repaired.Entries)
+
+	// valid entries of other pieces are not overwritten
+	_, _, err = RepairIndex(parsed, pieces[1:], dealSize)
+	assert.ErrorContains(t, err, "doesn't match the expected piece")
+	_, _, err = RepairIndex(parsed, pieces[:4], dealSize)
+	assert.ErrorContains(t, err, "no piece is expected")
+}
+
+func TestRepairIndexLayout(t *testing.T) {
+	var pieces []abi.PieceInfo
+	for i := 0; i < 5; i++ {
+		pieces = append(pieces, abi.PieceInfo{PieceCID: cidForDeal(i), Size: 1 << 10})
+	}
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	opts := []AggregateOption{WithIndexCapacity(64), WithIndexHeader()}
+	a, err := NewAggregate(dealSize, pieces, opts...)
+	require.NoError(t, err)
+	ir, err := a.IndexReader()
+	require.NoError(t, err)
+	parsed, err := ParseDataSegmentIndex(ir)
+	require.NoError(t, err)
+	require.Equal(t, a.Index.Entries, parsed.Entries)
+
+	repaired, fixes, err := RepairIndex(parsed, pieces, dealSize, opts...)
+	require.NoError(t, err)
+	assert.Empty(t, fixes)
+	assert.True(t, repaired.Equal(parsed))
+
+	// slots are counted from the header
+	damaged := IndexData{Entries: append([]SegmentDesc(nil), parsed.Entries...)}
+	damaged.Entries[2].Checksum[0] ^= 1
+	repaired, fixes, err = RepairIndex(damaged, pieces, dealSize, opts...)
+	require.NoError(t, err)
+	assert.Equal(t, []Fix{{Slot: 3, Kind: FixInvalidEntry, Old: damaged.Entries[2], New: parsed.Entries[2]}}, fixes)
+	assert.True(t, repaired.Equal(parsed))
+
+	// a damaged header is left in the parsed index area
+	area := a.indexAreaEntries()
+	area[0].Offset++
+	area = append(area, make([]SegmentDesc, 64-len(area))...)
+	repaired, fixes, err = RepairIndex(IndexData{Entries: area}, pieces, dealSize, opts...)
+	require.NoError(t, err)
+	require.NotEmpty(t, fixes)
+	assert.Equal(t, Fix{Slot: 0, Kind: FixIndexHeader, Old: area[0],
+		New: IndexHeader{Version: IndexHeaderVersion, Entries: 63}.SegmentDesc()}, fixes[0])
+	assert.Len(t, fixes, 1)
+	assert.Equal(t, a.Index.Entries, repaired.Entries[:len(pieces)])
+
+	// the header takes a slot of the index area
+	_, _, err = RepairIndex(IndexData{}, pieces[:4], 1<<14, WithIndexCapacity(4))
+	assert.NoError(t, err)
+	_, _, err = RepairIndex(IndexData{}, pieces[:4], 1<<14, WithIndexCapacity(4), WithIndexHeader())
+	assert.ErrorContains(t, err, "too many entries")
+}
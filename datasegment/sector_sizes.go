@@ -0,0 +1,82 @@
+package datasegment
+
+import (
+	abi "github.com/filecoin-project/go-state-types/abi"
+	xerrors "golang.org/x/xerrors"
+)
+
+// Sizes of the standard Filecoin sectors, deals of these sizes fill a whole sector
+const (
+	DealSize2KiB   = abi.PaddedPieceSize(2 << 10)
+	DealSize8MiB   = abi.PaddedPieceSize(8 << 20)
+	DealSize512MiB = abi.PaddedPieceSize(512 << 20)
+	DealSize32GiB  = abi.PaddedPieceSize(32 << 30)
+	DealSize64GiB  = abi.PaddedPieceSize(64 << 30)
+)
+
+// DealLayout describes the placement of the index area within a deal of DealSize
+type DealLayout struct {
+	DealSize abi.PaddedPieceSize
+	// MaxEntries is MaxIndexEntriesInDeal(DealSize)
+	MaxEntries uint
+	// IndexStartPadded is IndexAreaStartPadded(DealSize)
+	IndexStartPadded uint64
+	// IndexStartUnpadded is IndexAreaStartUnpadded(DealSize)
+	IndexStartUnpadded uint64
+	// IndexSizePadded is IndexAreaSizePadded(DealSize)
+	IndexSizePadded abi.PaddedPieceSize
+}
+
+// standardLayouts are the layouts of the standard deal sizes, kept in sync with the functions
+// computing them by tests
+var standardLayouts = []DealLayout{
+	{DealSize: DealSize2KiB, MaxEntries: 4, IndexStartPadded: 1792, IndexStartUnpadded: 1778, IndexSizePadded: 256},
+	{DealSize: DealSize8MiB, MaxEntries: 64, IndexStartPadded: 8384512, IndexStartUnpadded: 8319008, IndexSizePadded: 4096},
+	{DealSize: DealSize512MiB, MaxEntries: 4096, IndexStartPadded: 536608768, IndexStartUnpadded: 532416512, IndexSizePadded: 262144},
+	{DealSize: DealSize32GiB, MaxEntries: 262144, IndexStartPadded: 34342961152, IndexStartUnpadded: 34074656768, IndexSizePadded: 16777216},
+	{DealSize: DealSize64GiB, MaxEntries: 524288, IndexStartPadded: 68685922304, IndexStartUnpadded: 68149313536, IndexSizePadded: 33554432},
+}
+
+// SupportedDealSizes returns the standard deal sizes, equal to the Filecoin sector sizes,
+// in increasing order
+func SupportedDealSizes() []abi.PaddedPieceSize {
+	res := make([]abi.PaddedPieceSize, len(standardLayouts))
+	for i, l := range standardLayouts {
+		res[i] = l.DealSize
+	}
+	return res
+}
+
+// StandardDealLayout returns the precomputed layout of one of the SupportedDealSizes
+func StandardDealLayout(dealSize abi.PaddedPieceSize) (DealLayout, bool) {
+	for _, l := range standardLayouts {
+		if l.DealSize == dealSize {
+			return l, true
+		}
+	}
+	return DealLayout{}, false
+}
+
+// ComputeDealLayout computes the layout of any deal size supported by ValidateDealSize
+func ComputeDealLayout(dealSize abi.PaddedPieceSize) (DealLayout, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return DealLayout{}, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	return DealLayout{
+		DealSize:           dealSize,
+		MaxEntries:         MaxIndexEntriesInDeal(dealSize),
+		IndexStartPadded:   IndexAreaStartPadded(dealSize),
+		IndexStartUnpadded: IndexAreaStartUnpadded(dealSize),
+		IndexSizePadded:    IndexAreaSizePadded(dealSize),
+	}, nil
+}
+
+// ValidateStandardDealSize checks that the dealSize is one of the SupportedDealSizes,
+// for integrations which only create deals filling whole sectors.
+// Returned errors match ErrDealSizeNotSupported.
+func ValidateStandardDealSize(dealSize abi.PaddedPieceSize) error {
+	if _, ok := StandardDealLayout(dealSize); !ok {
+		return xerrors.Errorf("%w: %d", dealSizeError("deal size is not a sector size"), dealSize)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package datasegment
+
+import (
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardDealLayouts(t *testing.T) {
+	sizes := SupportedDealSizes()
+	assert.Equal(t, []abi.PaddedPieceSize{2 << 10, 8 << 20, 512 << 20, 32 << 30, 64 << 30}, sizes)
+	assert.Equal(t, MaxSupportedDealSize, sizes[len(sizes)-1])
+
+	for _, size := range sizes {
+		precomputed, ok := StandardDealLayout(size)
+		require.True(t, ok)
+		computed, err := ComputeDealLayout(size)
+		require.NoError(t, err)
+		assert.Equal(t, computed, precomputed, "deal size %d", size)
+		assert.NoError(t, ValidateStandardDealSize(size))
+	}
+
+	_, ok := StandardDealLayout(1 << 20)
+	assert.False(t, ok)
+	assert.ErrorIs(t, ValidateStandardDealSize(1<<20), ErrDealSizeNotSupported)
+	_, err := ComputeDealLayout(3 << 20)
+	assert.ErrorIs(t, err, ErrDealSizeNotSupported)
+}
@@ -0,0 +1,102 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/util"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"golang.org/x/exp/slices"
+	xerrors "golang.org/x/xerrors"
+)
+
+// PaddedRange is a range of the deal in padded bytes
+type PaddedRange struct {
+	Offset uint64
+	Size   uint64
+}
+
+// NewSparseAggregate creates an Aggregate like NewAggregate but subdeals with an undefined PieceCID
+// are treated as placeholders. Space for placeholders is reserved in the layout but they are
+// not written to the index nor to the tree. Replacing a placeholder with a sub-deal of the
+// same size in a later call results in the same placement of all other sub-deals.
+func NewSparseAggregate(dealSize abi.PaddedPieceSize, subdeals []abi.PieceInfo) (*Aggregate, error) {
+	if err := ValidateDealSize(dealSize); err != nil {
+		return nil, xerrors.Errorf("invalid dealSize: %w", err)
+	}
+	maxEntries := MaxIndexEntriesInDeal(dealSize)
+	if uint(len(subdeals)) > maxEntries {
+		return nil, xerrors.Errorf("too many subdeals for a %d sized deal: %d > %d",
+			dealSize, len(subdeals), maxEntries)
+	}
+
+	placeholderCID, err := lightCommP2Cid(merkletree.Node{})
+	if err != nil {
+		return nil, xerrors.Errorf("creating placeholder CID: %w", err)
+	}
+	withPlaceholders := make([]abi.PieceInfo, len(subdeals))
+	for i, sd := range subdeals {
+		withPlaceholders[i] = sd
+		if !sd.PieceCID.Defined() {
+			withPlaceholders[i].PieceCID = placeholderCID
+		}
+	}
+
+	cl, totalSize, err := ComputeDealPlacement(withPlaceholders)
+	if err != nil {
+		return nil, xerrors.Errorf("computing deal placment: %w", err)
+	}
+	if totalSize > IndexAreaStartPadded(dealSize) {
+		return nil, xerrors.Errorf(
+			"sub-deals are too large to fit in the index: %d (packed subdeals) + %d (index) > %d (dealSize)",
+			totalSize, IndexAreaSizePadded(dealSize), dealSize)
+	}
+
+	var present []merkletree.CommAndLoc
+	var reserved []merkletree.Location
+	for i, sd := range subdeals {
+		if !sd.PieceCID.Defined() {
+			reserved = append(reserved, cl[i].Loc)
+		} else {
+			present = append(present, cl[i])
+		}
+	}
+
+	agg, err := newAggregateFromCommLoc(dealSize, present, aggregateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	agg.Reserved = reserved
+	return agg, nil
+}
+
+// FreeRanges returns the ranges of the deal, in padded bytes, which are not occupied by
+// sub-deals, reserved locations, the metadata area or the index area.
+func (a Aggregate) FreeRanges() []PaddedRange {
+	used := make([]PaddedRange, 0, len(a.Index.Entries)+len(a.Reserved)+1)
+	for _, e := range a.Index.Entries {
+		used = append(used, PaddedRange{Offset: e.Offset, Size: e.Size})
+	}
+	for _, l := range a.Reserved {
+		used = append(used, PaddedRange{Offset: l.ByteOffset(), Size: l.Size()})
+	}
+	indexStart := a.indexAreaStart()
+	if layout, err := a.metadataLayout(); err == nil {
+		used = append(used, PaddedRange{Offset: layout.recordOffset, Size: indexStart - layout.recordOffset})
+	}
+	used = append(used, PaddedRange{Offset: indexStart, Size: util.SaturatingSub(uint64(a.DealSize), indexStart)})
+
+	slices.SortFunc(used, func(x, y PaddedRange) bool {
+		return x.Offset < y.Offset
+	})
+
+	res := []PaddedRange{}
+	offset := uint64(0)
+	for _, u := range used {
+		if u.Offset > offset {
+			res = append(res, PaddedRange{Offset: offset, Size: u.Offset - offset})
+		}
+		if end := util.SaturatingAdd(u.Offset, u.Size); end > offset {
+			offset = end
+		}
+	}
+	return res
+}
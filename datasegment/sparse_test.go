@@ -0,0 +1,49 @@
+package datasegment
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseAggregate(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 128 << 10},
+		{PieceCID: cid.Undef, Size: 256 << 10},
+		{PieceCID: cidForDeal(2), Size: 64 << 10},
+	}
+
+	sparse, err := NewSparseAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	require.Len(t, sparse.Index.Entries, 2)
+	assert.Equal(t, []merkletree.Location{{Level: 13, Index: 1}}, sparse.Reserved)
+	assert.Equal(t, []PaddedRange{
+		{Offset: 128 << 10, Size: 128 << 10},
+		{Offset: 576 << 10, Size: IndexAreaStartPadded(dealSize) - 576<<10},
+	}, sparse.FreeRanges())
+
+	for _, pi := range []abi.PieceInfo{pieces[0], pieces[2]} {
+		ip, err := sparse.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		aux, err := ip.ComputeExpectedAuxData(VerifierDataForPieceInfo(pi))
+		require.NoError(t, err)
+		assert.Equal(t, Must(sparse.PieceCID()), aux.CommPa)
+	}
+
+	// patching in the placeholder keeps the layout
+	pieces[1].PieceCID = cidForDeal(1)
+	full, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	assert.Equal(t, sparse.Index.Entries[0], full.Index.Entries[0])
+	assert.Equal(t, sparse.Index.Entries[1].Offset, full.Index.Entries[2].Offset)
+	assert.Equal(t, sparse.Reserved[0].ByteOffset(), full.Index.Entries[1].Offset)
+	assert.Equal(t, sparse.FreeRanges(), full.FreeRanges())
+
+	_, err = NewAggregate(dealSize, []abi.PieceInfo{{PieceCID: cid.Undef, Size: 128}})
+	assert.Error(t, err)
+}
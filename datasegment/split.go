@@ -0,0 +1,101 @@
+package datasegment
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/filecoin-project/go-data-segment/util"
+	xerrors "golang.org/x/xerrors"
+)
+
+// SplitAggregate reads the unpadded deal data from r once and routes the data of each segment
+// described by valid entries of the index to the writer returned by sinkFor.
+// Data between segments is skipped. If sinkFor returns a nil writer, the segment is skipped.
+// Overlapping segments result in an error.
+func SplitAggregate(r io.Reader, index IndexData, sinkFor func(SegmentDesc) (io.Writer, error)) error {
+	return splitSegments(r, index, nil, sinkFor)
+}
+
+// Split is SplitAggregate over the index of the Aggregate. If the Aggregate has RawSizes, only
+// the raw data of each sub-deal is routed to its writer, the padding following it is skipped.
+func (a Aggregate) Split(r io.Reader, sinkFor func(SegmentDesc) (io.Writer, error)) error {
+	if a.RawSizes != nil && len(a.RawSizes) != len(a.Index.Entries) {
+		return xerrors.Errorf("number of raw sizes doesn't match number of entries: %d != %d",
+			len(a.RawSizes), len(a.Index.Entries))
+	}
+	return splitSegments(r, a.Index, a.RawSizes, sinkFor)
+}
+
+// splitSegment is a segment of the unpadded deal data routed by splitSegments
+type splitSegment struct {
+	entry SegmentDesc
+	// slot is the position of the entry in the index
+	slot int
+	// start and length locate the segment in the unpadded deal data
+	start, length uint64
+	// raw is the length of the data routed to the writer, the rest of the segment is skipped
+	raw uint64
+}
+
+func splitSegments(r io.Reader, index IndexData, rawSizes []uint64, sinkFor func(SegmentDesc) (io.Writer, error)) error {
+	var segments []splitSegment
+	for i, e := range index.Entries {
+		if e == (SegmentDesc{}) {
+			continue
+		}
+		if err := e.Validate(); err != nil {
+			if errors.Is(err, ErrValidation) {
+				continue
+			}
+			return xerrors.Errorf("got unknown error for entry %d: %w", i, err)
+		}
+
+		s := splitSegment{entry: e, slot: i, start: e.UnpaddedOffest(), length: e.UnpaddedLength()}
+		s.raw = s.length
+		if rawSizes != nil {
+			if rawSizes[i] > s.length {
+				return xerrors.Errorf("raw size of entry %d doesn't fit in the segment: %d > %d",
+					i, rawSizes[i], s.length)
+			}
+			s.raw = rawSizes[i]
+		}
+		if end, ok := util.CheckedAdd(s.start, s.length); !ok || end > math.MaxInt64 {
+			return xerrors.Errorf("segment of entry %d at offset %d with length %d is out of range",
+				i, s.start, s.length)
+		}
+		segments = append(segments, s)
+	}
+	slices.SortStableFunc(segments, func(a, b splitSegment) int {
+		return cmp.Compare(a.entry.Offset, b.entry.Offset)
+	})
+
+	// pos is the position in the deal data read so far, end is the end of the previous segment
+	var pos, end uint64
+	for _, s := range segments {
+		if s.start < end {
+			return xerrors.Errorf("segment of entry %d at offset %d overlaps previous segment ending at %d",
+				s.slot, s.start, end)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(s.start-pos)); err != nil {
+			return xerrors.Errorf("skipping to segment of entry %d: %w", s.slot, err)
+		}
+
+		w, err := sinkFor(s.entry)
+		if err != nil {
+			return xerrors.Errorf("getting writer for segment of entry %d: %w", s.slot, err)
+		}
+		if w == nil {
+			w = io.Discard
+		}
+		if _, err := io.CopyN(w, r, int64(s.raw)); err != nil {
+			return xerrors.Errorf("copying segment of entry %d: %w", s.slot, err)
+		}
+		// the padding after the raw data is skipped together with the gap to the next segment
+		pos = s.start + s.raw
+		end = s.start + s.length
+	}
+	return nil
+}
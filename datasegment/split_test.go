@@ -0,0 +1,106 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/fr32"
+	abi "github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitAggregate(t *testing.T) {
+	a, pieces, err := GenerateRandomAggregate(1, 8<<20, 10)
+	require.NoError(t, err)
+
+	readers := make([]io.Reader, len(pieces))
+	for i, p := range pieces {
+		readers[i] = p.Reader()
+	}
+	objectReader, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+
+	sinks := map[uint64]*bytes.Buffer{}
+	err = SplitAggregate(objectReader, a.Index, func(sd SegmentDesc) (io.Writer, error) {
+		if sd.Offset == a.Index.Entries[0].Offset {
+			return nil, nil
+		}
+		b := new(bytes.Buffer)
+		sinks[sd.Offset] = b
+		return b, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, sinks, len(pieces)-1)
+
+	for i, e := range a.Index.Entries[1:] {
+		b := sinks[e.Offset].Bytes()
+		data := pieces[i+1].Data
+		require.Len(t, b, int(e.UnpaddedLength()))
+		assert.Equal(t, data, b[:len(data)])
+	}
+
+	err = SplitAggregate(bytes.NewReader(nil), a.Index, func(SegmentDesc) (io.Writer, error) {
+		return io.Discard, nil
+	})
+	assert.Error(t, err)
+}
+
+func TestAggregateSplitRawSizes(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0)},
+		{PieceCID: cidForDeal(1)},
+	}
+	rawSizes := []uint64{1000, 3000}
+	a, err := NewAggregate(1<<20, pieces, WithRawSizes(rawSizes))
+	require.NoError(t, err)
+
+	deal := make([]byte, a.DealSize.Unpadded())
+	for i, e := range a.Index.Entries {
+		copy(deal[e.UnpaddedOffest():], bytes.Repeat([]byte{byte(i + 1)}, int(rawSizes[i])))
+	}
+
+	split := func(split func(io.Reader, func(SegmentDesc) (io.Writer, error)) error) map[uint64][]byte {
+		sinks := map[uint64]*bytes.Buffer{}
+		err := split(bytes.NewReader(deal), func(sd SegmentDesc) (io.Writer, error) {
+			sinks[sd.Offset] = new(bytes.Buffer)
+			return sinks[sd.Offset], nil
+		})
+		require.NoError(t, err)
+		res := map[uint64][]byte{}
+		for k, v := range sinks {
+			res[k] = v.Bytes()
+		}
+		return res
+	}
+
+	got := split(a.Split)
+	require.Len(t, got, 2)
+	for i, e := range a.Index.Entries {
+		assert.Equal(t, bytes.Repeat([]byte{byte(i + 1)}, int(rawSizes[i])), got[e.Offset])
+	}
+
+	// without raw sizes the whole segment is routed, including its padding
+	got = split(func(r io.Reader, sinkFor func(SegmentDesc) (io.Writer, error)) error {
+		return SplitAggregate(r, a.Index, sinkFor)
+	})
+	for _, e := range a.Index.Entries {
+		assert.Len(t, got[e.Offset], int(e.UnpaddedLength()))
+	}
+
+	a.RawSizes = rawSizes[:1]
+	assert.Error(t, a.Split(bytes.NewReader(deal), func(SegmentDesc) (io.Writer, error) { return io.Discard, nil }))
+}
+
+func TestSplitAggregateOutOfRange(t *testing.T) {
+	e, err := MakeDataSegmentIdx(&fr32.Fr32{}, math.MaxUint64&^255, 128)
+	require.NoError(t, err)
+	require.NoError(t, e.Validate())
+
+	err = SplitAggregate(bytes.NewReader(nil), IndexData{Entries: []SegmentDesc{e}},
+		func(SegmentDesc) (io.Writer, error) { return io.Discard, nil })
+	assert.ErrorContains(t, err, "out of range")
+}
@@ -0,0 +1,109 @@
+package datasegment
+
+import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	xerrors "golang.org/x/xerrors"
+)
+
+// Tombstone records an index entry removed with TombstoneEntry
+type Tombstone struct {
+	// Entry is the position of the removed entry in the index
+	Entry int
+	// Freed is the range, in padded bytes, the segment of the entry occupied
+	Freed PaddedRange
+}
+
+// TombstoneEntry removes the segment of the i-th index entry from the Aggregate before the deal
+// is published: the entry is zeroed in place, such that the other entries keep their slots,
+// and the subtree of the segment and the slot of the entry in the tree become zero.
+// The freed range is recorded in Tombstones and reported by FreeRanges.
+// Proofs of the remaining pieces collected before are stale, they are collected again with
+// ProofForIndexEntry.
+// Readers passed to AggregateObjectReader for tombstoned entries are not read.
+func (a *Aggregate) TombstoneEntry(i int) error {
+	if i < 0 || i >= len(a.Index.Entries) {
+		return xerrors.Errorf("index entry %d out of range, the index has %d entries", i, len(a.Index.Entries))
+	}
+	e := a.Index.Entries[i]
+	if e == (SegmentDesc{}) {
+		return xerrors.Errorf("index entry %d is already empty", i)
+	}
+	if err := e.ValidateStrict(); err != nil {
+		return xerrors.Errorf("index entry %d: %w", i, err)
+	}
+	for _, m := range a.Metadata {
+		if m.Segment == uint64(i) {
+			return xerrors.Errorf("index entry %d is referenced by the segment metadata", i)
+		}
+	}
+
+	slot := uint64(i)
+	if a.IndexHeader {
+		slot++
+	}
+	entryLoc, err := indexEntryLocation(a.indexAreaStart(), slot)
+	if err != nil {
+		return xerrors.Errorf("locating index entry: %w", err)
+	}
+
+	tree := a.Tree.Clone()
+	loc := e.CommAndLoc().Loc
+	if err := tree.SetNode(loc.Level, loc.Index, &merkletree.Node{}); err != nil {
+		return xerrors.Errorf("zeroing segment of entry %d: %w", i, err)
+	}
+	for j := uint64(0); j < 2; j++ {
+		if err := tree.SetNode(0, entryLoc.LeafIndex()+j, &merkletree.Node{}); err != nil {
+			return xerrors.Errorf("zeroing index entry %d: %w", i, err)
+		}
+	}
+
+	entries := append([]SegmentDesc(nil), a.Index.Entries...)
+	entries[i] = SegmentDesc{}
+	a.Index = IndexData{Entries: entries}
+	a.Tree = tree
+	a.Tombstones = append(a.Tombstones, Tombstone{Entry: i, Freed: PaddedRange{Offset: e.Offset, Size: e.Size}})
+	return nil
+}
+
+// VerifyTombstones checks that the index entries recorded in Tombstones are empty and that
+// their slots in the index area and the freed ranges commit to zeros in the tree.
+func (a Aggregate) VerifyTombstones() error {
+	for _, ts := range a.Tombstones {
+		if ts.Entry < 0 || ts.Entry >= len(a.Index.Entries) {
+			return xerrors.Errorf("tombstone of entry %d out of range, the index has %d entries",
+				ts.Entry, len(a.Index.Entries))
+		}
+		if a.Index.Entries[ts.Entry] != (SegmentDesc{}) {
+			return xerrors.Errorf("tombstoned entry %d is not empty", ts.Entry)
+		}
+
+		loc, err := merkletree.LocationForOffsetSize(ts.Freed.Offset, ts.Freed.Size)
+		if err != nil {
+			return xerrors.Errorf("tombstone of entry %d: %w", ts.Entry, err)
+		}
+		n, err := a.Tree.GetNode(loc.Level, loc.Index)
+		if err != nil {
+			return xerrors.Errorf("getting freed node of entry %d: %w", ts.Entry, err)
+		}
+		if n != merkletree.ZeroCommitmentForLevel(loc.Level) {
+			return xerrors.Errorf("freed range of entry %d is not zero: %s", ts.Entry, loc)
+		}
+
+		slot := uint64(ts.Entry)
+		if a.IndexHeader {
+			slot++
+		}
+		entryLoc, err := indexEntryLocation(a.indexAreaStart(), slot)
+		if err != nil {
+			return xerrors.Errorf("locating index entry: %w", err)
+		}
+		n, err = a.Tree.GetNode(entryLoc.Level, entryLoc.Index)
+		if err != nil {
+			return xerrors.Errorf("getting index node of entry %d: %w", ts.Entry, err)
+		}
+		if n != merkletree.ZeroCommitmentForLevel(entryLoc.Level) {
+			return xerrors.Errorf("index slot of tombstoned entry %d is not zero", ts.Entry)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package datasegment
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTombstoneEntry(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	for _, opts := range [][]AggregateOption{nil, {WithIndexHeader()}} {
+		_, pieces, err := GenerateRandomAggregate(4159, dealSize, 4)
+		require.NoError(t, err)
+		pieceInfos := make([]abi.PieceInfo, len(pieces))
+		for i, p := range pieces {
+			pieceInfos[i] = p.PieceInfo
+		}
+		a, err := NewAggregate(dealSize, pieceInfos, opts...)
+		require.NoError(t, err)
+		var proofs []*InclusionProof
+		for i := range pieces {
+			proofs = append(proofs, Must(a.ProofForIndexEntry(i)))
+		}
+		before := a.Index
+		removed := a.Index.Entries[1]
+		freeBefore := a.FreeBytes()
+
+		require.NoError(t, a.TombstoneEntry(1))
+		assert.Equal(t, SegmentDesc{}, a.Index.Entries[1])
+		assert.Equal(t, removed, before.Entries[1], "the entries are copied")
+		assert.Equal(t, []Tombstone{{Entry: 1, Freed: PaddedRange{Offset: removed.Offset, Size: removed.Size}}}, a.Tombstones)
+		assert.Equal(t, 3, a.SegmentCount())
+		assert.Equal(t, freeBefore+removed.PaddedSize(), a.FreeBytes())
+		require.NoError(t, a.VerifyTombstones())
+		require.NoError(t, a.SelfCheck())
+
+		dealCID := Must(a.PieceCID())
+		for i, p := range pieces {
+			if i == 1 {
+				_, err := a.ProofForIndexEntry(i)
+				assert.Error(t, err)
+				continue
+			}
+			vd := VerifierDataForPieceInfo(p.PieceInfo)
+			aux, err := proofs[i].ComputeExpectedAuxData(vd)
+			require.NoError(t, err)
+			assert.NotEqual(t, dealCID, aux.CommPa)
+
+			aux, err = Must(a.ProofForIndexEntry(i)).ComputeExpectedAuxData(vd)
+			require.NoError(t, err)
+			assert.Equal(t, dealCID, aux.CommPa)
+		}
+
+		// the deal data commits to the new root, the tombstoned reader is not read
+		readers := make([]io.Reader, len(pieces))
+		for i, p := range pieces {
+			readers[i] = p.Reader()
+		}
+		readers[1] = nil
+		deal, err := io.ReadAll(Must(a.AggregateObjectReader(readers)))
+		require.NoError(t, err)
+		cp := &commp.Calc{}
+		_, _ = cp.Write(deal)
+		comm, _, err := cp.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, dealCID, Must(commcid.PieceCommitmentV1ToCID(comm)))
+
+		index, statuses, err := ParseDataSegmentIndexWithStatus(bytes.NewReader(deal[DataSegmentIndexStartOffset(dealSize):]))
+		require.NoError(t, err)
+		slot := 1
+		if a.IndexHeader {
+			slot++
+		}
+		assert.Equal(t, EntryEmpty, statuses[slot].State)
+		assert.Len(t, Must(index.ValidEntries()), 3)
+
+		// the freed range is proven to be padding
+		start := removed.UnpaddedOffest()
+		rp, err := a.ProveRangeOwnership(start, removed.UnpaddedLength())
+		require.NoError(t, err)
+		assert.Equal(t, RangeInPadding, rp.Kind)
+		require.NoError(t, rp.Verify(start, removed.UnpaddedLength(), InclusionAuxData{CommPa: dealCID, SizePa: dealSize}))
+
+		assert.Error(t, a.TombstoneEntry(1))
+		assert.Error(t, a.TombstoneEntry(len(pieces)))
+		assert.Error(t, a.TombstoneEntry(-1))
+	}
+}
+
+func TestVerifyTombstonesRejects(t *testing.T) {
+	dealSize := abi.PaddedPieceSize(1 << 20)
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 1 << 10},
+		{PieceCID: cidForDeal(1), Size: 4 << 10},
+	}
+	a, err := NewAggregate(dealSize, pieces)
+	require.NoError(t, err)
+	require.NoError(t, a.TombstoneEntry(0))
+
+	// a tombstone of a present entry
+	bad := *a
+	bad.Tombstones = []Tombstone{{Entry: 1, Freed: PaddedRange{Offset: a.Index.Entries[1].Offset, Size: a.Index.Entries[1].Size}}}
+	assert.Error(t, bad.VerifyTombstones())
+	// a freed range which is not zero
+	bad.Tombstones = []Tombstone{{Entry: 0, Freed: PaddedRange{Offset: a.Index.Entries[1].Offset, Size: a.Index.Entries[1].Size}}}
+	assert.Error(t, bad.VerifyTombstones())
+	assert.Error(t, bad.SelfCheck())
+	bad.Tombstones = []Tombstone{{Entry: 2}}
+	assert.Error(t, bad.VerifyTombstones())
+
+	// entries referenced by the metadata can't be removed
+	withMetadata, err := NewAggregate(dealSize, pieces, WithSegmentMetadata([]SegmentMetadata{{Segment: 1, PayloadCID: cidForDeal(100)}}))
+	require.NoError(t, err)
+	assert.Error(t, withMetadata.TombstoneEntry(1))
+	require.NoError(t, withMetadata.TombstoneEntry(0))
+	require.NoError(t, withMetadata.SelfCheck())
+}
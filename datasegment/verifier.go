@@ -1,7 +1,9 @@
 package datasegment
 
 import (
+	"github.com/filecoin-project/go-data-segment/merkletree"
 	"github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 )
 
@@ -13,6 +15,25 @@ type DataAggregationProof struct {
 	AuxDataSource SingletonMarketSource
 }
 
+// DataAggregationProofFor produces the DataAggregationProof of the piece within the Aggregate
+// stored in the deal dealID, ready to be handed to the verifier
+func (a Aggregate) DataAggregationProofFor(pi abi.PieceInfo, dealID abi.DealID) (*DataAggregationProof, error) {
+	ip, err := a.ProofForPieceInfo(pi)
+	if err != nil {
+		return nil, xerrors.Errorf("producing inclusion proof: %w", err)
+	}
+	return &DataAggregationProof{
+		Inclusion:     *ip,
+		AuxDataType:   0,
+		AuxDataSource: SingletonMarketSource{DealID: dealID},
+	}, nil
+}
+
+// MarshalForChain returns the canonical CBOR encoding of the proof, as passed on chain
+func (dap DataAggregationProof) MarshalForChain() ([]byte, error) {
+	return MarshalCanonical(&dap)
+}
+
 type SingletonMarketSource struct {
 	DealID abi.DealID
 }
@@ -52,3 +73,36 @@ func (dap DataAggregationProof) VerifyActive(verifierData InclusionVerifierData,
 func (dap DataAggregationProof) ComputeExpectedAuxData(verifierData InclusionVerifierData) (*InclusionAuxData, error) {
 	return dap.Inclusion.ComputeExpectedAuxData(verifierData)
 }
+
+// Verifier computes the InclusionAuxData like InclusionProof.ComputeExpectedAuxData, without
+// allocating when the proof is accepted and is for the same deal as the previous one.
+// The zero value is ready to use. A Verifier is not safe for concurrent use.
+type Verifier struct {
+	// commPa and cidPa cache the CID of the last deal
+	commPa merkletree.Node
+	cidPa  cid.Cid
+}
+
+// ComputeExpectedAuxDataInto verifies the proof like InclusionProof.ComputeExpectedAuxData and
+// stores the computed aux data in dst
+func (v *Verifier) ComputeExpectedAuxDataInto(dst *InclusionAuxData, ip InclusionProof, veriferData InclusionVerifierData) error {
+	commPc, err := veriferData.validate()
+	if err != nil {
+		return xerrors.Errorf("invalid verifier data: %w", err)
+	}
+	commPa, sizePa, err := ip.verify(commPc, veriferData.SizePc, 0)
+	if err != nil {
+		return err
+	}
+
+	if !v.cidPa.Defined() || commPa != v.commPa {
+		c, err := lightCommP2Cid(commPa)
+		if err != nil {
+			return xerrors.Errorf("converting raw commiement to CID: %w", err)
+		}
+		v.commPa, v.cidPa = commPa, c
+	}
+	dst.CommPa = v.cidPa
+	dst.SizePa = sizePa
+	return nil
+}
@@ -0,0 +1,85 @@
+package datasegment
+
+import (
+	"bytes"
+	"testing"
+
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataAggregationProofFor(t *testing.T) {
+	pieces := []abi.PieceInfo{
+		{PieceCID: cidForDeal(0), Size: 1024},
+		{PieceCID: cidForDeal(1), Size: 256},
+	}
+	a, err := NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+
+	dap, err := a.DataAggregationProofFor(pieces[1], 42)
+	require.NoError(t, err)
+	assert.Equal(t, abi.DealID(42), dap.AuxDataSource.DealID)
+
+	fetch := func(src SingletonMarketSource) (SingletonMarketAuxData, error) {
+		assert.Equal(t, abi.DealID(42), src.DealID)
+		return SingletonMarketAuxData{
+			DealActive: true,
+			AuxData:    InclusionAuxData{CommPa: Must(a.PieceCID()), SizePa: a.DealSize},
+		}, nil
+	}
+	assert.NoError(t, dap.VerifyActive(VerifierDataForPieceInfo(pieces[1]), fetch))
+
+	encoded, err := dap.MarshalForChain()
+	require.NoError(t, err)
+	var decoded DataAggregationProof
+	require.NoError(t, decoded.UnmarshalCBOR(bytes.NewReader(encoded)))
+	assert.Equal(t, *dap, decoded)
+
+	_, err = a.DataAggregationProofFor(abi.PieceInfo{PieceCID: cidForDeal(2), Size: 128}, 42)
+	assert.Error(t, err)
+}
+
+func TestVerifierComputeExpectedAuxDataInto(t *testing.T) {
+	verifData, incProof, expectedAux := InclusionGolden1()
+
+	var v Verifier
+	var aux InclusionAuxData
+	require.NoError(t, v.ComputeExpectedAuxDataInto(&aux, incProof, verifData))
+	assert.Equal(t, expectedAux, aux)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := v.ComputeExpectedAuxDataInto(&aux, incProof, verifData); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.Zero(t, allocs)
+
+	bad := incProof.Clone()
+	bad.ProofIndex.Path[0][0] ^= 1
+	assert.ErrorIs(t, v.ComputeExpectedAuxDataInto(&aux, bad, verifData), ErrInvalidProof)
+	verifData.SizePc = 3
+	assert.ErrorIs(t, v.ComputeExpectedAuxDataInto(&aux, incProof, verifData), ErrInvalidVerifierData)
+}
+
+func BenchmarkComputeExpectedAuxData(b *testing.B) {
+	verifData, incProof, _ := InclusionGolden1()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := incProof.ComputeExpectedAuxData(verifData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifierComputeExpectedAuxDataInto(b *testing.B) {
+	verifData, incProof, _ := InclusionGolden1()
+	var v Verifier
+	var aux InclusionAuxData
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := v.ComputeExpectedAuxDataInto(&aux, incProof, verifData); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Package watch maintains the parsed indexes of a set of deals whose piece data is available
+// locally, and answers in which deals a segment is stored.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/hashicorp/go-multierror"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// Piece describes the piece of a watched deal
+type Piece struct {
+	DealCID  cid.Cid
+	DealSize abi.PaddedPieceSize
+	// Version changes whenever the content of the piece changes, e.g. its modification time
+	Version string
+}
+
+// ReaderAtCloser is the unpadded data of a piece
+type ReaderAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Source provides the pieces to watch
+type Source interface {
+	// Pieces lists the pieces currently available
+	Pieces() ([]Piece, error)
+	// Open opens the unpadded data of the piece
+	Open(p Piece) (ReaderAtCloser, error)
+}
+
+// Location is the position of a segment within a watched deal
+type Location struct {
+	DealCID cid.Cid
+	// Offset is the offset of the segment from the start of the deal in padded bytes
+	Offset uint64
+	Size   abi.PaddedPieceSize
+}
+
+type watched struct {
+	piece Piece
+	index datasegment.IndexData
+}
+
+// Watcher keeps the valid entries of the indexes of the pieces provided by a Source.
+// It is safe for concurrent use.
+type Watcher struct {
+	src Source
+	// refreshMu serializes calls of Refresh
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	deals     map[cid.Cid]watched
+	bySegment map[cid.Cid][]Location
+}
+
+// New creates a Watcher of the source, Refresh or Run have to be called to load the pieces
+func New(src Source) *Watcher {
+	return &Watcher{
+		src:       src,
+		deals:     make(map[cid.Cid]watched),
+		bySegment: make(map[cid.Cid][]Location),
+	}
+}
+
+// Refresh lists the pieces of the source, parses the indexes of new and changed pieces and
+// drops removed pieces. Pieces which fail to parse are dropped and their errors are returned
+// together, the other pieces are still updated.
+func (w *Watcher) Refresh() error {
+	w.refreshMu.Lock()
+	defer w.refreshMu.Unlock()
+
+	pieces, err := w.src.Pieces()
+	if err != nil {
+		return xerrors.Errorf("listing pieces: %w", err)
+	}
+
+	w.mu.RLock()
+	current := make(map[cid.Cid]watched, len(w.deals))
+	for k, v := range w.deals {
+		current[k] = v
+	}
+	w.mu.RUnlock()
+
+	var errs error
+	next := make(map[cid.Cid]watched, len(pieces))
+	for _, p := range pieces {
+		if old, ok := current[p.DealCID]; ok && old.piece == p {
+			next[p.DealCID] = old
+			continue
+		}
+		index, err := w.parse(p)
+		if err != nil {
+			errs = multierror.Append(errs, xerrors.Errorf("piece %s: %w", p.DealCID, err))
+			continue
+		}
+		next[p.DealCID] = watched{piece: p, index: index}
+	}
+
+	// iterate in the order of the source, not of the map, so locations are listed deterministically
+	bySegment := make(map[cid.Cid][]Location)
+	for _, p := range pieces {
+		d, ok := next[p.DealCID]
+		if !ok || d.piece != p {
+			continue
+		}
+		dealCID := p.DealCID
+		for _, e := range d.index.Entries {
+			c, err := e.PieceCIDErr()
+			if err != nil {
+				continue
+			}
+			bySegment[c] = append(bySegment[c], Location{DealCID: dealCID, Offset: e.Offset, Size: e.PaddedSize()})
+		}
+	}
+
+	w.mu.Lock()
+	w.deals = next
+	w.bySegment = bySegment
+	w.mu.Unlock()
+	return errs
+}
+
+// parse reads the valid entries of the index of the piece
+func (w *Watcher) parse(p Piece) (datasegment.IndexData, error) {
+	if err := datasegment.ValidateDealSize(p.DealSize); err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("invalid deal size: %w", err)
+	}
+	r, err := w.src.Open(p)
+	if err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("opening piece: %w", err)
+	}
+	defer r.Close()
+
+	area := io.NewSectionReader(r, int64(datasegment.IndexAreaStartUnpadded(p.DealSize)),
+		int64(datasegment.IndexAreaSizeUnpadded(p.DealSize)))
+	index, err := datasegment.ParseDataSegmentIndexForDeal(p.DealSize, area)
+	if err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("parsing index: %w", err)
+	}
+	entries, err := index.ValidEntries()
+	if err != nil {
+		return datasegment.IndexData{}, xerrors.Errorf("validating index: %w", err)
+	}
+	return datasegment.IndexData{Entries: entries}, nil
+}
+
+// Run calls Refresh every interval until the context is cancelled. Errors of Refresh are
+// passed to onError if it is not nil.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, onError func(error)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := w.Refresh(); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Lookup returns the locations of the segment in all watched deals, in the order in which
+// the Source lists the deals
+func (w *Watcher) Lookup(pieceCID cid.Cid) []Location {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]Location(nil), w.bySegment[pieceCID]...)
+}
+
+// Index returns the valid entries of the index of the watched deal
+func (w *Watcher) Index(dealCID cid.Cid) (datasegment.IndexData, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	d, ok := w.deals[dealCID]
+	return d.index, ok
+}
+
+// Deals returns the pieces of the watched deals ordered by DealCID
+func (w *Watcher) Deals() []Piece {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	res := make([]Piece, 0, len(w.deals))
+	for _, d := range w.deals {
+		res = append(res, d.piece)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].DealCID.KeyString() < res[j].DealCID.KeyString()
+	})
+	return res
+}
+
+// DirSource is a Source of piece files in a directory. Each file holds the unpadded data of
+// a deal and is named with the PieceCID of the deal, the size of the deal is derived from
+// the size of the file. Other files are ignored.
+type DirSource string
+
+var _ Source = DirSource("")
+
+func (d DirSource) Pieces() ([]Piece, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, xerrors.Errorf("reading directory: %w", err)
+	}
+	var res []Piece
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		c, err := cid.Decode(e.Name())
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, xerrors.Errorf("stat of %s: %w", e.Name(), err)
+		}
+		dealSize := abi.UnpaddedPieceSize(info.Size()).Padded()
+		if datasegment.ValidateDealSize(dealSize) != nil || uint64(dealSize.Unpadded()) != uint64(info.Size()) {
+			continue
+		}
+		res = append(res, Piece{
+			DealCID:  c,
+			DealSize: dealSize,
+			Version:  fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano()),
+		})
+	}
+	return res, nil
+}
+
+func (d DirSource) Open(p Piece) (ReaderAtCloser, error) {
+	return os.Open(filepath.Join(string(d), p.DealCID.String()))
+}
@@ -0,0 +1,116 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pieceCID(t *testing.T, x byte) cid.Cid {
+	comm := bytes.Repeat([]byte{x}, 32)
+	comm[31] &= 0x3f
+	c, err := commcid.PieceCommitmentV1ToCID(comm)
+	require.NoError(t, err)
+	return c
+}
+
+// writeDeal writes the unpadded data of an aggregate of the pieces into dir and returns its PieceCID
+func writeDeal(t *testing.T, dir string, pieces []abi.PieceInfo) cid.Cid {
+	a, err := datasegment.NewAggregate(1<<20, pieces)
+	require.NoError(t, err)
+	readers := make([]io.Reader, len(pieces))
+	for i := range readers {
+		readers[i] = bytes.NewReader(nil)
+	}
+	r, err := a.AggregateObjectReader(readers)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	dealCID, err := a.PieceCID()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, dealCID.String()), data, 0o644))
+	return dealCID
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	shared := abi.PieceInfo{PieceCID: pieceCID(t, 1), Size: 1024}
+	deal1 := writeDeal(t, dir, []abi.PieceInfo{shared, {PieceCID: pieceCID(t, 2), Size: 256}})
+	deal2 := writeDeal(t, dir, []abi.PieceInfo{{PieceCID: pieceCID(t, 3), Size: 128}, shared})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644))
+
+	w := New(DirSource(dir))
+	require.NoError(t, w.Refresh())
+	assert.Len(t, w.Deals(), 2)
+	assert.ElementsMatch(t, []Location{
+		{DealCID: deal1, Offset: 0, Size: 1024},
+		{DealCID: deal2, Offset: 1024, Size: 1024},
+	}, w.Lookup(shared.PieceCID))
+	index, ok := w.Index(deal1)
+	require.True(t, ok)
+	assert.Len(t, index.Entries, 2)
+
+	// the deal is replaced, the watcher picks up the change
+	require.NoError(t, os.Remove(filepath.Join(dir, deal2.String())))
+	deal3 := writeDeal(t, dir, []abi.PieceInfo{{PieceCID: pieceCID(t, 4), Size: 128}})
+	require.NoError(t, w.Refresh())
+	assert.Equal(t, []Location{{DealCID: deal1, Offset: 0, Size: 1024}}, w.Lookup(shared.PieceCID))
+	assert.Len(t, w.Lookup(pieceCID(t, 4)), 1)
+	_, ok = w.Index(deal2)
+	assert.False(t, ok)
+
+	// content change of a deal with the same name
+	deal3Path := filepath.Join(dir, deal3.String())
+	data, err := os.ReadFile(filepath.Join(dir, deal1.String()))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(deal3Path, data, 0o644))
+	require.NoError(t, os.Chtimes(deal3Path, time.Now(), time.Now().Add(time.Hour)))
+	require.NoError(t, w.Refresh())
+	assert.Empty(t, w.Lookup(pieceCID(t, 4)))
+	assert.Len(t, w.Lookup(shared.PieceCID), 2)
+}
+
+func TestWatcherDeterministicOrder(t *testing.T) {
+	dir := t.TempDir()
+	shared := abi.PieceInfo{PieceCID: pieceCID(t, 1), Size: 1024}
+	for i := byte(0); i < 8; i++ {
+		writeDeal(t, dir, []abi.PieceInfo{{PieceCID: pieceCID(t, 10+i), Size: 128}, shared})
+	}
+
+	first := New(DirSource(dir))
+	require.NoError(t, first.Refresh())
+	require.Len(t, first.Lookup(shared.PieceCID), 8)
+	for i := 0; i < 10; i++ {
+		w := New(DirSource(dir))
+		require.NoError(t, w.Refresh())
+		assert.Equal(t, first.Lookup(shared.PieceCID), w.Lookup(shared.PieceCID))
+		assert.Equal(t, first.Deals(), w.Deals())
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	dir := t.TempDir()
+	dealCID := writeDeal(t, dir, []abi.PieceInfo{{PieceCID: pieceCID(t, 1), Size: 128}})
+	w := New(DirSource(dir))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx, time.Millisecond, nil) }()
+	require.Eventually(t, func() bool {
+		_, ok := w.Index(dealCID)
+		return ok
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
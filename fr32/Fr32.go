@@ -1,5 +1,7 @@
 package fr32
 
+import "encoding/binary"
+
 const BitsNeeded = 254
 const BytesNeeded = 32
 
@@ -9,6 +11,7 @@ type InternalType interface {
 
 type Fr32 [BytesNeeded]byte
 
+// Pad adds Fr32 padding to 127 byte chunks of in, writing 128 byte chunks to out.
 // Pad assumes len(in)%127==0 and len(out)%128==0
 func Pad(in, out []byte) {
 	chunks := len(out) / 128
@@ -50,55 +53,51 @@ func Pad(in, out []byte) {
 	}
 }
 
+// Unpad removes Fr32 padding from 128 byte chunks of in, writing 127 byte chunks to out.
 // Unpad assumes len(in)%128==0 and len(out)%127==0
+// The top two bits of each Fr32 element are ignored.
 func Unpad(out, in []byte) {
 	chunks := len(in) / 128
 	for chunk := 0; chunk < chunks; chunk++ {
-		inOffNext := chunk*128 + 1
-		outOff := chunk * 127
-
-		at := in[chunk*128]
-
-		for i := 0; i < 32; i++ {
-			next := in[i+inOffNext]
-
-			out[outOff+i] = at
-			//out[i] |= next << 8
-
-			at = next
-		}
-
-		out[outOff+31] |= at << 6
-
-		for i := 32; i < 64; i++ {
-			next := in[i+inOffNext]
-
-			out[outOff+i] = at >> 2
-			out[outOff+i] |= next << 6
-
-			at = next
-		}
-
-		out[outOff+63] ^= (at << 6) ^ (at << 4)
-
-		for i := 64; i < 96; i++ {
-			next := in[i+inOffNext]
-
-			out[outOff+i] = at >> 4
-			out[outOff+i] |= next << 4
-
-			at = next
-		}
-
-		out[outOff+95] ^= (at << 4) ^ (at << 2)
-
-		for i := 96; i < 127; i++ {
-			next := in[i+inOffNext]
-
-			out[outOff+i] = at >> 6
-			out[outOff+i] |= next << 2
+		unpadChunk((*[127]byte)(out[chunk*127:]), (*[128]byte)(in[chunk*128:]))
+	}
+}
 
-			at = next
-		}
+// mask254 clears the top two bits of the last word of an Fr32 element
+const mask254 = 1<<62 - 1
+
+// unpadChunk unpads a single chunk operating on 64-bit words. The 254 bit elements are
+// placed at bit offsets 0, 254, 508 and 762 of the output, which are offsets of 0, 62, 60
+// and 58 bits within output words 0, 3, 7 and 11.
+func unpadChunk(out *[127]byte, in *[128]byte) {
+	le := binary.LittleEndian
+	var w [16]uint64
+	for i := range w {
+		w[i] = le.Uint64(in[8*i:])
+	}
+	w[3] &= mask254
+	w[7] &= mask254
+	w[11] &= mask254
+	w[15] &= mask254
+
+	le.PutUint64(out[0:], w[0])
+	le.PutUint64(out[8:], w[1])
+	le.PutUint64(out[16:], w[2])
+	le.PutUint64(out[24:], w[3]|w[4]<<62)
+	le.PutUint64(out[32:], w[4]>>2|w[5]<<62)
+	le.PutUint64(out[40:], w[5]>>2|w[6]<<62)
+	le.PutUint64(out[48:], w[6]>>2|w[7]<<62)
+	le.PutUint64(out[56:], w[7]>>2|w[8]<<60)
+	le.PutUint64(out[64:], w[8]>>4|w[9]<<60)
+	le.PutUint64(out[72:], w[9]>>4|w[10]<<60)
+	le.PutUint64(out[80:], w[10]>>4|w[11]<<60)
+	le.PutUint64(out[88:], w[11]>>4|w[12]<<58)
+	le.PutUint64(out[96:], w[12]>>6|w[13]<<58)
+	le.PutUint64(out[104:], w[13]>>6|w[14]<<58)
+	le.PutUint64(out[112:], w[14]>>6|w[15]<<58)
+	last := w[15] >> 6
+	for i := 120; i < 127; i++ {
+		out[i] = byte(last)
+		last >>= 8
 	}
 }
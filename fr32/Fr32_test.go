@@ -0,0 +1,212 @@
+package fr32
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomUnpadded(t testing.TB, chunks int) []byte {
+	b := make([]byte, 127*chunks)
+	_, err := rand.New(rand.NewSource(int64(chunks))).Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestPadUnpadRoundtrip(t *testing.T) {
+	for _, chunks := range []int{1, 2, 63, 64, 65, 300} {
+		unpadded := randomUnpadded(t, chunks)
+		padded := make([]byte, 128*chunks)
+		Pad(unpadded, padded)
+		for i := 0; i < chunks; i++ {
+			for j := 31; j < 128; j += 32 {
+				assert.Zero(t, padded[i*128+j]&0xc0, "top two bits of each Fr32 element should be zero")
+			}
+		}
+
+		out := make([]byte, 127*chunks)
+		Unpad(out, padded)
+		assert.Equal(t, unpadded, out)
+	}
+}
+
+func TestPadReaderUnpadWriter(t *testing.T) {
+	for _, chunks := range []int{0, 1, 63, 64, 65, 300} {
+		unpadded := randomUnpadded(t, chunks)
+		expected := make([]byte, 128*chunks)
+		Pad(unpadded, expected)
+
+		padded, err := io.ReadAll(NewPadReader(bytes.NewReader(unpadded)))
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(expected, padded))
+
+		out := new(bytes.Buffer)
+		uw := NewUnpadWriter(out)
+		// write in uneven pieces to exercise buffering
+		for b := padded; len(b) > 0; {
+			n := 77
+			if n > len(b) {
+				n = len(b)
+			}
+			_, err := uw.Write(b[:n])
+			require.NoError(t, err)
+			b = b[n:]
+		}
+		require.NoError(t, uw.Close())
+		assert.True(t, bytes.Equal(unpadded, out.Bytes()))
+	}
+}
+
+func TestPadReaderUnpadWriterNegative(t *testing.T) {
+	_, err := io.ReadAll(NewPadReader(bytes.NewReader(make([]byte, 127*3+5))))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	uw := NewUnpadWriter(io.Discard)
+	_, err = uw.Write(make([]byte, 129))
+	require.NoError(t, err)
+	assert.Error(t, uw.Close())
+}
+
+// unpadScalar is the byte-wise reference implementation of Unpad
+func unpadScalar(out, in []byte) {
+	chunks := len(in) / 128
+	for chunk := 0; chunk < chunks; chunk++ {
+		inOffNext := chunk*128 + 1
+		outOff := chunk * 127
+
+		at := in[chunk*128]
+
+		for i := 0; i < 32; i++ {
+			next := in[i+inOffNext]
+
+			out[outOff+i] = at
+			//out[i] |= next << 8
+
+			at = next
+		}
+
+		out[outOff+31] |= at << 6
+
+		for i := 32; i < 64; i++ {
+			next := in[i+inOffNext]
+
+			out[outOff+i] = at >> 2
+			out[outOff+i] |= next << 6
+
+			at = next
+		}
+
+		out[outOff+63] ^= (at << 6) ^ (at << 4)
+
+		for i := 64; i < 96; i++ {
+			next := in[i+inOffNext]
+
+			out[outOff+i] = at >> 4
+			out[outOff+i] |= next << 4
+
+			at = next
+		}
+
+		out[outOff+95] ^= (at << 4) ^ (at << 2)
+
+		for i := 96; i < 127; i++ {
+			next := in[i+inOffNext]
+
+			out[outOff+i] = at >> 6
+			out[outOff+i] |= next << 2
+
+			at = next
+		}
+	}
+}
+
+// clearTopBits clears the top two bits of each Fr32 element, which Unpad ignores
+func clearTopBits(padded []byte) {
+	for i := 31; i < len(padded); i += 32 {
+		padded[i] &= 0x3f
+	}
+}
+
+func TestUnpadMatchesScalar(t *testing.T) {
+	for _, chunks := range []int{1, 2, 63, 300} {
+		padded := make([]byte, 128*chunks)
+		_, err := rand.New(rand.NewSource(int64(chunks))).Read(padded)
+		require.NoError(t, err)
+		clearTopBits(padded)
+
+		expected := make([]byte, 127*chunks)
+		unpadScalar(expected, padded)
+		out := make([]byte, 127*chunks)
+		Unpad(out, padded)
+		assert.Equal(t, expected, out)
+	}
+}
+
+func FuzzUnpad(f *testing.F) {
+	f.Add(make([]byte, 128))
+	f.Add(bytes.Repeat([]byte{0xff}, 256))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		padded := data[:len(data)/128*128]
+		out := make([]byte, len(padded)/128*127)
+		Unpad(out, padded)
+
+		clearTopBits(padded)
+		expected := make([]byte, len(out))
+		unpadScalar(expected, padded)
+		if !bytes.Equal(expected, out) {
+			t.Fatalf("Unpad doesn't match the scalar implementation")
+		}
+		repadded := make([]byte, len(padded))
+		Pad(out, repadded)
+		if !bytes.Equal(padded, repadded) {
+			t.Fatalf("Pad doesn't restore the padded data")
+		}
+	})
+}
+
+func BenchmarkUnpadScalar(b *testing.B) {
+	unpadded := randomUnpadded(b, 1<<13)
+	padded := make([]byte, 128<<13)
+	Pad(unpadded, padded)
+	b.SetBytes(int64(len(padded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		unpadScalar(unpadded, padded)
+	}
+}
+
+func BenchmarkPad(b *testing.B) {
+	unpadded := randomUnpadded(b, 1<<13)
+	padded := make([]byte, 128<<13)
+	b.SetBytes(int64(len(unpadded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Pad(unpadded, padded)
+	}
+}
+
+func BenchmarkUnpad(b *testing.B) {
+	unpadded := randomUnpadded(b, 1<<13)
+	padded := make([]byte, 128<<13)
+	Pad(unpadded, padded)
+	b.SetBytes(int64(len(padded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Unpad(unpadded, padded)
+	}
+}
+
+func BenchmarkPadReader(b *testing.B) {
+	unpadded := randomUnpadded(b, 1<<13)
+	b.SetBytes(int64(len(unpadded)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.Copy(io.Discard, NewPadReader(bytes.NewReader(unpadded))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
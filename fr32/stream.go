@@ -0,0 +1,114 @@
+package fr32
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// chunksPerBatch is the number of 127/128 byte chunks processed at once by stream wrappers
+const chunksPerBatch = 64
+
+type padReader struct {
+	src      io.Reader
+	unpadded []byte
+	padded   []byte
+	// buffered holds the remaining padded bytes not yet returned
+	buffered []byte
+	err      error
+}
+
+// NewPadReader returns a reader producing Fr32 padded data from the unpadded data read from r.
+// Length of the data read from r has to be a multiple of 127 bytes, otherwise reading
+// results in io.ErrUnexpectedEOF.
+func NewPadReader(r io.Reader) io.Reader {
+	return &padReader{
+		src:      r,
+		unpadded: make([]byte, 127*chunksPerBatch),
+		padded:   make([]byte, 128*chunksPerBatch),
+	}
+}
+
+func (pr *padReader) Read(b []byte) (int, error) {
+	if len(pr.buffered) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		n, err := io.ReadFull(pr.src, pr.unpadded)
+		switch {
+		case err == io.ErrUnexpectedEOF && n%127 == 0:
+			pr.err = io.EOF
+		case err == io.ErrUnexpectedEOF:
+			pr.err = xerrors.Errorf("unpadded data is not a multiple of 127 bytes: %w", io.ErrUnexpectedEOF)
+			n -= n % 127
+		case err != nil:
+			pr.err = err
+		}
+		chunks := n / 127
+		Pad(pr.unpadded[:chunks*127], pr.padded[:chunks*128])
+		pr.buffered = pr.padded[:chunks*128]
+		if len(pr.buffered) == 0 {
+			return 0, pr.err
+		}
+	}
+
+	n := copy(b, pr.buffered)
+	pr.buffered = pr.buffered[n:]
+	return n, nil
+}
+
+type unpadWriter struct {
+	dst      io.Writer
+	padded   []byte
+	unpadded []byte
+	// used is the number of bytes in padded waiting to be unpadded
+	used int
+}
+
+// NewUnpadWriter returns a writer which removes Fr32 padding from the data written to it
+// and writes the unpadded data to w.
+// Close has to be called to check that the written data was a multiple of 128 bytes,
+// it does not close w.
+func NewUnpadWriter(w io.Writer) io.WriteCloser {
+	return &unpadWriter{
+		dst:      w,
+		padded:   make([]byte, 128*chunksPerBatch),
+		unpadded: make([]byte, 127*chunksPerBatch),
+	}
+}
+
+func (uw *unpadWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := copy(uw.padded[uw.used:], b)
+		uw.used += n
+		b = b[n:]
+
+		if err := uw.flush(); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// flush unpads and writes out all complete chunks
+func (uw *unpadWriter) flush() error {
+	chunks := uw.used / 128
+	if chunks == 0 {
+		return nil
+	}
+	Unpad(uw.unpadded[:chunks*127], uw.padded[:chunks*128])
+	if _, err := uw.dst.Write(uw.unpadded[:chunks*127]); err != nil {
+		return err
+	}
+	uw.used = copy(uw.padded, uw.padded[chunks*128:uw.used])
+	return nil
+}
+
+func (uw *unpadWriter) Close() error {
+	if uw.used != 0 {
+		return xerrors.Errorf("padded data is not a multiple of 128 bytes, %d bytes left", uw.used)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 func main() {
 	if err := cbg.WriteTupleEncodersToFile("merkletree/cbor_gen.go", "merkletree",
 		merkletree.ProofDataSerialization{},
+		merkletree.CompressedProofDataSerialization{},
 	); err != nil {
 		panic(err)
 	}
@@ -21,6 +22,11 @@ func main() {
 
 		datasegment.SegmentDesc{},
 		datasegment.IndexData{},
+		datasegment.AggregateManifest{},
+		datasegment.ManifestPiece{},
+		datasegment.SegmentRecord{},
+		datasegment.SegmentMetadata{},
+		datasegment.SegmentMetadataTable{},
 	); err != nil {
 		panic(err)
 	}
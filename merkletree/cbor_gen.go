@@ -93,3 +93,139 @@ func (t *ProofDataSerialization) UnmarshalCBOR(r io.Reader) (err error) {
 	}
 	return nil
 }
+
+var lengthBufCompressedProofDataSerialization = []byte{133}
+
+func (t *CompressedProofDataSerialization) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write(lengthBufCompressedProofDataSerialization); err != nil {
+		return err
+	}
+
+	// t.Level (uint64) (uint64)
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Level)); err != nil {
+		return err
+	}
+
+	// t.Depth (uint64) (uint64)
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Depth)); err != nil {
+		return err
+	}
+
+	// t.Index (uint64) (uint64)
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Index)); err != nil {
+		return err
+	}
+
+	// t.Present (uint64) (uint64)
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(t.Present)); err != nil {
+		return err
+	}
+
+	// t.Nodes (merkletree.nodeArray) (struct)
+	if err := t.Nodes.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *CompressedProofDataSerialization) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = CompressedProofDataSerialization{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajArray {
+		return fmt.Errorf("cbor input should be of type array")
+	}
+
+	if extra != 5 {
+		return fmt.Errorf("cbor input had wrong number of fields")
+	}
+
+	// t.Level (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Level = uint64(extra)
+
+	}
+	// t.Depth (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Depth = uint64(extra)
+
+	}
+	// t.Index (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Index = uint64(extra)
+
+	}
+	// t.Present (uint64) (uint64)
+
+	{
+
+		maj, extra, err = cr.ReadHeader()
+		if err != nil {
+			return err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return fmt.Errorf("wrong type for uint64 field")
+		}
+		t.Present = uint64(extra)
+
+	}
+	// t.Nodes (merkletree.nodeArray) (struct)
+
+	{
+
+		if err := t.Nodes.UnmarshalCBOR(cr); err != nil {
+			return xerrors.Errorf("unmarshaling t.Nodes: %w", err)
+		}
+
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package merkletree
+
+import (
+	"io"
+	"math/bits"
+
+	"golang.org/x/xerrors"
+)
+
+// CompressedProofData is a ProofData with path nodes equal to ZeroCommitmentForLevel omitted.
+// Proofs in sparsely filled trees consist mostly of zero commitments.
+type CompressedProofData struct {
+	// Level is the level of the node the proof is for, counted from the leaf layer
+	Level uint64
+	// Depth is the length of the uncompressed path
+	Depth uint64
+	Index uint64
+	// Present is a bitmap of path nodes which are not zero commitments, bit i is set if
+	// the i-th path node is included in Nodes
+	Present uint64
+	// Nodes are the path nodes which are not zero commitments
+	Nodes []Node
+}
+
+// Compress omits zero commitments from the path of the proof.
+// The level is the level of the node the proof is for, counted from the leaf layer,
+// it is needed to determine which zero commitment is expected at each path position.
+func (d ProofData) Compress(level int) (CompressedProofData, error) {
+	if d.Depth() > maxProofDepth {
+		return CompressedProofData{}, xerrors.Errorf("proof too deep: %d > %d", d.Depth(), maxProofDepth)
+	}
+	if level < 0 || level > ZeroCommitmentLevels || d.Depth() > ZeroCommitmentLevels-level {
+		return CompressedProofData{}, xerrors.Errorf("level out of range: %d", level)
+	}
+
+	res := CompressedProofData{
+		Level: uint64(level),
+		Depth: uint64(d.Depth()),
+		Index: d.Index,
+	}
+	for i, n := range d.Path {
+		if n == ZeroCommitmentForLevel(level+i) {
+			continue
+		}
+		res.Present |= 1 << i
+		res.Nodes = append(res.Nodes, n)
+	}
+	return res, nil
+}
+
+// Decompress restores the full proof
+func (c CompressedProofData) Decompress() (ProofData, error) {
+	if c.Depth > maxProofDepth {
+		return ProofData{}, xerrors.Errorf("proof too deep: %d > %d", c.Depth, maxProofDepth)
+	}
+	// Level is untrusted, it is compared without overflowing
+	if c.Level > ZeroCommitmentLevels || c.Depth > ZeroCommitmentLevels-c.Level {
+		return ProofData{}, xerrors.Errorf("level out of range: %d", c.Level)
+	}
+	if c.Present>>c.Depth != 0 {
+		return ProofData{}, xerrors.Errorf("bitmap of present nodes exceeds the depth")
+	}
+	if n := bits.OnesCount64(c.Present); n != len(c.Nodes) {
+		return ProofData{}, xerrors.Errorf("number of nodes doesn't match the bitmap: %d != %d", len(c.Nodes), n)
+	}
+
+	res := ProofData{Index: c.Index}
+	if c.Depth > 0 {
+		res.Path = make([]Node, c.Depth)
+	}
+	nodes := c.Nodes
+	for i := range res.Path {
+		if c.Present&(1<<i) != 0 {
+			res.Path[i], nodes = nodes[0], nodes[1:]
+		} else {
+			res.Path[i] = ZeroCommitmentForLevel(int(c.Level) + i)
+		}
+	}
+	return res, nil
+}
+
+// CompressedProofDataSerialization is the CBOR representation of CompressedProofData
+type CompressedProofDataSerialization struct {
+	Level   uint64
+	Depth   uint64
+	Index   uint64
+	Present uint64
+	Nodes   nodeArray
+}
+
+func (c *CompressedProofData) MarshalCBOR(w io.Writer) error {
+	var cs *CompressedProofDataSerialization
+	if c != nil {
+		cs = &CompressedProofDataSerialization{
+			Level:   c.Level,
+			Depth:   c.Depth,
+			Index:   c.Index,
+			Present: c.Present,
+			Nodes:   nodeArray{nodes: c.Nodes},
+		}
+	}
+	return cs.MarshalCBOR(w)
+}
+
+func (c *CompressedProofData) UnmarshalCBOR(r io.Reader) error {
+	var cs CompressedProofDataSerialization
+	if err := cs.UnmarshalCBOR(r); err != nil {
+		return err
+	}
+	*c = CompressedProofData{
+		Level:   cs.Level,
+		Depth:   cs.Depth,
+		Index:   cs.Index,
+		Present: cs.Present,
+		Nodes:   cs.Nodes.nodes,
+	}
+	return nil
+}
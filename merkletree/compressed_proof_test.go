@@ -0,0 +1,63 @@
+package merkletree
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedProof(t *testing.T) {
+	ht, err := NewHybrid(20)
+	require.NoError(t, err)
+	require.NoError(t, ht.SetNode(3, 5, &Node{0x1}))
+	require.NoError(t, ht.SetNode(3, 4, &Node{0x2}))
+	require.NoError(t, ht.SetNode(10, 1000, &Node{0x3}))
+
+	proof, err := ht.CollectProof(3, 5)
+	require.NoError(t, err)
+	c, err := proof.Compress(3)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(17), c.Depth)
+	assert.Equal(t, uint64(1<<16|1), c.Present)
+	assert.Len(t, c.Nodes, 2)
+
+	decompressed, err := c.Decompress()
+	require.NoError(t, err)
+	assert.Equal(t, proof, decompressed)
+
+	var fullBuf, compressedBuf bytes.Buffer
+	require.NoError(t, proof.MarshalCBOR(&fullBuf))
+	require.NoError(t, c.MarshalCBOR(&compressedBuf))
+	assert.Less(t, compressedBuf.Len()*4, fullBuf.Len())
+
+	var c2 CompressedProofData
+	require.NoError(t, c2.UnmarshalCBOR(&compressedBuf))
+	assert.Equal(t, c, c2)
+
+	empty, err := ProofData{}.Compress(0)
+	require.NoError(t, err)
+	assert.Equal(t, ProofData{}, Must(empty.Decompress()))
+
+	_, err = proof.Compress(50)
+	assert.Error(t, err)
+
+	broken := c
+	broken.Nodes = broken.Nodes[:1]
+	_, err = broken.Decompress()
+	assert.Error(t, err)
+	broken = c
+	broken.Present |= 1 << 20
+	_, err = broken.Decompress()
+	assert.Error(t, err)
+
+	// the sum of level and depth overflows
+	_, err = CompressedProofData{Level: ^uint64(0), Depth: 1}.Decompress()
+	assert.ErrorContains(t, err, "level out of range")
+	_, err = CompressedProofData{Level: ^uint64(0) - 1, Depth: 2, Present: 1, Nodes: []Node{{}}}.Decompress()
+	assert.ErrorContains(t, err, "level out of range")
+	_, err = proof.Compress(math.MaxInt)
+	assert.ErrorContains(t, err, "level out of range")
+}
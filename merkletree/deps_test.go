@@ -0,0 +1,28 @@
+package merkletree
+
+import (
+	"go/build"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStandaloneDeps keeps the tree and its helper packages usable without the Filecoin
+// state types and the datasegment package
+func TestStandaloneDeps(t *testing.T) {
+	forbidden := []string{
+		"github.com/filecoin-project/go-state-types",
+		"github.com/filecoin-project/go-data-segment/datasegment",
+	}
+	for _, dir := range []string{".", "../fr32", "../util"} {
+		pkg, err := build.ImportDir(dir, 0)
+		require.NoError(t, err)
+		for _, imp := range pkg.Imports {
+			for _, f := range forbidden {
+				assert.False(t, strings.HasPrefix(imp, f), "%s imports %s", pkg.ImportPath, imp)
+			}
+		}
+	}
+}
@@ -28,8 +28,11 @@ func (nd *ProofData) UnmarshalCBOR(r io.Reader) error {
 		return err
 	}
 
-	nd.Index = pds.Index
-	nd.Path = pds.Path.nodes
+	pd := ProofData{Index: pds.Index, Path: pds.Path.nodes}
+	if err := pd.Validate(); err != nil {
+		return xerrors.Errorf("invalid proof: %w", err)
+	}
+	*nd = pd
 	return nil
 }
 
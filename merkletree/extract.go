@@ -0,0 +1,40 @@
+package merkletree
+
+import (
+	xerrors "golang.org/x/xerrors"
+)
+
+// MaxExtractLevel is the highest level of a subtree ExtractSubtree materializes,
+// a subtree of that level holds 1Mi leafs
+const MaxExtractLevel = 20
+
+// SubtreeRoot returns the root of the subtree at level and index, it is equal to GetNode
+func (ht Hybrid) SubtreeRoot(level int, idx uint64) (Node, error) {
+	return ht.GetNode(level, idx)
+}
+
+// ExtractSubtree materializes the subtree with the root at level and index as a dense TreeData,
+// for example the region of a single data segment. Zero nodes are filled with the zero
+// commitments of their level, so the result validates. The level is limited by MaxExtractLevel.
+func (ht Hybrid) ExtractSubtree(level int, idx uint64) (*TreeData, error) {
+	if err := ht.validateLevelIndex(level, idx); err != nil {
+		return nil, xerrors.Errorf("invalid subtree: %w", err)
+	}
+	if level > MaxExtractLevel {
+		return nil, xerrors.Errorf("subtree too large to extract: level %d > %d", level, MaxExtractLevel)
+	}
+
+	tree := newBareTree(uint64(1) << level)
+	for depth := 0; depth <= level; depth++ {
+		start := idx << depth
+		nodes := tree.nodes[depth]
+		for i := range nodes {
+			n, err := ht.GetNode(level-depth, start+uint64(i))
+			if err != nil {
+				return nil, xerrors.Errorf("getting node: %w", err)
+			}
+			nodes[i] = n
+		}
+	}
+	return tree, nil
+}
@@ -0,0 +1,44 @@
+package merkletree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractSubtree(t *testing.T) {
+	ht, err := NewHybrid(12)
+	require.NoError(t, err)
+	leafs := make([]Node, 8)
+	for i := range leafs {
+		leafs[i] = Node{byte(i + 1)}
+	}
+	require.NoError(t, ht.SetLeafRange(64+4, leafs))
+
+	sub, err := ht.ExtractSubtree(4, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 5, sub.Depth())
+	assert.Equal(t, uint64(16), sub.LeafCount())
+	assert.True(t, sub.Validate())
+	assert.Equal(t, Must(ht.SubtreeRoot(4, 4)), *sub.Root())
+	assert.Equal(t, leafs, sub.Leafs()[4:12])
+
+	// proofs within the subtree compose with the proof of the subtree root
+	inner, err := sub.ConstructProof(4, 5)
+	require.NoError(t, err)
+	outer, err := ht.CollectProof(4, 4)
+	require.NoError(t, err)
+	full := ProofData{Path: append(inner.Path, outer.Path...), Index: 4<<4 | 5}
+	root := ht.Root()
+	require.NoError(t, full.ValidateSubtree(&leafs[1], &root))
+
+	empty, err := ht.ExtractSubtree(2, 100)
+	require.NoError(t, err)
+	assert.Equal(t, ZeroCommitmentForLevel(2), *empty.Root())
+
+	_, err = ht.ExtractSubtree(MaxExtractLevel+1, 0)
+	assert.Error(t, err)
+	_, err = ht.ExtractSubtree(4, 1<<8)
+	assert.Error(t, err)
+}
@@ -1,6 +1,9 @@
 package merkletree
 
 import (
+	"fmt"
+
+	"github.com/filecoin-project/go-data-segment/util"
 	"golang.org/x/xerrors"
 )
 
@@ -11,6 +14,10 @@ type Hybrid struct {
 	// The root node of a the tree is stored at position [1].
 	log2Leafs int
 	data      SparseArray[Node]
+	// generation is incremented on every mutation of the tree
+	generation uint64
+	// pruned are the locations whose descendants were dropped by Prune
+	pruned []Location
 }
 
 // Location represents a location in the MerkleTree
@@ -24,6 +31,38 @@ func (l Location) LeafIndex() uint64 {
 	return l.Index << l.Level
 }
 
+// ByteOffset returns the offset of the first byte under the Location in padded bytes
+func (l Location) ByteOffset() uint64 {
+	return l.LeafIndex() * NodeSize
+}
+
+// Size returns the number of padded bytes under the Location.
+// datasegment.LocationPaddedSize returns it as a padded piece size.
+func (l Location) Size() uint64 {
+	return uint64(NodeSize) << l.Level
+}
+
+// String formats the Location with the range of padded bytes under it. The format is stable.
+func (l Location) String() string {
+	return fmt.Sprintf("Location{Level: %d, Index: %d, Offset: %d, Size: %d}", l.Level, l.Index, l.ByteOffset(), l.Size())
+}
+
+// LocationForOffsetSize returns the Location of a subtree covering size padded bytes
+// starting at offset. The size has to be a power of two, at least NodeSize, and the offset
+// has to be aligned to the size.
+func LocationForOffsetSize(offset, size uint64) (Location, error) {
+	if size < NodeSize || !util.IsPow2(size) {
+		return Location{}, xerrors.Errorf("size has to be a power of two of at least %d: %d", NodeSize, size)
+	}
+	if offset%size != 0 {
+		return Location{}, xerrors.Errorf("offset %d is not aligned to size %d", offset, size)
+	}
+	return Location{
+		Level: util.Log2Floor(size / NodeSize),
+		Index: offset / size,
+	}, nil
+}
+
 func NewHybrid(log2Leafs int) (Hybrid, error) {
 	if log2Leafs > 60 {
 		return Hybrid{}, xerrors.Errorf("too many leafs: 2^%d", log2Leafs)
@@ -34,6 +73,25 @@ func NewHybrid(log2Leafs int) (Hybrid, error) {
 	return Hybrid{log2Leafs: log2Leafs}, nil
 }
 
+// Clone returns a copy of the tree which can be modified independently of the original.
+// Sparse blocks are shared between the trees until either of them writes to a block,
+// at which point the block is copied.
+func (ht *Hybrid) Clone() Hybrid {
+	return Hybrid{
+		log2Leafs:  ht.log2Leafs,
+		data:       ht.data.clone(),
+		generation: ht.generation,
+		pruned:     append([]Location(nil), ht.pruned...),
+	}
+}
+
+// Generation returns a counter incremented with every mutation of the tree through SetNode,
+// BatchSet, SetLeafRange or Prune. It can be used to invalidate values derived from the tree,
+// such as the root, after incremental updates.
+func (ht Hybrid) Generation() uint64 {
+	return ht.generation
+}
+
 func (ht Hybrid) MaxLevel() int {
 	return ht.log2Leafs
 }
@@ -46,7 +104,9 @@ func (ht Hybrid) Root() Node {
 	return n
 }
 
-// CollectProof collects a proof from the specified node to the root of the tree
+// CollectProof collects a proof from the specified node to the root of the tree.
+// It reads the stored path nodes without hashing, so after the tree was mutated a proof
+// is updated by collecting it again.
 func (ht Hybrid) CollectProof(level int, idx uint64) (ProofData, error) {
 	if err := ht.validateLevelIndex(level, idx); err != nil {
 		return ProofData{}, xerrors.Errorf("CollectProof input check: %w", err)
@@ -82,6 +142,9 @@ func (ht Hybrid) getNodeRaw(level int, idx uint64) (Node, error) {
 	if err := ht.validateLevelIndex(level, idx); err != nil {
 		return Node{}, xerrors.Errorf("in getNodeRaw: %w", err)
 	}
+	if p, ok := ht.prunedAncestor(level, idx); ok {
+		return Node{}, xerrors.Errorf("node at level %d, index %d was pruned below %s", level, idx, p)
+	}
 	return ht.data.Get(ht.idxFor(level, idx)), nil
 }
 func (ht Hybrid) validateLevelIndex(level int, idx uint64) error {
@@ -123,6 +186,9 @@ func (ht *Hybrid) SetNode(level int, idx uint64, n *Node) error {
 	if err := ht.validateLevelIndex(level, idx); err != nil {
 		return xerrors.Errorf("in SetNode: %w", err)
 	}
+	if p, ok := ht.prunedAncestor(level, idx); ok {
+		return xerrors.Errorf("cannot set node below pruned %s", p)
+	}
 	// verify that subtrees of this node are empty
 	if level > 0 {
 		left, err := ht.getNodeRaw(level-1, 2*idx)
@@ -141,42 +207,208 @@ func (ht *Hybrid) SetNode(level int, idx uint64, n *Node) error {
 		}
 	}
 
+	ht.generation++
 	ht.data.Set(ht.idxFor(level, idx), n)
 
 	curIdx := idx
 	for i := level; i < ht.MaxLevel(); i++ {
-		nextIndex := curIdx >> 1
+		curIdx >>= 1
+		if err := ht.recomputeNode(i+1, curIdx); err != nil {
+			return xerrors.Errorf("updating node: %w", err)
+		}
+	}
 
-		left, err := ht.getNodeRaw(i, curIdx&^1) // clear the lowest bit of index for left node
-		if err != nil {
-			return xerrors.Errorf("getting left node during update: %w", err)
+	return nil
+}
+
+// SetLeafRange writes a contiguous run of leaves starting at startIdx.
+// The leaves are written directly into the sparse blocks and each affected ancestor
+// is recomputed only once.
+func (ht *Hybrid) SetLeafRange(startIdx uint64, nodes []Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	endIdx := startIdx + uint64(len(nodes)) - 1
+	if endIdx < startIdx {
+		return xerrors.Errorf("leaf range overflows: start %d, length %d", startIdx, len(nodes))
+	}
+	if err := ht.validateLevelIndex(0, endIdx); err != nil {
+		return xerrors.Errorf("in SetLeafRange: %w", err)
+	}
+	for _, p := range ht.pruned {
+		if startIdx>>p.Level <= p.Index && p.Index <= endIdx>>p.Level {
+			return xerrors.Errorf("cannot set leafs below pruned %s", p)
 		}
+	}
 
-		right, err := ht.getNodeRaw(i, curIdx|1) // set the lowest bit of index for right now
+	ht.generation++
+	// leafs within one subtree are stored contiguously in a single sparse block
+	width := uint64(1) << (ht.log2Leafs % SparseBlockLog2Size)
+	for written := 0; written < len(nodes); {
+		idx := startIdx + uint64(written)
+		n := len(nodes) - written
+		if rem := width - idx%width; uint64(n) > rem {
+			n = int(rem)
+		}
+		ref, err := ht.data.GetSliceRef(ht.idxFor(0, idx), n)
 		if err != nil {
-			return xerrors.Errorf("getting right node during update: %w", err)
+			return xerrors.Errorf("getting slice of leafs at %d: %w", idx, err)
+		}
+		copy(ref, nodes[written:written+n])
+		written += n
+	}
+
+	lo, hi := startIdx, endIdx
+	for l := 1; l <= ht.MaxLevel(); l++ {
+		lo, hi = lo>>1, hi>>1
+		for idx := lo; idx <= hi; idx++ {
+			if err := ht.recomputeNode(l, idx); err != nil {
+				return xerrors.Errorf("updating node: %w", err)
+			}
 		}
+	}
+	return nil
+}
+
+// recomputeNode updates the node at given level and index based on its children
+func (ht *Hybrid) recomputeNode(level int, idx uint64) error {
+	left, err := ht.getNodeRaw(level-1, 2*idx)
+	if err != nil {
+		return xerrors.Errorf("getting left node during update: %w", err)
+	}
+
+	right, err := ht.getNodeRaw(level-1, 2*idx+1)
+	if err != nil {
+		return xerrors.Errorf("getting right node during update: %w", err)
+	}
+
+	if left.IsZero() && right.IsZero() {
+		ht.data.Set(ht.idxFor(level, idx), &Node{})
+		return nil
+	}
+
+	zC := ZeroCommitmentForLevel(level - 1)
+	if left.IsZero() {
+		left = zC
+	}
+	if right.IsZero() {
+		right = zC
+	}
 
-		if left.IsZero() && right.IsZero() {
-			ht.data.Set(ht.idxFor(i+1, nextIndex), &Node{})
-			curIdx = nextIndex
+	n := PairHash(&left, &right)
+	ht.data.Set(ht.idxFor(level, idx), &n)
+	return nil
+}
+
+// Prune drops the nodes below the node at given level and index, keeping the node itself and
+// its ancestors, so the root and proofs of nodes outside of the subtree remain available.
+// Proofs of nodes below the kept node can no longer be collected and the subtree can no longer
+// be modified, reads of its nodes fail. Memory is reclaimed in whole sparse blocks, pruning
+// levels up to SparseBlockLog2Size above the leaves may not free any.
+// A pruned tree cannot be serialized.
+func (ht *Hybrid) Prune(level int, idx uint64) error {
+	if err := ht.validateLevelIndex(level, idx); err != nil {
+		return xerrors.Errorf("in Prune: %w", err)
+	}
+	if level == 0 {
+		return nil
+	}
+	if _, ok := ht.prunedAncestor(level, idx); ok {
+		return nil
+	}
+
+	ht.generation++
+	keptDepth := ht.log2Leafs - level
+	for block := range ht.data.subs {
+		// a block holds the subtree of depth SparseBlockLog2Size rooted at rootDepth
+		layer := subtreeLayerOfBlock(block)
+		rootDepth := layer * SparseBlockLog2Size
+		if rootDepth <= keptDepth {
 			continue
 		}
+		layerStart := (uint64(1)<<(layer*SparseBlockLog2Size) - 1) / (SparseBlockSize - 1)
+		if (block-layerStart)>>(rootDepth-keptDepth) == idx {
+			delete(ht.data.subs, block)
+			delete(ht.data.shared, block)
+		}
+	}
 
-		zC := ZeroCommitmentForLevel(i)
-		if left.IsZero() {
-			left = zC
+	// pruned locations below the new one are covered by it
+	kept := ht.pruned[:0]
+	for _, p := range ht.pruned {
+		if p.Level > level || p.Index>>(level-p.Level) != idx {
+			kept = append(kept, p)
 		}
-		if right.IsZero() {
-			right = zC
+	}
+	ht.pruned = append(kept, Location{Level: level, Index: idx})
+	return nil
+}
+
+// Pruned returns the locations whose descendants were dropped by Prune
+func (ht Hybrid) Pruned() []Location {
+	return append([]Location(nil), ht.pruned...)
+}
+
+// prunedAncestor returns the pruned location strictly above the node, if there is one
+func (ht Hybrid) prunedAncestor(level int, idx uint64) (Location, bool) {
+	for _, p := range ht.pruned {
+		if p.Level > level && idx>>(p.Level-level) == p.Index {
+			return p, true
 		}
+	}
+	return Location{}, false
+}
+
+// HybridStats describes the memory use and occupancy of a Hybrid tree
+type HybridStats struct {
+	// Blocks is the number of allocated sparse blocks
+	Blocks int
+	// ResidentBytes is the memory held by the allocated sparse blocks
+	ResidentBytes uint64
+	// OccupiedNodes is the number of non-zero nodes stored at each level, indexed by level
+	OccupiedNodes []uint64
+	// FillRatio is the fraction of node slots in the allocated blocks which are occupied
+	FillRatio float64
+}
 
-		n := computeNode(&left, &right)
-		ht.data.Set(ht.idxFor(i+1, nextIndex), n)
-		curIdx = nextIndex
+// Stats computes HybridStats of the tree, it iterates over all allocated blocks
+func (ht Hybrid) Stats() HybridStats {
+	res := HybridStats{
+		Blocks:        len(ht.data.subs),
+		ResidentBytes: uint64(len(ht.data.subs)) * SparseBlockSize * NodeSize,
+		OccupiedNodes: make([]uint64, ht.MaxLevel()+1),
 	}
 
-	return nil
+	var occupied uint64
+	for block, sub := range ht.data.subs {
+		blockDepth := subtreeLayerOfBlock(block) * SparseBlockLog2Size
+		// position 0 of a block is unused, see idxFor
+		for i := 1; i < len(sub); i++ {
+			if sub[i].IsZero() {
+				continue
+			}
+			level := ht.log2Leafs - (blockDepth + util.Log2Floor(uint64(i)))
+			if level < 0 {
+				continue
+			}
+			res.OccupiedNodes[level]++
+			occupied++
+		}
+	}
+	if res.Blocks != 0 {
+		res.FillRatio = float64(occupied) / float64(res.Blocks*(SparseBlockSize-1))
+	}
+	return res
+}
+
+// subtreeLayerOfBlock returns the layer of subtrees, counted from the root, the block belongs to
+func subtreeLayerOfBlock(block uint64) int {
+	// layer d starts at block sum(SparseBlockSize^N, {N, 0, d-1})
+	layer := 0
+	for start, width := uint64(1), uint64(SparseBlockSize); block >= start; start, width = start+width, width*SparseBlockSize {
+		layer++
+	}
+	return layer
 }
 
 // CommAndLoc represents Commitment and Location
@@ -206,6 +438,8 @@ const SparseBlockSize = 1 << SparseBlockLog2Size
 
 type SparseArray[T any] struct {
 	subs map[uint64][]T
+	// shared marks blocks which might be referenced by a clone and have to be copied before writing
+	shared map[uint64]struct{}
 }
 
 func (sa SparseArray[T]) Get(index uint64) T {
@@ -224,18 +458,52 @@ func (sa SparseArray[T]) Get(index uint64) T {
 
 func (sa *SparseArray[T]) initSubs() {
 	sa.subs = make(map[uint64][]T)
+	sa.shared = nil
 }
 
-// Set returns the old value
-func (sa *SparseArray[T]) Set(index uint64, val *T) T {
+// clone returns a copy of the array sharing all blocks with the original,
+// the blocks are marked as shared in both arrays
+func (sa *SparseArray[T]) clone() SparseArray[T] {
+	if len(sa.subs) == 0 {
+		return SparseArray[T]{}
+	}
+	if sa.shared == nil {
+		sa.shared = make(map[uint64]struct{}, len(sa.subs))
+	}
+	res := SparseArray[T]{
+		subs:   make(map[uint64][]T, len(sa.subs)),
+		shared: make(map[uint64]struct{}, len(sa.subs)),
+	}
+	for k, sub := range sa.subs {
+		res.subs[k] = sub
+		res.shared[k] = struct{}{}
+		sa.shared[k] = struct{}{}
+	}
+	return res
+}
+
+// writableSub returns the block for writing, allocating or copying it if necessary
+func (sa *SparseArray[T]) writableSub(block uint64) []T {
 	if sa.subs == nil {
 		sa.initSubs()
 	}
-	sub, ok := sa.subs[index/SparseBlockSize]
+	sub, ok := sa.subs[block]
 	if !ok {
 		sub = make([]T, SparseBlockSize)
-		sa.subs[index/SparseBlockSize] = sub
+		sa.subs[block] = sub
+		return sub
 	}
+	if _, ok := sa.shared[block]; ok {
+		sub = append(make([]T, 0, SparseBlockSize), sub...)
+		sa.subs[block] = sub
+		delete(sa.shared, block)
+	}
+	return sub
+}
+
+// Set returns the old value
+func (sa *SparseArray[T]) Set(index uint64, val *T) T {
+	sub := sa.writableSub(index / SparseBlockSize)
 	res := sub[index%SparseBlockSize]
 
 	sub[index%SparseBlockSize] = *val
@@ -246,14 +514,8 @@ func (sa *SparseArray[T]) GetSliceRef(index uint64, length int) ([]T, error) {
 	if index/SparseBlockSize != (index+uint64(length)-1)/SparseBlockSize {
 		return nil, xerrors.Errorf("requested slice does not align with one sparse block")
 	}
-	if sa.subs == nil {
-		sa.subs = make(map[uint64][]T)
-	}
-	sub, ok := sa.subs[index/SparseBlockSize]
-	if !ok {
-		sub = make([]T, SparseBlockSize)
-		sa.subs[index/SparseBlockSize] = sub
-	}
+	sub := sa.writableSub(index / SparseBlockSize)
 
-	return sub[index%SparseBlockSize : (index+uint64(length))%SparseBlockSize], nil
+	start := index % SparseBlockSize
+	return sub[start : start+uint64(length)], nil
 }
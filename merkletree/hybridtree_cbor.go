@@ -71,54 +71,11 @@ func (h *Hybrid) UnmarshalCBOR(r io.Reader) (err error) {
 
 		h.data.initSubs()
 		for i := uint64(0); i < mapItems; i++ {
-			maj, extra, err := cr.ReadHeader()
+			index, sparseBlock, err := readSparseBlock(cr)
 			if err != nil {
 				return err
 			}
-			if maj != cbg.MajUnsignedInt {
-				return fmt.Errorf("wrong type for uint field")
-			}
-
-			index := extra
-
-			maj, extra, err = cr.ReadHeader()
-			if err != nil {
-				return err
-			}
-
-			if maj != cbg.MajArray {
-				return fmt.Errorf("wrong type for int field")
-			}
-			if extra != SparseBlockSize {
-				return fmt.Errorf("incompatible sparse block size")
-			}
-			sparseBlock := make([]Node, SparseBlockSize)
-
-			for j := 0; j < SparseBlockSize; j++ {
-				b, err := cr.ReadByte()
-				if err != nil {
-					return err
-				}
-				if b == cbg.CborNull[0] {
-					continue
-				}
-				if err := cr.UnreadByte(); err != nil {
-					return err
-				}
-				maj, extra, err := cr.ReadHeader()
-				if err != nil {
-					return err
-				}
-				if maj != cbg.MajByteString {
-					return xerrors.Errorf("wrong type for Node")
-				}
-				if extra != NodeSize {
-					return xerrors.Errorf("wrong size for Node")
-				}
-				io.ReadFull(cr, sparseBlock[j][:])
-			}
 			h.data.subs[index] = sparseBlock
-
 		}
 
 	}
@@ -130,6 +87,9 @@ func (h *Hybrid) MarshalCBOR(w io.Writer) error {
 		_, err := w.Write(cbg.CborNull)
 		return err
 	}
+	if len(h.pruned) != 0 {
+		return xerrors.Errorf("pruned tree cannot be serialized")
+	}
 
 	cw := cbg.NewCborWriter(w)
 	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(2)); err != nil {
@@ -152,36 +112,12 @@ func (h *Hybrid) MarshalCBOR(w io.Writer) error {
 
 	slices.Sort(indexes)
 
-	writeSub := func(idx uint64, sub []Node) error {
-		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(idx)); err != nil {
-			return err
-		}
-		if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(sub))); err != nil {
-			return err
-		}
-		for _, s := range sub {
-			var err error
-
-			if s == (Node{}) {
-				_, err = cw.Write(cbg.CborNull)
-			} else {
-				err = cbg.WriteByteArray(cw, s[:])
-			}
-
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
-	}
-
 	if err := cw.WriteMajorTypeHeader(cbg.MajMap, uint64(len(indexes))); err != nil {
 		return err
 	}
 
 	for _, idx := range indexes {
-		if err := writeSub(idx, h.data.subs[idx]); err != nil {
+		if err := writeSparseBlock(cw, idx, h.data.subs[idx]); err != nil {
 			return xerrors.Errorf("writing sub: %w", err)
 		}
 	}
@@ -0,0 +1,264 @@
+package merkletree
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	xerrors "golang.org/x/xerrors"
+)
+
+// DefaultStreamBufferSize is the size of the buffer used by EncodeStream and DecodeHybridStream
+const DefaultStreamBufferSize = 1 << 20
+
+// StreamOption configures EncodeStream and DecodeHybridStream
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	bufferSize   int
+	compressor   func(io.Writer) (io.WriteCloser, error)
+	decompressor func(io.Reader) (io.Reader, error)
+}
+
+// WithStreamBufferSize sets the size of the buffer between the stream and the underlying
+// writer or reader, bounding the memory used for buffering
+func WithStreamBufferSize(size int) StreamOption {
+	return func(o *streamOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithCompression compresses the stream written by EncodeStream with the writer created by
+// compressor, e.g. a zstd encoder, and decompresses the stream read by DecodeHybridStream with
+// the reader created by decompressor. The writer is closed at the end of EncodeStream.
+func WithCompression(compressor func(io.Writer) (io.WriteCloser, error), decompressor func(io.Reader) (io.Reader, error)) StreamOption {
+	return func(o *streamOptions) {
+		o.compressor = compressor
+		o.decompressor = decompressor
+	}
+}
+
+func makeStreamOptions(opts []StreamOption) (streamOptions, error) {
+	options := streamOptions{bufferSize: DefaultStreamBufferSize}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.bufferSize <= 0 {
+		return streamOptions{}, xerrors.Errorf("buffer size has to be positive: %d", options.bufferSize)
+	}
+	return options, nil
+}
+
+// maxBlocks returns the number of sparse blocks a fully populated tree occupies
+func (ht Hybrid) maxBlocks() uint64 {
+	return ht.idxFor(0, uint64(1)<<ht.log2Leafs-1)/SparseBlockSize + 1
+}
+
+// EncodeStream writes the tree in the same CBOR encoding as MarshalCBOR, one sparse block at
+// a time, through a buffer of bounded size. Unlike MarshalCBOR, the size of the tree is only
+// limited by the number of its levels. The context is checked between blocks.
+func (ht *Hybrid) EncodeStream(ctx context.Context, w io.Writer, opts ...StreamOption) (err error) {
+	options, err := makeStreamOptions(opts)
+	if err != nil {
+		return err
+	}
+	if ht.log2Leafs < 0 {
+		return xerrors.Errorf("log2Levels cannot be negative")
+	}
+	if len(ht.pruned) != 0 {
+		return xerrors.Errorf("pruned tree cannot be serialized")
+	}
+
+	if options.compressor != nil {
+		cw, err := options.compressor(w)
+		if err != nil {
+			return xerrors.Errorf("creating compressor: %w", err)
+		}
+		defer func() {
+			if cerr := cw.Close(); cerr != nil && err == nil {
+				err = xerrors.Errorf("closing compressor: %w", cerr)
+			}
+		}()
+		w = cw
+	}
+	bw := bufio.NewWriterSize(w, options.bufferSize)
+	cw := cbg.NewCborWriter(bw)
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, 2); err != nil {
+		return err
+	}
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(ht.log2Leafs)); err != nil {
+		return err
+	}
+
+	indexes := maps.Keys(ht.data.subs)
+	slices.Sort(indexes)
+	if err := cw.WriteMajorTypeHeader(cbg.MajMap, uint64(len(indexes))); err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeSparseBlock(cw, idx, ht.data.subs[idx]); err != nil {
+			return xerrors.Errorf("writing sparse block %d: %w", idx, err)
+		}
+	}
+	return bw.Flush()
+}
+
+// DecodeHybridStream reads a tree written by EncodeStream or MarshalCBOR one sparse block at
+// a time through a buffer of bounded size. The number of blocks is limited by the number of
+// levels of the tree instead of a fixed size. The context is checked between blocks.
+func DecodeHybridStream(ctx context.Context, r io.Reader, opts ...StreamOption) (Hybrid, error) {
+	options, err := makeStreamOptions(opts)
+	if err != nil {
+		return Hybrid{}, err
+	}
+	if options.decompressor != nil {
+		r, err = options.decompressor(r)
+		if err != nil {
+			return Hybrid{}, xerrors.Errorf("creating decompressor: %w", err)
+		}
+	}
+	cr := cbg.NewCborReader(bufio.NewReaderSize(r, options.bufferSize))
+
+	ht, err := func() (Hybrid, error) {
+		maj, extra, err := cr.ReadHeader()
+		if err != nil {
+			return Hybrid{}, err
+		}
+		if maj != cbg.MajArray || extra != 2 {
+			return Hybrid{}, xerrors.Errorf("cbor input should be an array of 2 fields")
+		}
+		maj, extra, err = cr.ReadHeader()
+		if err != nil {
+			return Hybrid{}, err
+		}
+		if maj != cbg.MajUnsignedInt {
+			return Hybrid{}, xerrors.Errorf("wrong type for int field")
+		}
+		if extra > 60 {
+			return Hybrid{}, xerrors.Errorf("log2Leafs in cbor too large: %d", extra)
+		}
+		ht, err := NewHybrid(int(extra))
+		if err != nil {
+			return Hybrid{}, xerrors.Errorf("creating new empty hybrid failed: %w", err)
+		}
+
+		maj, blocks, err := cr.ReadHeader()
+		if err != nil {
+			return Hybrid{}, err
+		}
+		if maj != cbg.MajMap {
+			return Hybrid{}, xerrors.Errorf("wrong type for map field")
+		}
+		maxBlocks := ht.maxBlocks()
+		if blocks > maxBlocks {
+			return Hybrid{}, xerrors.Errorf("too many sparse blocks for the tree: %d > %d", blocks, maxBlocks)
+		}
+		if blocks == 0 {
+			return ht, nil
+		}
+
+		ht.data.initSubs()
+		for i := uint64(0); i < blocks; i++ {
+			if err := ctx.Err(); err != nil {
+				return Hybrid{}, err
+			}
+			idx, block, err := readSparseBlock(cr)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return Hybrid{}, xerrors.Errorf("reading sparse block %d: %w", i, err)
+			}
+			if idx >= maxBlocks {
+				return Hybrid{}, xerrors.Errorf("sparse block index out of the tree: %d >= %d", idx, maxBlocks)
+			}
+			if _, ok := ht.data.subs[idx]; ok {
+				return Hybrid{}, xerrors.Errorf("duplicate sparse block %d", idx)
+			}
+			ht.data.subs[idx] = block
+		}
+		return ht, nil
+	}()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return ht, err
+}
+
+// writeSparseBlock writes the index of the block and the block, zero nodes are written as null
+func writeSparseBlock(cw *cbg.CborWriter, idx uint64, sub []Node) error {
+	if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, idx); err != nil {
+		return err
+	}
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(sub))); err != nil {
+		return err
+	}
+	for _, s := range sub {
+		var err error
+		if s == (Node{}) {
+			_, err = cw.Write(cbg.CborNull)
+		} else {
+			err = cbg.WriteByteArray(cw, s[:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSparseBlock reads a block written by writeSparseBlock
+func readSparseBlock(cr *cbg.CborReader) (uint64, []Node, error) {
+	maj, idx, err := cr.ReadHeader()
+	if err != nil {
+		return 0, nil, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, nil, xerrors.Errorf("wrong type for uint field")
+	}
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return 0, nil, err
+	}
+	if maj != cbg.MajArray {
+		return 0, nil, xerrors.Errorf("wrong type for array field")
+	}
+	if extra != SparseBlockSize {
+		return 0, nil, xerrors.Errorf("incompatible sparse block size")
+	}
+
+	block := make([]Node, SparseBlockSize)
+	for j := range block {
+		b, err := cr.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if b == cbg.CborNull[0] {
+			continue
+		}
+		if err := cr.UnreadByte(); err != nil {
+			return 0, nil, err
+		}
+		maj, extra, err := cr.ReadHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+		if maj != cbg.MajByteString {
+			return 0, nil, xerrors.Errorf("wrong type for Node")
+		}
+		if extra != NodeSize {
+			return 0, nil, xerrors.Errorf("wrong size for Node")
+		}
+		if _, err := io.ReadFull(cr, block[j][:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	return idx, block, nil
+}
@@ -0,0 +1,90 @@
+package merkletree
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func streamTestTree(t *testing.T) Hybrid {
+	ht, err := NewHybrid(30)
+	require.NoError(t, err)
+	for i := uint64(0); i < 50; i++ {
+		n := Node{byte(i), 1}
+		require.NoError(t, ht.SetNode(int(i%10), i*(1<<14), &n))
+	}
+	return ht
+}
+
+func TestHybridStreamRoundtrip(t *testing.T) {
+	ht := streamTestTree(t)
+
+	var marshaled bytes.Buffer
+	require.NoError(t, ht.MarshalCBOR(&marshaled))
+	var streamed bytes.Buffer
+	require.NoError(t, ht.EncodeStream(context.Background(), &streamed, WithStreamBufferSize(64)))
+	assert.Equal(t, marshaled.Bytes(), streamed.Bytes())
+
+	decoded, err := DecodeHybridStream(context.Background(), &streamed, WithStreamBufferSize(64))
+	require.NoError(t, err)
+	assert.Equal(t, ht.Root(), decoded.Root())
+	assert.Equal(t, ht.data.subs, decoded.data.subs)
+
+	empty, err := NewHybrid(10)
+	require.NoError(t, err)
+	streamed.Reset()
+	require.NoError(t, empty.EncodeStream(context.Background(), &streamed))
+	decoded, err = DecodeHybridStream(context.Background(), &streamed)
+	require.NoError(t, err)
+	assert.Equal(t, empty.Root(), decoded.Root())
+}
+
+func TestHybridStreamCompression(t *testing.T) {
+	ht := streamTestTree(t)
+	compression := WithCompression(
+		func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	)
+
+	var plain, compressed bytes.Buffer
+	require.NoError(t, ht.EncodeStream(context.Background(), &plain))
+	require.NoError(t, ht.EncodeStream(context.Background(), &compressed, compression))
+	assert.Less(t, compressed.Len(), plain.Len())
+
+	decoded, err := DecodeHybridStream(context.Background(), &compressed, compression)
+	require.NoError(t, err)
+	assert.Equal(t, ht.data.subs, decoded.data.subs)
+}
+
+func TestHybridStreamErrors(t *testing.T) {
+	ht := streamTestTree(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	assert.ErrorIs(t, ht.EncodeStream(ctx, &buf), context.Canceled)
+
+	buf.Reset()
+	require.NoError(t, ht.EncodeStream(context.Background(), &buf))
+	encoded := buf.Bytes()
+	_, err := DecodeHybridStream(ctx, bytes.NewReader(encoded))
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = DecodeHybridStream(context.Background(), bytes.NewReader(encoded[:len(encoded)-10]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Error(t, ht.EncodeStream(context.Background(), &buf, WithStreamBufferSize(0)))
+
+	// a small tree cannot hold as many blocks as the encoded one
+	small, err := NewHybrid(8)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, small.EncodeStream(context.Background(), &buf))
+	tampered := append([]byte{}, buf.Bytes()...)
+	tampered[len(tampered)-1] = 0xb9 // map with a two byte length
+	tampered = append(tampered, 0xff, 0xff)
+	_, err = DecodeHybridStream(context.Background(), bytes.NewReader(tampered))
+	assert.ErrorContains(t, err, "too many sparse blocks")
+}
@@ -1,12 +1,14 @@
 package merkletree
 
 import (
+	"io"
 	"os"
 	"testing"
 
 	commcid "github.com/filecoin-project/go-fil-commcid"
 	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHybridSunshine(t *testing.T) {
@@ -127,7 +129,8 @@ func TestHybridAsGenerateUnsealedCID(t *testing.T) {
 	}
 	err = ht.SetNode(0, 1<<30-1, &Node{0x1})
 	assert.NoError(t, err)
-	t.Logf("Blocks: %d, size: %d", len(ht.data.subs), len(ht.data.subs)*SparseBlockSize*NodeSize)
+	stats := ht.Stats()
+	t.Logf("Blocks: %d, size: %d", stats.Blocks, stats.ResidentBytes)
 
 	if false {
 		f, err := os.CreateTemp("", "ht-encode-*.cbor")
@@ -140,6 +143,219 @@ func TestHybridAsGenerateUnsealedCID(t *testing.T) {
 
 }
 
+func TestHybridSetLeafRange(t *testing.T) {
+	for _, log2Leafs := range []int{0, 3, 8, 9, 17} {
+		for _, r := range [][2]uint64{{0, 1}, {3, 5}, {100, 300}, {250, 20}, {1000, 4000}} {
+			start, length := r[0], r[1]
+			if start+length > 1<<log2Leafs {
+				continue
+			}
+			leafs := make([]Node, length)
+			for i := range leafs {
+				leafs[i] = Node{byte(i), byte(i >> 8), 0x1}
+			}
+
+			expected, err := NewHybrid(log2Leafs)
+			assert.NoError(t, err)
+			for i := range leafs {
+				assert.NoError(t, expected.SetNode(0, start+uint64(i), &leafs[i]))
+			}
+
+			ht, err := NewHybrid(log2Leafs)
+			assert.NoError(t, err)
+			assert.NoError(t, ht.SetLeafRange(start, leafs))
+
+			assert.Equal(t, expected.Root(), ht.Root(), "log2Leafs %d, range %v", log2Leafs, r)
+			for i := range leafs {
+				assert.Equal(t, leafs[i], Must(ht.GetNode(0, start+uint64(i))))
+			}
+		}
+	}
+
+	ht, err := NewHybrid(4)
+	assert.NoError(t, err)
+	assert.Error(t, ht.SetLeafRange(10, make([]Node, 7)))
+	assert.Error(t, ht.SetLeafRange(1<<64-1, make([]Node, 2)))
+}
+
+func BenchmarkHybridIndexWrite(b *testing.B) {
+	const log2Leafs = 30
+	const start = 1<<log2Leafs - 1<<13
+	leafs := make([]Node, 1<<13)
+	for i := range leafs {
+		leafs[i] = Node{byte(i), byte(i >> 8), 0x1}
+	}
+
+	b.Run("SetNode", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			ht := Must(NewHybrid(log2Leafs))
+			for i := range leafs {
+				if err := ht.SetNode(0, start+uint64(i), &leafs[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("SetLeafRange", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			ht := Must(NewHybrid(log2Leafs))
+			if err := ht.SetLeafRange(start, leafs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestLocationHelpers(t *testing.T) {
+	tests := []struct {
+		offset, size uint64
+		loc          Location
+		err          string
+	}{
+		{offset: 0, size: 32, loc: Location{Level: 0, Index: 0}},
+		{offset: 32, size: 32, loc: Location{Level: 0, Index: 1}},
+		{offset: 0, size: 128, loc: Location{Level: 2, Index: 0}},
+		{offset: 1 << 30, size: 256 << 20, loc: Location{Level: 23, Index: 4}},
+		{offset: 0, size: 16, err: "power of two"},
+		{offset: 0, size: 96, err: "power of two"},
+		{offset: 64, size: 128, err: "not aligned"},
+	}
+	for i, tc := range tests {
+		loc, err := LocationForOffsetSize(tc.offset, tc.size)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, "testcase %d", i)
+			continue
+		}
+		assert.NoError(t, err, "testcase %d", i)
+		assert.Equal(t, tc.loc, loc, "testcase %d", i)
+		assert.Equal(t, tc.offset, loc.ByteOffset(), "testcase %d", i)
+		assert.Equal(t, tc.size, loc.Size(), "testcase %d", i)
+	}
+}
+
+func TestHybridGeneration(t *testing.T) {
+	ht, err := NewHybrid(4)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), ht.Generation())
+
+	assert.NoError(t, ht.SetNode(0, 1, &Node{0x1}))
+	assert.Equal(t, uint64(1), ht.Generation())
+	assert.NoError(t, ht.SetLeafRange(4, []Node{{0x1}, {0x2}}))
+	assert.Equal(t, uint64(2), ht.Generation())
+	assert.NoError(t, ht.BatchSet([]CommAndLoc{
+		{Comm: Node{0x3}, Loc: Location{Level: 1, Index: 4}},
+		{Comm: Node{0x4}, Loc: Location{Level: 1, Index: 5}},
+	}))
+	assert.Equal(t, uint64(4), ht.Generation())
+
+	// failed mutations don't change the generation
+	assert.Error(t, ht.SetNode(1, 0, &Node{0x1}))
+	assert.Equal(t, uint64(4), ht.Generation())
+}
+
+func TestHybridClone(t *testing.T) {
+	ht, err := NewHybrid(10)
+	require.NoError(t, err)
+	require.NoError(t, ht.SetLeafRange(0, []Node{{0x1}, {0x2}, {0x3}}))
+	root := ht.Root()
+
+	clone := ht.Clone()
+	assert.Equal(t, root, clone.Root())
+	assert.Equal(t, ht.Generation(), clone.Generation())
+
+	require.NoError(t, clone.SetNode(0, 500, &Node{0x4}))
+	require.NoError(t, clone.SetLeafRange(3, []Node{{0x5}}))
+	assert.Equal(t, root, ht.Root())
+	assert.NotEqual(t, root, clone.Root())
+	n, err := ht.GetNode(0, 3)
+	require.NoError(t, err)
+	assert.Equal(t, ZeroCommitmentForLevel(0), n)
+
+	// writes to the original don't affect the clone
+	cloneRoot := clone.Root()
+	require.NoError(t, ht.SetNode(0, 1000, &Node{0x6}))
+	assert.Equal(t, cloneRoot, clone.Root())
+
+	expected, err := NewHybrid(10)
+	require.NoError(t, err)
+	require.NoError(t, expected.SetLeafRange(0, []Node{{0x1}, {0x2}, {0x3}}))
+	require.NoError(t, expected.SetNode(0, 1000, &Node{0x6}))
+	assert.Equal(t, expected.Root(), ht.Root())
+
+	empty, err := NewHybrid(4)
+	require.NoError(t, err)
+	emptyClone := empty.Clone()
+	require.NoError(t, emptyClone.SetNode(0, 0, &Node{0x1}))
+	assert.Equal(t, ZeroCommitmentForLevel(4), empty.Root())
+}
+
+func TestHybridStats(t *testing.T) {
+	ht, err := NewHybrid(20)
+	require.NoError(t, err)
+	stats := ht.Stats()
+	assert.Equal(t, 0, stats.Blocks)
+	assert.Equal(t, 0.0, stats.FillRatio)
+	assert.Len(t, stats.OccupiedNodes, 21)
+
+	require.NoError(t, ht.SetNode(0, 0, &Node{0x1}))
+	require.NoError(t, ht.SetLeafRange(1<<19, []Node{{0x2}, {0x3}}))
+	stats = ht.Stats()
+	assert.Equal(t, len(ht.data.subs), stats.Blocks)
+	assert.Equal(t, uint64(stats.Blocks)*SparseBlockSize*NodeSize, stats.ResidentBytes)
+	assert.Equal(t, uint64(3), stats.OccupiedNodes[0])
+	for l := 1; l < 20; l++ {
+		assert.Equal(t, uint64(2), stats.OccupiedNodes[l], "level %d", l)
+	}
+	assert.Equal(t, uint64(1), stats.OccupiedNodes[20])
+	assert.InDelta(t, float64(3+19*2+1)/float64(stats.Blocks*(SparseBlockSize-1)), stats.FillRatio, 1e-9)
+}
+
+func TestHybridPrune(t *testing.T) {
+	ht, err := NewHybrid(20)
+	require.NoError(t, err)
+	leafs := make([]Node, 1<<12)
+	for i := range leafs {
+		leafs[i] = Node{byte(i), byte(i >> 8), 0x1}
+	}
+	require.NoError(t, ht.SetLeafRange(0, leafs))
+	require.NoError(t, ht.SetLeafRange(1<<19, leafs))
+	root := ht.Root()
+	outside := Must(ht.CollectProof(0, 1<<19))
+	blocks := ht.Stats().Blocks
+
+	// the first 4096 leafs are under the node at level 12, index 0
+	require.NoError(t, ht.Prune(12, 0))
+	assert.Equal(t, root, ht.Root())
+	assert.Less(t, ht.Stats().Blocks, blocks)
+	assert.Equal(t, []Location{{Level: 12, Index: 0}}, ht.Pruned())
+
+	kept, err := ht.GetNode(12, 0)
+	require.NoError(t, err)
+	proof, err := ht.CollectProof(12, 0)
+	require.NoError(t, err)
+	assert.NoError(t, proof.ValidateSubtree(&kept, &root))
+	assert.Equal(t, outside, Must(ht.CollectProof(0, 1<<19)))
+
+	_, err = ht.GetNode(0, 5)
+	assert.Error(t, err)
+	_, err = ht.CollectProof(3, 1)
+	assert.Error(t, err)
+	assert.Error(t, ht.SetNode(0, 5, &Node{0x1}))
+	assert.Error(t, ht.SetLeafRange(4000, make([]Node, 200)))
+	assert.NoError(t, ht.SetLeafRange(4096, []Node{{0x2}}))
+	assert.Error(t, ht.MarshalCBOR(io.Discard))
+
+	// pruning above a pruned node replaces it
+	require.NoError(t, ht.Prune(13, 0))
+	assert.Equal(t, []Location{{Level: 13, Index: 0}}, ht.Pruned())
+	assert.NoError(t, ht.Prune(2, 0))
+	assert.Len(t, ht.Pruned(), 1)
+	assert.Error(t, ht.Prune(21, 0))
+
+	clone := ht.Clone()
+	assert.Equal(t, ht.Pruned(), clone.Pruned())
+}
+
 // FuzzSparseIndexing fuzzes for the property that two tuples of (depth, index)
 // cannot map to the same spareseIndex
 func FuzzSparseIndexing(f *testing.F) {
@@ -0,0 +1,136 @@
+// Package merkletreetest provides test doubles of the merkletree package: a configurable fake
+// MerkleTree and helpers creating proofs with known roots without building a tree.
+package merkletreetest
+
+import (
+	"math/rand"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	xerrors "golang.org/x/xerrors"
+)
+
+// Location identifies a node of a Tree, levels are counted from the root like in merkletree.MerkleTree
+type Location struct {
+	Level int
+	Index uint64
+}
+
+// Tree is a fake merkletree.MerkleTree. Its methods return the configured values; methods with
+// a Func field call it if it is set. The zero value is a tree of depth 1 with a zero root.
+type Tree struct {
+	DepthValue     int
+	LeafCountValue uint64
+	RootNode       merkletree.Node
+	LeafNodes      []merkletree.Node
+	// Nodes are returned by Node, missing nodes are zero
+	Nodes map[Location]merkletree.Node
+
+	ConstructProofFunc    func(lvl int, idx uint64) (*merkletree.ProofData, error)
+	ValidateFromLeafsFunc func(leafData [][]byte) error
+	// Invalid is negated by Validate
+	Invalid    bool
+	Serialized []byte
+	// SerializeErr is returned by Serialize if set
+	SerializeErr error
+}
+
+var _ merkletree.MerkleTree = (*Tree)(nil)
+
+// Depth returns DepthValue, or 1 if it isn't set
+func (t *Tree) Depth() int {
+	if t.DepthValue == 0 {
+		return 1
+	}
+	return t.DepthValue
+}
+
+func (t *Tree) LeafCount() uint64 {
+	return t.LeafCountValue
+}
+
+func (t *Tree) Root() *merkletree.Node {
+	res := t.RootNode
+	return &res
+}
+
+func (t *Tree) Leafs() []merkletree.Node {
+	return t.LeafNodes
+}
+
+func (t *Tree) Node(lvl int, idx uint64) *merkletree.Node {
+	res := t.Nodes[Location{Level: lvl, Index: idx}]
+	return &res
+}
+
+// ConstructProof calls ConstructProofFunc, without it a proof with a random path of the depth
+// of the tree below lvl is returned
+func (t *Tree) ConstructProof(lvl int, idx uint64) (*merkletree.ProofData, error) {
+	if t.ConstructProofFunc != nil {
+		return t.ConstructProofFunc(lvl, idx)
+	}
+	if lvl < 0 || lvl >= t.Depth() {
+		return nil, xerrors.Errorf("level %d outside of the tree of depth %d", lvl, t.Depth())
+	}
+	p := RandomProof(rand.New(rand.NewSource(int64(idx))), lvl, idx)
+	return &p, nil
+}
+
+func (t *Tree) ValidateFromLeafs(leafData [][]byte) error {
+	if t.ValidateFromLeafsFunc != nil {
+		return t.ValidateFromLeafsFunc(leafData)
+	}
+	return nil
+}
+
+func (t *Tree) Validate() bool {
+	return !t.Invalid
+}
+
+func (t *Tree) Serialize() ([]byte, error) {
+	if t.SerializeErr != nil {
+		return nil, t.SerializeErr
+	}
+	return t.Serialized, nil
+}
+
+// RandomNode returns a node of random content, truncated like nodes of the merkletree
+func RandomNode(rng *rand.Rand) merkletree.Node {
+	var n merkletree.Node
+	rng.Read(n[:])
+	n[merkletree.NodeSize-1] &= 0b00111111
+	return n
+}
+
+// RandomProof returns a proof of the given depth and index with a random path
+func RandomProof(rng *rand.Rand, depth int, index uint64) merkletree.ProofData {
+	p := merkletree.ProofData{Path: make([]merkletree.Node, depth), Index: index}
+	for i := range p.Path {
+		p.Path[i] = RandomNode(rng)
+	}
+	return p
+}
+
+// ProofWithRoot returns a random proof of the given depth and index for the node together with
+// the root it leads to, so that the proof validates without building a tree
+func ProofWithRoot(rng *rand.Rand, node merkletree.Node, depth int, index uint64) (merkletree.ProofData, merkletree.Node, error) {
+	p := RandomProof(rng, depth, index)
+	root, err := p.ComputeRoot(&node)
+	if err != nil {
+		return merkletree.ProofData{}, merkletree.Node{}, xerrors.Errorf("computing root: %w", err)
+	}
+	return p, *root, nil
+}
+
+// CorruptProof returns a copy of the proof with one bit of its path flipped, the position is
+// selected by pos. Proofs with an empty path get their index changed instead.
+func CorruptProof(p merkletree.ProofData, pos int) merkletree.ProofData {
+	res := p.Clone()
+	if len(res.Path) == 0 {
+		res.Index++
+		return res
+	}
+	// negative positions wrap around, including math.MinInt which has no positive counterpart
+	u := uint(pos)
+	res.Path[u%uint(len(res.Path))][u%(merkletree.NodeSize-1)] ^= 1
+	return res
+}
@@ -0,0 +1,53 @@
+package merkletreetest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xerrors "golang.org/x/xerrors"
+)
+
+func TestTree(t *testing.T) {
+	var zero Tree
+	assert.Equal(t, 1, zero.Depth())
+	assert.True(t, zero.Validate())
+	p, err := zero.ConstructProof(0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, p.Depth())
+
+	rng := rand.New(rand.NewSource(1))
+	tr := &Tree{
+		DepthValue: 4,
+		RootNode:   RandomNode(rng),
+		Nodes:      map[Location]merkletree.Node{{Level: 3, Index: 2}: RandomNode(rng)},
+		Invalid:    true,
+		ConstructProofFunc: func(lvl int, idx uint64) (*merkletree.ProofData, error) {
+			return nil, xerrors.Errorf("no proofs")
+		},
+	}
+	assert.Equal(t, tr.Nodes[Location{Level: 3, Index: 2}], *tr.Node(3, 2))
+	assert.Equal(t, merkletree.Node{}, *tr.Node(3, 1))
+	assert.False(t, tr.Validate())
+	_, err = tr.ConstructProof(3, 2)
+	assert.Error(t, err)
+}
+
+func TestProofWithRoot(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	node := RandomNode(rng)
+	p, root, err := ProofWithRoot(rng, node, 10, 300)
+	require.NoError(t, err)
+	assert.NoError(t, p.ValidateSubtree(&node, &root))
+	assert.Error(t, CorruptProof(p, 77).ValidateSubtree(&node, &root))
+	assert.NoError(t, p.ValidateSubtree(&node, &root), "original proof is not modified")
+	for _, pos := range []int{-1, -77, math.MinInt, math.MaxInt} {
+		assert.Error(t, CorruptProof(p, pos).ValidateSubtree(&node, &root), "pos %d", pos)
+	}
+
+	_, _, err = ProofWithRoot(rng, node, 2, 4)
+	assert.Error(t, err)
+}
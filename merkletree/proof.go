@@ -2,10 +2,16 @@ package merkletree
 
 import (
 	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"fmt"
 
 	"golang.org/x/xerrors"
 )
 
+// ProofData is a Merkle inclusion proof.
+// Proofs are treated as immutable by this package, the Path might be shared between copies of
+// a proof, use Clone before modifying it.
 type ProofData struct {
 	Path []Node
 	// index indicates the index within the level where the element whose membership to prove is located
@@ -13,6 +19,25 @@ type ProofData struct {
 	Index uint64
 }
 
+// Clone returns a deep copy of the proof
+func (d ProofData) Clone() ProofData {
+	res := ProofData{Index: d.Index}
+	if d.Path != nil {
+		res.Path = append(make([]Node, 0, len(d.Path)), d.Path...)
+	}
+	return res
+}
+
+// String formats the proof with its depth, index and the first 4 bytes of the hash of the
+// path in hex, identifying the path without printing it. The format is stable.
+func (d ProofData) String() string {
+	h := sha256.New()
+	for i := range d.Path {
+		h.Write(d.Path[i][:])
+	}
+	return fmt.Sprintf("ProofData{Depth: %d, Index: %d, PathHash: %x}", d.Depth(), d.Index, h.Sum(nil)[:4])
+}
+
 // Depth returns the level in the tree which the node this proof validates is located
 func (d ProofData) Depth() int {
 	return len(d.Path)
@@ -27,47 +52,120 @@ func (d ProofData) ValidateLeaf(data []byte, root *Node) error {
 // ValidateSubtree validates that a subtree is contained in the in a Merkle tree with a given root
 func (d ProofData) ValidateSubtree(subtree *Node, root *Node) error {
 	// Validate the structure first to avoid panics
-	if err := d.validateProofStructure(); err != nil {
+	if err := d.Validate(); err != nil {
 		return xerrors.Errorf("in ValidateSubtree: %w", err)
 	}
 	return d.validateProof(subtree, root)
 }
 
+// maxProofDepth is the maximum depth of proof which can be verified, see ComputeRoot
+const maxProofDepth = 63
+
+var _ encoding.BinaryMarshaler = ProofData{}
+var _ encoding.BinaryUnmarshaler = (*ProofData)(nil)
+
+// MarshalBinary encodes the proof as:
+// depth (1 byte) || index (8 bytes, little-endian) || path (depth * NodeSize bytes)
+// Proofs failing Validate are not encoded.
+func (d ProofData) MarshalBinary() ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, xerrors.Errorf("encoding proof: %w", err)
+	}
+	res := make([]byte, 1+BytesInInt+d.Depth()*NodeSize)
+	res[0] = byte(d.Depth())
+	binary.LittleEndian.PutUint64(res[1:], d.Index)
+	for i, n := range d.Path {
+		copy(res[1+BytesInInt+i*NodeSize:], n[:])
+	}
+	return res, nil
+}
+
+// UnmarshalBinary decodes the proof encoded by MarshalBinary
+func (d *ProofData) UnmarshalBinary(data []byte) error {
+	if len(data) < 1+BytesInInt {
+		return xerrors.Errorf("proof encoding too short: %d", len(data))
+	}
+	depth := int(data[0])
+	if depth > maxProofDepth {
+		return xerrors.Errorf("proof too deep: %d > %d", depth, maxProofDepth)
+	}
+	if len(data) != 1+BytesInInt+depth*NodeSize {
+		return xerrors.Errorf("wrong length of proof encoding: %d != %d", len(data), 1+BytesInInt+depth*NodeSize)
+	}
+	index := binary.LittleEndian.Uint64(data[1:])
+	if index>>depth != 0 {
+		return xerrors.Errorf("index greater than width of the tree")
+	}
+
+	*d = ProofData{Index: index}
+	if depth > 0 {
+		d.Path = make([]Node, depth)
+		for i := range d.Path {
+			copy(d.Path[i][:], data[1+BytesInInt+i*NodeSize:])
+		}
+	}
+	return nil
+}
+
 func (d ProofData) ComputeRoot(subtree *Node) (*Node, error) {
-	if subtree == nil {
-		return nil, xerrors.Errorf("nil subtree cannot be used")
+	var root Node
+	if err := d.ComputeRootInto(&root, subtree); err != nil {
+		return nil, err
 	}
-	if d.Depth() > 63 {
-		return nil, xerrors.Errorf("merkleproofs with depths greater than 63 are not supported")
+	return &root, nil
+}
+
+// ComputeRootInto is like ComputeRoot but stores the root in dst instead of allocating it.
+// dst can alias subtree.
+func (d ProofData) ComputeRootInto(dst *Node, subtree *Node) error {
+	if subtree == nil {
+		return xerrors.Errorf("nil subtree cannot be used")
 	}
-	if d.Index>>d.Depth() != 0 {
-		return nil, xerrors.Errorf("index greater than width of the tree")
+	if err := d.Validate(); err != nil {
+		return err
 	}
 
 	var carry Node = *subtree
 	var index = d.Index
 	var right = uint64(0)
 
-	for _, p := range d.Path {
+	for i := range d.Path {
 		right, index = index&1, index>>1
 		if right == 1 {
-			carry = *computeNode(&p, &carry)
+			PairHashInto(&carry, &d.Path[i], &carry)
 		} else {
-			carry = *computeNode(&carry, &p)
+			PairHashInto(&carry, &carry, &d.Path[i])
 		}
 	}
 
-	return &carry, nil
+	*dst = carry
+	return nil
 }
 
-// computeNode computes a new internal node in a tree, from its left and right children
-func computeNode(left *Node, right *Node) *Node {
-	sha := sha256.New()
-	sha.Write(left[:])
-	sha.Write(right[:])
-	digest := sha.Sum(nil)
+// PairHash computes a new internal node in a tree, from its left and right children
+func PairHash(left, right *Node) Node {
+	var res Node
+	PairHashInto(&res, left, right)
+	return res
+}
 
-	return truncate((*Node)(digest))
+// PairHashInto computes the internal node from its left and right children and stores it in dst.
+// dst can alias either of the children.
+func PairHashInto(dst, left, right *Node) {
+	var buf [2 * NodeSize]byte
+	copy(buf[:NodeSize], left[:])
+	copy(buf[NodeSize:], right[:])
+	*dst = sha256.Sum256(buf[:])
+	truncate(dst)
+}
+
+// HashNodes computes the internal nodes for a batch of (left, right) children pairs
+func HashNodes(pairs [][2]Node) []Node {
+	res := make([]Node, len(pairs))
+	for i := range pairs {
+		PairHashInto(&res[i], &pairs[i][0], &pairs[i][1])
+	}
+	return res
 }
 
 func truncate(n *Node) *Node {
@@ -87,6 +185,28 @@ func (d ProofData) validateProof(subtree *Node, root *Node) error {
 	return nil
 }
 
-func (d ProofData) validateProofStructure() error {
+// Validate checks the structure of the proof: its depth is at most 63 and the index is within
+// the width of the tree at that depth. It is performed when decoding and before computing the root.
+// Zero nodes in the path are valid, they are reported by ZeroNodes.
+func (d ProofData) Validate() error {
+	if d.Depth() > maxProofDepth {
+		return xerrors.Errorf("merkleproofs with depths greater than 63 are not supported")
+	}
+	if d.Index>>d.Depth() != 0 {
+		return xerrors.Errorf("index greater than width of the tree")
+	}
 	return nil
 }
+
+// ZeroNodes returns the positions in the path holding all-zero nodes. These are valid, the
+// sibling leaf of zero data is a zero node, but above the leaves a zero node is not the hash
+// of anything, so they flag proofs worth a closer look.
+func (d ProofData) ZeroNodes() []int {
+	var res []int
+	for i := range d.Path {
+		if d.Path[i] == (Node{}) {
+			res = append(res, i)
+		}
+	}
+	return res
+}
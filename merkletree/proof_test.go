@@ -1,27 +1,42 @@
 package merkletree
 
 import (
+	"bytes"
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestComputeNode(t *testing.T) {
-	assert.Equal(t, &Node{
+func TestPairHash(t *testing.T) {
+	assert.Equal(t, Node{
 		0xf5, 0xa5, 0xfd, 0x42, 0xd1, 0x6a, 0x20, 0x30, 0x27, 0x98, 0xef, 0x6e, 0xd3, 0x9, 0x97,
 		0x9b, 0x43, 0x0, 0x3d, 0x23, 0x20, 0xd9, 0xf0, 0xe8, 0xea, 0x98, 0x31, 0xa9, 0x27, 0x59,
 		0xfb, 0xb},
-		computeNode(&Node{}, &Node{}))
-	assert.Equal(t, &Node{
+		PairHash(&Node{}, &Node{}))
+	assert.Equal(t, Node{
 		0xff, 0x55, 0xc9, 0x79, 0x76, 0xa8, 0x40, 0xb4, 0xce, 0xd9, 0x64, 0xed, 0x49, 0xe3, 0x79,
 		0x45, 0x94, 0xba, 0x3f, 0x67, 0x52, 0x38, 0xb5, 0xfd, 0x25, 0xd2, 0x82, 0xb6, 0xf, 0x70,
 		0xa1, 0x14},
-		computeNode(&Node{0x1}, &Node{0x2})) // specified bytes are the lowest bytes
-	assert.Equal(t, &Node{
+		PairHash(&Node{0x1}, &Node{0x2})) // specified bytes are the lowest bytes
+	assert.Equal(t, Node{
 		0x95, 0xe7, 0x3e, 0x86, 0x16, 0xbb, 0x92, 0x7b, 0xb0, 0x74, 0xee, 0x5, 0x5b, 0x12, 0x23,
 		0xf3, 0xa0, 0x85, 0xf7, 0x10, 0xc, 0x97, 0x46, 0x8d, 0x92, 0xe6, 0x3a, 0x1c, 0x87, 0xaf,
 		0x1c, 0x1a},
-		computeNode(&Node{0x2}, &Node{0x1}))
+		PairHash(&Node{0x2}, &Node{0x1}))
+
+	// dst can alias the inputs
+	n := Node{0x1}
+	PairHashInto(&n, &n, &Node{0x2})
+	assert.Equal(t, PairHash(&Node{0x1}, &Node{0x2}), n)
+
+	assert.Equal(t, []Node{PairHash(&Node{}, &Node{}), PairHash(&Node{0x2}, &Node{0x1})},
+		HashNodes([][2]Node{{{}, {}}, {{0x2}, {0x1}}}))
+	assert.Empty(t, HashNodes(nil))
+
+	// hashing of two nodes is the same as the truncated hash of their concatenation
+	left, right := Node{0x1}, Node{0x2}
+	assert.Equal(t, *TruncatedHash(append(left[:], right[:]...)), PairHash(&left, &right))
 }
 
 func TestComputeRootTestVectors(t *testing.T) {
@@ -182,3 +197,119 @@ func TestNegativeValidateSubtree(t *testing.T) {
 		}
 	}
 }
+
+func TestProofDataBinaryGolden(t *testing.T) {
+	tt := []struct {
+		proof   ProofData
+		encoded string
+	}{
+		{
+			proof:   ProofData{Index: 0},
+			encoded: "000000000000000000",
+		},
+		{
+			proof: ProofData{Path: []Node{{0x1}, {0x2}}, Index: 3},
+			encoded: "020300000000000000" +
+				"0100000000000000000000000000000000000000000000000000000000000000" +
+				"0200000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			proof: ProofData{Path: []Node{{0xaa, 0xbb}}, Index: 1},
+			encoded: "010100000000000000" +
+				"aabb000000000000000000000000000000000000000000000000000000000000",
+		},
+	}
+
+	for i, tc := range tt {
+		b, err := tc.proof.MarshalBinary()
+		assert.NoError(t, err, "testcase %d", i)
+		assert.Equal(t, tc.encoded, hex.EncodeToString(b), "testcase %d", i)
+
+		var decoded ProofData
+		err = decoded.UnmarshalBinary(b)
+		assert.NoError(t, err, "testcase %d", i)
+		assert.Equal(t, tc.proof, decoded, "testcase %d", i)
+	}
+}
+
+func TestProofDataBinaryNegative(t *testing.T) {
+	_, err := ProofData{Path: make([]Node, 64)}.MarshalBinary()
+	assert.Error(t, err)
+	_, err = ProofData{Path: make([]Node, 2), Index: 4}.MarshalBinary()
+	assert.ErrorContains(t, err, "index greater than width")
+	_, err = ProofData{Index: 1}.MarshalBinary()
+	assert.ErrorContains(t, err, "index greater than width")
+
+	tt := []struct {
+		encoded string
+		err     string
+	}{
+		{encoded: "", err: "too short"},
+		{encoded: "0000000000000000", err: "too short"},
+		{encoded: "400000000000000000", err: "proof too deep"},
+		{encoded: "010000000000000000", err: "wrong length"},
+		{encoded: "000100000000000000", err: "index greater than width"},
+	}
+	for i, tc := range tt {
+		var decoded ProofData
+		err := decoded.UnmarshalBinary(Must(hex.DecodeString(tc.encoded)))
+		assert.ErrorContains(t, err, tc.err, "testcase %d", i)
+	}
+}
+
+func TestProofDataClone(t *testing.T) {
+	proof := ProofData{Index: 5, Path: []Node{{0x1}, {0x2}, {0x3}}}
+	clone := proof.Clone()
+	assert.Equal(t, proof, clone)
+
+	clone.Path[0] = Node{0xff}
+	assert.Equal(t, Node{0x1}, proof.Path[0])
+
+	assert.Equal(t, ProofData{Index: 1}, ProofData{Index: 1}.Clone())
+}
+
+func TestProofDataValidate(t *testing.T) {
+	assert.NoError(t, ProofData{}.Validate())
+	assert.NoError(t, ProofData{Index: 7, Path: make([]Node, 3)}.Validate())
+	assert.NoError(t, ProofData{Index: 1<<63 - 1, Path: make([]Node, 63)}.Validate())
+	assert.ErrorContains(t, ProofData{Index: 8, Path: make([]Node, 3)}.Validate(), "index greater than width")
+	assert.ErrorContains(t, ProofData{Index: 1}.Validate(), "index greater than width")
+	assert.ErrorContains(t, ProofData{Path: make([]Node, 64)}.Validate(), "greater than 63")
+
+	p := ProofData{Index: 2, Path: []Node{{0x1}, {}, {0x3}, {}}}
+	assert.NoError(t, p.Validate())
+	assert.Equal(t, []int{1, 3}, p.ZeroNodes())
+	assert.Empty(t, ProofData{Path: []Node{{0x1}}}.ZeroNodes())
+}
+
+func TestProofDataUnmarshalCBORValidates(t *testing.T) {
+	for _, p := range []ProofData{
+		{Index: 8, Path: make([]Node, 3)},
+		{Index: 0, Path: make([]Node, 64)},
+	} {
+		var buf bytes.Buffer
+		assert.NoError(t, p.MarshalCBOR(&buf))
+		decoded := ProofData{Index: 1, Path: []Node{{0x1}}}
+		assert.ErrorContains(t, decoded.UnmarshalCBOR(&buf), "invalid proof")
+		assert.Equal(t, ProofData{Index: 1, Path: []Node{{0x1}}}, decoded, "rejected proof left the target unchanged")
+	}
+
+	p := ProofData{Index: 5, Path: []Node{{0x1}, {0x2}, {0x3}}}
+	var buf bytes.Buffer
+	assert.NoError(t, p.MarshalCBOR(&buf))
+	var decoded ProofData
+	assert.NoError(t, decoded.UnmarshalCBOR(&buf))
+	assert.Equal(t, p, decoded)
+}
+
+func TestStringFormats(t *testing.T) {
+	assert.Equal(t, "Location{Level: 2, Index: 3, Offset: 384, Size: 128}", Location{Level: 2, Index: 3}.String())
+
+	p := ProofData{Index: 5, Path: []Node{{0x1}, {0x2}, {0x3}}}
+	s := p.String()
+	assert.Regexp(t, `^ProofData\{Depth: 3, Index: 5, PathHash: [0-9a-f]{8}\}$`, s)
+	assert.Equal(t, s, p.Clone().String())
+	p.Path[0][0] = 0xff
+	assert.NotEqual(t, s, p.String())
+	assert.Equal(t, "ProofData{Depth: 0, Index: 0, PathHash: e3b0c442}", ProofData{}.String())
+}
@@ -0,0 +1,71 @@
+package merkletree
+
+import (
+	"golang.org/x/exp/slices"
+	xerrors "golang.org/x/xerrors"
+)
+
+// ComputeSparseRoot computes the root of a tree with the given maxLevel in which only the given
+// nodes are non-zero, without materializing the tree. The result is equal to the Root of a Hybrid
+// after BatchSet of the nodes. Nodes can be passed in any order but must not overlap.
+// The complexity is O(M*maxLevel) where M=len(nodes).
+func ComputeSparseRoot(maxLevel int, nodes []CommAndLoc) (Node, error) {
+	if maxLevel < 0 || maxLevel >= ZeroCommitmentLevels {
+		return Node{}, xerrors.Errorf("unsupported tree level: %d", maxLevel)
+	}
+
+	type levelNode struct {
+		index uint64
+		node  Node
+	}
+	byLevel := make([][]levelNode, maxLevel+1)
+	for i, n := range nodes {
+		if n.Loc.Level < 0 || n.Loc.Level > maxLevel {
+			return Node{}, xerrors.Errorf("node %d: level %d out of the tree", i, n.Loc.Level)
+		}
+		if n.Loc.Index>>(maxLevel-n.Loc.Level) != 0 {
+			return Node{}, xerrors.Errorf("node %d: index %d out of the tree at level %d", i, n.Loc.Index, n.Loc.Level)
+		}
+		byLevel[n.Loc.Level] = append(byLevel[n.Loc.Level], levelNode{n.Loc.Index, n.Comm})
+	}
+
+	var current []levelNode
+	for level := 0; ; level++ {
+		current = append(current, byLevel[level]...)
+		slices.SortFunc(current, func(a, b levelNode) bool {
+			return a.index < b.index
+		})
+		for i := 1; i < len(current); i++ {
+			if current[i-1].index == current[i].index {
+				return Node{}, xerrors.Errorf("nodes overlap at level %d, index %d", level, current[i].index)
+			}
+		}
+		if level == maxLevel {
+			break
+		}
+
+		zero := ZeroCommitmentForLevel(level)
+		next := current[:0]
+		for i := 0; i < len(current); i++ {
+			left, right := zero, zero
+			index := current[i].index
+			if index%2 == 0 {
+				left = current[i].node
+				if i+1 < len(current) && current[i+1].index == index+1 {
+					right = current[i+1].node
+					i++
+				}
+			} else {
+				right = current[i].node
+			}
+			// parents are written behind the nodes being read
+			next = append(next, levelNode{index / 2, PairHash(&left, &right)})
+		}
+		current = next
+	}
+
+	if len(current) == 0 {
+		return ZeroCommitmentForLevel(maxLevel), nil
+	}
+	return current[0].node, nil
+}
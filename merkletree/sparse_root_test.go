@@ -0,0 +1,59 @@
+package merkletree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeSparseRoot(t *testing.T) {
+	const maxLevel = 20
+	rng := rand.New(rand.NewSource(1))
+	var nodes []CommAndLoc
+	offset := uint64(0)
+	for offset < 1<<(maxLevel-1) {
+		level := rng.Intn(10)
+		index := (offset + uint64(1)<<level - 1) >> level
+		var n Node
+		rng.Read(n[:])
+		nodes = append(nodes, CommAndLoc{Comm: n, Loc: Location{Level: level, Index: index}})
+		offset = (index+1)<<level + uint64(rng.Intn(1<<10))
+	}
+
+	ht, err := NewHybrid(maxLevel)
+	require.NoError(t, err)
+	require.NoError(t, ht.BatchSet(nodes))
+
+	// order of the nodes doesn't matter
+	rng.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	root, err := ComputeSparseRoot(maxLevel, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, ht.Root(), root)
+
+	root, err = ComputeSparseRoot(maxLevel, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ZeroCommitmentForLevel(maxLevel), root)
+
+	single := []CommAndLoc{{Comm: Node{0x1}, Loc: Location{Level: maxLevel, Index: 0}}}
+	root, err = ComputeSparseRoot(maxLevel, single)
+	require.NoError(t, err)
+	assert.Equal(t, Node{0x1}, root)
+}
+
+func TestComputeSparseRootErrors(t *testing.T) {
+	overlapping := []CommAndLoc{
+		{Comm: Node{0x1}, Loc: Location{Level: 2, Index: 1}},
+		{Comm: Node{0x2}, Loc: Location{Level: 0, Index: 5}},
+	}
+	_, err := ComputeSparseRoot(10, overlapping)
+	assert.Error(t, err)
+
+	_, err = ComputeSparseRoot(10, []CommAndLoc{{Loc: Location{Level: 11}}})
+	assert.Error(t, err)
+	_, err = ComputeSparseRoot(10, []CommAndLoc{{Loc: Location{Level: 2, Index: 1 << 8}}})
+	assert.Error(t, err)
+	_, err = ComputeSparseRoot(ZeroCommitmentLevels, nil)
+	assert.Error(t, err)
+}
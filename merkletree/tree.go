@@ -6,8 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
-	"reflect"
+	"iter"
 
 	"github.com/filecoin-project/go-data-segment/fr32"
 	"github.com/filecoin-project/go-data-segment/util"
@@ -126,7 +125,7 @@ func GrowTreeHashedLeafs(leafs []Node) *TreeData {
 		currentLevel := make([]Node, util.Ceil(uint(len(parentNodes)), 2))
 		// Traverse the level left to right
 		for i := 0; i+1 < len(parentNodes); i = i + 2 {
-			currentLevel[i/2] = *computeNode(&parentNodes[i], &parentNodes[i+1])
+			PairHashInto(&currentLevel[i/2], &parentNodes[i], &parentNodes[i+1])
 		}
 		tree.nodes[level] = currentLevel
 		parentNodes = currentLevel
@@ -168,22 +167,84 @@ func (d TreeData) Node(lvl int, idx uint64) *Node {
 	return &res
 }
 
+// LevelNodes returns an iterator over the indexes and nodes of the level lvl, counted like in Node.
+// Nothing is yielded for levels outside of the tree.
+func (d TreeData) LevelNodes(lvl int) iter.Seq2[uint64, Node] {
+	return func(yield func(uint64, Node) bool) {
+		if lvl < 0 || lvl >= len(d.nodes) {
+			return
+		}
+		for i, n := range d.nodes[lvl] {
+			if !yield(uint64(i), n) {
+				return
+			}
+		}
+	}
+}
+
 // ValidateFromLeafs validates the structure of this Merkle tree, given the raw data elements the tree was constructed from
 func (d TreeData) ValidateFromLeafs(leafs [][]byte) error {
-	tree, err := GrowTree(leafs)
-	if err != nil {
-		return xerrors.Errorf("grow tree: %w", err)
+	if len(leafs) == 0 {
+		return xerrors.Errorf("empty input")
+	}
+	if uint64(len(leafs)) != d.leafs {
+		return xerrors.Errorf("number of leafs doesn't match the tree: %d != %d", len(leafs), d.leafs)
+	}
+	if err := d.validateShape(); err != nil {
+		return err
+	}
+	leafLevel := d.nodes[d.Depth()-1]
+	for i := range leafLevel {
+		var expected Node
+		if i < len(leafs) {
+			expected = *TruncatedHash(leafs[i])
+		}
+		if leafLevel[i] != expected {
+			return xerrors.Errorf("not equal to leafs: node at level %d, index %d differs", d.Depth()-1, i)
+		}
 	}
-	if !reflect.DeepEqual(&d, tree) {
-		return xerrors.Errorf("not equal to leafs")
+	if lvl, idx, ok := d.firstInvalidNode(); !ok {
+		return xerrors.Errorf("not equal to leafs: node at level %d, index %d differs", lvl, idx)
 	}
 	return nil
 }
 
 // Validate returns true of this tree has been constructed correctly from the leafs (hashed data)
 func (d TreeData) Validate() bool {
-	tree := GrowTreeHashedLeafs(d.nodes[d.Depth()-1])
-	return reflect.DeepEqual(d.nodes, tree.nodes)
+	if d.validateShape() != nil {
+		return false
+	}
+	_, _, ok := d.firstInvalidNode()
+	return ok
+}
+
+// validateShape checks that the tree is perfect, level i containing 1<<i nodes
+func (d TreeData) validateShape() error {
+	if len(d.nodes) == 0 {
+		return xerrors.Errorf("empty tree")
+	}
+	for lvl, nodes := range d.nodes {
+		if len(nodes) != 1<<lvl {
+			return xerrors.Errorf("level %d has %d nodes, expected %d", lvl, len(nodes), 1<<lvl)
+		}
+	}
+	return nil
+}
+
+// firstInvalidNode checks, bottom-up, that internal nodes are hashes of their children
+// and returns the position of the first node which isn't
+func (d TreeData) firstInvalidNode() (int, uint64, bool) {
+	var computed Node
+	for lvl := d.Depth() - 2; lvl >= 0; lvl-- {
+		children := d.nodes[lvl+1]
+		for i := range d.nodes[lvl] {
+			PairHashInto(&computed, &children[2*i], &children[2*i+1])
+			if computed != d.nodes[lvl][i] {
+				return lvl, uint64(i), false
+			}
+		}
+	}
+	return 0, 0, true
 }
 
 // ConstructProof constructs a proof that a node at level lvl and index idx within that level, is contained in the tree.
@@ -225,14 +286,14 @@ func (d TreeData) Serialize() ([]byte, error) {
 	buf := new(bytes.Buffer)
 	err := binary.Write(buf, binary.LittleEndian, uint64(d.LeafCount()))
 	if err != nil {
-		log.Println("could not write the leaf count")
+		logger.Printf("could not write the leaf count")
 		return nil, err
 	}
 	// Encode from the leafs to make decoding easier
 	for i := d.Depth() - 1; i >= 0; i-- {
 		err = binary.Write(buf, binary.LittleEndian, d.nodes[i])
 		if err != nil {
-			log.Printf("could not write layer %d\n", i)
+			logger.Printf("could not write layer %d", i)
 			return nil, err
 		}
 	}
@@ -107,6 +107,22 @@ func TestConstructProof(t *testing.T) {
 	assert.Equal(t, len(proof.Path), tree.Depth()-1)
 }
 
+func TestLevelNodes(t *testing.T) {
+	tree := getTree(t, 5)
+	for lvl := 0; lvl < tree.Depth(); lvl++ {
+		var count uint64
+		for i, n := range tree.LevelNodes(lvl) {
+			assert.Equal(t, count, i)
+			assert.Equal(t, *tree.Node(lvl, i), n)
+			count++
+		}
+		assert.Equal(t, uint64(1)<<lvl, count)
+	}
+	for range tree.LevelNodes(tree.Depth()) {
+		t.Fatal("level below the leafs yielded nodes")
+	}
+}
+
 func TestValidateFromLeafs(t *testing.T) {
 	testAmounts := []uint64{33, 235, 543}
 	for _, amount := range testAmounts {
@@ -203,7 +219,7 @@ func TestComputeNodeFullInput(t *testing.T) {
 	singletonInput, err := hex.DecodeString("038051e9c324393bd1ca1978dd0952c2aa3742ca4f1bd5cd4611cea83892d302")
 	assert.NoError(t, err)
 	nodeInput := *(*Node)(singletonInput)
-	result := computeNode(&nodeInput, &nodeInput)
+	result := PairHash(&nodeInput, &nodeInput)
 
 	// Truncated hash digest of input nodes (which are each truncated to 254 bits)
 	expected, err := hex.DecodeString("90a4a4c485b44abecda2c404e4a56df371c9f7c6f23f396f4c63903acf65d638")
@@ -265,3 +281,21 @@ func getLeaf(t *testing.T, idx uint64) []byte {
 	singletonInput[0] ^= byte(idx)
 	return singletonInput
 }
+
+func TestValidateFromLeafsReportsDivergence(t *testing.T) {
+	tree := getTree(t, 100)
+	leafs := getLeafs(t, 0, 100)
+
+	tree.nodes[3][5][0] ^= 0x1
+	assert.False(t, tree.Validate())
+	assert.ErrorContains(t, tree.ValidateFromLeafs(leafs), "level 3, index 5")
+
+	tree = getTree(t, 100)
+	leafs[42] = []byte{0x1}
+	assert.ErrorContains(t, tree.ValidateFromLeafs(leafs), "level 7, index 42")
+	// the padding leafs have to be zero
+	tree = getTree(t, 100)
+	leafs = getLeafs(t, 0, 100)
+	tree.nodes[7][120][0] = 0x1
+	assert.ErrorContains(t, tree.ValidateFromLeafs(leafs), "level 7, index 120")
+}
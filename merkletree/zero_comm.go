@@ -7,18 +7,53 @@ import (
 	xerrors "golang.org/x/xerrors"
 )
 
+// ZeroCommitmentLevels is the number of levels, starting from the leaf level, for which
+// zero commitments are available
+const ZeroCommitmentLevels = 64
+
 //go:embed zerocomm.bin
 var zeroComms []byte
 
-// simple access by level, only levels between 0 and 64 inclusive are avaliable otherwise panics
+func init() {
+	if len(zeroComms) != ZeroCommitmentLevels*NodeSize {
+		zeroComms = generateZeroComms(ZeroCommitmentLevels)
+	}
+}
+
+// generateZeroComms computes the table of zero commitments for the given number of levels
+func generateZeroComms(levels int) []byte {
+	res := make([]byte, levels*NodeSize)
+	var n Node
+	for i := 1; i < levels; i++ {
+		n = PairHash(&n, &n)
+		copy(res[i*NodeSize:], n[:])
+	}
+	return res
+}
+
+// simple access by level, only levels between 0 and ZeroCommitmentLevels-1 are avaliable otherwise panics
 func ZeroCommitmentForLevel(lvl int) Node {
-	return *(*Node)(zeroComms[32*lvl : 32*(lvl+1)])
+	n, err := ZeroCommitmentForLevelErr(lvl)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// ZeroCommitmentForLevelErr returns the zero commitment for the level or an error if the level
+// is out of range
+func ZeroCommitmentForLevelErr(lvl int) (Node, error) {
+	if lvl < 0 || lvl >= ZeroCommitmentLevels {
+		return Node{}, xerrors.Errorf("zero commitments for level %d are not supported", lvl)
+	}
+	return *(*Node)(zeroComms[NodeSize*lvl : NodeSize*(lvl+1)]), nil
 }
 
 func ZeroCommitmentForSize(size uint64) (Node, error) {
 	lvl := util.Log2Ceil(size / NodeSize)
-	if lvl*32 >= len(zeroComms) {
-		return Node{}, xerrors.Errorf("zero commimtents for size %d are not supported", size)
+	n, err := ZeroCommitmentForLevelErr(lvl)
+	if err != nil {
+		return Node{}, xerrors.Errorf("zero commimtents for size %d are not supported: %w", size, err)
 	}
-	return ZeroCommitmentForLevel(lvl), nil
+	return n, nil
 }
@@ -3,16 +3,51 @@ package merkletree
 import (
 	"os"
 	"testing"
+
+	"github.com/filecoin-project/go-data-segment/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestZeroCommitments(t *testing.T) {
+	generated := generateZeroComms(ZeroCommitmentLevels)
+	assert.Equal(t, generated, zeroComms, "embedded table doesn't match the generated one")
+
+	for lvl := 0; lvl < ZeroCommitmentLevels; lvl++ {
+		n, err := ZeroCommitmentForLevelErr(lvl)
+		require.NoError(t, err)
+		assert.Equal(t, ZeroCommitmentForLevel(lvl), n)
+	}
+	_, err := ZeroCommitmentForLevelErr(-1)
+	assert.Error(t, err)
+	_, err = ZeroCommitmentForLevelErr(ZeroCommitmentLevels)
+	assert.Error(t, err)
+	assert.Panics(t, func() { ZeroCommitmentForLevel(ZeroCommitmentLevels) })
+
+	// all sizes representable in uint64 are covered
+	for size := uint64(NodeSize); size != 0; size <<= 1 {
+		n, err := ZeroCommitmentForSize(size)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, ZeroCommitmentForLevel(util.Log2Floor(size/NodeSize)), n)
+	}
+	n, err := ZeroCommitmentForSize(1<<64 - 1)
+	require.NoError(t, err)
+	assert.Equal(t, ZeroCommitmentForLevel(59), n)
+
+	// the root of an empty tree is the zero commitment of its level
+	ht, err := NewHybrid(30)
+	require.NoError(t, err)
+	assert.Equal(t, ZeroCommitmentForLevel(30), ht.Root())
+}
+
 func TestGenerateZeroCommTable(t *testing.T) {
 	if os.Getenv("ZEROCCOMM_REGEN") == "" {
 		t.SkipNow()
 	}
-	maxD := 64
+	maxD := ZeroCommitmentLevels
 	zeroComms := make([]Node, maxD)
 	for i := 1; i < maxD; i++ {
-		zeroComms[i] = *computeNode(&zeroComms[i-1], &zeroComms[i-1])
+		zeroComms[i] = PairHash(&zeroComms[i-1], &zeroComms[i-1])
 	}
 	f, err := os.Create("zerocomm.bin")
 	if err != nil {
@@ -2,6 +2,7 @@ package util
 
 import (
 	"errors"
+	"math"
 	"math/bits"
 )
 
@@ -11,6 +12,34 @@ func CheckedMultiply(a, b uint64) (uint64, bool) {
 	return lo, hi == 0
 }
 
+// CheckedAdd adds a and b and returns (truncate(a+b), no_overflow)
+func CheckedAdd(a, b uint64) (uint64, bool) {
+	sum, carry := bits.Add64(a, b, 0)
+	return sum, carry == 0
+}
+
+// CheckedSub subtracts b from a and returns (truncate(a-b), no_underflow)
+func CheckedSub(a, b uint64) (uint64, bool) {
+	diff, borrow := bits.Sub64(a, b, 0)
+	return diff, borrow == 0
+}
+
+// SaturatingAdd returns a+b, or the maximum uint64 if the sum overflows
+func SaturatingAdd(a, b uint64) uint64 {
+	if sum, ok := CheckedAdd(a, b); ok {
+		return sum
+	}
+	return math.MaxUint64
+}
+
+// SaturatingSub returns a-b, or zero if b is larger than a
+func SaturatingSub(a, b uint64) uint64 {
+	if diff, ok := CheckedSub(a, b); ok {
+		return diff
+	}
+	return 0
+}
+
 // Max returns the minimum value of inputs x, y
 func Max(x int, y int) int {
 	if x > y {
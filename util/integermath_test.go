@@ -1,10 +1,11 @@
 package util
 
 import (
+	"math"
+	"math/big"
 	"math/bits"
 	"math/rand"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +31,71 @@ func TestLog2Floor(t *testing.T) {
 	assert.Equal(t, 63, Log2Floor(1<<64-1))
 }
 
+func TestCheckedArithmetic(t *testing.T) {
+	tt := []struct {
+		a, b      uint64
+		sum       uint64
+		sumOk     bool
+		diff      uint64
+		diffOk    bool
+		satSum    uint64
+		satDiff   uint64
+		product   uint64
+		productOk bool
+	}{
+		{0, 0, 0, true, 0, true, 0, 0, 0, true},
+		{5, 3, 8, true, 2, true, 8, 2, 15, true},
+		{3, 5, 8, true, 1<<64 - 2, false, 8, 0, 15, true},
+		{math.MaxUint64, 0, math.MaxUint64, true, math.MaxUint64, true, math.MaxUint64, math.MaxUint64, 0, true},
+		{math.MaxUint64, 1, 0, false, math.MaxUint64 - 1, true, math.MaxUint64, math.MaxUint64 - 1, math.MaxUint64, true},
+		{1 << 63, 1 << 63, 0, false, 0, true, math.MaxUint64, 0, 0, false},
+		{0, math.MaxUint64, math.MaxUint64, true, 1, false, math.MaxUint64, 0, 0, true},
+		{1 << 32, 1 << 32, 1 << 33, true, 0, true, 1 << 33, 0, 0, false},
+	}
+	for i, tc := range tt {
+		sum, ok := CheckedAdd(tc.a, tc.b)
+		assert.Equal(t, tc.sum, sum, "CheckedAdd(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		assert.Equal(t, tc.sumOk, ok, "CheckedAdd(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		diff, ok := CheckedSub(tc.a, tc.b)
+		assert.Equal(t, tc.diff, diff, "CheckedSub(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		assert.Equal(t, tc.diffOk, ok, "CheckedSub(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		assert.Equal(t, tc.satSum, SaturatingAdd(tc.a, tc.b), "SaturatingAdd(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		assert.Equal(t, tc.satDiff, SaturatingSub(tc.a, tc.b), "SaturatingSub(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		product, ok := CheckedMultiply(tc.a, tc.b)
+		assert.Equal(t, tc.product, product, "CheckedMultiply(%d, %d) (testcase %d)", tc.a, tc.b, i)
+		assert.Equal(t, tc.productOk, ok, "CheckedMultiply(%d, %d) (testcase %d)", tc.a, tc.b, i)
+	}
+}
+
+func FuzzCheckedArithmetic(f *testing.F) {
+	f.Add(uint64(0), uint64(0))
+	f.Add(uint64(math.MaxUint64), uint64(1))
+	f.Add(uint64(1), uint64(math.MaxUint64))
+	f.Fuzz(func(t *testing.T, a, b uint64) {
+		wide := new(big.Int).Add(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+		sum, ok := CheckedAdd(a, b)
+		if ok != wide.IsUint64() || ok && sum != wide.Uint64() {
+			t.Fatalf("CheckedAdd(%d, %d) = %d, %t", a, b, sum, ok)
+		}
+		if s := SaturatingAdd(a, b); !ok && s != math.MaxUint64 || ok && s != sum {
+			t.Fatalf("SaturatingAdd(%d, %d) = %d", a, b, s)
+		}
+		wide.Sub(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+		diff, ok := CheckedSub(a, b)
+		if ok != wide.IsUint64() || ok && diff != wide.Uint64() {
+			t.Fatalf("CheckedSub(%d, %d) = %d, %t", a, b, diff, ok)
+		}
+		if d := SaturatingSub(a, b); !ok && d != 0 || ok && d != diff {
+			t.Fatalf("SaturatingSub(%d, %d) = %d", a, b, d)
+		}
+		wide.Mul(new(big.Int).SetUint64(a), new(big.Int).SetUint64(b))
+		product, ok := CheckedMultiply(a, b)
+		if ok != wide.IsUint64() || ok && product != wide.Uint64() {
+			t.Fatalf("CheckedMultiply(%d, %d) = %d, %t", a, b, product, ok)
+		}
+	})
+}
+
 func TestCeilPow2(t *testing.T) {
 	tt := []struct {
 		input  uint64
@@ -61,9 +127,7 @@ func TestIsPow2(t *testing.T) {
 		value := uint64(1) << i
 		assert.True(t, IsPow2(value), "%d is power of two", value)
 	}
-	seed := time.Now().UnixMicro()
-	rng := rand.New(rand.NewSource(seed))
-	t.Logf("rng seed: %d", seed)
+	rng := rand.New(rand.NewSource(1))
 	for i := 0; i < 100000; i++ {
 		value := rng.Uint64()
 		expected := bits.OnesCount64(value) <= 1
@@ -110,3 +174,16 @@ func TestAbs(t *testing.T) {
 	assert.Equal(t, 0, Max(0, -1))
 	assert.Equal(t, 123, Max(122, 123))
 }
+
+func TestCheckedAdd(t *testing.T) {
+	sum, ok := CheckedAdd(1<<63, 1<<62)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3<<62), sum)
+	_, ok = CheckedAdd(1<<63, 1<<63)
+	assert.False(t, ok)
+	_, ok = CheckedAdd(1<<64-1, 1)
+	assert.False(t, ok)
+	sum, ok = CheckedAdd(1<<64-1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1<<64-1), sum)
+}
@@ -0,0 +1,38 @@
+package util
+
+import "sync/atomic"
+
+// Logger is the minimal logging interface used for diagnostics within the library.
+// *log.Logger from the standard library satisfies it.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// NopLogger is a Logger which discards all messages
+type NopLogger struct{}
+
+var _ Logger = NopLogger{}
+
+func (NopLogger) Printf(string, ...any) {}
+
+// AtomicLogger is a Logger forwarding to a Logger which can be replaced concurrently with its use.
+// The zero value discards all messages.
+type AtomicLogger struct {
+	l atomic.Pointer[Logger]
+}
+
+var _ Logger = (*AtomicLogger)(nil)
+
+// Store replaces the Logger messages are forwarded to, nil discards all messages
+func (a *AtomicLogger) Store(l Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	a.l.Store(&l)
+}
+
+func (a *AtomicLogger) Printf(format string, v ...any) {
+	if l := a.l.Load(); l != nil {
+		(*l).Printf(format, v...)
+	}
+}
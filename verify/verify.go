@@ -0,0 +1,127 @@
+// Package verify exposes stateless verification routines for data segment inclusion proofs.
+// It does not construct or hold an Aggregate, all inputs are passed explicitly.
+// It is built on the verifylite core and doesn't depend on the datasegment package: proofs and
+// index entries are passed as verifylite types.
+package verify
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/filecoin-project/go-data-segment/verifylite"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	xerrors "golang.org/x/xerrors"
+)
+
+// VerifierData describes the client's piece, it converts from datasegment.InclusionVerifierData
+type VerifierData struct {
+	// CommPc is the PieceCID of the client's piece
+	CommPc cid.Cid
+	// SizePc is the padded size of the client's piece
+	SizePc abi.PaddedPieceSize
+}
+
+// AuxData describes the aggregator's deal, it converts from datasegment.InclusionAuxData
+type AuxData struct {
+	// CommPa is the PieceCID of the aggregator's deal
+	CommPa cid.Cid
+	// SizePa is the padded size of the aggregator's deal
+	SizePa abi.PaddedPieceSize
+}
+
+// VerifyInclusion checks that the proof for the client's data described by verifierData
+// results in the expectedAux.
+func VerifyInclusion(proof verifylite.InclusionProof, verifierData VerifierData, expectedAux AuxData) error {
+	commPc, err := verifylite.CommPFromCID(verifierData.CommPc.KeyString())
+	if err != nil {
+		return xerrors.Errorf("invalid client's commitment: %w", err)
+	}
+	commPa, sizePa, err := proof.ComputeExpectedAuxData(commPc, uint64(verifierData.SizePc))
+	if err != nil {
+		return xerrors.Errorf("computing expected aux data: %w", err)
+	}
+	if abi.PaddedPieceSize(sizePa) != expectedAux.SizePa {
+		return xerrors.Errorf("aggregator's deal size doesn't match: %d != %d", sizePa, expectedAux.SizePa)
+	}
+	if !bytes.Equal(verifylite.CommPToCID(commPa), expectedAux.CommPa.Bytes()) {
+		return xerrors.Errorf("aggregator's commitment doesn't match: %x != %s", commPa, expectedAux.CommPa)
+	}
+	return nil
+}
+
+// VerifyIndexEntry checks that the entry is valid and is included in the index area of
+// the deal with commitment commPa using proofIndex. The index area holds indexCapacity
+// entries, zero selects the default capacity of verifylite.MaxIndexEntries. The segment of
+// the entry has to end before the index area starts.
+func VerifyIndexEntry(entry verifylite.Entry, commPa cid.Cid, proofIndex verifylite.Proof, indexCapacity uint64) error {
+	if err := entry.Validate(); err != nil {
+		return xerrors.Errorf("invalid index entry: %w", err)
+	}
+	if len(proofIndex.Path) > verifylite.MaxIndexProofDepth {
+		return xerrors.Errorf("index proof too deep: %d > %d", len(proofIndex.Path), verifylite.MaxIndexProofDepth)
+	}
+
+	dealSize := uint64(verifylite.EntrySize) << len(proofIndex.Path)
+	indexStart, err := verifylite.IndexAreaStart(dealSize, indexCapacity)
+	if err != nil {
+		return xerrors.Errorf("locating index area of a %d sized deal: %w", dealSize, err)
+	}
+	if proofIndex.Index*verifylite.EntrySize < indexStart {
+		return xerrors.Errorf("index entry at wrong position: %d < %d",
+			proofIndex.Index*verifylite.EntrySize, indexStart)
+	}
+	if entry.Size > indexStart || entry.Offset > indexStart-entry.Size {
+		return xerrors.Errorf("segment overlaps the index area: %d + %d > %d", entry.Offset, entry.Size, indexStart)
+	}
+
+	expectedRoot, err := verifylite.CommPFromCID(commPa.KeyString())
+	if err != nil {
+		return xerrors.Errorf("invalid commPa: %w", err)
+	}
+	root, err := proofIndex.ComputeRoot(entry.Node())
+	if err != nil {
+		return xerrors.Errorf("computing root from index proof: %w", err)
+	}
+	if root != expectedRoot {
+		return xerrors.Errorf("index proof does not lead to commPa")
+	}
+	return nil
+}
+
+// VerifySegmentBytes reads unpadded data of the segment from r and checks that it matches
+// the commitment and size in the entry. Data shorter than the segment is zero padded,
+// data longer than the segment results in an error.
+func VerifySegmentBytes(r io.Reader, entry verifylite.Entry) error {
+	if err := entry.Validate(); err != nil {
+		return xerrors.Errorf("invalid index entry: %w", err)
+	}
+
+	cp := &commp.Calc{}
+	unpaddedLength := int64(abi.PaddedPieceSize(entry.Size).Unpadded())
+	n, err := io.CopyBuffer(cp, io.LimitReader(r, unpaddedLength), make([]byte, cp.BlockSize()*128))
+	if err != nil {
+		return xerrors.Errorf("reading segment data: %w", err)
+	}
+	if extra, err := io.ReadFull(r, make([]byte, 1)); extra != 0 || (err != nil && err != io.EOF) {
+		return xerrors.Errorf("segment data longer than the entry size (%d unpadded bytes)", unpaddedLength)
+	}
+	if n < unpaddedLength {
+		if _, err := cp.Write(make([]byte, unpaddedLength-n)); err != nil {
+			return xerrors.Errorf("padding segment data: %w", err)
+		}
+	}
+
+	comm, paddedSize, err := cp.Digest()
+	if err != nil {
+		return xerrors.Errorf("computing commitment: %w", err)
+	}
+	if paddedSize != entry.Size {
+		return xerrors.Errorf("segment size doesn't match: %d != %d", paddedSize, entry.Size)
+	}
+	if !bytes.Equal(comm, entry.CommDs[:]) {
+		return xerrors.Errorf("segment commitment doesn't match the entry")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package verify
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/verifylite"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	cid "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var samplePieceInfos = []abi.PieceInfo{
+	{
+		PieceCID: cid.MustParse("baga6ea4seaqae5ysjdbsr4b5jhotaz5ooh62jrrdbxwygfpkkfjz44kvywycmgy"),
+		Size:     abi.UnpaddedPieceSize(520192).Padded(),
+	},
+	{
+		PieceCID: cid.MustParse("baga6ea4seaqnrm2n2g4m23t6rs26obxjw2tjtr7tcho24gepj2naqhevytduyoa"),
+		Size:     abi.UnpaddedPieceSize(260096).Padded(),
+	},
+}
+
+func liteEntry(e datasegment.SegmentDesc) verifylite.Entry {
+	return verifylite.Entry{CommDs: verifylite.Node(e.CommDs), Offset: e.Offset, Size: e.Size, Checksum: e.Checksum}
+}
+
+func liteProof(p merkletree.ProofData) verifylite.Proof {
+	path := make([]verifylite.Node, len(p.Path))
+	for i, n := range p.Path {
+		path[i] = verifylite.Node(n)
+	}
+	return verifylite.Proof{Path: path, Index: p.Index}
+}
+
+func liteInclusionProof(ip datasegment.InclusionProof) verifylite.InclusionProof {
+	return verifylite.InclusionProof{ProofSubtree: liteProof(ip.ProofSubtree), ProofIndex: liteProof(ip.ProofIndex)}
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	a, err := datasegment.NewAggregate(abi.PaddedPieceSize(1<<20), samplePieceInfos)
+	require.NoError(t, err)
+	commPa, err := a.PieceCID()
+	require.NoError(t, err)
+	expectedAux := AuxData{CommPa: commPa, SizePa: a.DealSize}
+
+	for i, pi := range samplePieceInfos {
+		ip, err := a.ProofForPieceInfo(pi)
+		require.NoError(t, err)
+		verifierData := VerifierData(datasegment.VerifierDataForPieceInfo(pi))
+		assert.NoError(t, VerifyInclusion(liteInclusionProof(*ip), verifierData, expectedAux))
+		assert.NoError(t, VerifyIndexEntry(liteEntry(a.Index.Entries[i]), commPa, liteProof(ip.ProofIndex), 0))
+
+		wrongAux := expectedAux
+		wrongAux.SizePa *= 2
+		assert.Error(t, VerifyInclusion(liteInclusionProof(*ip), verifierData, wrongAux))
+		assert.Error(t, VerifyIndexEntry(liteEntry(a.Index.Entries[1-i]), commPa, liteProof(ip.ProofIndex), 0))
+	}
+}
+
+func TestVerifyIndexEntryCapacity(t *testing.T) {
+	a, err := datasegment.NewAggregate(abi.PaddedPieceSize(1<<20), samplePieceInfos[1:], datasegment.WithIndexCapacity(64))
+	require.NoError(t, err)
+	commPa, err := a.PieceCID()
+	require.NoError(t, err)
+
+	ip, err := a.ProofForPieceInfo(samplePieceInfos[1])
+	require.NoError(t, err)
+	entry := liteEntry(a.Index.Entries[0])
+	assert.NoError(t, VerifyIndexEntry(entry, commPa, liteProof(ip.ProofIndex), 64))
+	// the entry is placed before the default index area
+	assert.Error(t, VerifyIndexEntry(entry, commPa, liteProof(ip.ProofIndex), 0))
+	assert.Error(t, VerifyIndexEntry(entry, commPa, liteProof(ip.ProofIndex), 3))
+}
+
+func TestVerifyIndexEntryOverlap(t *testing.T) {
+	const dealSize = 1 << 20
+	indexStart, err := verifylite.IndexAreaStart(dealSize, 0)
+	require.NoError(t, err)
+
+	// a valid entry describing a segment inside of the index area
+	entry := verifylite.Entry{CommDs: verifylite.Node{1}, Offset: indexStart, Size: 128}
+	entry.Checksum = entry.ComputeChecksum()
+	require.NoError(t, entry.Validate())
+
+	tree, err := merkletree.NewHybrid(15)
+	require.NoError(t, err)
+	slot := indexStart / verifylite.EntrySize
+	n := merkletree.Node(entry.Node())
+	require.NoError(t, tree.SetNode(1, slot, &n))
+	proof, err := tree.CollectProof(1, slot)
+	require.NoError(t, err)
+	commPa, err := cid.Cast(verifylite.CommPToCID(verifylite.Node(tree.Root())))
+	require.NoError(t, err)
+
+	err = VerifyIndexEntry(entry, commPa, liteProof(proof), 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "overlaps the index area")
+
+	entry.Offset = indexStart - 128
+	entry.Checksum = entry.ComputeChecksum()
+	n = merkletree.Node(entry.Node())
+	require.NoError(t, tree.SetNode(1, slot, &n))
+	proof, err = tree.CollectProof(1, slot)
+	require.NoError(t, err)
+	commPa, err = cid.Cast(verifylite.CommPToCID(verifylite.Node(tree.Root())))
+	require.NoError(t, err)
+	assert.NoError(t, VerifyIndexEntry(entry, commPa, liteProof(proof), 0))
+}
+
+func TestVerifySegmentBytes(t *testing.T) {
+	a, err := datasegment.NewAggregate(abi.PaddedPieceSize(1<<20), samplePieceInfos)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile("../datasegment/testdata/sample_aggregate/cat.png.car")
+	require.NoError(t, err)
+
+	assert.NoError(t, VerifySegmentBytes(bytes.NewReader(b), liteEntry(a.Index.Entries[0])))
+	assert.Error(t, VerifySegmentBytes(bytes.NewReader(b), liteEntry(a.Index.Entries[1])))
+
+	b[0] ^= 0xff
+	assert.Error(t, VerifySegmentBytes(bytes.NewReader(b), liteEntry(a.Index.Entries[0])))
+}
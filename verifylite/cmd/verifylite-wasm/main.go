@@ -0,0 +1,9 @@
+// Command verifylite-wasm builds the verifylite core into a wasm module. Built for wasip1 with
+// -buildmode=c-shared it exports input_buffer, output_buffer and verify_inclusion.
+package main
+
+import (
+	_ "github.com/filecoin-project/go-data-segment/verifylite"
+)
+
+func main() {}
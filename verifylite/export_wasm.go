@@ -0,0 +1,65 @@
+//go:build wasip1 && go1.24
+
+package verifylite
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// Result codes of verify_inclusion
+const (
+	resultOK uint32 = iota
+	resultMalformedInput
+	resultInvalidVerifierData
+	resultInvalidProof
+	resultLimitExceeded
+)
+
+var (
+	input  []byte
+	output [NodeSize + 8]byte
+)
+
+// inputBuffer returns a buffer of size bytes in the module memory, the host writes the input
+// of verify_inclusion into it
+//
+//go:wasmexport input_buffer
+func inputBuffer(size uint32) unsafe.Pointer {
+	input = make([]byte, size)
+	return unsafe.Pointer(unsafe.SliceData(input))
+}
+
+// outputBuffer returns the buffer holding commPa (32 bytes) || sizePa (8 bytes, little-endian)
+// after a successful verify_inclusion
+//
+//go:wasmexport output_buffer
+func outputBuffer() unsafe.Pointer {
+	return unsafe.Pointer(&output)
+}
+
+// verifyInclusion verifies the input written to the input buffer, see DecodeInput, and returns
+// one of the result codes
+//
+//go:wasmexport verify_inclusion
+func verifyInclusion() uint32 {
+	ip, commPc, sizePc, err := DecodeInput(input)
+	if err != nil {
+		return resultMalformedInput
+	}
+	commPa, sizePa, err := ip.ComputeExpectedAuxData(commPc, sizePc)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrInvalidVerifierData):
+		return resultInvalidVerifierData
+	case errors.Is(err, ErrProofLimitExceeded):
+		return resultLimitExceeded
+	default:
+		return resultInvalidProof
+	}
+	copy(output[:NodeSize], commPa[:])
+	for i := 0; i < 8; i++ {
+		output[NodeSize+i] = byte(sizePa >> (8 * i))
+	}
+	return resultOK
+}
@@ -0,0 +1,347 @@
+//go:build gc || tinygo
+
+// Package verifylite is the core of the verification of data segment inclusion proofs. It uses
+// only a small subset of the standard library: no reflection, formatting or logging, so it can
+// be compiled with TinyGo and for wasm32 sandboxes such as FVM actors. The datasegment and
+// verify packages verify proofs with it.
+//
+// Commitments are passed as raw 32 byte nodes and sizes as padded byte counts, use CommPFromCID
+// and CommPToCID to convert PieceCIDs.
+//
+// The package is guarded to the toolchains it is checked with in CI: the gc compiler, on all
+// platforms, and TinyGo. The wasm exports in export_wasm.go are built for wasip1 with Go 1.24
+// or later, which added //go:wasmexport. The cmd/verifylite-wasm command builds the package
+// into a wasm module.
+package verifylite
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	// NodeSize is the size of a node of the deal tree
+	NodeSize = 32
+	// EntrySize is the size of an entry of the data segment index
+	EntrySize = 2 * NodeSize
+	// ChecksumSize is the size of the checksum of an entry of the data segment index
+	ChecksumSize = 16
+	// MinSegmentSize is the padded size of the smallest segment
+	MinSegmentSize = 128
+
+	// MaxSupportedDealSize is the largest deal size, in padded bytes, proofs are accepted for
+	MaxSupportedDealSize = uint64(64 << 30)
+	// MaxSubtreeProofDepth is the maximum depth of the subtree proof
+	MaxSubtreeProofDepth = 29
+	// MaxIndexProofDepth is the maximum depth of the index proof
+	MaxIndexProofDepth = 30
+)
+
+var (
+	// ErrInvalidVerifierData is returned for a client's piece of invalid size or commitment
+	ErrInvalidVerifierData = errors.New("invalid verifier data")
+	// ErrInvalidProof is returned when the proof doesn't prove the inclusion of the client's piece
+	ErrInvalidProof = errors.New("invalid proof")
+	// ErrProofLimitExceeded is returned for proofs of deals larger than MaxSupportedDealSize
+	ErrProofLimitExceeded = errors.New("proof exceeds supported limits")
+	// ErrInvalidIndexCapacity is returned for an index capacity the deal can't have, including
+	// the default capacity of a deal too small to hold the index area
+	ErrInvalidIndexCapacity = errors.New("invalid index capacity")
+	// ErrInvalidEntry is returned by Entry.Validate for malformed index entries
+	ErrInvalidEntry = errors.New("invalid index entry")
+	// ErrMalformedInput is returned by DecodeInput for inputs not in the expected layout
+	ErrMalformedInput = errors.New("malformed input")
+)
+
+// Error is returned for rejected inputs. It matches one of the Err values with errors.Is and
+// describes the reason of the rejection.
+type Error struct {
+	Kind   error
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Reason
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Node is a node of the deal tree
+type Node [NodeSize]byte
+
+// ProofOf is a Merkle inclusion proof, laid out as merkletree.ProofData. The nodes of the path
+// can be of any type laid out as Node, such that merkletree.ProofData converts to
+// ProofOf[merkletree.Node] without copying the path.
+type ProofOf[N ~[NodeSize]byte] struct {
+	Path  []N
+	Index uint64
+}
+
+// Proof is a Merkle inclusion proof with a path of Nodes
+type Proof = ProofOf[Node]
+
+// InclusionProofOf is the datasegment.InclusionProof of a client's piece
+type InclusionProofOf[N ~[NodeSize]byte] struct {
+	ProofSubtree ProofOf[N]
+	ProofIndex   ProofOf[N]
+}
+
+// InclusionProof is the inclusion proof of a client's piece with paths of Nodes
+type InclusionProof = InclusionProofOf[Node]
+
+// ComputeRoot computes the root of the tree from the subtree node and the proof
+func (p ProofOf[N]) ComputeRoot(subtree Node) (Node, error) {
+	if len(p.Path) > 63 || p.Index>>len(p.Path) != 0 {
+		return Node{}, &Error{ErrInvalidProof, "proof index out of range of the path"}
+	}
+	carry := subtree
+	index := p.Index
+	for i := range p.Path {
+		sibling := Node(p.Path[i])
+		if index&1 == 1 {
+			carry = pairHash(&sibling, &carry)
+		} else {
+			carry = pairHash(&carry, &sibling)
+		}
+		index >>= 1
+	}
+	return carry, nil
+}
+
+// ComputeExpectedAuxData verifies the proof of inclusion of the client's piece with commitment
+// commPc of sizePc padded bytes and returns the commitment and the padded size of the
+// aggregator's deal, which have to be cross-checked with the chain state.
+func (ip InclusionProofOf[N]) ComputeExpectedAuxData(commPc Node, sizePc uint64) (Node, uint64, error) {
+	return ip.ComputeExpectedAuxDataWithIndexCapacity(commPc, sizePc, 0)
+}
+
+// ComputeExpectedAuxDataWithIndexCapacity is like ComputeExpectedAuxData for a deal whose index
+// area holds indexCapacity entries, zero selects the default capacity of MaxIndexEntries.
+// It doesn't allocate unless the proof is rejected.
+func (ip InclusionProofOf[N]) ComputeExpectedAuxDataWithIndexCapacity(commPc Node, sizePc uint64, indexCapacity uint64) (Node, uint64, error) {
+	if sizePc&(sizePc-1) != 0 {
+		return Node{}, 0, &Error{ErrInvalidVerifierData, "size of piece is not power of two"}
+	}
+	if sizePc < MinSegmentSize {
+		return Node{}, 0, &Error{ErrInvalidVerifierData, "size of piece is too small"}
+	}
+	if len(ip.ProofSubtree.Path) > MaxSubtreeProofDepth {
+		return Node{}, 0, &Error{ErrProofLimitExceeded, "subtree proof too deep"}
+	}
+	if len(ip.ProofIndex.Path) > MaxIndexProofDepth {
+		return Node{}, 0, &Error{ErrProofLimitExceeded, "index proof too deep"}
+	}
+	// both factors are powers of two, the product fits unless it exceeds the deal size limit
+	if bits.TrailingZeros64(sizePc)+len(ip.ProofSubtree.Path) > bits.TrailingZeros64(MaxSupportedDealSize) {
+		return Node{}, 0, &Error{ErrProofLimitExceeded, "proven deal size too large"}
+	}
+	sizePa := sizePc << len(ip.ProofSubtree.Path)
+
+	commPa, err := ip.ProofSubtree.ComputeRoot(commPc)
+	if err != nil {
+		return Node{}, 0, &Error{ErrInvalidProof, "could not validate the subtree proof"}
+	}
+
+	// the index of the subtree proof is less than 1<<depth, the offset is within the deal
+	offset := ip.ProofSubtree.Index * sizePc
+	entry := Entry{CommDs: commPc, Offset: offset, Size: sizePc}
+	entry.Checksum = entry.ComputeChecksum()
+	commPa2, err := ip.ProofIndex.ComputeRoot(entry.Node())
+	if err != nil {
+		return Node{}, 0, &Error{ErrInvalidProof, "could not validate the index proof"}
+	}
+	if commPa != commPa2 {
+		return Node{}, 0, &Error{ErrInvalidProof, "aggregator's data commitments don't match"}
+	}
+	if uint64(EntrySize)<<len(ip.ProofIndex.Path) != sizePa {
+		return Node{}, 0, &Error{ErrInvalidProof, "aggregator's data size doesn't match"}
+	}
+
+	indexStart, err := IndexAreaStart(sizePa, indexCapacity)
+	if err != nil {
+		return Node{}, 0, err
+	}
+	// the index of the index proof is less than 1<<depth, the offset is within the deal
+	if ip.ProofIndex.Index*EntrySize < indexStart {
+		return Node{}, 0, &Error{ErrInvalidProof, "index entry at wrong position"}
+	}
+	return commPa, sizePa, nil
+}
+
+// MaxIndexEntries returns the default number of entries of the index area of a deal of
+// dealSize padded bytes, as datasegment.MaxIndexEntriesInDeal
+func MaxIndexEntries(dealSize uint64) uint64 {
+	entries := uint64(1) << log2Ceil(dealSize/2048/EntrySize)
+	if entries < 4 {
+		return 4
+	}
+	return entries
+}
+
+// IndexAreaStart returns the offset, in padded bytes, of the index area holding indexCapacity
+// entries at the end of a deal of dealSize padded bytes. Zero selects the default capacity of
+// MaxIndexEntries. Other capacities have to be powers of two of at least the default capacity.
+// The index area has to be smaller than the deal, else the returned error matches
+// ErrInvalidIndexCapacity.
+func IndexAreaStart(dealSize, indexCapacity uint64) (uint64, error) {
+	if indexCapacity == 0 {
+		indexCapacity = MaxIndexEntries(dealSize)
+		if indexCapacity >= dealSize/EntrySize {
+			return 0, &Error{ErrInvalidIndexCapacity, "deal too small to hold the index area"}
+		}
+		return dealSize - indexCapacity*EntrySize, nil
+	}
+	if indexCapacity&(indexCapacity-1) != 0 {
+		return 0, &Error{ErrInvalidIndexCapacity, "capacity is not a power of two"}
+	}
+	if indexCapacity < MaxIndexEntries(dealSize) {
+		return 0, &Error{ErrInvalidIndexCapacity, "capacity is smaller than required for the deal size"}
+	}
+	if indexCapacity >= dealSize/EntrySize {
+		return 0, &Error{ErrInvalidIndexCapacity, "index area does not fit in the deal"}
+	}
+	return dealSize - indexCapacity*EntrySize, nil
+}
+
+func log2Ceil(v uint64) int {
+	if v <= 1 {
+		return 0
+	}
+	return bits.Len64(v - 1)
+}
+
+// Entry is an entry of the data segment index, laid out as datasegment.SegmentDesc
+type Entry struct {
+	CommDs   Node
+	Offset   uint64
+	Size     uint64
+	Checksum [ChecksumSize]byte
+}
+
+// ComputeChecksum returns the checksum of the entry: the SHA256 of the entry with a zero
+// checksum, truncated to 126 bits
+func (e Entry) ComputeChecksum() [ChecksumSize]byte {
+	e.Checksum = [ChecksumSize]byte{}
+	entry := e.serialize()
+	digest := sha256.Sum256(entry[:])
+	var res [ChecksumSize]byte
+	copy(res[:], digest[:])
+	res[ChecksumSize-1] &= 0b00111111
+	return res
+}
+
+// Validate checks the checksum and the alignment of the entry, that the segment is at least
+// MinSegmentSize and that its end doesn't overflow. Returned errors match ErrInvalidEntry.
+func (e Entry) Validate() error {
+	if e.ComputeChecksum() != e.Checksum {
+		return &Error{ErrInvalidEntry, "computed checksum does not match embedded checksum"}
+	}
+	if e.Offset%128 != 0 {
+		return &Error{ErrInvalidEntry, "offset is not aligned in padded data"}
+	}
+	if e.Size%128 != 0 {
+		return &Error{ErrInvalidEntry, "size is not aligned in padded data"}
+	}
+	if e.Size < MinSegmentSize {
+		return &Error{ErrInvalidEntry, "segment is smaller than the minimal piece size"}
+	}
+	if _, carry := bits.Add64(e.Offset, e.Size, 0); carry != 0 {
+		return &Error{ErrInvalidEntry, "end of the segment overflows"}
+	}
+	return nil
+}
+
+// Node returns the node of the deal tree covering the entry
+func (e Entry) Node() Node {
+	entry := e.serialize()
+	return pairHash((*Node)(entry[:NodeSize]), (*Node)(entry[NodeSize:]))
+}
+
+func (e Entry) serialize() [EntrySize]byte {
+	var res [EntrySize]byte
+	copy(res[:], e.CommDs[:])
+	binary.LittleEndian.PutUint64(res[NodeSize:], e.Offset)
+	binary.LittleEndian.PutUint64(res[NodeSize+8:], e.Size)
+	copy(res[NodeSize+16:], e.Checksum[:])
+	return res
+}
+
+func pairHash(left, right *Node) Node {
+	var buf [2 * NodeSize]byte
+	copy(buf[:NodeSize], left[:])
+	copy(buf[NodeSize:], right[:])
+	res := Node(sha256.Sum256(buf[:]))
+	res[NodeSize-1] &= 0b00111111
+	return res
+}
+
+// cidCommPHeader is the prefix of the binary form of a v1 PieceCID
+const cidCommPHeader = "\x01\x81\xe2\x03\x92\x20\x20"
+
+// CommPFromCID returns the commitment of a PieceCID given in its binary form, as returned by
+// cid.Cid.Bytes or cid.Cid.KeyString
+func CommPFromCID[T string | []byte](c T) (Node, error) {
+	if len(c) != len(cidCommPHeader)+NodeSize {
+		return Node{}, &Error{ErrInvalidVerifierData, "wrong length of CID"}
+	}
+	if string(c[:len(cidCommPHeader)]) != cidCommPHeader {
+		return Node{}, &Error{ErrInvalidVerifierData, "wrong content of CID header"}
+	}
+	var res Node
+	copy(res[:], c[len(cidCommPHeader):])
+	return res, nil
+}
+
+// CommPToCID returns the binary form of the PieceCID of the commitment
+func CommPToCID(commP Node) []byte {
+	res := make([]byte, 0, len(cidCommPHeader)+NodeSize)
+	res = append(res, cidCommPHeader...)
+	return append(res, commP[:]...)
+}
+
+// DecodeInput decodes the input of the wasm entry point:
+// commPc (32 bytes) || sizePc (8 bytes, little-endian) || subtree proof || index proof,
+// with the proofs in the binary encoding of merkletree.ProofData.
+func DecodeInput(data []byte) (InclusionProof, Node, uint64, error) {
+	if len(data) < NodeSize+8 {
+		return InclusionProof{}, Node{}, 0, ErrMalformedInput
+	}
+	commPc := Node(data[:NodeSize])
+	sizePc := binary.LittleEndian.Uint64(data[NodeSize:])
+	rest := data[NodeSize+8:]
+
+	var ip InclusionProof
+	var err error
+	if ip.ProofSubtree, rest, err = decodeProof(rest); err != nil {
+		return InclusionProof{}, Node{}, 0, err
+	}
+	if ip.ProofIndex, rest, err = decodeProof(rest); err != nil {
+		return InclusionProof{}, Node{}, 0, err
+	}
+	if len(rest) != 0 {
+		return InclusionProof{}, Node{}, 0, ErrMalformedInput
+	}
+	return ip, commPc, sizePc, nil
+}
+
+// decodeProof decodes a proof encoded as depth (1 byte) || index (8 bytes, little-endian) ||
+// path and returns the remaining data
+func decodeProof(data []byte) (Proof, []byte, error) {
+	if len(data) < 1+8 {
+		return Proof{}, nil, ErrMalformedInput
+	}
+	depth := int(data[0])
+	end := 1 + 8 + depth*NodeSize
+	if depth > 63 || len(data) < end {
+		return Proof{}, nil, ErrMalformedInput
+	}
+	p := Proof{Index: binary.LittleEndian.Uint64(data[1:]), Path: make([]Node, depth)}
+	for i := range p.Path {
+		p.Path[i] = Node(data[1+8+i*NodeSize:])
+	}
+	return p, data[end:], nil
+}
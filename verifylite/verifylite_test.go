@@ -0,0 +1,148 @@
+package verifylite_test
+
+import (
+	"go/build"
+	"testing"
+
+	"github.com/filecoin-project/go-data-segment/datasegment"
+	"github.com/filecoin-project/go-data-segment/merkletree"
+	"github.com/filecoin-project/go-data-segment/verifylite"
+	abi "github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func liteProof(p merkletree.ProofData) verifylite.Proof {
+	res := verifylite.Proof{Index: p.Index, Path: make([]verifylite.Node, len(p.Path))}
+	for i, n := range p.Path {
+		res.Path[i] = verifylite.Node(n)
+	}
+	return res
+}
+
+func liteInclusionProof(ip datasegment.InclusionProof) verifylite.InclusionProof {
+	return verifylite.InclusionProof{ProofSubtree: liteProof(ip.ProofSubtree), ProofIndex: liteProof(ip.ProofIndex)}
+}
+
+func encodeInput(t *testing.T, commPc verifylite.Node, sizePc uint64, ip datasegment.InclusionProof) []byte {
+	res := append([]byte(nil), commPc[:]...)
+	res = append(res, byte(sizePc), byte(sizePc>>8), byte(sizePc>>16), byte(sizePc>>24),
+		byte(sizePc>>32), byte(sizePc>>40), byte(sizePc>>48), byte(sizePc>>56))
+	for _, p := range []merkletree.ProofData{ip.ProofSubtree, ip.ProofIndex} {
+		b, err := p.MarshalBinary()
+		require.NoError(t, err)
+		res = append(res, b...)
+	}
+	return res
+}
+
+func TestAgreesWithDatasegment(t *testing.T) {
+	for _, dealSize := range []uint64{1 << 20, 8 << 20} {
+		a, pieces, err := datasegment.GenerateRandomAggregate(int64(dealSize), abi.PaddedPieceSize(dealSize), 5)
+		require.NoError(t, err)
+		for i, p := range pieces {
+			ip, err := a.ProofForIndexEntry(i)
+			require.NoError(t, err)
+			vd := datasegment.VerifierDataForPieceInfo(p.PieceInfo)
+			expected, err := ip.ComputeExpectedAuxData(vd)
+			require.NoError(t, err)
+
+			commPc, err := verifylite.CommPFromCID(p.PieceInfo.PieceCID.Bytes())
+			require.NoError(t, err)
+			lip := liteInclusionProof(*ip)
+			commPa, sizePa, err := lip.ComputeExpectedAuxData(commPc, uint64(p.PieceInfo.Size))
+			require.NoError(t, err)
+			assert.Equal(t, expected.CommPa.Bytes(), verifylite.CommPToCID(commPa))
+			assert.Equal(t, uint64(expected.SizePa), sizePa)
+
+			decoded, decodedCommPc, decodedSizePc, err := verifylite.DecodeInput(encodeInput(t, commPc, uint64(p.PieceInfo.Size), *ip))
+			require.NoError(t, err)
+			assert.Equal(t, lip, decoded)
+			assert.Equal(t, commPc, decodedCommPc)
+			assert.Equal(t, uint64(p.PieceInfo.Size), decodedSizePc)
+
+			// the paths of the datasegment proof are used as they are
+			withTreeNodes := verifylite.InclusionProofOf[merkletree.Node]{
+				ProofSubtree: verifylite.ProofOf[merkletree.Node](ip.ProofSubtree),
+				ProofIndex:   verifylite.ProofOf[merkletree.Node](ip.ProofIndex),
+			}
+			commPa2, sizePa2, err := withTreeNodes.ComputeExpectedAuxData(commPc, uint64(p.PieceInfo.Size))
+			require.NoError(t, err)
+			assert.Equal(t, commPa, commPa2)
+			assert.Equal(t, sizePa, sizePa2)
+
+			// a tampered proof is rejected by both
+			tampered := ip.Clone()
+			tampered.ProofIndex.Path[0][0] ^= 1
+			_, err = tampered.ComputeExpectedAuxData(vd)
+			assert.ErrorIs(t, err, datasegment.ErrInvalidProof)
+			_, _, err = liteInclusionProof(tampered).ComputeExpectedAuxData(commPc, uint64(p.PieceInfo.Size))
+			assert.ErrorIs(t, err, verifylite.ErrInvalidProof)
+		}
+	}
+}
+
+func TestComputeExpectedAuxDataRejects(t *testing.T) {
+	var commPc verifylite.Node
+	_, _, err := verifylite.InclusionProof{}.ComputeExpectedAuxData(commPc, 100)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidVerifierData)
+	_, _, err = verifylite.InclusionProof{}.ComputeExpectedAuxData(commPc, 64)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidVerifierData)
+
+	deep := verifylite.InclusionProof{ProofIndex: verifylite.Proof{Path: make([]verifylite.Node, verifylite.MaxIndexProofDepth+1)}}
+	_, _, err = deep.ComputeExpectedAuxData(commPc, 128)
+	assert.ErrorIs(t, err, verifylite.ErrProofLimitExceeded)
+	large := verifylite.InclusionProof{ProofSubtree: verifylite.Proof{Path: make([]verifylite.Node, 2)}}
+	_, _, err = large.ComputeExpectedAuxData(commPc, verifylite.MaxSupportedDealSize)
+	assert.ErrorIs(t, err, verifylite.ErrProofLimitExceeded)
+
+	_, err = verifylite.Proof{Path: make([]verifylite.Node, 1), Index: 2}.ComputeRoot(commPc)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidProof)
+
+	_, err = verifylite.IndexAreaStart(1<<20, 6)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidIndexCapacity)
+	_, err = verifylite.IndexAreaStart(1<<20, 4)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidIndexCapacity)
+	_, err = verifylite.IndexAreaStart(1<<20, 1<<14)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidIndexCapacity)
+	_, err = verifylite.IndexAreaStart(256, 0)
+	assert.ErrorIs(t, err, verifylite.ErrInvalidIndexCapacity)
+	start, err := verifylite.IndexAreaStart(1<<20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1<<20-8*verifylite.EntrySize), start)
+
+	entry := verifylite.Entry{Offset: 128, Size: 128}
+	assert.ErrorIs(t, entry.Validate(), verifylite.ErrInvalidEntry)
+	entry.Checksum = entry.ComputeChecksum()
+	assert.NoError(t, entry.Validate())
+	entry.Size = 64
+	entry.Checksum = entry.ComputeChecksum()
+	assert.ErrorIs(t, entry.Validate(), verifylite.ErrInvalidEntry)
+	entry = verifylite.Entry{Offset: 1<<64 - 128, Size: 128}
+	entry.Checksum = entry.ComputeChecksum()
+	assert.ErrorIs(t, entry.Validate(), verifylite.ErrInvalidEntry)
+
+	_, err = verifylite.CommPFromCID(make([]byte, 39))
+	assert.ErrorIs(t, err, verifylite.ErrInvalidVerifierData)
+	_, _, _, err = verifylite.DecodeInput(make([]byte, verifylite.NodeSize+8+9+1))
+	assert.ErrorIs(t, err, verifylite.ErrMalformedInput)
+}
+
+// TestImports keeps the package buildable with TinyGo and for wasm32
+func TestImports(t *testing.T) {
+	allowed := map[string]bool{
+		"crypto/sha256": true, "encoding/binary": true, "errors": true, "math/bits": true, "unsafe": true,
+	}
+	for _, goos := range []string{"linux", "wasip1"} {
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH = goos, "amd64"
+		if goos == "wasip1" {
+			ctx.GOARCH = "wasm"
+		}
+		pkg, err := ctx.ImportDir(".", 0)
+		require.NoError(t, err)
+		for _, imp := range pkg.Imports {
+			assert.True(t, allowed[imp], "%s imports %s", pkg.ImportPath, imp)
+		}
+	}
+}